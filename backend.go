@@ -0,0 +1,182 @@
+package natsfs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/derekcollison/nats-fs/natshttp"
+	"github.com/nats-io/nats.go"
+)
+
+// Backend stores the files a server serves. Names are slash separated and
+// relative to the root, "." being the root itself, as with io/fs. Errors
+// should wrap fs.ErrNotExist for missing files and ErrReadOnly for
+// backends that can not be written.
+//
+// DirBackend, FSBackend, MemBackend and ObjectStoreBackend are provided,
+// others serve the same way once they implement it.
+type Backend interface {
+	// Open returns the content of a file and its info. Content that is an
+	// io.Seeker has ranges served from it, otherwise it is sent whole.
+	Open(ctx context.Context, name string) (io.ReadCloser, fs.FileInfo, error)
+	Stat(ctx context.Context, name string) (fs.FileInfo, error)
+	// List returns the entries of a directory.
+	List(ctx context.Context, dir string) ([]fs.FileInfo, error)
+	// Put stores r as the file, replacing any there.
+	Put(ctx context.Context, name string, r io.Reader) error
+	Delete(ctx context.Context, name string) error
+}
+
+// ErrReadOnly is returned by backends that can not be written.
+var ErrReadOnly = errors.New("natsfs: backend is read-only")
+
+// BackendName returns the backend name for a request path.
+func BackendName(upath string) string {
+	name := strings.Trim(path.Clean("/"+upath), "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+// BackendHandler returns a read-only handler serving b. GET and HEAD fetch
+// files or list directories as JSON, anything else is refused.
+func BackendHandler(b Backend) http.Handler {
+	return backendHandler{b: b}
+}
+
+// WritableBackendHandler returns a handler serving b like BackendHandler,
+// that also lets PUT store the body and DELETE remove files. Requests are
+// not authenticated, wrap it in whatever decides who may write.
+func WritableBackendHandler(b Backend) http.Handler {
+	return backendHandler{b: b, writable: true}
+}
+
+// HandleBackend serves b read-only on subject.
+func HandleBackend(nc *nats.Conn, subject string, b Backend, opts *natshttp.Options) (*nats.Subscription, error) {
+	return natshttp.Handle(nc, subject, BackendHandler(b), opts)
+}
+
+type backendHandler struct {
+	b        Backend
+	writable bool
+}
+
+func (bh backendHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := BackendName(r.URL.Path)
+	switch {
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		bh.serveGet(w, r, name)
+	case r.Method == http.MethodPut && bh.writable:
+		if err := bh.b.Put(r.Context(), name, r.Body); err != nil {
+			backendError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodDelete && bh.writable:
+		// Whatever the backend does with it, the root stays.
+		if name == "." {
+			http.Error(w, "403 forbidden", http.StatusForbidden)
+			return
+		}
+		if err := bh.b.Delete(r.Context(), name); err != nil {
+			backendError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		if bh.writable {
+			w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+		} else {
+			w.Header().Set("Allow", "GET, HEAD")
+		}
+		http.Error(w, "405 method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (bh backendHandler) serveGet(w http.ResponseWriter, r *http.Request, name string) {
+	fi, err := bh.b.Stat(r.Context(), name)
+	if err != nil {
+		backendError(w, err)
+		return
+	}
+	if fi.IsDir() {
+		bh.serveList(w, r, name)
+		return
+	}
+	rc, fi, err := bh.b.Open(r.Context(), name)
+	if err != nil {
+		backendError(w, err)
+		return
+	}
+	defer rc.Close()
+	if rs, ok := rc.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, fi.Name(), fi.ModTime(), rs)
+		return
+	}
+	h := w.Header()
+	if ct := mime.TypeByExtension(path.Ext(name)); ct != "" {
+		h.Set("Content-Type", ct)
+	}
+	h.Set("Content-Length", strconv.FormatInt(fi.Size(), 10))
+	if !fi.ModTime().IsZero() {
+		h.Set("Last-Modified", fi.ModTime().UTC().Format(http.TimeFormat))
+	}
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		io.Copy(w, rc)
+	}
+}
+
+// serveList responds with the entries of dir, named by their full paths.
+func (bh backendHandler) serveList(w http.ResponseWriter, r *http.Request, dir string) {
+	fis, err := bh.b.List(r.Context(), dir)
+	if err != nil {
+		backendError(w, err)
+		return
+	}
+	entries := make([]natshttp.ListEntry, 0, len(fis))
+	for _, fi := range fis {
+		name := fi.Name()
+		if dir != "." {
+			name = dir + "/" + name
+		}
+		entries = append(entries, natshttp.NewListEntry(name, fi))
+	}
+	body, err := json.Marshal(entries)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		w.Write(body)
+	}
+}
+
+// backendError responds with the status for a backend error.
+func backendError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		http.Error(w, "404 page not found", http.StatusNotFound)
+	case errors.Is(err, fs.ErrPermission):
+		http.Error(w, "403 forbidden", http.StatusForbidden)
+	case errors.Is(err, fs.ErrInvalid):
+		http.Error(w, "400 bad request", http.StatusBadRequest)
+	case errors.Is(err, ErrReadOnly):
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "405 method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.Error(w, "500 internal server error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,77 @@
+package natsfs
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// serve sends a request to h, returning the response code and body.
+func serve(h http.Handler, method, target, body string) (int, string) {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+	return rec.Code, rec.Body.String()
+}
+
+func TestBackendHandlerReadOnly(t *testing.T) {
+	b := NewMemBackend()
+	b.Put(context.Background(), "a.txt", strings.NewReader("kept"))
+	h := BackendHandler(b)
+
+	if code, _ := serve(h, http.MethodPut, "/a.txt", "replaced"); code != http.StatusMethodNotAllowed {
+		t.Fatalf("PUT = %d, want 405", code)
+	}
+	if code, _ := serve(h, http.MethodDelete, "/a.txt", ""); code != http.StatusMethodNotAllowed {
+		t.Fatalf("DELETE = %d, want 405", code)
+	}
+	if code, body := serve(h, http.MethodGet, "/a.txt", ""); code != http.StatusOK || body != "kept" {
+		t.Fatalf("GET = %d %q, want 200 kept", code, body)
+	}
+}
+
+func TestWritableBackendHandler(t *testing.T) {
+	dir := t.TempDir()
+	h := WritableBackendHandler(DirBackend(dir))
+
+	if code, _ := serve(h, http.MethodPut, "/sub/a.txt", "written"); code != http.StatusNoContent {
+		t.Fatalf("PUT = %d, want 204", code)
+	}
+	if code, body := serve(h, http.MethodGet, "/sub/a.txt", ""); code != http.StatusOK || body != "written" {
+		t.Fatalf("GET = %d %q, want 200 written", code, body)
+	}
+	if code, _ := serve(h, http.MethodDelete, "/sub/a.txt", ""); code != http.StatusNoContent {
+		t.Fatalf("DELETE = %d, want 204", code)
+	}
+	if code, _ := serve(h, http.MethodDelete, "/sub/", ""); code != http.StatusNoContent {
+		t.Fatalf("DELETE of the empty directory = %d, want 204", code)
+	}
+	if code, _ := serve(h, http.MethodDelete, "/", ""); code != http.StatusForbidden {
+		t.Fatalf("DELETE of the root = %d, want 403", code)
+	}
+	if _, err := os.Stat(dir); err != nil {
+		t.Fatalf("root gone: %v", err)
+	}
+}
+
+func TestDirBackendKeepsRoot(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "root")
+	if err := os.Mkdir(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+	b := DirBackend(root)
+	if err := b.Delete(context.Background(), "."); !errors.Is(err, fs.ErrInvalid) {
+		t.Fatalf("Delete(.) = %v, want ErrInvalid", err)
+	}
+	if err := b.Put(context.Background(), ".", strings.NewReader("x")); !errors.Is(err, fs.ErrInvalid) {
+		t.Fatalf("Put(.) = %v, want ErrInvalid", err)
+	}
+	if _, err := os.Stat(root); err != nil {
+		t.Fatalf("root gone: %v", err)
+	}
+}
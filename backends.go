@@ -0,0 +1,404 @@
+package natsfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// DirBackend returns a Backend storing files under root on the local
+// filesystem. Writes go to a temporary file renamed into place, so readers
+// never see half a file.
+func DirBackend(root string) Backend {
+	return dirBackend(root)
+}
+
+type dirBackend string
+
+func (d dirBackend) path(name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	return filepath.Join(string(d), filepath.FromSlash(name)), nil
+}
+
+func (d dirBackend) Open(ctx context.Context, name string) (io.ReadCloser, fs.FileInfo, error) {
+	p, err := d.path(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	fd, err := os.Open(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	fi, err := fd.Stat()
+	if err != nil {
+		fd.Close()
+		return nil, nil, err
+	}
+	return fd, fi, nil
+}
+
+func (d dirBackend) Stat(ctx context.Context, name string) (fs.FileInfo, error) {
+	p, err := d.path(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(p)
+}
+
+func (d dirBackend) List(ctx context.Context, dir string) ([]fs.FileInfo, error) {
+	p, err := d.path(dir)
+	if err != nil {
+		return nil, err
+	}
+	des, err := os.ReadDir(p)
+	if err != nil {
+		return nil, err
+	}
+	return dirInfos(des), nil
+}
+
+func (d dirBackend) Put(ctx context.Context, name string, r io.Reader) error {
+	if name == "." {
+		return &fs.PathError{Op: "put", Path: name, Err: fs.ErrInvalid}
+	}
+	p, err := d.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".nats-fs-*")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tmp, r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), p)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// Delete removes a file or empty directory, never the root.
+func (d dirBackend) Delete(ctx context.Context, name string) error {
+	if name == "." {
+		return &fs.PathError{Op: "delete", Path: name, Err: fs.ErrInvalid}
+	}
+	p, err := d.path(name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(p)
+}
+
+// FSBackend returns a read-only Backend serving fsys, such as a *zip.Reader
+// or files embedded with go:embed. Files that can not seek are streamed,
+// without ranges.
+func FSBackend(fsys fs.FS) Backend {
+	return fsBackend{fsys}
+}
+
+type fsBackend struct {
+	fsys fs.FS
+}
+
+func (b fsBackend) Open(ctx context.Context, name string) (io.ReadCloser, fs.FileInfo, error) {
+	f, err := b.fsys.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, fi, nil
+}
+
+func (b fsBackend) Stat(ctx context.Context, name string) (fs.FileInfo, error) {
+	return fs.Stat(b.fsys, name)
+}
+
+func (b fsBackend) List(ctx context.Context, dir string) ([]fs.FileInfo, error) {
+	des, err := fs.ReadDir(b.fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	return dirInfos(des), nil
+}
+
+func (b fsBackend) Put(ctx context.Context, name string, r io.Reader) error {
+	return ErrReadOnly
+}
+
+func (b fsBackend) Delete(ctx context.Context, name string) error {
+	return ErrReadOnly
+}
+
+// dirInfos returns the info of directory entries, skipping any gone since.
+func dirInfos(des []fs.DirEntry) []fs.FileInfo {
+	fis := make([]fs.FileInfo, 0, len(des))
+	for _, de := range des {
+		if fi, err := de.Info(); err == nil {
+			fis = append(fis, fi)
+		}
+	}
+	return fis
+}
+
+// MemBackend keeps files in memory, for tests and scratch space.
+// Directories exist while they hold files.
+type MemBackend struct {
+	mu    sync.RWMutex
+	files map[string]*memEntry
+}
+
+type memEntry struct {
+	data  []byte
+	mtime time.Time
+}
+
+// NewMemBackend returns an empty MemBackend.
+func NewMemBackend() *MemBackend {
+	return &MemBackend{files: make(map[string]*memEntry)}
+}
+
+func (m *MemBackend) Open(ctx context.Context, name string) (io.ReadCloser, fs.FileInfo, error) {
+	m.mu.RLock()
+	e := m.files[name]
+	m.mu.RUnlock()
+	if e == nil {
+		return nil, nil, notExist("open", name)
+	}
+	fi := fileInfo{name: path.Base(name), size: int64(len(e.data)), mtime: e.mtime}
+	return &memFile{Reader: bytes.NewReader(e.data), fi: fi}, fi, nil
+}
+
+func (m *MemBackend) Stat(ctx context.Context, name string) (fs.FileInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if e := m.files[name]; e != nil {
+		return fileInfo{name: path.Base(name), size: int64(len(e.data)), mtime: e.mtime}, nil
+	}
+	if name == "." {
+		return fileInfo{name: ".", dir: true}, nil
+	}
+	for n := range m.files {
+		if strings.HasPrefix(n, name+"/") {
+			return fileInfo{name: path.Base(name), dir: true}, nil
+		}
+	}
+	return nil, notExist("stat", name)
+}
+
+func (m *MemBackend) List(ctx context.Context, dir string) ([]fs.FileInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	names := make([]string, 0, len(m.files))
+	mtimes := make(map[string]time.Time, len(m.files))
+	sizes := make(map[string]int64, len(m.files))
+	for n, e := range m.files {
+		names = append(names, n)
+		mtimes[n], sizes[n] = e.mtime, int64(len(e.data))
+	}
+	fis := childInfos(dir, names, func(n string) (int64, time.Time) { return sizes[n], mtimes[n] })
+	if fis == nil {
+		return nil, notExist("readdir", dir)
+	}
+	return fis, nil
+}
+
+func (m *MemBackend) Put(ctx context.Context, name string, r io.Reader) error {
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: "put", Path: name, Err: fs.ErrInvalid}
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.files[name] = &memEntry{data: data, mtime: time.Now()}
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *MemBackend) Delete(ctx context.Context, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.files[name] == nil {
+		return notExist("delete", name)
+	}
+	delete(m.files, name)
+	return nil
+}
+
+// ObjectStoreBackend returns a Backend storing files as objects in a
+// JetStream object store, named by their paths. Directories exist while
+// they hold objects.
+func ObjectStoreBackend(obs nats.ObjectStore) Backend {
+	return objectBackend{obs}
+}
+
+type objectBackend struct {
+	obs nats.ObjectStore
+}
+
+func (b objectBackend) Open(ctx context.Context, name string) (io.ReadCloser, fs.FileInfo, error) {
+	res, err := b.obs.Get(name)
+	if err != nil {
+		return nil, nil, objectError("open", name, err)
+	}
+	info, err := res.Info()
+	if err != nil {
+		res.Close()
+		return nil, nil, err
+	}
+	return res, objectInfo(info), nil
+}
+
+func (b objectBackend) Stat(ctx context.Context, name string) (fs.FileInfo, error) {
+	if name != "." {
+		info, err := b.obs.GetInfo(name)
+		if err == nil && !info.Deleted {
+			return objectInfo(info), nil
+		}
+		if err != nil && !errors.Is(err, nats.ErrObjectNotFound) {
+			return nil, err
+		}
+	}
+	fis, err := b.List(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if fis == nil && name != "." {
+		return nil, notExist("stat", name)
+	}
+	return fileInfo{name: path.Base(name), dir: true}, nil
+}
+
+func (b objectBackend) List(ctx context.Context, dir string) ([]fs.FileInfo, error) {
+	infos, err := b.obs.List()
+	if err != nil && !errors.Is(err, nats.ErrNoObjectsFound) {
+		return nil, err
+	}
+	byName := make(map[string]*nats.ObjectInfo, len(infos))
+	names := make([]string, 0, len(infos))
+	for _, info := range infos {
+		if !info.Deleted {
+			byName[info.Name] = info
+			names = append(names, info.Name)
+		}
+	}
+	fis := childInfos(dir, names, func(n string) (int64, time.Time) {
+		return int64(byName[n].Size), byName[n].ModTime
+	})
+	if fis == nil {
+		if dir == "." {
+			return []fs.FileInfo{}, nil
+		}
+		return nil, notExist("readdir", dir)
+	}
+	return fis, nil
+}
+
+func (b objectBackend) Put(ctx context.Context, name string, r io.Reader) error {
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: "put", Path: name, Err: fs.ErrInvalid}
+	}
+	_, err := b.obs.Put(&nats.ObjectMeta{Name: name}, r)
+	return err
+}
+
+func (b objectBackend) Delete(ctx context.Context, name string) error {
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: "delete", Path: name, Err: fs.ErrInvalid}
+	}
+	return objectError("delete", name, b.obs.Delete(name))
+}
+
+func objectInfo(info *nats.ObjectInfo) fileInfo {
+	return fileInfo{name: path.Base(info.Name), size: int64(info.Size), mtime: info.ModTime}
+}
+
+// objectError maps a missing object to fs.ErrNotExist.
+func objectError(op, name string, err error) error {
+	if errors.Is(err, nats.ErrObjectNotFound) {
+		return notExist(op, name)
+	}
+	return err
+}
+
+// childInfos returns the entries of dir among the slash separated file
+// names, subdirectories being implied by longer names, sorted by name.
+// It returns nil if dir holds nothing.
+func childInfos(dir string, names []string, stat func(name string) (int64, time.Time)) []fs.FileInfo {
+	prefix := dir + "/"
+	if dir == "." {
+		prefix = ""
+	}
+	seen := make(map[string]bool)
+	var fis []fs.FileInfo
+	for _, n := range names {
+		rest, ok := strings.CutPrefix(n, prefix)
+		if !ok || rest == "" {
+			continue
+		}
+		child, _, isDir := strings.Cut(rest, "/")
+		if seen[child] {
+			continue
+		}
+		seen[child] = true
+		if isDir {
+			fis = append(fis, fileInfo{name: child, dir: true})
+			continue
+		}
+		size, mtime := stat(n)
+		fis = append(fis, fileInfo{name: child, size: size, mtime: mtime})
+	}
+	sort.Slice(fis, func(i, j int) bool { return fis[i].Name() < fis[j].Name() })
+	return fis
+}
+
+func notExist(op, name string) error {
+	return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+}
+
+// fileInfo describes files of backends without their own.
+type fileInfo struct {
+	name  string
+	size  int64
+	mtime time.Time
+	dir   bool
+}
+
+func (fi fileInfo) Name() string       { return fi.name }
+func (fi fileInfo) Size() int64        { return fi.size }
+func (fi fileInfo) ModTime() time.Time { return fi.mtime }
+func (fi fileInfo) IsDir() bool        { return fi.dir }
+func (fi fileInfo) Sys() interface{}   { return nil }
+
+func (fi fileInfo) Mode() fs.FileMode {
+	if fi.dir {
+		return fs.ModeDir | 0755
+	}
+	return 0644
+}
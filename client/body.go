@@ -0,0 +1,155 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/derekcollison/nats-fs/natshttp"
+	"github.com/derekcollison/nats-fs/ratelimit"
+	"github.com/nats-io/nats.go"
+)
+
+// ErrIncomplete is returned when a transfer ends before all of the body
+// was received.
+var ErrIncomplete = errors.New("nats-fs: incomplete transfer")
+
+// body reads the chunks following the header message, acking each one
+// once it has been handed to the reader.
+type body struct {
+	nc       *nats.Conn
+	ctx      context.Context
+	sub      *nats.Subscription
+	size     int64
+	idle     time.Duration
+	received int64
+	acker    natshttp.Acker
+	ackLimit *ratelimit.Bucket
+	// Shared key the chunks are encrypted with, nil if they are not, and
+	// the chunks received so far, each one's nonce.
+	shared *[32]byte
+	seq    uint64
+	// Fetches corrupt chunks again, nil if they can not be.
+	repair *repairer
+	// Unread part of the current chunk.
+	buf  []byte
+	err  error
+	done bool
+	// The end message, once read.
+	end *nats.Msg
+
+	// Where to cancel the rest of the transfer, once a chunk arrived.
+	mu       sync.Mutex
+	cancel   string
+	canceled bool
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	for len(b.buf) == 0 {
+		if b.err != nil {
+			return 0, b.err
+		}
+		b.next()
+	}
+	n := copy(p, b.buf)
+	b.buf = b.buf[n:]
+	return n, nil
+}
+
+// next waits for the next chunk, setting err at the end of the body.
+func (b *body) next() {
+	if b.size >= 0 && b.received >= b.size {
+		b.err = io.EOF
+		return
+	}
+	msg, err := nextMsg(b.ctx, b.sub, b.idle)
+	if err != nil {
+		b.err = fmt.Errorf("%w after %d bytes: %v", ErrIncomplete, b.received, err)
+		return
+	}
+	if len(msg.Data) == 0 {
+		if b.size >= 0 {
+			b.err = fmt.Errorf("%w, received %d of %d bytes", ErrIncomplete, b.received, b.size)
+		} else {
+			b.end = msg
+			b.err = io.EOF
+		}
+		return
+	}
+	data := msg.Data
+	b.seq++
+	if err := natshttp.CheckChunk(msg); err != nil {
+		n := len(data)
+		if b.shared != nil {
+			n -= natshttp.E2EOverhead
+		}
+		if data, err = b.repair.fetch(b.received, n); err != nil {
+			b.err = err
+			return
+		}
+	} else if b.shared != nil {
+		if data, err = decryptChunk(b.shared, b.seq, data); err != nil {
+			b.err = err
+			return
+		}
+	}
+	b.received += int64(len(data))
+	b.buf = data
+	b.mu.Lock()
+	if b.cancel == "" {
+		b.cancel = natshttp.CancelSubject(msg)
+	}
+	b.mu.Unlock()
+	// ack flow control, pacing acks limits the rate the server sends.
+	b.ackLimit.Wait(len(msg.Data))
+	b.acker.Ack(msg)
+}
+
+// trailer returns the headers of the end message, reading it if the body
+// ended at its length.
+func (b *body) trailer() (http.Header, error) {
+	if b.end != nil {
+		return natshttp.HTTPHeader(b.end.Header), nil
+	}
+	if b.err != io.EOF {
+		return nil, errors.New("nats-fs: trailer read before the end of the body")
+	}
+	msg, err := nextMsg(b.ctx, b.sub, b.idle)
+	if err != nil {
+		return nil, fmt.Errorf("%w, no end message: %v", ErrIncomplete, err)
+	}
+	if len(msg.Data) != 0 {
+		return nil, fmt.Errorf("nats-fs: body longer than its Content-Length %d", b.size)
+	}
+	b.end = msg
+	return natshttp.HTTPHeader(msg.Header), nil
+}
+
+// cancelTransfer has the server stop sending, once.
+func (b *body) cancelTransfer() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.cancel != "" && !b.canceled && b.nc != nil {
+		b.nc.Publish(b.cancel, nil)
+		b.canceled = true
+	}
+}
+
+func (b *body) Close() error {
+	if b.done {
+		return nil
+	}
+	b.done = true
+	if b.err == nil {
+		// Have the server stop sending.
+		if b.size < 0 || b.received < b.size {
+			b.cancelTransfer()
+		}
+		b.err = errors.New("nats-fs: read on closed body")
+	}
+	return b.sub.Unsubscribe()
+}
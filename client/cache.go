@@ -0,0 +1,270 @@
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache keeps response bodies on disk, keyed by subject, path and the
+// request headers the caller chose, and revalidates them with conditional
+// requests, so fetching an unchanged
+// file again costs a round trip instead of a transfer. Only complete 200
+// responses with an ETag or Last-Modified are kept. The least recently
+// used are removed once the cache grows past its size.
+type Cache struct {
+	dir     string
+	maxSize int64
+	mu      sync.Mutex
+}
+
+// NewCache returns a cache in dir, holding up to maxSize bytes, 0 for no
+// limit.
+func NewCache(dir string, maxSize int64) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Cache{dir: dir, maxSize: maxSize}, nil
+}
+
+// CacheEntry is a cached response.
+type CacheEntry struct {
+	Subject string `json:"subject"`
+	Path    string `json:"path"`
+	// Request is the request headers that selected the response.
+	Request string      `json:"request,omitempty"`
+	Header  http.Header `json:"header"`
+	Size    int64       `json:"size"`
+	body    string
+}
+
+// selecting returns the headers of h that select a response, in a fixed
+// order. The trace context differs on every request and never does. As the
+// client's own headers are fixed, any a response varies on are here too.
+func selecting(h http.Header) string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		if ck := http.CanonicalHeaderKey(k); ck != "Traceparent" && ck != "Tracestate" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(http.CanonicalHeaderKey(k) + ": " + strings.Join(h[k], ", ") + "\n")
+	}
+	return b.String()
+}
+
+func (c *Cache) files(subject, path, request string) (body, meta string) {
+	sum := sha256.Sum256([]byte(subject + "\x00" + path + "\x00" + request))
+	name := filepath.Join(c.dir, hex.EncodeToString(sum[:]))
+	return name, name + ".json"
+}
+
+// Lookup returns the cached response for path on subject, nil if none. h
+// is the headers the caller adds to requests, such as Client.Header, a
+// response to other headers is not used.
+func (c *Cache) Lookup(subject, path string, h http.Header) *CacheEntry {
+	request := selecting(h)
+	body, meta := c.files(subject, path, request)
+	b, err := os.ReadFile(meta)
+	if err != nil {
+		return nil
+	}
+	var e CacheEntry
+	if err := json.Unmarshal(b, &e); err != nil || e.Subject != subject || e.Path != path || e.Request != request {
+		return nil
+	}
+	if fi, err := os.Stat(body); err != nil || fi.Size() != e.Size {
+		return nil
+	}
+	e.body = body
+	return &e
+}
+
+// Validators adds the conditional request headers that revalidate e.
+func (e *CacheEntry) Validators(h http.Header) {
+	if v := e.Header.Get("ETag"); v != "" {
+		h.Set("If-None-Match", v)
+	}
+	if v := e.Header.Get("Last-Modified"); v != "" {
+		h.Set("If-Modified-Since", v)
+	}
+}
+
+// Open returns the cached body, marking it recently used.
+func (e *CacheEntry) Open() (*os.File, error) {
+	now := time.Now()
+	os.Chtimes(e.body, now, now)
+	return os.Open(e.body)
+}
+
+// Cacheable reports whether a response with header can be kept.
+func Cacheable(statusCode int, header http.Header) bool {
+	if statusCode != http.StatusOK {
+		return false
+	}
+	if cc := strings.ToLower(header.Get("Cache-Control")); strings.Contains(cc, "no-store") {
+		return false
+	}
+	if header.Get("Content-Range") != "" {
+		return false
+	}
+	// Varies on something other than the request headers.
+	for _, v := range header.Values("Vary") {
+		if strings.TrimSpace(v) == "*" {
+			return false
+		}
+	}
+	return header.Get("ETag") != "" || header.Get("Last-Modified") != ""
+}
+
+// CacheWriter saves a response body as it is written.
+type CacheWriter struct {
+	c     *Cache
+	fd    *os.File
+	entry CacheEntry
+	// Why the body could not be saved.
+	err error
+}
+
+// Store returns a writer for the body of a response to path on subject,
+// requested with the caller's headers h as for Lookup. It is kept once
+// Commit is called, and dropped by Abort.
+func (c *Cache) Store(subject, path string, h, header http.Header) (*CacheWriter, error) {
+	fd, err := os.CreateTemp(c.dir, ".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+	return &CacheWriter{c: c, fd: fd, entry: CacheEntry{Subject: subject, Path: path, Request: selecting(h), Header: header}}, nil
+}
+
+// Write saves p. It never fails, so a full disk does not abort the
+// transfer being cached, the entry is dropped and Commit fails instead.
+func (cw *CacheWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return len(p), nil
+	}
+	n, err := cw.fd.Write(p)
+	cw.entry.Size += int64(n)
+	if err != nil {
+		cw.err = err
+		cw.Abort()
+	}
+	return len(p), nil
+}
+
+// Commit keeps the body written.
+func (cw *CacheWriter) Commit() error {
+	if cw.err != nil {
+		return cw.err
+	}
+	if err := cw.fd.Close(); err != nil {
+		os.Remove(cw.fd.Name())
+		return err
+	}
+	meta, err := json.Marshal(cw.entry)
+	if err != nil {
+		os.Remove(cw.fd.Name())
+		return err
+	}
+	cw.c.mu.Lock()
+	defer cw.c.mu.Unlock()
+	body, metaFile := cw.c.files(cw.entry.Subject, cw.entry.Path, cw.entry.Request)
+	if err := os.Rename(cw.fd.Name(), body); err != nil {
+		os.Remove(cw.fd.Name())
+		return err
+	}
+	if err := os.WriteFile(metaFile, meta, 0600); err != nil {
+		os.Remove(body)
+		return err
+	}
+	cw.c.evict()
+	return nil
+}
+
+// Abort drops the body written.
+func (cw *CacheWriter) Abort() {
+	cw.fd.Close()
+	os.Remove(cw.fd.Name())
+}
+
+// evict removes the least recently used entries until the cache fits.
+// Lock should be held.
+func (c *Cache) evict() {
+	if c.maxSize <= 0 {
+		return
+	}
+	ents, err := os.ReadDir(c.dir)
+	if err != nil {
+		return
+	}
+	type body struct {
+		name  string
+		size  int64
+		mtime time.Time
+	}
+	var bodies []body
+	var total int64
+	for _, de := range ents {
+		name := de.Name()
+		if strings.HasSuffix(name, ".json") || strings.HasPrefix(name, ".") {
+			continue
+		}
+		fi, err := de.Info()
+		if err != nil {
+			continue
+		}
+		bodies = append(bodies, body{filepath.Join(c.dir, name), fi.Size(), fi.ModTime()})
+		total += fi.Size()
+	}
+	sort.Slice(bodies, func(i, j int) bool { return bodies[i].mtime.Before(bodies[j].mtime) })
+	for _, b := range bodies {
+		if total <= c.maxSize {
+			break
+		}
+		os.Remove(b.name + ".json")
+		os.Remove(b.name)
+		total -= b.size
+	}
+}
+
+// cachingBody saves a body to the cache as it is read, keeping it only if
+// read to the end.
+type cachingBody struct {
+	io.ReadCloser
+	cw   *CacheWriter
+	done bool
+}
+
+func (cb *cachingBody) Read(p []byte) (int, error) {
+	n, err := cb.ReadCloser.Read(p)
+	if n > 0 && !cb.done {
+		if _, werr := cb.cw.Write(p[:n]); werr != nil {
+			cb.cw.Abort()
+			cb.done = true
+		}
+	}
+	if err == io.EOF && !cb.done {
+		cb.cw.Commit()
+		cb.done = true
+	}
+	return n, err
+}
+
+func (cb *cachingBody) Close() error {
+	if !cb.done {
+		cb.cw.Abort()
+		cb.done = true
+	}
+	return cb.ReadCloser.Close()
+}
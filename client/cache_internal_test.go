@@ -0,0 +1,28 @@
+package client
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCacheWriteErrorKeepsStreaming(t *testing.T) {
+	c, err := NewCache(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cw, err := c.Store("files", "/a.txt", nil, http.Header{"Etag": {`"1"`}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// As if the disk filled up.
+	cw.fd.Close()
+	if n, err := cw.Write([]byte("data")); n != 4 || err != nil {
+		t.Fatalf("Write = %d %v, want the transfer to carry on", n, err)
+	}
+	if err := cw.Commit(); err == nil {
+		t.Fatal("Commit after a failed write succeeded")
+	}
+	if e := c.Lookup("files", "/a.txt", nil); e != nil {
+		t.Fatal("partial body kept")
+	}
+}
@@ -0,0 +1,88 @@
+package client_test
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/derekcollison/nats-fs/client"
+)
+
+// store caches body for path as requested with h.
+func store(t *testing.T, c *client.Cache, path string, h http.Header, body string) {
+	t.Helper()
+	cw, err := c.Store("files", path, h, http.Header{"Etag": {`"1"`}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(cw, body)
+	if err := cw.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCacheKeyedOnRequestHeaders(t *testing.T) {
+	c, err := client.NewCache(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gzip := http.Header{"Accept-Encoding": {"gzip"}}
+	store(t, c, "/a.txt", gzip, "compressed")
+	store(t, c, "/a.txt", nil, "plain")
+
+	for _, tc := range []struct {
+		h    http.Header
+		want string
+	}{
+		{nil, "plain"},
+		{gzip, "compressed"},
+		{http.Header{"Accept-Encoding": {"gzip"}, "Traceparent": {"00-1-2-01"}}, "compressed"},
+	} {
+		e := c.Lookup("files", "/a.txt", tc.h)
+		if e == nil {
+			t.Fatalf("Lookup with %v found nothing", tc.h)
+		}
+		fd, err := e.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := io.ReadAll(fd)
+		fd.Close()
+		if string(got) != tc.want {
+			t.Fatalf("Lookup with %v = %q, want %q", tc.h, got, tc.want)
+		}
+	}
+	if e := c.Lookup("files", "/a.txt", http.Header{"Accept-Encoding": {"br"}}); e != nil {
+		t.Fatal("response to other headers used")
+	}
+}
+
+func TestCacheableVary(t *testing.T) {
+	h := http.Header{"Etag": {`"1"`}, "Vary": {"Accept-Encoding"}}
+	if !client.Cacheable(http.StatusOK, h) {
+		t.Fatal("response varying on a request header not cacheable")
+	}
+	h.Add("Vary", "*")
+	if client.Cacheable(http.StatusOK, h) {
+		t.Fatal("response with Vary: * cacheable")
+	}
+}
+
+func TestCacheAbort(t *testing.T) {
+	c, err := client.NewCache(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cw, err := c.Store("files", "/b.txt", nil, http.Header{"Etag": {`"1"`}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := io.Copy(cw, strings.NewReader("body")); n != 4 || err != nil {
+		t.Fatalf("Write = %d %v", n, err)
+	}
+	cw.Abort()
+	if e := c.Lookup("files", "/b.txt", nil); e != nil {
+		t.Fatal("aborted body kept")
+	}
+}
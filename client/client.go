@@ -0,0 +1,436 @@
+// Package client fetches files served by nats-fs, so Go programs can read
+// remote files as ordinary readers. Flow control acks are sent as the body
+// is read, and each chunk is checked against its CRC.
+//
+//	c := client.New(nc)
+//	rc, st, err := c.Open(ctx, "files", "/hello.txt")
+//	if err != nil {
+//		return err
+//	}
+//	defer rc.Close()
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/derekcollison/nats-fs/natshttp"
+	"github.com/derekcollison/nats-fs/ratelimit"
+	"github.com/nats-io/nats.go"
+)
+
+// Max redirects we will follow.
+const maxRedirects = 10
+
+// Max error body we will include in a StatusError.
+const maxErrorBody = 4 * 1024
+
+// Client makes requests to nats-fs servers over a NATS connection.
+type Client struct {
+	Conn *nats.Conn
+	// Headers added to every request, replacing any defaults with the
+	// same key. An empty value removes the default.
+	Header http.Header
+	// How long to wait for the header message and for each chunk of the
+	// body, 0 for the defaults of 10s and 30s.
+	FirstByteTimeout time.Duration
+	IdleTimeout      time.Duration
+	// Where bodies are kept and revalidated, nil to always fetch them.
+	Cache *Cache
+	// Limits the rate chunks are acked, and so the rate the server sends,
+	// nil for unlimited.
+	AckLimit *ratelimit.Bucket
+	// Retry is called when a request fails with no responders, a timeout
+	// or a 5xx response, with the attempt counting from 0. It waits out
+	// any backoff and reports whether to send the request again. Nil
+	// never retries, nor are requests with a streamed body retried.
+	Retry func(attempt int, req *nats.Msg, err error) bool
+	// Key pair response bodies are encrypted to end to end, nil for none.
+	E2E *E2EKey
+
+	// Requests sent again and corrupt chunks fetched again.
+	retransmits atomic.Int64
+}
+
+// New returns a client using nc.
+func New(nc *nats.Conn) *Client {
+	return &Client{Conn: nc}
+}
+
+// Stat describes a response.
+type Stat struct {
+	StatusCode int
+	Status     string
+	Header     http.Header
+	// Size of the body, -1 if unknown.
+	Size    int64
+	ModTime time.Time
+}
+
+// StatusError is returned for responses other than 2xx.
+type StatusError struct {
+	StatusCode int
+	Status     string
+	// Start of the error body, if any.
+	Body string
+	// Server's request ID, to find it in the server logs.
+	RequestID string
+}
+
+func (e *StatusError) Error() string {
+	msg := fmt.Sprintf("nats-fs: %s", e.Status)
+	if e.Body != "" {
+		msg += ": " + e.Body
+	}
+	if e.RequestID != "" {
+		msg += fmt.Sprintf(" (request id %s)", e.RequestID)
+	}
+	return msg
+}
+
+// ErrVersion is returned when the server does not speak our protocol
+// version.
+var ErrVersion = errors.New("nats-fs: incompatible server protocol version")
+
+// Response is a response to Do, its header message received.
+type Response struct {
+	Stat
+	// Body streams the rest of the response, it must be closed.
+	Body io.ReadCloser
+	// Request is the request answered, the last one if redirected.
+	Request *nats.Msg
+	// Msg is the header message.
+	Msg *nats.Msg
+
+	body *body
+}
+
+// Cancel has the server stop sending the body. It may be called from
+// another goroutine while the body is read.
+func (r *Response) Cancel() {
+	r.body.cancelTransfer()
+}
+
+// ReadTrailer returns the headers of the end message, which signed
+// responses carry their signature in. It reads the end message if the
+// body was read to its Content-Length without it, and fails if the body
+// was not read to the end.
+func (r *Response) ReadTrailer() (http.Header, error) {
+	return r.body.trailer()
+}
+
+// Open requests path on subject and returns the body as a reader. The
+// caller must close it, which abandons the rest of the transfer.
+func (c *Client) Open(ctx context.Context, subject, path string) (io.ReadCloser, *Stat, error) {
+	req := c.NewRequest(subject, path)
+	var cached *CacheEntry
+	if c.Cache != nil {
+		if cached = c.Cache.Lookup(subject, path, c.Header); cached != nil {
+			cached.Validators(http.Header(req.Header))
+		}
+	}
+	resp, err := c.Do(ctx, req)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cached != nil && resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		if fd, err := cached.Open(); err == nil {
+			st := &Stat{StatusCode: http.StatusOK, Status: "200 OK", Header: cached.Header, Size: cached.Size}
+			st.ModTime, _ = http.ParseTime(cached.Header.Get("Last-Modified"))
+			return fd, st, nil
+		}
+		// Gone from the cache meanwhile.
+		req.Header.Del("If-None-Match")
+		req.Header.Del("If-Modified-Since")
+		if resp, err = c.Do(ctx, req); err != nil {
+			return nil, nil, err
+		}
+	}
+	st := &resp.Stat
+	if err := CheckStatus(resp); err != nil || st.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		if err == nil {
+			err = &StatusError{StatusCode: st.StatusCode, Status: st.Status}
+		}
+		return nil, st, err
+	}
+	if c.Cache != nil && Cacheable(st.StatusCode, st.Header) {
+		if cw, err := c.Cache.Store(subject, path, c.Header, st.Header); err == nil {
+			return &cachingBody{ReadCloser: resp.Body, cw: cw}, st, nil
+		}
+	}
+	return resp.Body, st, nil
+}
+
+// Get requests path on subject and copies the body to w.
+func (c *Client) Get(ctx context.Context, subject, path string, w io.Writer) (*Stat, error) {
+	rc, st, err := c.Open(ctx, subject, path)
+	if err != nil {
+		return st, err
+	}
+	defer rc.Close()
+	_, err = io.Copy(w, rc)
+	return st, err
+}
+
+// Retransmits returns how many requests were sent again and corrupt chunks
+// fetched again.
+func (c *Client) Retransmits() int64 {
+	return c.retransmits.Load()
+}
+
+func (c *Client) firstByteTimeout() time.Duration {
+	if c.FirstByteTimeout > 0 {
+		return c.FirstByteTimeout
+	}
+	return 10 * time.Second
+}
+
+func (c *Client) idleTimeout() time.Duration {
+	if c.IdleTimeout > 0 {
+		return c.IdleTimeout
+	}
+	return 30 * time.Second
+}
+
+// NewRequest returns a GET request for path on subject, with the client's
+// headers. An empty path requests what the server serves at its root.
+func (c *Client) NewRequest(subject, path string) *nats.Msg {
+	req := nats.NewMsg(subject)
+	req.Header.Set("Accept", "*/*")
+	req.Header.Set("User-Agent", "nats-fs-client/0.1")
+	req.Header.Set("Method", "GET")
+	req.Header.Set(natshttp.VersionHeader, strconv.Itoa(natshttp.ProtocolVersion))
+	if path != "" {
+		req.Header.Set("URL", path)
+	}
+	if c.E2E != nil {
+		req.Header.Set(natshttp.E2EKeyHeader, c.E2E.public)
+	}
+	for k, vs := range c.Header {
+		req.Header.Del(k)
+		for _, v := range vs {
+			if v != "" {
+				req.Header.Add(k, v)
+			}
+		}
+	}
+	return req
+}
+
+// Do sends req and returns the response once its header message arrives,
+// following redirects and retrying as Retry allows. Unlike Open, any
+// status is returned as a response, see CheckStatus. Each attempt gets a
+// new reply inbox.
+func (c *Client) Do(ctx context.Context, req *nats.Msg) (*Response, error) {
+	resp, err := c.do(ctx, req)
+	if err == nil {
+		allowRepair(c, resp)
+	}
+	return resp, err
+}
+
+// do is Do without corrupt chunks fetched again.
+func (c *Client) do(ctx context.Context, req *nats.Msg) (*Response, error) {
+	streamed := req.Header.Get(natshttp.BodyInboxHeader) != ""
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, req)
+		if err == nil {
+			if resp.StatusCode < 500 || streamed || c.Retry == nil {
+				return resp, nil
+			}
+			err = &StatusError{StatusCode: resp.StatusCode, Status: resp.Status, RequestID: resp.Header.Get("X-Request-Id")}
+			if !c.Retry(attempt, req, err) {
+				return resp, nil
+			}
+			resp.Body.Close()
+		} else if streamed || c.Retry == nil || !isTransient(err) || !c.Retry(attempt, req, err) {
+			return nil, err
+		}
+		c.retransmits.Add(1)
+	}
+}
+
+// isTransient reports whether an error sending a request is worth a retry.
+func isTransient(err error) bool {
+	return errors.Is(err, nats.ErrTimeout) || errors.Is(err, nats.ErrNoResponders)
+}
+
+// send publishes the request and waits for the header message, following
+// any redirects.
+func (c *Client) send(ctx context.Context, req *nats.Msg) (*Response, error) {
+	for redirects := 0; ; redirects++ {
+		req.Reply = nats.NewInbox()
+		sub, err := c.Conn.SubscribeSync(req.Reply)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.Conn.PublishMsg(req); err != nil {
+			sub.Unsubscribe()
+			return nil, err
+		}
+		msg, err := nextMsg(ctx, sub, c.firstByteTimeout())
+		if err != nil {
+			// Have the server drop the request should it get to it.
+			c.Conn.Publish(natshttp.RequestCancelSubject(req.Reply), nil)
+			sub.Unsubscribe()
+			if lerr := c.Conn.LastError(); lerr != nil {
+				return nil, lerr
+			}
+			return nil, err
+		}
+		if err := checkVersion(msg); err != nil {
+			sub.Unsubscribe()
+			return nil, err
+		}
+		code := statusCode(msg)
+		switch code {
+		case 301, 302, 303, 307, 308:
+		default:
+			return c.newResponse(ctx, req, sub, msg)
+		}
+		sub.Unsubscribe()
+		if redirects == maxRedirects {
+			return nil, &StatusError{StatusCode: code, Status: natshttp.StatusLine(msg), Body: "too many redirects"}
+		}
+		loc := msg.Header.Get("Location")
+		ref, err := url.Parse(loc)
+		if err != nil || loc == "" {
+			return nil, &StatusError{StatusCode: code, Status: natshttp.StatusLine(msg), Body: fmt.Sprintf("bad redirect Location %q", loc)}
+		}
+		base, err := url.Parse("/" + strings.TrimPrefix(req.Header.Get("URL"), "/"))
+		if err != nil {
+			return nil, err
+		}
+		next := nats.NewMsg(req.Subject)
+		for k, v := range req.Header {
+			next.Header[k] = v
+		}
+		next.Header.Set("URL", base.ResolveReference(ref).RequestURI())
+		next.Data = req.Data
+		if code == http.StatusSeeOther {
+			next.Header.Set("Method", "GET")
+			next.Header.Del(natshttp.BodyInboxHeader)
+			next.Header.Del("Content-Length")
+			next.Data = nil
+		}
+		req = next
+	}
+}
+
+// newResponse returns the response whose header message msg arrived on
+// sub, setting up decryption of the body if we asked for it.
+func (c *Client) newResponse(ctx context.Context, req *nats.Msg, sub *nats.Subscription, msg *nats.Msg) (*Response, error) {
+	st := newStat(msg)
+	b := &body{nc: c.Conn, ctx: ctx, sub: sub, size: st.Size, idle: c.idleTimeout(), ackLimit: c.AckLimit}
+	if c.E2E != nil {
+		shared, err := c.E2E.open(msg)
+		if err != nil {
+			sub.Unsubscribe()
+			return nil, err
+		}
+		b.shared = shared
+	}
+	return &Response{Stat: *st, Body: b, Request: req, Msg: msg, body: b}, nil
+}
+
+// checkVersion makes sure the server answered with a protocol version we
+// speak. Servers from before versioning do not send one and speak 1.
+func checkVersion(msg *nats.Msg) error {
+	v := msg.Header.Get(natshttp.VersionHeader)
+	if statusCode(msg) == http.StatusHTTPVersionNotSupported {
+		return fmt.Errorf("%w, server speaks %s and we speak 1-%d", ErrVersion, v, natshttp.ProtocolVersion)
+	}
+	if v == "" {
+		return nil
+	}
+	if n, err := strconv.Atoi(v); err != nil || n < 1 || n > natshttp.ProtocolVersion {
+		return fmt.Errorf("%w %q, we speak 1-%d", ErrVersion, v, natshttp.ProtocolVersion)
+	}
+	return nil
+}
+
+// CheckStatus returns nil for 2xx and 304 responses. Otherwise it returns
+// a StatusError with the start of the body, which is closed.
+func CheckStatus(resp *Response) error {
+	if code := resp.StatusCode; code >= 200 && code < 300 || code == http.StatusNotModified {
+		return nil
+	}
+	defer resp.Body.Close()
+	e := &StatusError{StatusCode: resp.StatusCode, Status: resp.Status, RequestID: resp.Header.Get("X-Request-Id")}
+	if resp.Size >= 0 && resp.Size <= maxErrorBody {
+		if b, err := io.ReadAll(resp.Body); err == nil {
+			e.Body = strings.TrimSpace(string(b))
+		}
+	}
+	return e
+}
+
+type continueKey struct{}
+
+// WithContinue returns a copy of ctx that has f called when a request sent
+// with it is accepted with 100 Continue, so its streamed body can be sent,
+// see natshttp.BodyInboxHeader.
+func WithContinue(ctx context.Context, f func()) context.Context {
+	return context.WithValue(ctx, continueKey{}, f)
+}
+
+// nextMsg waits up to timeout for the next message, or until ctx is done.
+// Idle heartbeats and 100 Continue are skipped, each restarting the
+// timeout, the latter calling any function given with WithContinue.
+func nextMsg(ctx context.Context, sub *nats.Subscription, timeout time.Duration) (*nats.Msg, error) {
+	for {
+		msg, err := nextMsgOrHeartbeat(ctx, sub, timeout)
+		if err != nil || !natshttp.IsHeartbeat(msg) && !natshttp.IsContinue(msg) {
+			return msg, err
+		}
+		if f, _ := ctx.Value(continueKey{}).(func()); f != nil && natshttp.IsContinue(msg) {
+			f()
+		}
+	}
+}
+
+// nextMsgOrHeartbeat returns nats.ErrTimeout after timeout, and the
+// context's error once ctx is done.
+func nextMsgOrHeartbeat(ctx context.Context, sub *nats.Subscription, timeout time.Duration) (*nats.Msg, error) {
+	tctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	msg, err := sub.NextMsgWithContext(tctx)
+	if err != nil {
+		if cerr := ctx.Err(); cerr != nil {
+			return nil, cerr
+		}
+		if err == context.DeadlineExceeded {
+			err = nats.ErrTimeout
+		}
+	}
+	return msg, err
+}
+
+// statusCode returns the numeric status of a header message, 0 if missing.
+func statusCode(msg *nats.Msg) int {
+	status := msg.Header.Get("Status")
+	if i := strings.IndexByte(status, ' '); i > 0 {
+		status = status[:i]
+	}
+	code, _ := strconv.Atoi(status)
+	return code
+}
+
+func newStat(msg *nats.Msg) *Stat {
+	st := &Stat{StatusCode: statusCode(msg), Status: natshttp.StatusLine(msg), Header: natshttp.HTTPHeader(msg.Header), Size: -1}
+	if cl, err := strconv.ParseInt(msg.Header.Get("Content-Length"), 10, 64); err == nil {
+		st.Size = cl
+	}
+	st.ModTime, _ = http.ParseTime(msg.Header.Get("Last-Modified"))
+	return st
+}
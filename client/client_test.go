@@ -0,0 +1,113 @@
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/derekcollison/nats-fs/client"
+	"github.com/derekcollison/nats-fs/natsfstest"
+	"github.com/derekcollison/nats-fs/natshttp"
+)
+
+func TestGet(t *testing.T) {
+	srv := natsfstest.NewServer(t, nil)
+	data := bytes.Repeat([]byte("nats-fs "), 1000)
+	srv.WriteFile(t, "dir/file.txt", data)
+
+	var buf bytes.Buffer
+	st, err := client.New(srv.Connect(t)).Get(context.Background(), srv.Subject, "/dir/file.txt", &buf)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if st.StatusCode != http.StatusOK || st.Size != int64(len(data)) {
+		t.Fatalf("status %d size %d, want 200 and %d", st.StatusCode, st.Size, len(data))
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Fatalf("received %q, want the file", buf.String())
+	}
+}
+
+func TestGetNotFound(t *testing.T) {
+	srv := natsfstest.NewServer(t, nil)
+	_, err := client.New(srv.Connect(t)).Get(context.Background(), srv.Subject, "/missing", io.Discard)
+	var se *client.StatusError
+	if !errors.As(err, &se) || se.StatusCode != http.StatusNotFound {
+		t.Fatalf("Get = %v, want a 404 StatusError", err)
+	}
+}
+
+func TestDoFollowsRedirects(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/old", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/new", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/new", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "moved")
+	})
+	srv := natsfstest.NewServer(t, mux)
+	c := client.New(srv.Connect(t))
+
+	resp, err := c.Do(context.Background(), c.NewRequest(srv.Subject, "/old"))
+	if err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	if err := client.CheckStatus(resp); err != nil {
+		t.Fatalf("CheckStatus: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading body: %v", err)
+	}
+	if string(body) != "moved" || resp.Request.Header.Get("URL") != "/new" {
+		t.Fatalf("body %q from %q, want moved from /new", body, resp.Request.Header.Get("URL"))
+	}
+}
+
+func TestNewRequestHeaders(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Header.Get("Accept")+"|"+r.Header.Get("X-Extra"))
+	})
+	srv := natsfstest.NewServer(t, h)
+	c := client.New(srv.Connect(t))
+	c.Header = http.Header{"Accept": {"text/plain"}, "X-Extra": {"1"}}
+
+	var buf bytes.Buffer
+	if _, err := c.Get(context.Background(), srv.Subject, "/", &buf); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got, want := buf.String(), "text/plain|1"; got != want {
+		t.Fatalf("handler saw %q, want %q", got, want)
+	}
+}
+
+func TestList(t *testing.T) {
+	want := []natshttp.ListEntry{{Name: "/a", Size: 1, Mode: "-rw-r--r--"}, {Name: "/b", Mode: "drwxr-xr-x", IsDir: true}}
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(natshttp.ListHeader) != natshttp.ListRecursive || r.Header.Get(natshttp.GlobHeader) != "*.txt" {
+			http.Error(w, "not a recursive listing", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(want)
+	})
+	srv := natsfstest.NewServer(t, h)
+
+	entries, err := client.New(srv.Connect(t)).List(context.Background(), srv.Subject, "/", true, "*.txt")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("listed %d entries, want %d", len(entries), len(want))
+	}
+	for i := range want {
+		if entries[i].Name != want[i].Name || entries[i].IsDir != want[i].IsDir || entries[i].Size != want[i].Size {
+			t.Fatalf("entry %d is %+v, want %+v", i, entries[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/derekcollison/nats-fs/natshttp"
+	"github.com/nats-io/nats.go"
+)
+
+// Most we send in a session before waiting for acks.
+const sessionWindow = 8 * 1024 * 1024
+
+// Session is our end of a duplex session with a handler that took over
+// the response, see natshttp.DuplexProtocol. Reads return what the
+// handler writes, writes are sent to it as they are made.
+type Session struct {
+	nc    *nats.Conn
+	inbox string
+	body  *body
+	idle  time.Duration
+	acks  *nats.Subscription
+	acked chan struct{}
+
+	mu     sync.Mutex
+	seq    int64
+	sent   int64
+	got    int64
+	eof    bool
+	closed bool
+}
+
+// Dial requests path on subject as a duplex session. It fails with a
+// StatusError if the handler responds without taking over the response.
+func (c *Client) Dial(ctx context.Context, subject, path string) (*Session, *Stat, error) {
+	req := c.NewRequest(subject, path)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", natshttp.DuplexProtocol)
+	resp, err := c.send(ctx, req)
+	if err != nil {
+		return nil, nil, err
+	}
+	st := &resp.Stat
+	// The session outlives ctx.
+	body := resp.body
+	body.ctx = context.Background()
+	inbox := resp.Header.Get(natshttp.DuplexInboxHeader)
+	if st.StatusCode != http.StatusSwitchingProtocols || inbox == "" {
+		if err := CheckStatus(resp); err != nil {
+			return nil, st, err
+		}
+		body.Close()
+		return nil, st, &StatusError{StatusCode: st.StatusCode, Status: st.Status, Body: "handler did not take over the response"}
+	}
+	s := &Session{nc: c.Conn, inbox: inbox, body: body, idle: c.idleTimeout(), acked: make(chan struct{}, 1)}
+	if s.acks, err = c.Conn.Subscribe(nats.NewInbox(), s.processAck); err != nil {
+		body.Close()
+		return nil, nil, err
+	}
+	return s, st, nil
+}
+
+func (s *Session) processAck(m *nats.Msg) {
+	acked, err := strconv.ParseInt(m.Header.Get(natshttp.AckedHeader), 10, 64)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	// Acks are cumulative, late ones are stale.
+	if acked > s.got {
+		s.got = acked
+	}
+	s.mu.Unlock()
+	select {
+	case s.acked <- struct{}{}:
+	default:
+	}
+}
+
+// Read reads what the handler wrote, io.EOF once the session has ended.
+func (s *Session) Read(p []byte) (int, error) {
+	return s.body.Read(p)
+}
+
+// Write sends p to the handler, waiting while too much is unacked.
+func (s *Session) Write(p []byte) (int, error) {
+	size := natshttp.MaxChunk(s.nc)
+	written := 0
+	for written < len(p) {
+		n := len(p) - written
+		if n > size {
+			n = size
+		}
+		if err := s.send(p[written : written+n]); err != nil {
+			return written, err
+		}
+		written += n
+	}
+	return written, nil
+}
+
+func (s *Session) send(data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.sent-s.got > sessionWindow {
+		if s.closed || s.eof {
+			return errClosed
+		}
+		s.mu.Unlock()
+		select {
+		case <-s.acked:
+		case <-time.After(s.idle):
+			s.mu.Lock()
+			return nats.ErrTimeout
+		}
+		s.mu.Lock()
+	}
+	if s.closed || s.eof {
+		return errClosed
+	}
+	s.seq++
+	msg := nats.NewMsg(s.inbox)
+	msg.Reply = s.acks.Subject
+	msg.Header.Set(natshttp.SeqHeader, strconv.FormatInt(s.seq, 10))
+	msg.Data = data
+	if err := s.nc.PublishMsg(msg); err != nil {
+		return err
+	}
+	s.sent += int64(len(data))
+	return nil
+}
+
+var errClosed = errors.New("nats-fs: write on closed session")
+
+// CloseWrite tells the handler we have nothing more to send, it can still
+// be read from.
+func (s *Session) CloseWrite() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.eof {
+		return nil
+	}
+	s.eof = true
+	return s.nc.Publish(s.inbox, nil)
+}
+
+// Close ends our side of the session and stops reading the handler's.
+func (s *Session) Close() error {
+	err := s.CloseWrite()
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	s.acks.Unsubscribe()
+	if cerr := s.body.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,81 @@
+package client
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/derekcollison/nats-fs/natshttp"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// E2EKey is an ephemeral key pair response bodies are encrypted to, so
+// only we can read them and not the NATS servers they pass through.
+type E2EKey struct {
+	public  string
+	private *[32]byte
+	// Signs the server's keys, see natshttp.Options.E2ESigner.
+	server nkeys.KeyPair
+}
+
+// NewE2EKey generates a key pair for responses from the server whose
+// public nkey is serverKey, which must sign its own ephemeral keys.
+func NewE2EKey(serverKey string) (*E2EKey, error) {
+	server, err := nkeys.FromPublicKey(serverKey)
+	if err != nil {
+		return nil, fmt.Errorf("bad server key %q: %w", serverKey, err)
+	}
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &E2EKey{public: base64.StdEncoding.EncodeToString(pub[:]), private: priv, server: server}, nil
+}
+
+// ErrE2E is returned when a response should have been encrypted and was
+// not, or does not decrypt.
+var ErrE2E = errors.New("nats-fs: end to end encryption failed")
+
+// open derives the shared key for a response from the server's key in its
+// header message, once its signature checks out. Successful responses must be encrypted, error responses
+// may not be if the server failed before it could, nil is returned for
+// those.
+func (k *E2EKey) open(msg *nats.Msg) (*[32]byte, error) {
+	key := msg.Header.Get(natshttp.E2EKeyHeader)
+	if key == "" {
+		if code := statusCode(msg); code >= 200 && code < 300 {
+			return nil, fmt.Errorf("%w: server did not encrypt the response", ErrE2E)
+		}
+		return nil, nil
+	}
+	peer, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(peer) != 32 {
+		return nil, fmt.Errorf("%w: bad server key", ErrE2E)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(msg.Header.Get(natshttp.E2EKeySignatureHeader))
+	if err != nil || len(sig) == 0 {
+		return nil, fmt.Errorf("%w: server key not signed", ErrE2E)
+	}
+	if err := k.server.Verify(natshttp.E2ESigned(k.public, key), sig); err != nil {
+		return nil, fmt.Errorf("%w: server key not signed by the server", ErrE2E)
+	}
+	shared := new([32]byte)
+	box.Precompute(shared, (*[32]byte)(peer), k.private)
+	return shared, nil
+}
+
+// decryptChunk returns the plaintext of chunk seq of an encrypted
+// response, counting from 1.
+func decryptChunk(shared *[32]byte, seq uint64, data []byte) ([]byte, error) {
+	if len(data) < natshttp.E2EOverhead {
+		return nil, fmt.Errorf("%w: short chunk", ErrE2E)
+	}
+	plain, ok := box.OpenAfterPrecomputation(nil, data, natshttp.E2ENonce(seq), shared)
+	if !ok {
+		return nil, fmt.Errorf("%w: chunk %d does not decrypt", ErrE2E, seq)
+	}
+	return plain, nil
+}
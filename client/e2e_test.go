@@ -0,0 +1,72 @@
+package client
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/derekcollison/nats-fs/natshttp"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+	"golang.org/x/crypto/nacl/box"
+)
+
+func TestDecryptChunkInOrder(t *testing.T) {
+	var shared [32]byte
+	rand.Read(shared[:])
+	seal := func(seq uint64, data string) []byte {
+		return box.SealAfterPrecomputation(nil, []byte(data), natshttp.E2ENonce(seq), &shared)
+	}
+	first, second := seal(1, "first"), seal(2, "second")
+
+	if plain, err := decryptChunk(&shared, 1, first); err != nil || string(plain) != "first" {
+		t.Fatalf("chunk 1 = %q %v", plain, err)
+	}
+	if _, err := decryptChunk(&shared, 2, first); !errors.Is(err, ErrE2E) {
+		t.Fatalf("replayed chunk = %v, want ErrE2E", err)
+	}
+	if _, err := decryptChunk(&shared, 1, second); !errors.Is(err, ErrE2E) {
+		t.Fatalf("reordered chunk = %v, want ErrE2E", err)
+	}
+}
+
+func TestE2EKeyChecksSignature(t *testing.T) {
+	server, err := nkeys.CreateServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, _ := server.PublicKey()
+	k, err := NewE2EKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eph, _, _ := box.GenerateKey(rand.Reader)
+	ours := base64.StdEncoding.EncodeToString(eph[:])
+
+	// header returns a header message carrying our key signed by signer.
+	header := func(signer nkeys.KeyPair) *nats.Msg {
+		msg := nats.NewMsg("reply")
+		msg.Header.Set("Status", "200")
+		msg.Header.Set(natshttp.E2EKeyHeader, ours)
+		sig, err := signer.Sign(natshttp.E2ESigned(k.public, ours))
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg.Header.Set(natshttp.E2EKeySignatureHeader, base64.RawURLEncoding.EncodeToString(sig))
+		return msg
+	}
+
+	if _, err := k.open(header(server)); err != nil {
+		t.Fatalf("signed key refused: %v", err)
+	}
+	other, _ := nkeys.CreateServer()
+	if _, err := k.open(header(other)); !errors.Is(err, ErrE2E) {
+		t.Fatalf("key signed by another = %v, want ErrE2E", err)
+	}
+	unsigned := header(server)
+	unsigned.Header.Del(natshttp.E2EKeySignatureHeader)
+	if _, err := k.open(unsigned); !errors.Is(err, ErrE2E) {
+		t.Fatalf("unsigned key = %v, want ErrE2E", err)
+	}
+}
@@ -0,0 +1,43 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/derekcollison/nats-fs/natshttp"
+)
+
+// List returns the entries of the directory at path on subject, or of the
+// whole tree below it if recursive. A non-empty glob is matched by the
+// server against paths relative to path. A file lists as itself.
+func (c *Client) List(ctx context.Context, subject, path string, recursive bool, glob string) ([]natshttp.ListEntry, error) {
+	req := c.NewRequest(subject, path)
+	req.Header.Set("Accept", "application/json")
+	if recursive {
+		req.Header.Set(natshttp.ListHeader, natshttp.ListRecursive)
+	} else {
+		req.Header.Set(natshttp.ListHeader, natshttp.ListDir)
+	}
+	if glob != "" {
+		req.Header.Set(natshttp.GlobHeader, glob)
+	}
+	resp, err := c.Do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckStatus(resp); err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var entries []natshttp.ListEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("nats-fs: bad listing of %q: %v", path, err)
+	}
+	return entries, nil
+}
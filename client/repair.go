@@ -0,0 +1,102 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/derekcollison/nats-fs/natshttp"
+	"github.com/nats-io/nats.go"
+)
+
+// How many times we fetch a corrupt chunk again before giving up.
+const maxRepairs = 3
+
+// repairer fetches parts of a file response again as byte ranges, in place
+// of chunks that fail their CRC.
+type repairer struct {
+	c         *Client
+	resp      *Response
+	validator string
+	// Offset of the body in the file, for a range response.
+	base int64
+}
+
+// allowRepair lets chunks of resp that fail their CRC be fetched again, if
+// it is a file the server serves ranges of. If-Range makes sure the bytes
+// come from the same version.
+func allowRepair(c *Client, resp *Response) {
+	req := resp.Request
+	if m := req.Header.Get("Method"); m != "" && m != "GET" || len(req.Data) > 0 {
+		return
+	}
+	validator := resp.Header.Get("ETag")
+	if validator == "" {
+		validator = resp.Header.Get("Last-Modified")
+	}
+	if validator == "" || resp.Header.Get("Accept-Ranges") != "bytes" {
+		return
+	}
+	var base int64
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusPartialContent:
+		// Single ranges only, "bytes START-END/SIZE".
+		cr, ok := strings.CutPrefix(resp.Header.Get("Content-Range"), "bytes ")
+		start, _, _ := strings.Cut(cr, "-")
+		var err error
+		if base, err = strconv.ParseInt(start, 10, 64); !ok || err != nil {
+			return
+		}
+	default:
+		return
+	}
+	resp.body.repair = &repairer{c: c, resp: resp, validator: validator, base: base}
+}
+
+// fetch returns the n bytes at off in the body again. A nil repairer
+// fails with natshttp.ErrCorruptChunk.
+func (rp *repairer) fetch(off int64, n int) ([]byte, error) {
+	if rp == nil {
+		return nil, fmt.Errorf("%w at byte %d", natshttp.ErrCorruptChunk, off)
+	}
+	var err error
+	for attempt := 0; attempt < maxRepairs; attempt++ {
+		rp.c.retransmits.Add(1)
+		var data []byte
+		if data, err = rp.fetchOnce(off, n); err == nil {
+			return data, nil
+		}
+	}
+	return nil, fmt.Errorf("%w at byte %d: %v", natshttp.ErrCorruptChunk, off, err)
+}
+
+func (rp *repairer) fetchOnce(off int64, n int) ([]byte, error) {
+	req := nats.NewMsg(rp.resp.Request.Subject)
+	for k, v := range rp.resp.Request.Header {
+		req.Header[k] = v
+	}
+	start := rp.base + off
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, start+int64(n)-1))
+	req.Header.Set("If-Range", rp.validator)
+	// Not repairs of repairs.
+	resp, err := rp.c.do(rp.resp.body.ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := CheckStatus(resp); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("nats-fs: file changed, status %d for range %d-%d", resp.StatusCode, start, start+int64(n)-1)
+	}
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, resp.Body, int64(n)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,68 @@
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"sync/atomic"
+	"testing"
+
+	"github.com/derekcollison/nats-fs/client"
+	"github.com/derekcollison/nats-fs/natsfstest"
+	"github.com/nats-io/nats.go"
+)
+
+// relay forwards requests on subject to the server, passing its responses
+// back with the chunk numbered corrupt flipped on the first transfer only.
+// Acks still go straight to the server.
+func relay(t *testing.T, srv *natsfstest.Server, subject string, corrupt int) {
+	t.Helper()
+	nc := srv.Connect(t)
+	var transfers atomic.Int32
+	_, err := nc.Subscribe(subject, func(m *nats.Msg) {
+		first := transfers.Add(1) == 1
+		var chunks int
+		inbox := nats.NewInbox()
+		nc.Subscribe(inbox, func(r *nats.Msg) {
+			fwd := nats.NewMsg(m.Reply)
+			fwd.Header, fwd.Reply, fwd.Data = r.Header, r.Reply, r.Data
+			if len(r.Data) > 0 {
+				if chunks++; first && chunks == corrupt {
+					fwd.Data = bytes.Clone(r.Data)
+					fwd.Data[0] ^= 0xff
+				}
+			}
+			nc.PublishMsg(fwd)
+		})
+		req := nats.NewMsg(srv.Subject)
+		req.Header, req.Data, req.Reply = m.Header, m.Data, inbox
+		nc.PublishMsg(req)
+	})
+	if err != nil {
+		t.Fatalf("relay: %v", err)
+	}
+	if err := nc.Flush(); err != nil {
+		t.Fatalf("relay: %v", err)
+	}
+}
+
+func TestCorruptChunkFetchedAgain(t *testing.T) {
+	srv := natsfstest.NewServer(t, nil)
+	nc := srv.Connect(t)
+	data := make([]byte, 3*int(nc.MaxPayload())+123)
+	rand.New(rand.NewSource(1)).Read(data)
+	srv.WriteFile(t, "big.bin", data)
+	relay(t, srv, "relay", 2)
+
+	c := client.New(nc)
+	var buf bytes.Buffer
+	if _, err := c.Get(context.Background(), "relay", "/big.bin", &buf); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Fatalf("received %d bytes differing from the %d served", buf.Len(), len(data))
+	}
+	if n := c.Retransmits(); n != 1 {
+		t.Fatalf("%d retransmits, want 1", n)
+	}
+}
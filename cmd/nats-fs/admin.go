@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/derekcollison/nats-fs/natshttp"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+)
+
+// Default prefix of the admin subjects, commands are the last token.
+const defaultControlPrefix = "nats-fs.control"
+
+// Admin commands can cancel transfers and dump our config, so anyone able
+// to publish on the control subjects must not be trusted blindly. Commands
+// are signed with an admin nkey, over the subject, the time in
+// adminTimeHeader and the argument, and refused unless signed by the key
+// the server was given with -admin-key within maxSignatureAge. Without a
+// key the server only answers with -admin-unsigned, when NATS permissions
+// already restrict who may publish on the control subjects.
+const adminTimeHeader = "Admin-Time"
+
+// adminSigned returns what an admin command is signed over.
+func adminSigned(subject, at string, arg []byte) []byte {
+	return []byte(subject + "\n" + at + "\n" + string(arg))
+}
+
+// checkAdmin returns an error unless m is signed by key, which may be nil
+// to accept any command.
+func checkAdmin(key nkeys.KeyPair, m *nats.Msg) error {
+	if key == nil {
+		return nil
+	}
+	at := m.Header.Get(adminTimeHeader)
+	sig, err := base64.RawURLEncoding.DecodeString(m.Header.Get(signatureHeader))
+	if err != nil || at == "" || key.Verify(adminSigned(m.Subject, at, m.Data), sig) != nil {
+		return errors.New("command not signed by the admin key")
+	}
+	t, err := time.Parse(time.RFC3339Nano, at)
+	if err != nil {
+		return errors.New("bad command time")
+	}
+	if age := time.Since(t); age > maxSignatureAge || age < -maxSignatureAge {
+		return errors.New("command signed too long ago")
+	}
+	return nil
+}
+
+// Log levels, debug also logs every request.
+const (
+	logInfo  = "info"
+	logDebug = "debug"
+)
+
+var debugLog atomic.Bool
+
+func setLogLevel(level string) error {
+	switch level {
+	case logInfo:
+		debugLog.Store(false)
+	case logDebug:
+		debugLog.Store(true)
+	default:
+		return fmt.Errorf("unknown log level %q", level)
+	}
+	return nil
+}
+
+func logLevel() string {
+	if debugLog.Load() {
+		return logDebug
+	}
+	return logInfo
+}
+
+// logRequests logs each request when at debug level.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if debugLog.Load() {
+			natshttp.Logf(r, "%s %s from %q", r.Method, r.URL.Path, natshttp.Subject(r))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// controlResponse is the reply to an admin command. Every replica
+// answers, so each says who it is.
+type controlResponse struct {
+	Server    string              `json:"server"`
+	Transfers []natshttp.Transfer `json:"transfers,omitempty"`
+	Stats     map[string]pathStat `json:"stats,omitempty"`
+	Canceled  bool                `json:"canceled,omitempty"`
+	Config    map[string]string   `json:"config,omitempty"`
+	LogLevel  string              `json:"log_level,omitempty"`
+	Error     string              `json:"error,omitempty"`
+}
+
+// serverName identifies this replica in admin replies.
+func serverName() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s:%d", host, os.Getpid())
+}
+
+// handleControl subscribes to the admin commands, signed by key unless it
+// is nil:
+//
+//	transfers          list transfers in progress
+//	stats [PREFIX]     requests and bytes served per path
+//	cancel ID          cancel the transfer with the ID listed by transfers
+//	config             dump the flags we are running with
+//	loglevel [LEVEL]   show or set the log level, info or debug
+func handleControl(nc *nats.Conn, prefix string, key nkeys.KeyPair, stats *pathStats, flags *flag.FlagSet) (*nats.Subscription, error) {
+	name := serverName()
+	return nc.Subscribe(prefix+".*", func(m *nats.Msg) {
+		resp := controlResponse{Server: name}
+		arg := strings.TrimSpace(string(m.Data))
+		cmd := m.Subject[strings.LastIndexByte(m.Subject, '.')+1:]
+		if err := checkAdmin(key, m); err != nil {
+			log.Printf("Refused admin command %q: %v", cmd, err)
+			resp.Error = err.Error()
+			data, _ := json.Marshal(resp)
+			m.Respond(data)
+			return
+		}
+		switch cmd {
+		case "transfers":
+			resp.Transfers = natshttp.Transfers()
+		case "stats":
+			resp.Stats = stats.snapshot(arg)
+		case "cancel":
+			if resp.Canceled = natshttp.Cancel(arg); resp.Canceled {
+				log.Printf("Canceled transfer %s", arg)
+			}
+		case "config":
+			resp.Config = make(map[string]string)
+			flags.VisitAll(func(f *flag.Flag) {
+				resp.Config[f.Name] = f.Value.String()
+			})
+		case "loglevel":
+			if arg != "" {
+				if err := setLogLevel(arg); err != nil {
+					resp.Error = err.Error()
+				} else {
+					log.Printf("Log level set to %s", arg)
+				}
+			}
+			resp.LogLevel = logLevel()
+		default:
+			resp.Error = fmt.Sprintf("unknown command %q", cmd)
+		}
+		data, _ := json.Marshal(resp)
+		m.Respond(data)
+	})
+}
+
+// runAdmin sends a command to every replica and prints each reply as a
+// line of JSON.
+func runAdmin(args []string) {
+	fs := newFlagSet("admin", "<transfers|stats [PREFIX]|cancel ID|config|loglevel [info|debug]>")
+	conn := addConnFlags(fs)
+	var prefix = fs.String("control", defaultControlPrefix, "Prefix of the admin subjects")
+	var wait = fs.Duration("wait", time.Second, "How long to wait for replies from replicas")
+	var seed = fs.String("seed", "", "Admin nkey seed file to sign the command with, its public key given to servers with -admin-key")
+	fs.Parse(args)
+
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	cmd, arg := fs.Arg(0), fs.Arg(1)
+	if cmd == "cancel" && arg == "" {
+		log.Fatalf("cancel needs a transfer ID")
+	}
+
+	nc := conn.connect("NATS HTTP File Server Admin")
+	defer nc.Close()
+
+	inbox := nats.NewInbox()
+	sub, err := nc.SubscribeSync(inbox)
+	if err != nil {
+		log.Fatal(err)
+	}
+	msg := nats.NewMsg(*prefix + "." + cmd)
+	msg.Reply, msg.Data = inbox, []byte(arg)
+	if *seed != "" {
+		kp, err := loadSigner(*seed)
+		if err != nil {
+			log.Fatalf("Error loading admin key: %v", err)
+		}
+		at := time.Now().UTC().Format(time.RFC3339Nano)
+		sig, err := kp.Sign(adminSigned(msg.Subject, at, msg.Data))
+		if err != nil {
+			log.Fatalf("Error signing command: %v", err)
+		}
+		msg.Header.Set(adminTimeHeader, at)
+		msg.Header.Set(signatureHeader, base64.RawURLEncoding.EncodeToString(sig))
+	}
+	if err := nc.PublishMsg(msg); err != nil {
+		log.Fatal(err)
+	}
+
+	replies := 0
+	until := time.Now().Add(*wait)
+	for {
+		msg, err := sub.NextMsg(time.Until(until))
+		if err != nil {
+			break
+		}
+		replies++
+		fmt.Println(string(msg.Data))
+	}
+	if replies == 0 {
+		log.Fatalf("No replies on %q", *prefix+"."+cmd)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+)
+
+func TestCheckAdmin(t *testing.T) {
+	kp, err := nkeys.CreateServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, _ := kp.PublicKey()
+	key, err := nkeys.FromPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// command returns a config command signed by signer at time at.
+	command := func(signer nkeys.KeyPair, at time.Time) *nats.Msg {
+		m := nats.NewMsg(defaultControlPrefix + ".config")
+		when := at.UTC().Format(time.RFC3339Nano)
+		sig, err := signer.Sign(adminSigned(m.Subject, when, m.Data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.Header.Set(adminTimeHeader, when)
+		m.Header.Set(signatureHeader, base64.RawURLEncoding.EncodeToString(sig))
+		return m
+	}
+
+	if err := checkAdmin(key, command(kp, time.Now())); err != nil {
+		t.Fatalf("signed command refused: %v", err)
+	}
+	if err := checkAdmin(key, nats.NewMsg(defaultControlPrefix+".config")); err == nil {
+		t.Fatal("unsigned command accepted")
+	}
+	if err := checkAdmin(key, command(kp, time.Now().Add(-2*maxSignatureAge))); err == nil {
+		t.Fatal("stale command accepted")
+	}
+	other, _ := nkeys.CreateServer()
+	if err := checkAdmin(key, command(other, time.Now())); err == nil {
+		t.Fatal("command signed by another key accepted")
+	}
+	// The signature covers the subject, so it cannot be replayed on another.
+	m := command(kp, time.Now())
+	m.Subject = defaultControlPrefix + ".cancel"
+	if err := checkAdmin(key, m); err == nil {
+		t.Fatal("command moved to another subject accepted")
+	}
+	if err := checkAdmin(nil, nats.NewMsg(defaultControlPrefix+".config")); err != nil {
+		t.Fatalf("unsigned command refused without a key: %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// archiveWriter unpacks a tar.gz stream into a local directory.
+type archiveWriter struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func newArchiveWriter(dir string) *archiveWriter {
+	pr, pw := io.Pipe()
+	aw := &archiveWriter{pw: pw, done: make(chan error, 1)}
+	go func() {
+		err := unpack(pr, dir)
+		pr.CloseWithError(err)
+		aw.done <- err
+	}()
+	return aw
+}
+
+func (aw *archiveWriter) Write(data []byte) (int, error) {
+	return aw.pw.Write(data)
+}
+
+func (aw *archiveWriter) Close() error {
+	aw.pw.Close()
+	return <-aw.done
+}
+
+// unpack extracts directories and regular files, reporting any other
+// entries, such as symlinks, as skipped.
+func unpack(r io.Reader, dir string) error {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name, err := localPath(dir, hdr.Name)
+		if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(name, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+				return err
+			}
+			fd, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, hdr.FileInfo().Mode().Perm())
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(fd, tr)
+			if cerr := fd.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return err
+			}
+			if err := os.Chtimes(name, hdr.ModTime, hdr.ModTime); err != nil {
+				return err
+			}
+		case tar.TypeSymlink, tar.TypeLink:
+			log.Printf("Skipping link %q -> %q", hdr.Name, hdr.Linkname)
+		default:
+			log.Printf("Skipping %q of unsupported type %q", hdr.Name, hdr.Typeflag)
+		}
+	}
+}
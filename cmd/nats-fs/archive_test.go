@@ -0,0 +1,64 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testArchive(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, hdr := range []*tar.Header{
+		{Name: "d/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: mtime},
+		{Name: "d/a.txt", Typeflag: tar.TypeReg, Mode: 0644, Size: 5, ModTime: mtime},
+		{Name: "d/link", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd", ModTime: mtime},
+	} {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Size > 0 {
+			tw.Write([]byte("hello"))
+		}
+	}
+	tw.Close()
+	gw.Close()
+	return buf.Bytes()
+}
+
+func TestUnpack(t *testing.T) {
+	dir := t.TempDir()
+	aw := newArchiveWriter(dir)
+	if _, err := aw.Write(testArchive(t)); err != nil {
+		t.Fatal(err)
+	}
+	if err := aw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "d", "a.txt"))
+	if err != nil || string(data) != "hello" {
+		t.Fatalf("d/a.txt = %q, %v", data, err)
+	}
+	if fi, _ := os.Stat(filepath.Join(dir, "d", "a.txt")); !fi.ModTime().Equal(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Errorf("d/a.txt mtime %v", fi.ModTime())
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "d", "link")); !os.IsNotExist(err) {
+		t.Errorf("symlink was extracted: %v", err)
+	}
+}
+
+func TestUnpackTruncated(t *testing.T) {
+	data := testArchive(t)
+	aw := newArchiveWriter(t.TempDir())
+	aw.Write(data[:len(data)/2])
+	if err := aw.Close(); err == nil {
+		t.Fatal("truncated archive unpacked without an error")
+	}
+}
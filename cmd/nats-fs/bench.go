@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/derekcollison/nats-fs/natshttp"
+	"github.com/nats-io/nats.go"
+)
+
+// Path of the synthetic file bench serves itself.
+const benchPath = "/bench"
+
+// runBench runs concurrent downloads and reports throughput and latency.
+// Without a subject it serves a synthetic file itself, so the chunk size
+// and window can be tuned.
+func runBench(args []string) {
+	flags := newFlagSet("bench", "[subject path]")
+	conn := addConnFlags(flags)
+	rf := addRequestFlags(flags)
+	size := flags.String("size", "16MB", "Size of the file served when no subject is given")
+	concurrency := flags.Int("c", 4, "Number of concurrent downloads")
+	duration := flags.Duration("duration", 10*time.Second, "How long to run")
+	count := flags.Int("n", 0, "Stop after this many downloads, 0 to run for -duration")
+	chunk := flags.String("chunk-size", "0", "Chunk size when serving, 0 for the default")
+	window := flags.String("window", "0", "Flow control window when serving, 0 for the default")
+	args = requestArgs(flags, rf, args, 0, 2)
+	if len(args) == 1 {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	nc := conn.connect("NATS HTTP File Server Bench")
+	defer nc.Close()
+
+	subj, upath := "", benchPath
+	if len(args) == 2 {
+		subj, upath = args[0], args[1]
+	} else {
+		n, err := parseSize(*size)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts := &natshttp.Options{}
+		if opts.ChunkSize, err = parseSizeInt(*chunk); err != nil {
+			log.Fatal(err)
+		}
+		if opts.WindowSize, err = parseSizeInt(*window); err != nil {
+			log.Fatal(err)
+		}
+		subj = nats.NewInbox()
+		if _, err := natshttp.Handle(nc, subj, benchHandler(n), opts); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Serving %s on %s", formatBytes(n), subj)
+	}
+
+	var (
+		mu        sync.Mutex
+		total     []time.Duration
+		firstByte []time.Duration
+		errs      int
+		bytes     int64
+		started   int64
+	)
+	start := time.Now()
+	stop := start.Add(*duration)
+	var wg sync.WaitGroup
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for time.Now().Before(stop) {
+				if *count > 0 && atomic.AddInt64(&started, 1) > int64(*count) {
+					return
+				}
+				tfb, tt, n, err := benchGet(nc, subj, upath)
+				mu.Lock()
+				bytes += int64(n)
+				if err != nil {
+					errs++
+					if errs <= 10 {
+						log.Printf("Error: %v", err)
+					}
+				} else {
+					firstByte = append(firstByte, tfb)
+					total = append(total, tt)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	fmt.Printf("Downloads:   %d ok, %d errors, %d retransmits in %v\n", len(total), errs, retransmits(), elapsed.Round(time.Millisecond))
+	fmt.Printf("Throughput:  %s/s, %.1f downloads/s\n", formatBytes(int64(float64(bytes)/elapsed.Seconds())), float64(len(total))/elapsed.Seconds())
+	fmt.Printf("First byte:  %s\n", percentiles(firstByte))
+	fmt.Printf("Total:       %s\n", percentiles(total))
+}
+
+// benchGet downloads upath, discarding it, and returns the time to the
+// first byte, the total time and the bytes received.
+func benchGet(nc *nats.Conn, subj, upath string) (time.Duration, time.Duration, int, error) {
+	start := time.Now()
+	resp, err := sendRequest(nc, newRequest(subj, upath))
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	tfb := time.Since(start)
+	if err := checkStatus(resp); err != nil {
+		return 0, 0, 0, err
+	}
+	defer resp.Body.Close()
+	n, err := readBody(resp, io.Discard)
+	return tfb, time.Since(start), n, err
+}
+
+// benchHandler serves size zero bytes.
+func benchHandler(size int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "bench", time.Time{}, io.NewSectionReader(zeroReader{}, 0, size))
+	})
+}
+
+type zeroReader struct{}
+
+func (zeroReader) ReadAt(p []byte, off int64) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+// percentiles formats the p50, p90, p99 and max of the durations.
+func percentiles(d []time.Duration) string {
+	if len(d) == 0 {
+		return "-"
+	}
+	sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+	p := func(q float64) time.Duration {
+		return d[int(q*float64(len(d)-1))].Round(time.Microsecond)
+	}
+	return fmt.Sprintf("p50 %v  p90 %v  p99 %v  max %v", p(0.5), p(0.9), p(0.99), d[len(d)-1].Round(time.Microsecond))
+}
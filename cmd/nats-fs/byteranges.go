@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+
+	"github.com/derekcollison/nats-fs/client"
+)
+
+// rangesWriter writes a multipart/byteranges body into a local file, each
+// part at the offset given by its Content-Range, so the file ends up with
+// the ranges in place and holes in between.
+type rangesWriter struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+// isByteranges reports whether a response carries several ranges and
+// returns the multipart boundary.
+func isByteranges(contentType string) (string, bool) {
+	mt, params, err := mime.ParseMediaType(contentType)
+	if err != nil || mt != "multipart/byteranges" || params["boundary"] == "" {
+		return "", false
+	}
+	return params["boundary"], true
+}
+
+func newRangesWriter(fd *os.File, boundary string) *rangesWriter {
+	pr, pw := io.Pipe()
+	rw := &rangesWriter{pw: pw, done: make(chan error, 1)}
+	go func() {
+		err := writeRanges(multipart.NewReader(pr, boundary), fd)
+		pr.CloseWithError(err)
+		rw.done <- err
+	}()
+	return rw
+}
+
+func (rw *rangesWriter) Write(data []byte) (int, error) {
+	return rw.pw.Write(data)
+}
+
+func (rw *rangesWriter) Close() error {
+	rw.pw.Close()
+	return <-rw.done
+}
+
+func writeRanges(mr *multipart.Reader, fd *os.File) error {
+	defer fd.Close()
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		start, end, err := parseContentRange(part.Header)
+		if err != nil {
+			return err
+		}
+		n, err := io.Copy(io.NewOffsetWriter(fd, start), part)
+		if err != nil {
+			return err
+		}
+		if n != end-start+1 {
+			return fmt.Errorf("%w, range %d-%d has %d bytes", client.ErrIncomplete, start, end, n)
+		}
+	}
+}
+
+// parseContentRange returns the first and last byte of a part.
+func parseContentRange(h textproto.MIMEHeader) (int64, int64, error) {
+	cr := h.Get("Content-Range")
+	var start, end int64
+	var size string
+	if _, err := fmt.Sscanf(cr, "bytes %d-%d/%s", &start, &end, &size); err != nil || end < start {
+		return 0, 0, fmt.Errorf("bad Content-Range %q", cr)
+	}
+	return start, end, nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"expvar"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache counters, published with expvar on the HTTP listener.
+var (
+	cacheHits   = expvar.NewInt("cache_hits")
+	cacheMisses = expvar.NewInt("cache_misses")
+	cacheBytes  = expvar.NewInt("cache_bytes")
+)
+
+// fileCache is an LRU cache of small file contents. Entries are
+// invalidated when the file's mtime or size changes.
+type fileCache struct {
+	sync.Mutex
+	maxObject int64
+	maxTotal  int64
+	size      int64
+	ll        *list.List
+	items     map[string]*list.Element
+}
+
+type cacheEntry struct {
+	name    string
+	modTime time.Time
+	data    []byte
+}
+
+func newFileCache(maxTotal, maxObject int64) *fileCache {
+	if maxTotal <= 0 || maxObject <= 0 {
+		return nil
+	}
+	return &fileCache{maxObject: maxObject, maxTotal: maxTotal, ll: list.New(), items: make(map[string]*list.Element)}
+}
+
+// get returns the cached contents of name if still current.
+func (c *fileCache) get(name string, fi os.FileInfo) ([]byte, bool) {
+	c.Lock()
+	defer c.Unlock()
+	e, ok := c.items[name]
+	if !ok {
+		return nil, false
+	}
+	ce := e.Value.(*cacheEntry)
+	if !ce.modTime.Equal(fi.ModTime()) || int64(len(ce.data)) != fi.Size() {
+		c.remove(e)
+		return nil, false
+	}
+	c.ll.MoveToFront(e)
+	return ce.data, true
+}
+
+func (c *fileCache) add(name string, modTime time.Time, data []byte) {
+	c.Lock()
+	defer c.Unlock()
+	if e, ok := c.items[name]; ok {
+		c.remove(e)
+	}
+	c.items[name] = c.ll.PushFront(&cacheEntry{name: name, modTime: modTime, data: data})
+	c.size += int64(len(data))
+	cacheBytes.Add(int64(len(data)))
+	for c.size > c.maxTotal {
+		c.remove(c.ll.Back())
+	}
+}
+
+// Lock held.
+func (c *fileCache) remove(e *list.Element) {
+	ce := c.ll.Remove(e).(*cacheEntry)
+	delete(c.items, ce.name)
+	c.size -= int64(len(ce.data))
+	cacheBytes.Add(-int64(len(ce.data)))
+}
+
+// serveCached serves small regular files from the cache, reporting false
+// if the file should be served normally.
+func (c *fileCache) serveCached(w http.ResponseWriter, r *http.Request, file string) bool {
+	if c == nil || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+		return false
+	}
+	fi, err := os.Stat(file)
+	if err != nil || !fi.Mode().IsRegular() || fi.Size() > c.maxObject {
+		return false
+	}
+	data, ok := c.get(file, fi)
+	if ok {
+		cacheHits.Add(1)
+	} else {
+		cacheMisses.Add(1)
+		if data, err = os.ReadFile(file); err != nil || int64(len(data)) > c.maxObject {
+			return false
+		}
+		c.add(file, fi.ModTime(), data)
+	}
+	http.ServeContent(w, r, fi.Name(), fi.ModTime(), bytes.NewReader(data))
+	return true
+}
+
+// parseSize parses sizes like "64MB", "512K" or "1048576".
+func parseSize(s string) (int64, error) {
+	v := strings.ToUpper(strings.TrimSpace(s))
+	v = strings.TrimSuffix(v, "B")
+	v = strings.TrimSuffix(v, "I")
+	mult := int64(1)
+	if n := len(v); n > 0 {
+		switch v[n-1] {
+		case 'K':
+			mult = 1024
+		case 'M':
+			mult = 1024 * 1024
+		case 'G':
+			mult = 1024 * 1024 * 1024
+		}
+		if mult > 1 {
+			v = v[:n-1]
+		}
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * mult, nil
+}
+
+// parseSizeInt is parseSize for sizes held in an int.
+func parseSizeInt(s string) (int, error) {
+	n, err := parseSize(s)
+	return int(n), err
+}
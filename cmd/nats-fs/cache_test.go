@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileCache(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "a.txt")
+	os.WriteFile(file, []byte("first"), 0644)
+	c := newFileCache(10, 8)
+
+	get := func(file string) string {
+		rec := httptest.NewRecorder()
+		if !c.serveCached(rec, httptest.NewRequest(http.MethodGet, "/", nil), file) {
+			return "not cached"
+		}
+		return rec.Body.String()
+	}
+	if got := get(file); got != "first" {
+		t.Fatalf("served %q, want first", got)
+	}
+	if _, ok := c.items[file]; !ok {
+		t.Fatal("file not cached")
+	}
+
+	// A change to the file is served, not the stale entry.
+	os.WriteFile(file, []byte("second!"), 0644)
+	os.Chtimes(file, time.Now(), time.Now().Add(time.Second))
+	if got := get(file); got != "second!" {
+		t.Fatalf("served %q after a change, want second!", got)
+	}
+
+	// Files over the object limit are not cached.
+	big := filepath.Join(dir, "big.txt")
+	os.WriteFile(big, []byte("too big to cache"), 0644)
+	if got := get(big); got != "not cached" {
+		t.Fatalf("served %q, want the large file left alone", got)
+	}
+
+	// The least recently used entry goes to stay within the total.
+	other := filepath.Join(dir, "b.txt")
+	os.WriteFile(other, []byte("other"), 0644)
+	get(other)
+	if _, ok := c.items[file]; ok || c.size > c.maxTotal {
+		t.Fatalf("cache holds %d bytes with the old entry, want at most %d without it", c.size, c.maxTotal)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want int64
+	}{
+		{"0", 0},
+		{"512", 512},
+		{"512K", 512 << 10},
+		{"64MB", 64 << 20},
+		{"1GiB", 1 << 30},
+	} {
+		if got, err := parseSize(tc.in); err != nil || got != tc.want {
+			t.Errorf("parseSize(%q) = %d, %v, want %d", tc.in, got, err, tc.want)
+		}
+	}
+	if _, err := parseSize("lots"); err == nil {
+		t.Error("parseSize(lots) succeeded")
+	}
+}
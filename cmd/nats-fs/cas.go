@@ -0,0 +1,324 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// With -cas every file written is stored once per content, as a blob named
+// by its SHA-256, and each path is a hard link to its blob, so identical
+// uploads share storage. The store has to be on the same filesystem as the
+// served tree, and the tree should only be changed through the server.
+//
+// A PUT with Have-Sha256 and no body links the path to a blob we already
+// have, or is answered 404 so the client sends the body. A PUT with
+// Content-Sha256 has the body checked against it.
+const (
+	haveSHA256Header    = "Have-Sha256"
+	contentSHA256Header = "Content-Sha256"
+)
+
+// casStore keeps the blobs and which paths link to them. Nil stores
+// nothing. The index is a snapshot plus a log of the changes since, the
+// log compacted into the snapshot when the store is opened.
+type casStore struct {
+	dir string
+	mu  sync.Mutex
+	// Hash of the blob each linked file is, by file path.
+	index map[string]string
+	// Paths linking to each blob, by hash.
+	refs map[string]map[string]bool
+	log  *os.File
+	// Changes logged since the snapshot.
+	logged int
+}
+
+// Changes logged beyond the size of the index before it is compacted.
+const casCompactAfter = 1024
+
+// casChange is a line of the index log, an empty hash unlinks the path.
+type casChange struct {
+	Path string `json:"path"`
+	Hash string `json:"hash,omitempty"`
+}
+
+func openCAS(dir string) (*casStore, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "blobs"), 0755); err != nil {
+		return nil, err
+	}
+	c := &casStore{dir: dir, index: map[string]string{}, refs: map[string]map[string]bool{}}
+	b, err := os.ReadFile(c.indexFile())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(b, &c.index); err != nil {
+			return nil, err
+		}
+	}
+	// Replay the changes since, a torn last line from a crash is dropped.
+	if fd, err := os.Open(c.logFile()); err == nil {
+		dec := json.NewDecoder(fd)
+		for {
+			var ch casChange
+			if err := dec.Decode(&ch); err != nil {
+				break
+			}
+			if ch.Hash == "" {
+				delete(c.index, ch.Path)
+			} else {
+				c.index[ch.Path] = ch.Hash
+			}
+		}
+		fd.Close()
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	for p, h := range c.index {
+		c.ref(p, h)
+	}
+	if err := c.saveLocked(); err != nil {
+		return nil, err
+	}
+	if c.log, err = os.OpenFile(c.logFile(), os.O_CREATE|os.O_WRONLY|os.O_TRUNC|os.O_APPEND, 0644); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *casStore) indexFile() string { return filepath.Join(c.dir, "index.json") }
+func (c *casStore) logFile() string   { return filepath.Join(c.dir, "index.log") }
+
+func (c *casStore) blob(hash string) string {
+	return filepath.Join(c.dir, "blobs", hash[:2], hash)
+}
+
+func (c *casStore) ref(p, hash string) {
+	if c.refs[hash] == nil {
+		c.refs[hash] = map[string]bool{}
+	}
+	c.refs[hash][p] = true
+}
+
+// setLocked links p to hash in the index, or unlinks it for an empty hash,
+// and logs the change. It returns the hash p linked to before. Lock should
+// be held.
+func (c *casStore) setLocked(p, hash string) (string, error) {
+	old, ok := c.index[p]
+	if ok {
+		delete(c.refs[old], p)
+		if len(c.refs[old]) == 0 {
+			delete(c.refs, old)
+		}
+	}
+	if hash == "" {
+		delete(c.index, p)
+	} else {
+		c.index[p] = hash
+		c.ref(p, hash)
+	}
+	b, err := json.Marshal(casChange{Path: p, Hash: hash})
+	if err != nil {
+		return old, err
+	}
+	if _, err := c.log.Write(append(b, '\n')); err != nil {
+		return old, err
+	}
+	if c.logged++; c.logged > len(c.index)+casCompactAfter {
+		return old, c.compactLocked()
+	}
+	return old, nil
+}
+
+// compactLocked writes the snapshot and empties the log. Lock should be
+// held.
+func (c *casStore) compactLocked() error {
+	if err := c.saveLocked(); err != nil {
+		return err
+	}
+	c.logged = 0
+	return c.log.Truncate(0)
+}
+
+// validHash reports whether h is a hex SHA-256 as we name blobs.
+func validHash(h string) bool {
+	if len(h) != sha256.Size*2 || strings.ToLower(h) != h {
+		return false
+	}
+	_, err := hex.DecodeString(h)
+	return err == nil
+}
+
+// hashFile returns the hex SHA-256 of the file at p.
+func hashFile(p string) (string, error) {
+	fd, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer fd.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, fd); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// size returns the size of the blob for hash, an error if we do not have
+// it. Only blobs linked to from under root count, so tenants can neither
+// learn of nor link to each other's content.
+func (c *casStore) size(hash, root string) (int64, error) {
+	if c == nil || !validHash(hash) || !c.linkedUnder(hash, root) {
+		return 0, os.ErrNotExist
+	}
+	fi, err := os.Stat(c.blob(hash))
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+// linkedUnder reports whether a file under root links to the blob for hash.
+func (c *casStore) linkedUnder(hash, root string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for p := range c.refs[hash] {
+		if under(p, root) {
+			return true
+		}
+	}
+	return false
+}
+
+// store makes the file at src the blob for hash, or drops it if we have
+// that blob already, then links file to the blob.
+func (c *casStore) store(src, hash, file string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	blob := c.blob(hash)
+	if _, err := os.Stat(blob); err == nil {
+		os.Remove(src)
+	} else {
+		if err := os.MkdirAll(filepath.Dir(blob), 0755); err != nil {
+			return err
+		}
+		if err := moveFile(src, blob); err != nil {
+			return err
+		}
+	}
+	return c.linkLocked(hash, file)
+}
+
+// link points file at the blob for hash, which must be linked to from
+// under root.
+func (c *casStore) link(hash, root, file string) error {
+	if _, err := c.size(hash, root); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.linkLocked(hash, file)
+}
+
+// linkLocked replaces file with a link to the blob for hash. Lock should
+// be held.
+func (c *casStore) linkLocked(hash, file string) error {
+	tmp := file + ".nats-fs-tmp"
+	os.Remove(tmp)
+	if err := os.Link(c.blob(hash), tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	old, err := c.setLocked(file, hash)
+	c.dropLocked(old)
+	return err
+}
+
+// removed forgets file and anything under it, dropping blobs no longer
+// linked to.
+func (c *casStore) removed(file string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for p := range c.index {
+		if under(p, file) {
+			old, _ := c.setLocked(p, "")
+			c.dropLocked(old)
+		}
+	}
+}
+
+// moved renames the entries for src and anything under it to dst.
+func (c *casStore) moved(src, dst string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	moves := map[string]string{}
+	for p, h := range c.index {
+		if under(p, src) {
+			moves[p] = h
+		}
+	}
+	for p, h := range moves {
+		c.setLocked(p, "")
+		c.setLocked(dst+p[len(src):], h)
+	}
+}
+
+// copied links the copies of files under src, made by copyTree, back to
+// their blobs.
+func (c *casStore) copied(src, dst string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	links := map[string]string{}
+	for p, h := range c.index {
+		if under(p, src) {
+			links[dst+p[len(src):]] = h
+		}
+	}
+	for p, h := range links {
+		c.linkLocked(h, p)
+	}
+}
+
+// dropLocked removes the blob for hash if nothing links to it. Lock
+// should be held.
+func (c *casStore) dropLocked(hash string) {
+	if hash == "" || len(c.refs[hash]) > 0 {
+		return
+	}
+	os.Remove(c.blob(hash))
+}
+
+// saveLocked writes the whole index as the snapshot. Lock should be held.
+func (c *casStore) saveLocked() error {
+	b, err := json.Marshal(c.index)
+	if err != nil {
+		return err
+	}
+	tmp := c.indexFile() + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.indexFile())
+}
+
+// under reports whether p is dir or inside it.
+func under(p, dir string) bool {
+	return p == dir || strings.HasPrefix(p, dir+string(filepath.Separator))
+}
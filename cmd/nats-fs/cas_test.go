@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCASTenants(t *testing.T) {
+	dir := t.TempDir()
+	c, err := openCAS(filepath.Join(dir, "cas"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootA, rootB := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	for _, d := range []string{rootA, rootB} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data := []byte("tenant a's secret")
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	src := filepath.Join(dir, "upload")
+	if err := os.WriteFile(src, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.store(src, hash, filepath.Join(rootA, "f")); err != nil {
+		t.Fatalf("store: %v", err)
+	}
+
+	if n, err := c.size(hash, rootA); err != nil || n != int64(len(data)) {
+		t.Fatalf("size for a = %d, %v, want %d", n, err, len(data))
+	}
+	if _, err := c.size(hash, rootB); !os.IsNotExist(err) {
+		t.Fatalf("size for b = %v, want not found", err)
+	}
+	if err := c.link(hash, rootB, filepath.Join(rootB, "f")); !os.IsNotExist(err) {
+		t.Fatalf("link for b = %v, want not found", err)
+	}
+	if err := c.link(hash, rootA, filepath.Join(rootA, "g")); err != nil {
+		t.Fatalf("link for a: %v", err)
+	}
+}
+
+func TestCASReopen(t *testing.T) {
+	dir := t.TempDir()
+	casDir := filepath.Join(dir, "cas")
+	c, err := openCAS(casDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, file := filepath.Join(dir, "upload"), filepath.Join(dir, "f")
+	if err := os.WriteFile(src, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	hash := hex.EncodeToString(make([]byte, sha256.Size))
+	if err := c.store(src, hash, file); err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	c.moved(file, filepath.Join(dir, "g"))
+	c.log.Close()
+
+	// The logged changes are replayed.
+	if c, err = openCAS(casDir); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.index[filepath.Join(dir, "g")]; got != hash {
+		t.Fatalf("index has %q for the moved file, want %q", got, hash)
+	}
+	c.removed(filepath.Join(dir, "g"))
+	if _, err := os.Stat(c.blob(hash)); !os.IsNotExist(err) {
+		t.Fatalf("blob linked to by nothing was kept: %v", err)
+	}
+}
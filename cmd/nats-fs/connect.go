@@ -0,0 +1,37 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+)
+
+// runConnect opens a duplex session with a handler that takes over the
+// response, like netcat: stdin is sent to it and what it sends back goes
+// to stdout, until it ends the session. nats-fs serve follows the file at
+// path, taking a filter expression per line of stdin.
+func runConnect(args []string) {
+	fs := newFlagSet("connect", "<subject> <path>")
+	conn := addConnFlags(fs)
+	rf := addRequestFlags(fs)
+	args = requestArgs(fs, rf, args, 2, 2)
+
+	nc := conn.connect("NATS HTTP Style Requestor")
+	defer nc.Close()
+
+	s, _, err := clientFor(nc).Dial(runCtx, args[0], args[1])
+	if err != nil {
+		fatal(err)
+	}
+	defer s.Close()
+
+	go func() {
+		if _, err := io.Copy(s, os.Stdin); err != nil {
+			log.Printf("Error sending: %v", err)
+		}
+		s.CloseWrite()
+	}()
+	if _, err := io.Copy(os.Stdout, s); err != nil {
+		fatal(err)
+	}
+}
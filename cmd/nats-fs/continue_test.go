@@ -0,0 +1,28 @@
+package main
+
+import (
+	"crypto/sha256"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWrappersPassContinue(t *testing.T) {
+	rec := httptest.NewRecorder()
+	sw := &statsWriter{ResponseWriter: rec}
+	gw := &signWriter{ResponseWriter: sw, sum: sha256.New()}
+	cw := &cacheControlWriter{ResponseWriter: gw, value: "max-age=60"}
+	ew := &errorPageWriter{ResponseWriter: cw, r: httptest.NewRequest(http.MethodPut, "/", nil)}
+
+	ew.WriteHeader(http.StatusContinue)
+	ew.WriteHeader(http.StatusCreated)
+	if sw.status != http.StatusCreated || gw.status != http.StatusCreated {
+		t.Fatalf("status recorded %d and signed %d, want 201", sw.status, gw.status)
+	}
+	if got := rec.Header().Get("Cache-Control"); got != "max-age=60" {
+		t.Fatalf("Cache-Control = %q, set on the final status", got)
+	}
+	if !ew.wroteHeader || !cw.wroteHeader {
+		t.Fatal("final status not written through")
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// corsConfig is the CORS policy for the HTTP listener.
+type corsConfig struct {
+	origins []string
+	methods string
+	headers string
+	expose  string
+	maxAge  int
+}
+
+// Nil if no origins are allowed.
+func newCorsConfig(origins []string, methods, headers, expose string, maxAge int) *corsConfig {
+	if len(origins) == 0 {
+		return nil
+	}
+	return &corsConfig{origins: origins, methods: methods, headers: headers, expose: expose, maxAge: maxAge}
+}
+
+func (c *corsConfig) allowOrigin(origin string) (string, bool) {
+	for _, o := range c.origins {
+		if o == "*" {
+			return "*", true
+		}
+		if strings.EqualFold(o, origin) {
+			return origin, true
+		}
+	}
+	return "", false
+}
+
+// wrap adds CORS headers to responses for allowed origins and answers
+// preflight requests itself.
+func (c *corsConfig) wrap(h http.Handler) http.Handler {
+	if c == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		hdr := w.Header()
+		hdr.Add("Vary", "Origin")
+		allowed, ok := c.allowOrigin(origin)
+		if !ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+		hdr.Set("Access-Control-Allow-Origin", allowed)
+
+		// Preflight.
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			hdr.Add("Vary", "Access-Control-Request-Method")
+			hdr.Add("Vary", "Access-Control-Request-Headers")
+			hdr.Set("Access-Control-Allow-Methods", c.methods)
+			if c.headers != "" {
+				hdr.Set("Access-Control-Allow-Headers", c.headers)
+			} else if req := r.Header.Get("Access-Control-Request-Headers"); req != "" {
+				hdr.Set("Access-Control-Allow-Headers", req)
+			}
+			if c.maxAge > 0 {
+				hdr.Set("Access-Control-Max-Age", strconv.Itoa(c.maxAge))
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if c.expose != "" {
+			hdr.Set("Access-Control-Expose-Headers", c.expose)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
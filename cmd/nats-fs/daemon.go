@@ -0,0 +1,120 @@
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Supervisors are told how serve is doing: systemd through NOTIFY_SOCKET
+// when run with Type=notify, and pinged every half WatchdogSec while
+// healthy, anything else through the PID file.
+//
+//	[Service]
+//	Type=notify
+//	WatchdogSec=30
+//	ExecStart=/usr/local/bin/nats-fs serve -pid-file /run/nats-fs.pid /srv/files
+
+// serviceStop is closed to have serve shut down, by the Windows service
+// manager.
+var serviceStop = make(chan struct{})
+
+type daemon struct {
+	pidFile string
+	done    chan struct{}
+}
+
+// startDaemon writes the PID file and tells systemd we are ready, pinging
+// its watchdog while healthy returns nil.
+func startDaemon(pidFile string, healthy func() error) (*daemon, error) {
+	d := &daemon{pidFile: pidFile, done: make(chan struct{})}
+	if pidFile != "" {
+		if err := os.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+			return nil, err
+		}
+	}
+	if err := sdNotify("READY=1\nSTATUS=Serving"); err != nil {
+		log.Printf("Error notifying systemd: %v", err)
+	}
+	if iv := watchdogInterval(); iv > 0 {
+		go d.watchdog(iv/2, healthy)
+	}
+	return d, nil
+}
+
+func (d *daemon) watchdog(iv time.Duration, healthy func() error) {
+	t := time.NewTicker(iv)
+	defer t.Stop()
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-t.C:
+		}
+		// Missing pings has systemd restart us.
+		if err := healthy(); err != nil {
+			log.Printf("Unhealthy, not pinging the watchdog: %v", err)
+			continue
+		}
+		sdNotify("WATCHDOG=1")
+	}
+}
+
+// stop tells systemd we are stopping and removes the PID file.
+func (d *daemon) stop() {
+	close(d.done)
+	sdNotify("STOPPING=1")
+	if d.pidFile != "" {
+		os.Remove(d.pidFile)
+	}
+}
+
+// sdNotify sends state to systemd, if it is listening.
+func sdNotify(state string) error {
+	sock := os.Getenv("NOTIFY_SOCKET")
+	if sock == "" {
+		return nil
+	}
+	// Abstract socket.
+	if strings.HasPrefix(sock, "@") {
+		sock = "\x00" + sock[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: sock, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// watchdogInterval is how often systemd expects to hear from us, 0 if not.
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// waitForStop blocks until we are interrupted, terminated or stopped as a
+// service.
+func waitForStop() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	select {
+	case s := <-sigs:
+		log.Printf("Received %v, shutting down", s)
+	case <-serviceStop:
+		log.Printf("Service stopping, shutting down")
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/derekcollison/nats-fs/delta"
+)
+
+// Leave room in the request for headers.
+const deltaHeaderRoom = 4 * 1024
+
+// deltaSignature computes the signature for our current copy, growing
+// the block size until it fits in a single message.
+func deltaSignature(fd *os.File, maxPayload int64) (*delta.Signature, error) {
+	fi, err := fd.Stat()
+	if err != nil {
+		return nil, err
+	}
+	for bs := delta.BlockSizeFor(fi.Size()); ; bs *= 2 {
+		if bs > delta.MaxBlockSize {
+			bs = delta.MaxBlockSize
+		}
+		if _, err := fd.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		sig, err := delta.NewSignature(fd, bs)
+		if err != nil {
+			return nil, err
+		}
+		if int64(sig.EncodedSize()) <= maxPayload-deltaHeaderRoom || bs >= delta.MaxBlockSize {
+			return sig, nil
+		}
+	}
+}
+
+// deltaWriter applies a delta stream against the basis file, writing the
+// result to a temporary file that replaces output on Close.
+type deltaWriter struct {
+	pw     *io.PipeWriter
+	tmp    *os.File
+	output string
+	done   chan error
+}
+
+func newDeltaWriter(basis *os.File, blockSize int, output string) (*deltaWriter, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(output), ".nats-fs-delta-*")
+	if err != nil {
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	dw := &deltaWriter{pw: pw, tmp: tmp, output: output, done: make(chan error, 1)}
+	go func() {
+		err := delta.Apply(basis, blockSize, pr, tmp)
+		pr.CloseWithError(err)
+		dw.done <- err
+	}()
+	return dw, nil
+}
+
+func (dw *deltaWriter) Write(data []byte) (int, error) {
+	return dw.pw.Write(data)
+}
+
+func (dw *deltaWriter) Close() error {
+	dw.pw.Close()
+	err := <-dw.done
+	if cerr := dw.tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dw.tmp.Name())
+		return err
+	}
+	return os.Rename(dw.tmp.Name(), dw.output)
+}
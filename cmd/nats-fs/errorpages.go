@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/derekcollison/nats-fs/natshttp"
+)
+
+// errorPage is a custom response body for an error status. Files ending in
+// .tmpl are Go templates, HTML templates if the name before that is .html.
+type errorPage struct {
+	contentType string
+	body        []byte
+	tmpl        interface {
+		Execute(io.Writer, interface{}) error
+	}
+}
+
+// Data available to error page templates.
+type errorPageData struct {
+	Status     int
+	StatusText string
+	Method     string
+	Path       string
+	RequestID  string
+	Request    *http.Request
+}
+
+func loadErrorPage(file string) (*errorPage, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	name := strings.TrimSuffix(file, ".tmpl")
+	ep := &errorPage{contentType: mime.TypeByExtension(filepath.Ext(name))}
+	if ep.contentType == "" {
+		ep.contentType = "text/plain; charset=utf-8"
+	}
+	switch {
+	case name == file:
+		ep.body = data
+	case strings.HasSuffix(name, ".html") || strings.HasSuffix(name, ".htm"):
+		if ep.tmpl, err = htmltemplate.New(filepath.Base(file)).Parse(string(data)); err != nil {
+			return nil, err
+		}
+	default:
+		if ep.tmpl, err = texttemplate.New(filepath.Base(file)).Parse(string(data)); err != nil {
+			return nil, err
+		}
+	}
+	return ep, nil
+}
+
+func (ep *errorPage) render(r *http.Request, code int) []byte {
+	if ep.tmpl == nil {
+		return ep.body
+	}
+	var buf bytes.Buffer
+	data := &errorPageData{Status: code, StatusText: http.StatusText(code), Method: r.Method, Path: r.URL.Path, RequestID: natshttp.RequestIDOf(r), Request: r}
+	if err := ep.tmpl.Execute(&buf, data); err != nil {
+		return []byte(fmt.Sprintf("%d %s", code, http.StatusText(code)))
+	}
+	return buf.Bytes()
+}
+
+// errorPages maps status codes to custom pages. It is a flag.Value taking
+// repeated CODE=FILE arguments.
+type errorPages map[int]*errorPage
+
+func (ep errorPages) String() string {
+	return ""
+}
+
+func (ep errorPages) Set(v string) error {
+	code, file, ok := strings.Cut(v, "=")
+	if !ok {
+		return fmt.Errorf("expected CODE=FILE, got %q", v)
+	}
+	c, err := strconv.Atoi(code)
+	if err != nil || c < 400 || c > 599 {
+		return fmt.Errorf("invalid error status %q", code)
+	}
+	page, err := loadErrorPage(file)
+	if err != nil {
+		return err
+	}
+	ep[c] = page
+	return nil
+}
+
+// wrap replaces the bodies of error responses from h with our pages.
+func (ep errorPages) wrap(h http.HandlerFunc) http.HandlerFunc {
+	if len(ep) == 0 {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		h(&errorPageWriter{ResponseWriter: w, r: r, pages: ep}, r)
+	}
+}
+
+// errorPageWriter swaps in our page when an error status is written and
+// discards the handler's own body.
+type errorPageWriter struct {
+	http.ResponseWriter
+	r           *http.Request
+	pages       errorPages
+	wroteHeader bool
+	discard     bool
+}
+
+func (w *errorPageWriter) WriteHeader(code int) {
+	if code < 200 {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if w.wroteHeader {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.wroteHeader = true
+	page := w.pages[code]
+	if page == nil {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	body := page.render(w.r, code)
+	h := w.Header()
+	h.Del("Content-Encoding")
+	h.Set("Content-Type", page.contentType)
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	w.ResponseWriter.WriteHeader(code)
+	if w.r.Method != http.MethodHead {
+		w.ResponseWriter.Write(body)
+	}
+	w.discard = true
+}
+
+func (w *errorPageWriter) Write(data []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.discard {
+		return len(data), nil
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// ReadFrom keeps the underlying writer's fast path.
+func (w *errorPageWriter) ReadFrom(r io.Reader) (int64, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.discard {
+		return io.Copy(io.Discard, r)
+	}
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(struct{ io.Writer }{w.ResponseWriter}, r)
+}
+
+func (w *errorPageWriter) Flush() {
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *errorPageWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
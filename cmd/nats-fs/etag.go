@@ -0,0 +1,16 @@
+package main
+
+import (
+	"os"
+	"strconv"
+)
+
+// fileETag is a strong validator for a file from its modification time in
+// nanoseconds and size. Unlike Last-Modified it changes on writes within
+// the same second, so an If-Range resume never stitches two versions. GET,
+// HEAD and STAT all send it. Replicas only agree on it, and so serve
+// ranges of the same download, when copies keep their modification times,
+// as with rsync -t.
+func fileETag(fi os.FileInfo) string {
+	return `"` + strconv.FormatInt(fi.ModTime().UnixNano(), 36) + "-" + strconv.FormatInt(fi.Size(), 36) + `"`
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/derekcollison/nats-fs/natshttp"
+)
+
+// How often a followed file is checked for growth.
+const followInterval = 250 * time.Millisecond
+
+// Longest line held back waiting for its newline, longer ones are sent as is.
+const maxFollowLine = 64 * 1024
+
+func isFollowRequest(r *http.Request) bool {
+	return r.Header.Get("Upgrade") == natshttp.DuplexProtocol
+}
+
+// serveFollow takes over the response to send lines appended to file from
+// now on, like tail -f, as a duplex session for nats-fs connect. Each line
+// the requester sends is a regular expression lines must match from then
+// on, an empty line matches everything again. The session ends when the
+// requester goes away.
+func serveFollow(w http.ResponseWriter, r *http.Request, file string) {
+	if natshttp.Subject(r) == "" {
+		http.Error(w, "400 bad request, files are only followed over NATS", http.StatusBadRequest)
+		return
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		http.Error(w, "404 page not found", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil || !fi.Mode().IsRegular() {
+		http.Error(w, "404 page not found", http.StatusNotFound)
+		return
+	}
+	conn, _, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		natshttp.Logf(r, "Error taking over the response: %v", err)
+		http.Error(w, "500 internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer conn.Close()
+
+	var filter lineFilter
+	go filter.read(conn)
+
+	offset := fi.Size()
+	var pending []byte
+	buf := make([]byte, 32*1024)
+	tick := time.NewTicker(followInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-tick.C:
+		}
+		if fi, err := f.Stat(); err == nil && fi.Size() < offset {
+			// Truncated, start again from the top.
+			offset, pending = 0, pending[:0]
+		}
+		for {
+			n, err := f.ReadAt(buf, offset)
+			offset += int64(n)
+			pending = append(pending, buf[:n]...)
+			if err != nil || n == 0 {
+				break
+			}
+		}
+		for len(pending) > 0 {
+			i := bytes.IndexByte(pending, '\n')
+			if i < 0 && len(pending) < maxFollowLine {
+				break
+			}
+			line := pending
+			if i >= 0 {
+				line = pending[:i+1]
+			}
+			pending = pending[len(line):]
+			if !filter.match(line) {
+				continue
+			}
+			if _, err := conn.Write(line); err != nil {
+				return
+			}
+		}
+		pending = append([]byte(nil), pending...)
+	}
+}
+
+// lineFilter is the expression followed lines must match, as last sent by
+// the requester.
+type lineFilter struct {
+	mu sync.Mutex
+	re *regexp.Regexp
+}
+
+// read takes filters from the requester until it has nothing more to send.
+// A bad expression is reported back and leaves the filter as it was.
+func (lf *lineFilter) read(conn io.ReadWriter) {
+	sc := bufio.NewScanner(conn)
+	for sc.Scan() {
+		var re *regexp.Regexp
+		if expr := sc.Text(); expr != "" {
+			var err error
+			if re, err = regexp.Compile(expr); err != nil {
+				io.WriteString(conn, "nats-fs: bad filter: "+err.Error()+"\n")
+				continue
+			}
+		}
+		lf.mu.Lock()
+		lf.re = re
+		lf.mu.Unlock()
+	}
+}
+
+func (lf *lineFilter) match(line []byte) bool {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	return lf.re == nil || lf.re.Match(line)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/derekcollison/nats-fs/client"
+	"github.com/derekcollison/nats-fs/natsfstest"
+	"github.com/nats-io/nkeys"
+)
+
+// hijackRecorder is a ResponseRecorder that can be taken over.
+type hijackRecorder struct {
+	*httptest.ResponseRecorder
+	hijacked bool
+}
+
+func (w *hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.hijacked = true
+	return nil, nil, nil
+}
+
+func TestWrappersHijack(t *testing.T) {
+	inner := &hijackRecorder{ResponseRecorder: httptest.NewRecorder()}
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	var w http.ResponseWriter = inner
+	w = &statsWriter{ResponseWriter: w}
+	w = &signWriter{ResponseWriter: w, sum: sha256.New()}
+	w = &cacheControlWriter{ResponseWriter: w, value: "no-cache"}
+	w = &errorPageWriter{ResponseWriter: w, r: r}
+	if _, _, err := http.NewResponseController(w).Hijack(); err != nil {
+		t.Fatalf("Hijack through the serve chain: %v", err)
+	}
+	if !inner.hijacked {
+		t.Fatal("underlying writer not hijacked")
+	}
+}
+
+// filterConn reads filters from in and collects what is written back.
+type filterConn struct {
+	in  *strings.Reader
+	out bytes.Buffer
+}
+
+func (c *filterConn) Read(p []byte) (int, error)  { return c.in.Read(p) }
+func (c *filterConn) Write(p []byte) (int, error) { return c.out.Write(p) }
+
+func TestLineFilter(t *testing.T) {
+	var lf lineFilter
+	c := &filterConn{in: strings.NewReader("ERROR\n(\n")}
+	lf.read(c)
+	if !lf.match([]byte("ERROR disk full\n")) || lf.match([]byte("INFO started\n")) {
+		t.Fatal("filter ERROR not applied")
+	}
+	if !strings.Contains(c.out.String(), "bad filter") {
+		t.Fatalf("bad filter not reported, got %q", c.out.String())
+	}
+	lf.read(&filterConn{in: strings.NewReader("\n")})
+	if !lf.match([]byte("INFO started\n")) {
+		t.Fatal("empty filter does not match everything")
+	}
+}
+
+func TestFollow(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "app.log")
+	if err := os.WriteFile(file, []byte("INFO before\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	kp, err := nkeys.CreateServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Signed, so the session goes through a wrapped writer as in serve.
+	h := signResponses(kp)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveFollow(w, r, file)
+	}))
+	srv := natsfstest.NewServer(t, h)
+	c := client.New(srv.Connect(t))
+	s, _, err := c.Dial(context.Background(), srv.Subject, "/app.log")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer s.Close()
+
+	if _, err := s.Write([]byte("ERROR\n")); err != nil {
+		t.Fatal(err)
+	}
+	// Give the filter time to arrive before the lines do.
+	time.Sleep(2 * followInterval)
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("INFO skipped\nERROR kept\n")
+	f.Close()
+
+	line, err := bufio.NewReader(s).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "ERROR kept\n" {
+		t.Fatalf("followed %q, want the appended ERROR line", line)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	natsfs "github.com/derekcollison/nats-fs"
+	"github.com/derekcollison/nats-fs/natshttp"
+	"github.com/nats-io/nats.go"
+)
+
+const indexPage = "index.html"
+
+func isListRequest(r *http.Request) bool {
+	return r.Header.Get(natshttp.ListHeader) != ""
+}
+
+// storeHandler serves files, directory pages and listings from a
+// natsfs.Backend, whether the directory served, the zip archive given with
+// -zip, the object store given with -backend objects or an S3 bucket.
+// Everything it serves is read-only, writes to directories go through
+// writeConfig.
+type storeHandler struct {
+	b     natsfs.Backend
+	files http.Handler
+	// Whether directories without an index.html are listed, otherwise 403.
+	autoindex bool
+}
+
+func newStoreHandler(b natsfs.Backend, autoindex bool) *storeHandler {
+	return &storeHandler{b: b, files: natsfs.BackendHandler(b), autoindex: autoindex}
+}
+
+func (sh *storeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case isListRequest(r):
+		sh.serveList(w, r)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		r, ok := sh.resolveIndex(w, r)
+		if !ok {
+			return
+		}
+		name := natsfs.BackendName(r.URL.Path)
+		if fi, err := sh.b.Stat(r.Context(), name); err == nil && fi.IsDir() {
+			sh.serveDirList(w, r, name)
+			return
+		}
+		sh.files.ServeHTTP(w, r)
+	default:
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "405 method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// check reports whether the backend can still serve, for health checks.
+func (sh *storeHandler) check() error {
+	_, err := sh.b.Stat(context.Background(), ".")
+	return err
+}
+
+// openObjectStore opens the JetStream object store bucket.
+func openObjectStore(nc *nats.Conn, bucket string) (nats.ObjectStore, error) {
+	js, err := nc.JetStream()
+	if err != nil {
+		return nil, err
+	}
+	return js.ObjectStore(bucket)
+}
+
+// resolveIndex maps a directory request to its index.html if present,
+// redirecting first to the trailing slash so relative links work. Without
+// an index the directory is listed if autoindex is set, otherwise the
+// request is forbidden and false is returned.
+func (sh *storeHandler) resolveIndex(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	name := natsfs.BackendName(r.URL.Path)
+	fi, err := sh.b.Stat(r.Context(), name)
+	if err != nil || !fi.IsDir() {
+		return r, true
+	}
+	if !strings.HasSuffix(r.URL.Path, "/") {
+		http.Redirect(w, r, path.Base(r.URL.Path)+"/", http.StatusMovedPermanently)
+		return r, false
+	}
+	if ifi, err := sh.b.Stat(r.Context(), path.Join(name, indexPage)); err == nil && !ifi.IsDir() {
+		r2 := r.Clone(r.Context())
+		r2.URL.Path += indexPage
+		return r2, true
+	}
+	if !sh.autoindex {
+		http.Error(w, "403 Forbidden", http.StatusForbidden)
+		return r, false
+	}
+	return r, true
+}
+
+// serveDirList writes an HTML listing of dir like http.ServeFile's, minus
+// hidden entries.
+func (sh *storeHandler) serveDirList(w http.ResponseWriter, r *http.Request, dir string) {
+	fis, err := sh.b.List(r.Context(), dir)
+	if err != nil {
+		http.Error(w, "Error reading directory", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.Method == http.MethodHead {
+		return
+	}
+	fmt.Fprintf(w, "<pre>\n")
+	for _, fi := range fis {
+		if hidden.hide(path.Join(r.URL.Path, fi.Name())) {
+			continue
+		}
+		name := fi.Name()
+		if fi.IsDir() {
+			name += "/"
+		}
+		u := url.URL{Path: name}
+		fmt.Fprintf(w, "<a href=\"%s\">%s</a>\n", u.String(), html.EscapeString(name))
+	}
+	fmt.Fprintf(w, "</pre>\n")
+}
+
+// serveList responds with a JSON listing of the request path. Names are
+// the full request paths so requesters can fetch and mirror them directly.
+func (sh *storeHandler) serveList(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	upath := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	recursive := r.Header.Get(natshttp.ListHeader) == natshttp.ListRecursive
+	glob := strings.Trim(r.Header.Get(natshttp.GlobHeader), "/")
+	if glob != "" {
+		if _, err := path.Match(glob, ""); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	target := natsfs.BackendName(upath)
+	fi, err := sh.b.Stat(ctx, target)
+	if err != nil {
+		http.Error(w, "404 page not found", http.StatusNotFound)
+		return
+	}
+
+	entries := []natshttp.ListEntry{}
+	// With a glob we only need to walk as deep as the pattern.
+	depth := strings.Count(glob, "/")
+	var walk func(dir, rel string) error
+	walk = func(dir, rel string) error {
+		fis, err := sh.b.List(ctx, dir)
+		if err != nil {
+			return err
+		}
+		for _, fi := range fis {
+			child := path.Join(rel, fi.Name())
+			if hidden.hide(path.Join(upath, child)) {
+				continue
+			}
+			match := true
+			if glob != "" {
+				match, _ = path.Match(glob, child)
+			}
+			if match {
+				entries = append(entries, natshttp.NewListEntry(path.Join(upath, child), fi))
+			}
+			if !fi.IsDir() || glob != "" && strings.Count(child, "/") >= depth || glob == "" && !recursive {
+				continue
+			}
+			if err := walk(path.Join(dir, fi.Name()), child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if !fi.IsDir() {
+		name := upath
+		if name == "" {
+			name = fi.Name()
+		}
+		entries = append(entries, natshttp.NewListEntry(name, fi))
+	} else if err := walk(target, ""); err != nil {
+		natshttp.Logf(r, "Error listing %q: %v", upath, err)
+		http.Error(w, "500 internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	body, err := json.Marshal(entries)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
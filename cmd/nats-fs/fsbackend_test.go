@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	natsfs "github.com/derekcollison/nats-fs"
+	"github.com/derekcollison/nats-fs/natshttp"
+)
+
+func TestStoreHandler(t *testing.T) {
+	b := natsfs.NewMemBackend()
+	for name, data := range map[string]string{"a.txt": "file a", "site/index.html": "<h1>index</h1>", "docs/b.txt": "file b"} {
+		b.Put(context.Background(), name, strings.NewReader(data))
+	}
+	sh := newStoreHandler(b, false)
+	get := func(target string, hdr http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		for k, v := range hdr {
+			req.Header[k] = v
+		}
+		rec := httptest.NewRecorder()
+		sh.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := get("/a.txt", nil); rec.Code != http.StatusOK || rec.Body.String() != "file a" {
+		t.Fatalf("GET /a.txt = %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := get("/site", nil); rec.Code != http.StatusMovedPermanently || rec.Header().Get("Location") != "/site/" {
+		t.Fatalf("GET /site = %d to %q, want a redirect to /site/", rec.Code, rec.Header().Get("Location"))
+	}
+	if rec := get("/site/", nil); rec.Code != http.StatusOK || rec.Body.String() != "<h1>index</h1>" {
+		t.Fatalf("GET /site/ = %d %q, want the index", rec.Code, rec.Body.String())
+	}
+	if rec := get("/docs/", nil); rec.Code != http.StatusForbidden {
+		t.Fatalf("GET /docs/ without autoindex = %d, want 403", rec.Code)
+	}
+	sh.autoindex = true
+	if rec := get("/docs/", nil); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `<a href="b.txt">`) {
+		t.Fatalf("GET /docs/ = %d %q, want a listing", rec.Code, rec.Body.String())
+	}
+
+	rec := get("/", http.Header{natshttp.ListHeader: {natshttp.ListRecursive}})
+	var entries []natshttp.ListEntry
+	if err := json.Unmarshal(rec.Body.Bytes(), &entries); err != nil {
+		t.Fatalf("listing %q: %v", rec.Body.String(), err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name)
+	}
+	if got, want := strings.Join(names, " "), "a.txt docs docs/b.txt site site/index.html"; got != want {
+		t.Fatalf("listed %q, want %q", got, want)
+	}
+
+	rec = httptest.NewRecorder()
+	sh.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/a.txt", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("DELETE = %d, want 405", rec.Code)
+	}
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/derekcollison/nats-fs/client"
+	"github.com/derekcollison/nats-fs/delta"
+	"github.com/derekcollison/nats-fs/natshttp"
+)
+
+// runGet downloads one or more files, to stdout or -output.
+func runGet(args []string) {
+	fs := newFlagSet("get", "<subject> [path ...]")
+	conn := addConnFlags(fs)
+	rf := addRequestFlags(fs)
+	var (
+		showHeaders = fs.Bool("i", false, "Show message headers")
+		output      = fs.String("output", "", "Output file, - for stdout")
+		useDelta    = fs.Bool("delta", false, "Only transfer changes to an existing output file")
+		archive     = fs.Bool("archive", false, "Download a directory as tar.gz and unpack it into -output (default current directory)")
+		recursive   = fs.Bool("r", false, "Recursively download a directory into -output (default current directory)")
+		workers     = fs.Int("P", 4, "Number of files to download at once")
+		byteRange   = fs.String("range", "", "Byte ranges to get, e.g. 0-99,500-, a single range is written as is and several at their offsets in -output")
+		parallel    = fs.Int("parallel", 1, "Download a file as this many concurrent byte ranges, requires -output")
+		quiet       = fs.Bool("q", false, "Do not show progress or the transfer summary")
+		method      = fs.String("X", "", "Request method, POST if there is a body otherwise GET")
+		data        = fs.String("d", "", "Request body, @file or @- for stdin, newlines are stripped")
+		dataBinary  = fs.String("data-binary", "", "Request body sent as is, @file or @- for stdin, large bodies are streamed")
+		jsonOut     = fs.Bool("json", false, "Write the response status, headers, size and timings as JSON to stdout when done")
+		verifyKey   = fs.String("verify-key", "", "Server public nkey, fail unless the response is signed by it")
+		writeOutFmt = fs.String("w", "", "Write this template to stdout when done, e.g. \"%{status} %{size} %{time_total}\\n\"")
+		cacheDir    = fs.String("cache", os.Getenv("NATS_FS_CACHE"), "Keep downloads in this directory and revalidate them, so unchanged files are not fetched again (default $NATS_FS_CACHE)")
+		cacheSize   = fs.String("cache-size", "1GB", "Largest the cache may grow, least recently used files are removed past it")
+		noCache     = fs.Bool("no-cache", false, "Fetch without using or filling the cache")
+		resumeOut   = fs.Bool("continue", false, "Resume a partial -output file where an earlier run stopped, unless it changed on the server since")
+		keepPartial = fs.Bool("keep-partial", false, "Keep partly written files when interrupted, to resume with -continue, which keeps them too")
+	)
+	fs.StringVar(output, "o", "", "Shorthand for -output")
+	fs.Usage = func() {
+		log.Printf("Usage: nats-fs get [options] <subject> [path ...]\n")
+		log.Printf("The body goes to stdout unless -output is given, everything else to stderr.\n")
+		fs.PrintDefaults()
+		log.Printf("\nExit codes: 0 ok, 1 error, 2 no response or incomplete transfer, 3 redirect, 4 client error (4xx), 5 server error (5xx), 130 interrupted\n")
+	}
+	args = requestArgs(fs, rf, args, 1, -1)
+	jsonOutput, writeOut = *jsonOut, *writeOutFmt
+
+	nc := conn.connect("NATS HTTP Style Requestor")
+	defer nc.Close()
+	// Resuming, a partial file is what there is to resume from next time.
+	handleInterrupts(nc, *keepPartial || *resumeOut)
+
+	subj := args[0]
+	var upath string
+	if len(args) > 1 {
+		upath = args[1]
+	}
+
+	var v *verifier
+	if *verifyKey != "" {
+		var err error
+		if v, err = newVerifier(*verifyKey); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Multiple targets, recursive and glob downloads all go into a directory.
+	if len(args) > 2 || *recursive || (hasGlob(upath) && !*archive) {
+		if v != nil {
+			log.Fatalf("-verify-key only works for single downloads")
+		}
+		dir := *output
+		if dir == "" {
+			dir = "."
+		} else if dir == "-" {
+			log.Fatalf("Downloading several files requires -output DIR")
+		}
+		var files []natshttp.ListEntry
+		for _, target := range args[1:] {
+			if !*recursive && !hasGlob(target) {
+				files = append(files, natshttp.ListEntry{Name: target})
+				continue
+			}
+			// Recursive and glob downloads are driven by listings.
+			entries, err := listTree(nc, subj, target, *recursive)
+			if err != nil {
+				newResult(subj, target).fatal(err)
+			}
+			files = append(files, entries...)
+		}
+		if err := getFiles(nc, subj, files, dir, *workers, newProgress(!*quiet)); err != nil {
+			os.Exit(exitCode(err))
+		}
+		return
+	}
+
+	// Split into ranges, these can be served by different replicas.
+	if *parallel > 1 && !*archive && !*useDelta && v == nil && *byteRange == "" {
+		if *output == "" || *output == "-" {
+			log.Fatalf("Parallel download requires -output FILE")
+		}
+		trackPartial(*output)
+		res := newResult(subj, upath)
+		p := newProgress(!*quiet)
+		err := getParallel(nc, subj, upath, *parallel, *output, p)
+		res.Bytes = p.Received()
+		if err != nil {
+			res.fatal(err)
+		}
+		untrackPartial(*output)
+		res.report(nil)
+		return
+	}
+
+	req := newRequest(subj, upath)
+	if *archive {
+		req.Header.Add("Archive", "tar.gz")
+	}
+	if *byteRange != "" {
+		req.Header.Set("Range", "bytes="+*byteRange)
+	}
+	if v != nil {
+		req.Header.Set(signNonceHeader, v.nonce)
+	}
+
+	body, size, contentType, err := requestBody(*data, *dataBinary)
+	if err != nil {
+		log.Fatalf("Error opening request body: %v", err)
+	}
+	if body != nil {
+		defer body.Close()
+		bsub, err := attachBody(nc, req, body, size, contentType)
+		if err != nil {
+			log.Fatalf("Error reading request body: %v", err)
+		}
+		if bsub != nil {
+			defer bsub.Unsubscribe()
+		}
+	}
+	if *method != "" {
+		req.Header.Set("Method", strings.ToUpper(*method))
+	}
+
+	// Pick up an interrupted download where it stopped. With If-Range the
+	// server sends the whole file instead if it changed since.
+	var resumeFrom int64
+	if *resumeOut {
+		if *output == "" || *output == "-" || *archive || *useDelta || *byteRange != "" || body != nil {
+			log.Fatalf("-continue requires a plain download to -output FILE")
+		}
+		if validator, err := os.ReadFile(resumeFile(*output)); err == nil {
+			if fi, err := os.Stat(*output); err == nil && fi.Size() > 0 {
+				resumeFrom = fi.Size()
+				req.Header.Set("Range", fmt.Sprintf("bytes=%d-", resumeFrom))
+				req.Header.Set("If-Range", strings.TrimSpace(string(validator)))
+			}
+		}
+	}
+
+	// Revalidate a cached copy rather than fetching it again.
+	var cache *client.Cache
+	var cached *client.CacheEntry
+	if *cacheDir != "" && !*noCache && !*resumeOut && body == nil && *method == "" && !*archive && !*useDelta && *byteRange == "" && v == nil {
+		max, err := parseSize(*cacheSize)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if cache, err = client.NewCache(*cacheDir, max); err != nil {
+			log.Fatalf("Error opening cache %q: %v", *cacheDir, err)
+		}
+		if cached = cache.Lookup(subj, upath, extraHeaders); cached != nil {
+			cached.Validators(http.Header(req.Header))
+		}
+	}
+
+	// For delta sync we send the signature of our current copy.
+	var basis *os.File
+	var sig *delta.Signature
+	if *useDelta && !*archive {
+		if *output == "" || *output == "-" {
+			log.Fatalf("Delta sync requires -output FILE")
+		}
+		if basis, err = os.Open(*output); err == nil {
+			defer basis.Close()
+			if sig, err = deltaSignature(basis, nc.MaxPayload()); err != nil {
+				log.Fatalf("Error computing signature for %q: %v", *output, err)
+			}
+			req.Header.Add("Delta", "rsync")
+			req.Data, _ = sig.MarshalBinary()
+		} else if !os.IsNotExist(err) {
+			log.Fatalf("Error opening output file %q: %v", *output, err)
+		}
+	}
+
+	// Grab first message.
+	res := newResult(subj, req.Header.Get("URL"))
+	resp, err := sendRequest(nc, req)
+	if err != nil {
+		res.fatal(fmt.Errorf("%w for request", err))
+	}
+	defer resp.Body.Close()
+	res.gotHeader(resp)
+
+	// Nothing left if unchanged and we have it all.
+	if resumeFrom > 0 && resp.StatusCode == http.StatusRequestedRangeNotSatisfiable &&
+		resp.Header.Get("Content-Range") == fmt.Sprintf("bytes */%d", resumeFrom) {
+		os.Remove(resumeFile(*output))
+		res.report(nil)
+		return
+	}
+
+	// Check Status
+	if err := checkStatus(resp); err != nil {
+		res.fatal(err)
+	}
+
+	if *showHeaders {
+		log.Printf("Received  [%v]\n", resp.Msg.Subject)
+		for k, v := range resp.Header {
+			log.Printf("\u001b[1m%s:\u001b[0m %s\n", k, strings.Join(v, ","))
+		}
+	}
+
+	if cached != nil && resp.StatusCode == http.StatusNotModified {
+		n, err := writeCached(cached, *output)
+		res.Bytes = n
+		if err != nil {
+			res.fatal(err)
+		}
+		res.report(nil)
+		return
+	}
+	var cw *client.CacheWriter
+	if cache != nil && client.Cacheable(resp.StatusCode, resp.Header) {
+		if cw, err = cache.Store(subj, upath, extraHeaders, resp.Header); err != nil {
+			log.Printf("Error caching %q: %v", upath, err)
+		}
+	}
+
+	// Where the body goes, nil means stdout. Plain files can be resumed.
+	var out io.WriteCloser
+	var file *os.File
+	var offset int64
+	boundary, multi := isByteranges(resp.Header.Get("Content-Type"))
+	switch {
+	case *output == "-":
+		// Raw bytes, an archive is not unpacked.
+	case *archive:
+		dir := *output
+		if dir == "" {
+			dir = "."
+		}
+		out = newArchiveWriter(dir)
+	case sig != nil && resp.Header.Get("Delta") == "rsync":
+		if out, err = newDeltaWriter(basis, sig.BlockSize, *output); err != nil {
+			log.Fatalf("Error applying delta to %q: %v", *output, err)
+		}
+	case multi && resp.StatusCode == http.StatusPartialContent && *output != "":
+		fd, err := os.OpenFile(*output, os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			log.Fatalf("Error opening output file %q: %v", *output, err)
+		}
+		out = newRangesWriter(fd, boundary)
+	case *output != "":
+		if file, err = os.OpenFile(*output, os.O_CREATE|os.O_RDWR, 0644); err != nil {
+			log.Fatalf("Error opening output file %q: %v", *output, err)
+		}
+		if resumeFrom > 0 && resp.StatusCode == http.StatusPartialContent {
+			offset = resumeFrom
+		}
+		if err := file.Truncate(offset); err != nil {
+			log.Fatalf("Error truncating output file %q: %v", *output, err)
+		}
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			log.Fatalf("Error seeking output file %q: %v", *output, err)
+		}
+		trackPartial(*output)
+		if validator := validatorOf(resp.Header); *resumeOut && validator != "" {
+			if err := os.WriteFile(resumeFile(*output), []byte(validator+"\n"), 0644); err != nil {
+				log.Printf("Error saving resume state: %v", err)
+			}
+		}
+		out = file
+	}
+
+	if out == nil {
+		var w io.Writer = &stdoutWriter{}
+		if v != nil {
+			w = io.MultiWriter(w, v)
+		}
+		if cw != nil {
+			w = io.MultiWriter(w, cw)
+		}
+		n, err := readBody(resp, w)
+		res.Bytes = int64(n)
+		if err == nil && v != nil {
+			err = v.verify(resp)
+		}
+		finishCache(cw, err)
+		if err != nil {
+			res.fatal(err)
+		}
+		res.report(nil)
+		return
+	}
+	p := newProgress(!*quiet)
+	p.SetTotal(resp.Size)
+	w := p.Writer(out)
+	if v != nil {
+		w = io.MultiWriter(w, v)
+	}
+	if cw != nil {
+		w = io.MultiWriter(w, cw)
+	}
+	n, err := readBody(resp, w)
+	if err == nil && v != nil {
+		err = v.verify(resp)
+	}
+	finishCache(cw, err)
+	// A resumed transfer can not be verified, the signature covers only
+	// the range fetched.
+	if err != nil && file != nil && v == nil && req.Header.Get(bodyInboxHeader) == "" {
+		resp.Body.Close()
+		err = resume(nc, resp, file, offset, offset+int64(n), err, p)
+	}
+	p.Done()
+	res.Bytes = p.Received()
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		res.fatal(fmt.Errorf("error writing output: %w", err))
+	}
+	if file != nil {
+		untrackPartial(*output)
+	}
+	if *resumeOut {
+		os.Remove(resumeFile(*output))
+	}
+	res.report(nil)
+}
+
+// writeCached copies a cached body to output, stdout if empty or -.
+func writeCached(e *client.CacheEntry, output string) (int64, error) {
+	fd, err := e.Open()
+	if err != nil {
+		return 0, err
+	}
+	defer fd.Close()
+	if output == "" || output == "-" {
+		return io.Copy(os.Stdout, fd)
+	}
+	out, err := os.Create(output)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(out, fd)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return n, err
+}
+
+// finishCache keeps a body in the cache if it was received whole.
+func finishCache(cw *client.CacheWriter, err error) {
+	if cw == nil {
+		return
+	}
+	if err != nil {
+		cw.Abort()
+		return
+	}
+	if err := cw.Commit(); err != nil {
+		log.Printf("Error caching: %v", err)
+	}
+}
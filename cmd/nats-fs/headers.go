@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Extra request headers from -H, sent with every request.
+var extraHeaders = http.Header{}
+
+// headerFlag is a flag.Value taking repeated "Key: Value" arguments like
+// curl. "Key:" with no value removes a header we would send by default.
+type headerFlag http.Header
+
+func (h headerFlag) String() string {
+	return ""
+}
+
+func (h headerFlag) Set(v string) error {
+	key, value, ok := strings.Cut(v, ":")
+	key = strings.TrimSpace(key)
+	if !ok || key == "" || strings.ContainsAny(key, " \t") {
+		return fmt.Errorf("expected \"Key: Value\", got %q", v)
+	}
+	http.Header(h).Add(key, strings.TrimSpace(value))
+	return nil
+}
+
+// addExtraHeaders applies the -H headers to a request, replacing any
+// defaults with the same key.
+func addExtraHeaders(h http.Header) {
+	for k, vs := range extraHeaders {
+		h.Del(k)
+		for _, v := range vs {
+			if v != "" {
+				h.Add(k, v)
+			}
+		}
+	}
+}
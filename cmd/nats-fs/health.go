@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/derekcollison/nats-fs/natshttp"
+	"github.com/nats-io/nats.go"
+)
+
+// Suffix of the health subject.
+const healthName = "healthz"
+
+// HTTP health path, under a reserved prefix so it never shadows a served
+// file named healthz.
+const healthPath = "/.well-known/nats-fs/healthz"
+
+// healthStatus is the health check response.
+type healthStatus struct {
+	Status    string `json:"status"`
+	NATS      string `json:"nats"`
+	Root      string `json:"root"`
+	Transfers int64  `json:"transfers"`
+}
+
+// healthHandler reports whether we are connected to NATS and can read what
+// we serve, checked by checkRoot, with a 503 if not, so it can be used as
+// a readiness probe. Transfers are those counted in stats.
+func healthHandler(nc *nats.Conn, checkRoot func() error, stats *natshttp.HandlerStats) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hs := healthStatus{Status: "ok", NATS: "ok", Root: "ok", Transfers: stats.InFlight.Load()}
+		if !nc.IsConnected() {
+			hs.Status, hs.NATS = "unavailable", nc.Status().String()
+		}
+		if err := checkRoot(); err != nil {
+			hs.Status, hs.Root = "unavailable", err.Error()
+		}
+		code := http.StatusOK
+		if hs.Status != "ok" {
+			code = http.StatusServiceUnavailable
+		}
+		body, _ := json.Marshal(hs)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(code)
+		w.Write(body)
+	}
+}
+
+// checkRoot makes sure the served file or directory can still be read.
+func checkRoot(root string) error {
+	fd, err := os.Open(root)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	fi, err := fd.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		_, err = fd.Readdirnames(1)
+		if err == io.EOF {
+			err = nil
+		}
+	}
+	return err
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// hideRules decide which paths can never be fetched or listed.
+type hideRules struct {
+	dotfiles bool
+	patterns []string
+}
+
+// Nil hides nothing.
+var hidden *hideRules
+
+func newHideRules(dotfiles bool, patterns []string) (*hideRules, error) {
+	if !dotfiles && len(patterns) == 0 {
+		return nil, nil
+	}
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("bad exclude pattern %q: %v", p, err)
+		}
+	}
+	return &hideRules{dotfiles: dotfiles, patterns: patterns}, nil
+}
+
+// hide reports whether the path relative to the served root is hidden.
+// Patterns without a slash match any path element, others the whole path.
+func (hr *hideRules) hide(upath string) bool {
+	if hr == nil {
+		return false
+	}
+	rel := strings.Trim(path.Clean("/"+upath), "/")
+	if rel == "" {
+		return false
+	}
+	for _, part := range strings.Split(rel, "/") {
+		if hr.dotfiles && strings.HasPrefix(part, ".") {
+			return true
+		}
+		for _, p := range hr.patterns {
+			if strings.Contains(p, "/") {
+				continue
+			}
+			if ok, _ := path.Match(p, part); ok {
+				return true
+			}
+		}
+	}
+	for _, p := range hr.patterns {
+		if !strings.Contains(p, "/") {
+			continue
+		}
+		if ok, _ := path.Match(strings.Trim(p, "/"), rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (sl *stringList) String() string {
+	return strings.Join(*sl, ",")
+}
+
+func (sl *stringList) Set(v string) error {
+	*sl = append(*sl, v)
+	return nil
+}
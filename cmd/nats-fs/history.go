@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/derekcollison/nats-fs/natshttp"
+	"github.com/nats-io/nats.go"
+)
+
+// With -history every file written through the server is also put in a
+// JetStream object store, as PATH@REV, so earlier revisions can be fetched
+// with ?rev=N or an X-Version header and are listed by STAT. The revisions
+// of each file are indexed in a KV bucket named after the store with
+// -index appended, revisions being handed out with compare and set so
+// replicas writing at once never reuse one. Files only get history from
+// the first time they are written through the server.
+const versionHeader = "X-Version"
+
+// Times we retry an index update that lost a race with another writer.
+const maxHistoryRetries = 10
+
+// version describes a revision of a file.
+type version struct {
+	Rev    int       `json:"rev"`
+	Size   int64     `json:"size"`
+	Time   time.Time `json:"time"`
+	Digest string    `json:"digest,omitempty"`
+}
+
+// revIndex is what the index keeps for a file, the revisions in the store
+// oldest first, and the last revision handed out, which may not have made
+// it there.
+type revIndex struct {
+	Last     int       `json:"last"`
+	Versions []version `json:"versions"`
+}
+
+// history keeps the revisions of files under root. Nil keeps nothing.
+type history struct {
+	obs   nats.ObjectStore
+	index nats.KeyValue
+	keep  int
+	root  string
+}
+
+// openHistory uses bucket for revisions, creating it and its index if
+// needed, keeping the last keep of each file, 0 for all.
+func openHistory(nc *nats.Conn, bucket string, keep int, root string) (*history, error) {
+	js, err := nc.JetStream()
+	if err != nil {
+		return nil, err
+	}
+	obs, err := js.ObjectStore(bucket)
+	if errors.Is(err, nats.ErrBucketNotFound) || errors.Is(err, nats.ErrStreamNotFound) {
+		obs, err = js.CreateObjectStore(&nats.ObjectStoreConfig{Bucket: bucket, Description: "nats-fs file revisions"})
+	}
+	if err != nil {
+		return nil, err
+	}
+	index, err := js.KeyValue(bucket + "-index")
+	if errors.Is(err, nats.ErrBucketNotFound) {
+		index, err = js.CreateKeyValue(&nats.KeyValueConfig{Bucket: bucket + "-index", Description: "nats-fs file revisions per path"})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &history{obs: obs, index: index, keep: keep, root: root}, nil
+}
+
+// key names file in the store, by its path under root.
+func (h *history) key(file string) string {
+	rel, err := filepath.Rel(h.root, file)
+	if err != nil {
+		return filepath.ToSlash(file)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// load returns the index of the file stored as key and the KV revision it
+// was read at, 0 if there is none yet.
+func (h *history) load(key string) (revIndex, uint64, error) {
+	var idx revIndex
+	e, err := h.index.Get(escapeKey(key))
+	if errors.Is(err, nats.ErrKeyNotFound) {
+		return idx, 0, nil
+	}
+	if err != nil {
+		return idx, 0, err
+	}
+	if err := json.Unmarshal(e.Value(), &idx); err != nil {
+		return idx, 0, fmt.Errorf("bad revision index for %q: %v", key, err)
+	}
+	return idx, e.Revision(), nil
+}
+
+// update applies f to the index of the file stored as key, trying again
+// with a fresh index if another writer changed it first.
+func (h *history) update(key string, f func(*revIndex)) error {
+	var err error
+	for i := 0; i < maxHistoryRetries; i++ {
+		var idx revIndex
+		var rev uint64
+		if idx, rev, err = h.load(key); err != nil {
+			return err
+		}
+		f(&idx)
+		var data []byte
+		if data, err = json.Marshal(idx); err != nil {
+			return err
+		}
+		if rev == 0 {
+			_, err = h.index.Create(escapeKey(key), data)
+		} else {
+			_, err = h.index.Update(escapeKey(key), data, rev)
+		}
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// versions returns the revisions of file, oldest first.
+func (h *history) versions(file string) ([]version, error) {
+	if h == nil {
+		return nil, nil
+	}
+	idx, _, err := h.load(h.key(file))
+	return idx.Versions, err
+}
+
+// record puts the current contents of file in as its next revision,
+// dropping revisions past the ones we keep.
+func (h *history) record(r *http.Request, file string) {
+	if h == nil {
+		return
+	}
+	if fi, err := os.Stat(file); err != nil || !fi.Mode().IsRegular() {
+		return
+	}
+	if err := h.put(file); err != nil {
+		natshttp.Logf(r, "Error recording history of %q: %v", file, err)
+	}
+}
+
+func (h *history) put(file string) error {
+	key := h.key(file)
+	var rev int
+	if err := h.update(key, func(idx *revIndex) {
+		idx.Last++
+		rev = idx.Last
+	}); err != nil {
+		return err
+	}
+	fd, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	info, err := h.obs.Put(&nats.ObjectMeta{Name: fmt.Sprintf("%s@%d", key, rev)}, fd)
+	if err != nil {
+		return err
+	}
+	v := version{Rev: rev, Size: int64(info.Size), Time: info.ModTime, Digest: info.Digest}
+	var dropped []version
+	if err := h.update(key, func(idx *revIndex) {
+		// Writers may finish out of order.
+		idx.Versions = append(idx.Versions, v)
+		sort.Slice(idx.Versions, func(i, j int) bool { return idx.Versions[i].Rev < idx.Versions[j].Rev })
+		dropped = nil
+		if h.keep > 0 && len(idx.Versions) > h.keep {
+			dropped = append(dropped, idx.Versions[:len(idx.Versions)-h.keep]...)
+			idx.Versions = idx.Versions[len(idx.Versions)-h.keep:]
+		}
+	}); err != nil {
+		return err
+	}
+	for _, v := range dropped {
+		if err := h.obs.Delete(fmt.Sprintf("%s@%d", key, v.Rev)); err != nil {
+			log.Printf("Error dropping revision %d of %q: %v", v.Rev, file, err)
+		}
+	}
+	return nil
+}
+
+// requestedRev returns the revision asked for with ?rev=N or X-Version,
+// 0 for the current file.
+func requestedRev(r *http.Request) (int, error) {
+	v := r.URL.Query().Get("rev")
+	if hv := r.Header.Get(versionHeader); hv != "" {
+		v = hv
+	}
+	if v == "" {
+		return 0, nil
+	}
+	rev, err := strconv.Atoi(v)
+	if err != nil || rev < 1 {
+		return 0, fmt.Errorf("bad revision %q", v)
+	}
+	return rev, nil
+}
+
+// serveVersion responds with revision rev of file.
+func (h *history) serveVersion(w http.ResponseWriter, r *http.Request, file string, rev int) {
+	if h == nil {
+		http.Error(w, "404 page not found, no history kept", http.StatusNotFound)
+		return
+	}
+	res, err := h.obs.Get(fmt.Sprintf("%s@%d", h.key(file), rev))
+	if errors.Is(err, nats.ErrObjectNotFound) {
+		http.Error(w, "404 page not found, no such revision", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		natshttp.Logf(r, "Error fetching revision %d of %q: %v", rev, file, err)
+		http.Error(w, "500 internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer res.Close()
+	info, err := res.Info()
+	if err != nil {
+		http.Error(w, "500 internal server error", http.StatusInternalServerError)
+		return
+	}
+	ct := mime.TypeByExtension(path.Ext(file))
+	if ct == "" {
+		ct = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", ct)
+	w.Header().Set("Content-Length", strconv.FormatUint(info.Size, 10))
+	w.Header().Set("Last-Modified", info.ModTime.UTC().Format(http.TimeFormat))
+	w.Header().Set(versionHeader, strconv.Itoa(rev))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err := io.Copy(w, res); err != nil {
+		natshttp.Logf(r, "Error sending revision %d of %q: %v", rev, file, err)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/derekcollison/nats-fs/natsfstest"
+)
+
+func TestHistoryConcurrentWriters(t *testing.T) {
+	srv := &natsfstest.Server{NATS: natsfstest.RunJetStream(t)}
+	root := t.TempDir()
+	file := filepath.Join(root, "a.txt")
+	if err := os.WriteFile(file, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	const writers, keep = 8, 5
+	// One history per writer, as replicas would have.
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		h, err := openHistory(srv.Connect(t), "history-test", keep, root)
+		if err != nil {
+			t.Fatalf("openHistory: %v", err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := h.put(file); err != nil {
+				t.Errorf("put: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	h, err := openHistory(srv.Connect(t), "history-test", keep, root)
+	if err != nil {
+		t.Fatalf("openHistory: %v", err)
+	}
+	vs, err := h.versions(file)
+	if err != nil {
+		t.Fatalf("versions: %v", err)
+	}
+	if len(vs) != keep {
+		t.Fatalf("%d revisions kept, want %d", len(vs), keep)
+	}
+	for i, v := range vs {
+		if want := writers - keep + 1 + i; v.Rev != want {
+			t.Fatalf("revisions %v, want the last %d of %d", vs, keep, writers)
+		}
+	}
+	if _, err := h.obs.GetInfo("a.txt@1"); err == nil {
+		t.Fatal("revision past those kept still stored")
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/derekcollison/nats-fs/client"
+	"github.com/nats-io/nats.go"
+)
+
+// Downloads in progress, so an interrupt can cancel them on the server
+// rather than leave it sending into the void, and clean up after them.
+// Sending counts requests still waiting for their response, which cancel
+// themselves when the run is canceled.
+var inflight = struct {
+	sync.Mutex
+	responses map[*client.Response]bool
+	partials  map[string]bool
+	sending   atomic.Int64
+}{responses: make(map[*client.Response]bool), partials: make(map[string]bool)}
+
+// How long an interrupt waits for requests to cancel themselves.
+const cancelWait = time.Second
+
+// trackCancel records a response whose body is being read, to cancel it.
+func trackCancel(resp *client.Response) {
+	inflight.Lock()
+	inflight.responses[resp] = true
+	inflight.Unlock()
+}
+
+func untrackCancel(resp *client.Response) {
+	inflight.Lock()
+	delete(inflight.responses, resp)
+	inflight.Unlock()
+}
+
+// trackPartial records a file being written, removed if we are
+// interrupted before untrackPartial.
+func trackPartial(name string) {
+	inflight.Lock()
+	inflight.partials[name] = true
+	inflight.Unlock()
+}
+
+func untrackPartial(name string) {
+	inflight.Lock()
+	delete(inflight.partials, name)
+	inflight.Unlock()
+}
+
+// handleInterrupts cancels transfers in progress on SIGINT or SIGTERM,
+// including those still waiting for a response, removes partly written
+// files unless keepPartial, and exits.
+func handleInterrupts(nc *nats.Conn, keepPartial bool) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		s := <-sigs
+		inflight.Lock()
+		for resp := range inflight.responses {
+			resp.Cancel()
+		}
+		cancelRun()
+		for deadline := time.Now().Add(cancelWait); inflight.sending.Load() > 0 && time.Now().Before(deadline); {
+			time.Sleep(10 * time.Millisecond)
+		}
+		nc.Flush()
+		for name := range inflight.partials {
+			if keepPartial {
+				log.Printf("Kept partial %s", name)
+				continue
+			}
+			os.Remove(name)
+			os.Remove(resumeFile(name))
+		}
+		log.Printf("Received %v, canceled %d transfers", s, len(inflight.responses))
+		os.Exit(exitInterrupted)
+	}()
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+
+	"github.com/derekcollison/nats-fs/natshttp"
+)
+
+// runLs lists a directory, or files matching a glob.
+func runLs(args []string) {
+	fs := newFlagSet("ls", "<subject> [path]")
+	conn := addConnFlags(fs)
+	rf := addRequestFlags(fs)
+	long := fs.Bool("l", false, "Show mode, size and modification time")
+	recursive := fs.Bool("r", false, "List the whole tree")
+	jsonOut := fs.Bool("json", false, "Write the listing as JSON")
+	args = requestArgs(fs, rf, args, 1, 2)
+
+	upath := "/"
+	if len(args) > 1 {
+		upath = args[1]
+	}
+	nc := conn.connect("NATS HTTP Style Requestor")
+	defer nc.Close()
+
+	base, glob := splitGlob(upath)
+	entries, err := listDir(nc, args[0], base, *recursive, glob)
+	if err != nil {
+		fatal(err)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	if *jsonOut {
+		json.NewEncoder(os.Stdout).Encode(entries)
+		return
+	}
+	printEntries(os.Stdout, entries, *long, *recursive || glob != "")
+}
+
+// printEntries prints a listing, with full paths or just the names.
+func printEntries(w io.Writer, entries []natshttp.ListEntry, long, fullPaths bool) {
+	for _, e := range entries {
+		name := e.Name
+		if !fullPaths {
+			name = path.Base(name)
+		}
+		if e.IsDir {
+			name += "/"
+		}
+		if long {
+			fmt.Fprintf(w, "%s %10s %s %s\n", e.Mode, formatBytes(e.Size), e.ModTime.Local().Format("Jan _2 15:04"), name)
+		} else {
+			fmt.Fprintln(w, name)
+		}
+	}
+}
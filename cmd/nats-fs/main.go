@@ -0,0 +1,176 @@
+// Command nats-fs serves files over NATS and fetches them, with a
+// subcommand for each.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"github.com/derekcollison/nats-fs/client"
+	"github.com/derekcollison/nats-fs/ratelimit"
+	"github.com/nats-io/nats.go"
+)
+
+// NOTE: Can test with demo servers.
+// nats-fs get -s demo.nats.io <subject> <path>
+// nats-fs get -s demo.nats.io:4443 <subject> <path> (TLS version)
+
+type command struct {
+	name    string
+	summary string
+	run     func(args []string)
+}
+
+var commands = []command{
+	{"serve", "Serve a file or directory", runServe},
+	{"get", "Download files", runGet},
+	{"put", "Upload a file", runPut},
+	{"ls", "List a directory", runLs},
+	{"stat", "Show a file's size, type and modification time", runStat},
+	{"sync", "Make a local directory match a remote one", runSync},
+	{"mount", "Mount a remote directory read only", runMount},
+	{"shell", "Interactive session", runShell},
+	{"connect", "Open a duplex session, sending stdin and printing what comes back", runConnect},
+	{"bench", "Measure download throughput and latency", runBench},
+	{"trace", "Follow transfers on a subject, or replay captured ones, showing chunks, acks and flow control", runTrace},
+	{"admin", "Send admin commands to servers", runAdmin},
+	{"service", "Install, start and stop serve as a Windows service", runService},
+}
+
+func usage() {
+	log.Printf("Usage: nats-fs <command> [options] [args]\n\nCommands:\n")
+	for _, c := range commands {
+		log.Printf("  %-8s %s\n", c.name, c.summary)
+	}
+	log.Printf("\nRun \"nats-fs <command> -h\" for its options.\n")
+}
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(1)
+	}
+	name := os.Args[1]
+	switch name {
+	case "-h", "-help", "--help", "help":
+		usage()
+		return
+	}
+	for _, c := range commands {
+		if c.name == name {
+			c.run(os.Args[2:])
+			return
+		}
+	}
+	log.Printf("Unknown command %q\n\n", name)
+	usage()
+	os.Exit(1)
+}
+
+// newFlagSet returns the flags for a command, with usage showing the
+// arguments it takes.
+func newFlagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		log.Printf("Usage: nats-fs %s [options] %s\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// connFlags are the NATS connection flags every command takes.
+type connFlags struct {
+	urls    *string
+	creds   *string
+	timeout *time.Duration
+}
+
+func addConnFlags(fs *flag.FlagSet) *connFlags {
+	return &connFlags{
+		urls:    fs.String("s", nats.DefaultURL, "The nats server URLs (separated by comma)"),
+		creds:   fs.String("creds", "", "User Credentials File"),
+		timeout: fs.Duration("connect-timeout", nats.DefaultTimeout, "Timeout connecting to NATS"),
+	}
+}
+
+// connect connects to NATS, exiting if it can't.
+func (c *connFlags) connect(name string) *nats.Conn {
+	opts := []nats.Option{nats.Name(name), nats.Timeout(*c.timeout)}
+	if *c.creds != "" {
+		opts = append(opts, nats.UserCredentials(*c.creds))
+	}
+	nc, err := nats.Connect(*c.urls, opts...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return nc
+}
+
+// requestFlags are the flags for commands making requests.
+type requestFlags struct {
+	limitRate *string
+	firstByte *time.Duration
+	idle      *time.Duration
+	maxTime   *time.Duration
+	retry     *int
+	e2e       *bool
+	e2eKey    *string
+	seed      *string
+}
+
+func addRequestFlags(fs *flag.FlagSet) *requestFlags {
+	fs.Var(headerFlag(extraHeaders), "H", "Extra request header as \"Key: Value\", \"Key:\" removes a default (repeatable)")
+	return &requestFlags{
+		limitRate: fs.String("limit-rate", "", "Limit download rate, e.g. 1MB/s"),
+		firstByte: fs.Duration("first-byte-timeout", firstByteTimeout, "Timeout waiting for a response to start"),
+		idle:      fs.Duration("idle-timeout", idleTimeout, "Timeout waiting for the next chunk of a response"),
+		maxTime:   fs.Duration("max-time", 0, "Deadline for the whole run, 0 for none"),
+		retry:     fs.Int("retry", 0, "Retry no responders, timeouts and 5xx responses this many times"),
+		e2e:       fs.Bool("e2e", false, "Have response bodies encrypted to an ephemeral key, so only we can read them, requires -e2e-key"),
+		e2eKey:    fs.String("e2e-key", "", "Server public nkey, its -sign-seed, that must sign its end of -e2e"),
+		seed:      fs.String("seed", "", "Nkey seed file to sign writes with, its public key given to servers with -write-key"),
+	}
+}
+
+// apply sets the request settings once flags are parsed.
+func (r *requestFlags) apply() {
+	rate, err := ratelimit.ParseRate(*r.limitRate)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ackLimit = ratelimit.New(rate)
+	firstByteTimeout, idleTimeout, retries = *r.firstByte, *r.idle, *r.retry
+	if *r.maxTime > 0 {
+		deadline = time.Now().Add(*r.maxTime)
+		runCtx, cancelRun = context.WithDeadline(context.Background(), deadline)
+	}
+	if *r.e2e {
+		if *r.e2eKey == "" {
+			log.Fatalf("-e2e requires -e2e-key, or the server's key could be swapped on the way")
+		}
+		if e2eKey, err = client.NewE2EKey(*r.e2eKey); err != nil {
+			log.Fatalf("Error generating encryption key: %v", err)
+		}
+	}
+	if *r.seed != "" {
+		if writeSigner, err = loadSigner(*r.seed); err != nil {
+			log.Fatalf("Error loading write key: %v", err)
+		}
+	}
+}
+
+// requestArgs parses the flags of a request command and checks it has
+// between min and max arguments, the first being the subject.
+func requestArgs(fs *flag.FlagSet, rf *requestFlags, args []string, min, max int) []string {
+	fs.Parse(args)
+	if fs.NArg() < min || (max >= 0 && fs.NArg() > max) {
+		fs.Usage()
+		os.Exit(1)
+	}
+	rf.apply()
+	return fs.Args()
+}
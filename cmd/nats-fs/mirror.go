@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/derekcollison/nats-fs/natshttp"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+)
+
+// mirror keeps root in step with another instance, serving on subj: a full
+// sync when we start, then each change from its change feed as it happens.
+// Only changes to its plain root are followed, not its tenants, and only
+// events signed with its key.
+type mirror struct {
+	nc    *nats.Conn
+	subj  string
+	key   nkeys.KeyPair
+	root  string
+	delta bool
+	p     *progress
+}
+
+// Change events buffered while one is applied, beyond which we resync.
+const mirrorBacklog = 4096
+
+// startMirror subscribes to events, then syncs in the background. Should
+// the backlog overflow, the dropped events are made up for by another full
+// sync.
+func startMirror(nc *nats.Conn, subj, events, pub, root string, delta bool) error {
+	key, err := nkeys.FromPublicKey(pub)
+	if err != nil {
+		return fmt.Errorf("bad mirror key %q: %v", pub, err)
+	}
+	m := &mirror{nc: nc, subj: subj, key: key, root: root, delta: delta, p: newProgress(false)}
+	// Subscribed before the full sync so no change made during it is lost.
+	ch := make(chan *nats.Msg, mirrorBacklog)
+	var overflowed atomic.Bool
+	_, err = nc.Subscribe(events, func(msg *nats.Msg) {
+		select {
+		case ch <- msg:
+		default:
+			overflowed.Store(true)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	go func() {
+		m.syncAll()
+		for msg := range ch {
+			if overflowed.Swap(false) {
+				log.Printf("Mirror fell behind the change feed of %q, syncing again", subj)
+				m.syncAll()
+			}
+			m.apply(msg)
+		}
+	}()
+	return nil
+}
+
+// syncAll fetches whatever differs from the source and removes what it
+// does not have.
+func (m *mirror) syncAll() {
+	start := time.Now()
+	entries, err := listDir(m.nc, m.subj, "/", true, "")
+	if err != nil {
+		log.Printf("Error listing mirror source %q: %v", m.subj, err)
+		return
+	}
+	keep := map[string]bool{m.root: true}
+	fetched := 0
+	for _, e := range entries {
+		name, err := localPath(m.root, e.Name)
+		if err != nil {
+			log.Printf("Error mirroring %q: %v", e.Name, err)
+			continue
+		}
+		keep[name] = true
+		if e.IsDir {
+			os.MkdirAll(name, 0755)
+			continue
+		}
+		if fi, err := os.Stat(name); err == nil && fi.Size() == e.Size && fi.ModTime().Truncate(time.Second).Equal(e.ModTime.Truncate(time.Second)) {
+			continue
+		}
+		if err := m.fetch(e); err != nil {
+			log.Printf("Error mirroring %q: %v", e.Name, err)
+			continue
+		}
+		fetched++
+	}
+	var removed []string
+	filepath.WalkDir(m.root, func(p string, d fs.DirEntry, err error) error {
+		if err == nil && !keep[p] {
+			removed = append(removed, p)
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+		}
+		return nil
+	})
+	for _, p := range removed {
+		os.RemoveAll(p)
+	}
+	log.Printf("Mirrored %q in %v, fetched %d and removed %d", m.subj, time.Since(start).Round(time.Millisecond), fetched, len(removed))
+}
+
+// apply makes one change from the source's change feed.
+func (m *mirror) apply(msg *nats.Msg) {
+	sig, err := base64.RawURLEncoding.DecodeString(msg.Header.Get(signatureHeader))
+	if err != nil || m.key.Verify(msg.Data, sig) != nil {
+		log.Printf("Ignoring change event not signed by the mirror key")
+		return
+	}
+	var ev changeEvent
+	if err := json.Unmarshal(msg.Data, &ev); err != nil {
+		log.Printf("Bad change event: %v", err)
+		return
+	}
+	if ev.Subject != m.subj {
+		return
+	}
+	name, err := localPath(m.root, ev.Path)
+	if err != nil {
+		log.Printf("Error mirroring %q: %v", ev.Path, err)
+		return
+	}
+	switch ev.Op {
+	case "delete":
+		err = os.RemoveAll(name)
+	case "mkcol":
+		err = os.MkdirAll(name, 0755)
+	case "put", "copy", "move":
+		err = m.fetchPath(ev.Path)
+	default:
+		return
+	}
+	if err != nil {
+		log.Printf("Error mirroring %s of %q: %v", ev.Op, ev.Path, err)
+		return
+	}
+	if debugLog.Load() {
+		log.Printf("Mirrored %s of %q", ev.Op, ev.Path)
+	}
+}
+
+// fetchPath fetches a file, or everything under a directory.
+func (m *mirror) fetchPath(upath string) error {
+	entries, _, err := statMeta(m.nc, m.subj, upath, "", false)
+	if err != nil || len(entries) == 0 {
+		return err
+	}
+	e := entries[0].ListEntry
+	e.Name = strings.Trim(upath, "/")
+	if !e.IsDir {
+		return m.fetch(e)
+	}
+	name, err := localPath(m.root, e.Name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(name, 0755); err != nil {
+		return err
+	}
+	files, err := listDir(m.nc, m.subj, "/"+e.Name, true, "")
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if f.IsDir {
+			continue
+		}
+		if err := m.fetch(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fetch gets a listed file, as a delta against our copy if enabled.
+func (m *mirror) fetch(e natshttp.ListEntry) error {
+	if !m.delta {
+		_, err := getFile(m.nc, m.subj, e, m.root, m.p, newResult(m.subj, e.Name))
+		return err
+	}
+	name, err := localPath(m.root, e.Name)
+	if err != nil {
+		return err
+	}
+	_, err = syncFile(m.nc, m.subj, e, name, m.p)
+	return err
+}
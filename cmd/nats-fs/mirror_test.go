@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+)
+
+func TestMirrorApplyAuthenticates(t *testing.T) {
+	kp, err := nkeys.CreateServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _ := nkeys.CreateServer()
+	m := &mirror{subj: "files", key: kp, root: t.TempDir()}
+
+	// event returns a delete of /victim for subj, signed by signer if any.
+	event := func(subj string, signer nkeys.KeyPair) *nats.Msg {
+		msg := nats.NewMsg("nats-fs.events.changes")
+		msg.Data, _ = json.Marshal(changeEvent{Op: "delete", Path: "/victim", Subject: subj})
+		if signer != nil {
+			sig, _ := signer.Sign(msg.Data)
+			msg.Header.Set(signatureHeader, base64.RawURLEncoding.EncodeToString(sig))
+		}
+		return msg
+	}
+	victim := filepath.Join(m.root, "victim")
+	for _, tc := range []struct {
+		name    string
+		msg     *nats.Msg
+		applied bool
+	}{
+		{"unsigned", event("files", nil), false},
+		{"signed by another key", event("files", other), false},
+		{"no subject", event("", kp), false},
+		{"another subject", event("other", kp), false},
+		{"signed", event("files", kp), true},
+	} {
+		if err := os.WriteFile(victim, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		m.apply(tc.msg)
+		_, err := os.Stat(victim)
+		if applied := os.IsNotExist(err); applied != tc.applied {
+			t.Errorf("%s: applied %v, want %v", tc.name, applied, tc.applied)
+		}
+	}
+}
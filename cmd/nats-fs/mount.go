@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/derekcollison/nats-fs/natshttp"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/nats-io/nats.go"
+)
+
+// How long the kernel may cache names and attributes.
+const mountCacheTimeout = 5 * time.Second
+
+// runMount mounts a remote directory read only with FUSE, until unmounted
+// or interrupted. Listings drive lookups and reads are Range requests.
+func runMount(args []string) {
+	flags := newFlagSet("mount", "<subject> <mountpoint> [remote dir]")
+	conn := addConnFlags(flags)
+	rf := addRequestFlags(flags)
+	args = requestArgs(flags, rf, args, 2, 3)
+	remote := ""
+	if len(args) > 2 {
+		remote = path.Clean("/" + args[2])
+	}
+
+	nc := conn.connect("NATS HTTP Style Requestor")
+	defer nc.Close()
+
+	timeout := mountCacheTimeout
+	root := &mountNode{nc: nc, subj: args[0], upath: remote, entry: natshttp.ListEntry{IsDir: true}}
+	server, err := fs.Mount(args[1], root, &fs.Options{
+		MountOptions: fuse.MountOptions{FsName: "nats-fs:" + args[0], Name: "nats-fs"},
+		EntryTimeout: &timeout,
+		AttrTimeout:  &timeout,
+	})
+	if err != nil {
+		log.Fatalf("Error mounting %q: %v", args[1], err)
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		if err := server.Unmount(); err != nil {
+			log.Printf("Error unmounting %q: %v", args[1], err)
+		}
+	}()
+	server.Wait()
+}
+
+// mountNode is a remote file or directory.
+type mountNode struct {
+	fs.Inode
+	nc    *nats.Conn
+	subj  string
+	upath string
+	entry natshttp.ListEntry
+
+	mu       sync.Mutex
+	children map[string]natshttp.ListEntry
+}
+
+var (
+	_ fs.NodeReaddirer = (*mountNode)(nil)
+	_ fs.NodeLookuper  = (*mountNode)(nil)
+	_ fs.NodeGetattrer = (*mountNode)(nil)
+	_ fs.NodeOpener    = (*mountNode)(nil)
+	_ fs.NodeReader    = (*mountNode)(nil)
+)
+
+// list fetches the directory's entries by name.
+func (n *mountNode) list() (map[string]natshttp.ListEntry, syscall.Errno) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.children != nil {
+		return n.children, 0
+	}
+	entries, err := listDir(n.nc, n.subj, n.upath+"/", false, "")
+	if err != nil {
+		return nil, toErrno(err)
+	}
+	n.children = make(map[string]natshttp.ListEntry, len(entries))
+	for _, e := range entries {
+		n.children[path.Base(e.Name)] = e
+	}
+	return n.children, 0
+}
+
+func (n *mountNode) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
+	children, errno := n.list()
+	if errno != 0 {
+		return nil, errno
+	}
+	list := make([]fuse.DirEntry, 0, len(children))
+	for name, e := range children {
+		list = append(list, fuse.DirEntry{Name: name, Mode: fileMode(e)})
+	}
+	return fs.NewListDirStream(list), 0
+}
+
+func (n *mountNode) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	children, errno := n.list()
+	if errno != 0 {
+		return nil, errno
+	}
+	e, ok := children[name]
+	if !ok {
+		return nil, syscall.ENOENT
+	}
+	child := &mountNode{nc: n.nc, subj: n.subj, upath: n.upath + "/" + name, entry: e}
+	child.fillAttr(&out.Attr)
+	return n.NewInode(ctx, child, fs.StableAttr{Mode: fileMode(e)}), 0
+}
+
+func (n *mountNode) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	n.fillAttr(&out.Attr)
+	return 0
+}
+
+func (n *mountNode) fillAttr(attr *fuse.Attr) {
+	attr.Mode = fileMode(n.entry) | 0444
+	if n.entry.IsDir {
+		attr.Mode |= 0111
+	}
+	attr.Size = uint64(n.entry.Size)
+	attr.Mtime = uint64(n.entry.ModTime.Unix())
+}
+
+func (n *mountNode) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	if flags&(syscall.O_WRONLY|syscall.O_RDWR) != 0 {
+		return nil, 0, syscall.EROFS
+	}
+	return nil, fuse.FOPEN_KEEP_CACHE, 0
+}
+
+func (n *mountNode) Read(ctx context.Context, fh fs.FileHandle, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	if off >= n.entry.Size {
+		return fuse.ReadResultData(nil), 0
+	}
+	end := off + int64(len(dest)) - 1
+	if end >= n.entry.Size {
+		end = n.entry.Size - 1
+	}
+	req := newRequest(n.subj, n.upath)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, end))
+	resp, err := sendRequest(n.nc, req)
+	if err != nil {
+		return nil, toErrno(err)
+	}
+	if err := checkStatus(resp); err != nil {
+		return nil, toErrno(err)
+	}
+	defer resp.Body.Close()
+	buf := bytes.NewBuffer(dest[:0])
+	if _, err := readBody(resp, buf); err != nil {
+		return nil, syscall.EIO
+	}
+	data := buf.Bytes()
+	// Without range support we get the whole file.
+	if resp.StatusCode != 206 {
+		if off >= int64(len(data)) {
+			return fuse.ReadResultData(nil), 0
+		}
+		data = data[off:]
+		if len(data) > len(dest) {
+			data = data[:len(dest)]
+		}
+	}
+	return fuse.ReadResultData(data), 0
+}
+
+func fileMode(e natshttp.ListEntry) uint32 {
+	if e.IsDir {
+		return fuse.S_IFDIR
+	}
+	return fuse.S_IFREG
+}
+
+// toErrno maps request errors to what the kernel expects.
+func toErrno(err error) syscall.Errno {
+	switch exitCode(err) {
+	case exitClientError:
+		return syscall.ENOENT
+	case exitTransport:
+		return syscall.ETIMEDOUT
+	}
+	return syscall.EIO
+}
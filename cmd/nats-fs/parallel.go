@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/derekcollison/nats-fs/client"
+	"github.com/nats-io/nats.go"
+)
+
+// Do not bother splitting below this size.
+const minRangeSize = 1024 * 1024
+
+// stat asks for the headers of upath only.
+func stat(nc *nats.Conn, subj, upath string) (*client.Stat, error) {
+	req := newRequest(subj, upath)
+	req.Header.Set("Method", "HEAD")
+	resp, err := sendRequest(nc, req)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkStatus(resp); err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return &resp.Stat, nil
+}
+
+// getParallel downloads upath as n concurrent byte ranges into output,
+// each over its own inbox, written at their offsets into a sparse file.
+// Ranges are pinned to the version first seen with If-Range.
+func getParallel(nc *nats.Conn, subj, upath string, n int, output string, p *progress) error {
+	st, err := stat(nc, subj, upath)
+	if err != nil {
+		return err
+	}
+	if st.Size < 0 {
+		return fmt.Errorf("size of %q is unknown", upath)
+	}
+	size := int(st.Size)
+	validator := validatorOf(st.Header)
+	p.SetTotal(int64(size))
+	if size/n < minRangeSize {
+		n = size/minRangeSize + 1
+	}
+
+	fd, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if err := fd.Truncate(int64(size)); err != nil {
+		fd.Close()
+		return err
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	rs := size / n
+	for i := 0; i < n; i++ {
+		start, end := i*rs, (i+1)*rs-1
+		if i == n-1 {
+			end = size - 1
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := getRange(nc, subj, upath, validator, start, end, fd, p); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	p.Done()
+
+	err = <-errs
+	if cerr := fd.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// errChanged is returned when a file changes while its ranges are fetched.
+var errChanged = errors.New("file changed during download")
+
+// getRange downloads bytes start-end inclusive and writes them at start,
+// resuming from where it stopped if the transfer is cut short.
+func getRange(nc *nats.Conn, subj, upath, validator string, start, end int, fd *os.File, p *progress) error {
+	if end < start {
+		return nil
+	}
+	var err error
+	for attempt := 0; ; attempt++ {
+		var n int
+		n, err = fetchRange(nc, subj, upath, validator, start, end, fd, p)
+		start += n
+		if !errors.Is(err, client.ErrIncomplete) || start > end || !retryWait(attempt, fmt.Sprintf("%s range %d-%d", upath, start, end), err) {
+			break
+		}
+	}
+	if start > end {
+		return nil
+	}
+	return err
+}
+
+// fetchRange requests bytes start-end once, returning how many were written.
+func fetchRange(nc *nats.Conn, subj, upath, validator string, start, end int, fd *os.File, p *progress) (int, error) {
+	req := newRequest(subj, upath)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	if validator != "" {
+		req.Header.Set("If-Range", validator)
+	}
+	resp, err := sendRequest(nc, req)
+	if err != nil {
+		return 0, fmt.Errorf("%w for range %d-%d", err, start, end)
+	}
+	if err := checkStatus(resp); err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if code := resp.StatusCode; code != 206 {
+		if code == 200 && validator != "" {
+			return 0, fmt.Errorf("%w: %s", errChanged, upath)
+		}
+		return 0, fmt.Errorf("server did not return range %d-%d, status %d", start, end, code)
+	}
+	if resp.Size != int64(end-start+1) {
+		return 0, fmt.Errorf("unexpected length %d for range %d-%d", resp.Size, start, end)
+	}
+	return readBody(resp, p.Writer(io.NewOffsetWriter(fd, int64(start))))
+}
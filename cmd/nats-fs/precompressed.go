@@ -0,0 +1,83 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Precompressed sidecar files in order of preference.
+var sidecars = []struct {
+	encoding string
+	ext      string
+}{
+	{"br", ".br"},
+	{"zstd", ".zst"},
+	{"gzip", ".gz"},
+}
+
+// servePrecompressed serves file.gz (or .br, .zst) in place of file if it
+// exists and the requester accepts that encoding. Responses for regular
+// files vary with Accept-Encoding whether or not a sidecar is served.
+func servePrecompressed(w http.ResponseWriter, r *http.Request, file string) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	fi, err := os.Stat(file)
+	if err != nil || !fi.Mode().IsRegular() {
+		return false
+	}
+	w.Header().Add("Vary", "Accept-Encoding")
+	accept := r.Header.Get("Accept-Encoding")
+	if accept == "" {
+		return false
+	}
+	for _, sc := range sidecars {
+		if acceptsEncoding(accept, sc.encoding) && serveSidecar(w, r, file, fi, sc.encoding, sc.ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// serveSidecar serves file+ext with the given encoding if it is a regular
+// file.
+func serveSidecar(w http.ResponseWriter, r *http.Request, file string, fi os.FileInfo, encoding, ext string) bool {
+	fd, err := os.Open(file + ext)
+	if err != nil {
+		return false
+	}
+	defer fd.Close()
+	sfi, err := fd.Stat()
+	if err != nil || !sfi.Mode().IsRegular() {
+		return false
+	}
+	h := w.Header()
+	if ct := mime.TypeByExtension(filepath.Ext(file)); ct != "" {
+		h.Set("Content-Type", ct)
+	}
+	h.Set("Content-Encoding", encoding)
+	h.Set("ETag", fileETag(sfi))
+	http.ServeContent(w, r, fi.Name(), sfi.ModTime(), fd)
+	return true
+}
+
+// acceptsEncoding reports whether an Accept-Encoding value allows enc.
+func acceptsEncoding(accept, enc string) bool {
+	for _, part := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if name = strings.TrimSpace(name); name != enc && name != "*" {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
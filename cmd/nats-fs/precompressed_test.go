@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestServePrecompressed(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "a.txt")
+	if err := os.WriteFile(file, []byte("plain"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file+".gz", []byte("gzipped"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		accept   string
+		served   bool
+		encoding string
+	}{
+		{"", false, ""},
+		{"br", false, ""},
+		{"gzip", true, "gzip"},
+		{"br, gzip;q=0.5", true, "gzip"},
+		{"gzip;q=0", false, ""},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/a.txt", nil)
+		if tc.accept != "" {
+			r.Header.Set("Accept-Encoding", tc.accept)
+		}
+		w := httptest.NewRecorder()
+		if served := servePrecompressed(w, r, file); served != tc.served {
+			t.Errorf("Accept-Encoding %q: served %v, want %v", tc.accept, served, tc.served)
+			continue
+		}
+		if got := w.Header().Get("Vary"); got != "Accept-Encoding" {
+			t.Errorf("Accept-Encoding %q: Vary %q, want Accept-Encoding", tc.accept, got)
+		}
+		if got := w.Header().Get("Content-Encoding"); got != tc.encoding {
+			t.Errorf("Accept-Encoding %q: Content-Encoding %q, want %q", tc.accept, got, tc.encoding)
+		}
+		if tc.served && w.Body.String() != "gzipped" {
+			t.Errorf("Accept-Encoding %q: body %q", tc.accept, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How often we redraw the progress bar.
+const progressInterval = 200 * time.Millisecond
+
+const progressBarWidth = 30
+
+// progress tracks bytes received for one or more transfers, drawing a
+// live progress bar on stderr when it is a terminal.
+type progress struct {
+	sync.Mutex
+	enabled bool
+	live    bool
+	total   int64
+	n       int64
+	start   time.Time
+	last    time.Time
+	lastN   int64
+	rate    float64
+	drawn   bool
+	// Retransmits before the transfers started.
+	retransmits int64
+}
+
+func newProgress(enabled bool) *progress {
+	return &progress{
+		enabled:     enabled,
+		live:        enabled && isTerminal(os.Stderr),
+		total:       -1,
+		start:       time.Now(),
+		last:        time.Now(),
+		retransmits: retransmits(),
+	}
+}
+
+func isTerminal(fd *os.File) bool {
+	fi, err := fd.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// SetTotal sets the expected number of bytes, -1 if unknown.
+func (p *progress) SetTotal(total int64) {
+	p.Lock()
+	p.total = total
+	p.Unlock()
+}
+
+// Add records n more bytes received.
+func (p *progress) Add(n int) {
+	p.Lock()
+	defer p.Unlock()
+	p.n += int64(n)
+	if now := time.Now(); p.live && now.Sub(p.last) >= progressInterval {
+		p.sample(now)
+		p.draw()
+	}
+}
+
+// Received returns the number of bytes received so far.
+func (p *progress) Received() int64 {
+	p.Lock()
+	defer p.Unlock()
+	return p.n
+}
+
+// Writer returns a writer that records bytes written through to w.
+func (p *progress) Writer(w io.Writer) io.Writer {
+	return &progressWriter{w: w, p: p}
+}
+
+// Logf logs without mangling the progress bar.
+func (p *progress) Logf(format string, args ...interface{}) {
+	p.Lock()
+	defer p.Unlock()
+	p.clear()
+	log.Printf(format, args...)
+	if p.live {
+		p.draw()
+	}
+}
+
+// Done clears the progress bar and prints the transfer summary. Retried
+// requests and chunks fetched again count as retransmits.
+func (p *progress) Done() {
+	p.Lock()
+	defer p.Unlock()
+	p.clear()
+	if !p.enabled {
+		return
+	}
+	elapsed := time.Since(p.start)
+	log.Printf("Received %s in %v (%s/s), %d retransmits", formatBytes(p.n), elapsed.Round(time.Millisecond),
+		formatBytes(int64(float64(p.n)/elapsed.Seconds())), retransmits()-p.retransmits)
+}
+
+// Lock held.
+func (p *progress) sample(now time.Time) {
+	rate := float64(p.n-p.lastN) / now.Sub(p.last).Seconds()
+	if p.rate == 0 {
+		p.rate = rate
+	} else {
+		p.rate = 0.7*p.rate + 0.3*rate
+	}
+	p.last, p.lastN = now, p.n
+}
+
+// Lock held.
+func (p *progress) draw() {
+	var b strings.Builder
+	b.WriteString("\r")
+	if p.total > 0 {
+		pct := float64(p.n) / float64(p.total)
+		if pct > 1 {
+			pct = 1
+		}
+		filled := int(pct * progressBarWidth)
+		fmt.Fprintf(&b, "%3.0f%% [%s%s] %s / %s", pct*100,
+			strings.Repeat("#", filled), strings.Repeat(".", progressBarWidth-filled),
+			formatBytes(p.n), formatBytes(p.total))
+	} else {
+		fmt.Fprintf(&b, "%s", formatBytes(p.n))
+	}
+	fmt.Fprintf(&b, "  %s/s", formatBytes(int64(p.rate)))
+	if p.total > 0 && p.rate > 0 && p.n < p.total {
+		eta := time.Duration(float64(p.total-p.n) / p.rate * float64(time.Second))
+		fmt.Fprintf(&b, "  ETA %v", eta.Round(time.Second))
+	}
+	b.WriteString("\033[K")
+	fmt.Fprint(os.Stderr, b.String())
+	p.drawn = true
+}
+
+// Lock held.
+func (p *progress) clear() {
+	if p.drawn {
+		fmt.Fprint(os.Stderr, "\r\033[K")
+		p.drawn = false
+	}
+}
+
+type progressWriter struct {
+	w io.Writer
+	p *progress
+}
+
+func (pw *progressWriter) Write(data []byte) (int, error) {
+	n, err := pw.w.Write(data)
+	pw.p.Add(n)
+	return n, err
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/derekcollison/nats-fs/client"
+	"github.com/nats-io/nats.go"
+)
+
+// runPut uploads a file with PUT, streaming it if large.
+func runPut(args []string) {
+	fs := newFlagSet("put", "<subject> <file> [path]")
+	conn := addConnFlags(fs)
+	rf := addRequestFlags(fs)
+	resumable := fs.Bool("resumable", false, "Upload in parts to a session that survives a dropped connection, run again to resume")
+	partSize := fs.String("part-size", "8MB", "Size of each part of a resumable upload")
+	dedup := fs.Bool("dedup", false, "Skip the upload if the server already has the content, checked by SHA-256")
+	args = requestArgs(fs, rf, args, 2, 3)
+
+	local, remote := args[1], "/"+filepath.Base(args[1])
+	if len(args) > 2 {
+		remote = path.Clean("/" + args[2])
+	}
+	nc := conn.connect("NATS HTTP Style Requestor")
+	defer nc.Close()
+	var hash string
+	if *dedup {
+		var err error
+		if hash, err = hashFile(local); err != nil {
+			fatal(err)
+		}
+		ok, err := putHave(nc, args[0], remote, hash)
+		if err != nil {
+			fatal(err)
+		}
+		if ok {
+			log.Printf("Server already has %s, skipped the upload", hash)
+			return
+		}
+	}
+	if *resumable {
+		size, err := parseSize(*partSize)
+		if err != nil || size <= 0 {
+			log.Fatalf("Bad part size %q", *partSize)
+		}
+		if err := putResumable(nc, args[0], local, remote, size); err != nil {
+			fatal(err)
+		}
+		return
+	}
+	if err := putFile(nc, args[0], local, remote, hash); err != nil {
+		fatal(err)
+	}
+}
+
+// putHave asks the server to link remote to content it already has with
+// hash, reporting whether it did.
+func putHave(nc *nats.Conn, subj, remote, hash string) (bool, error) {
+	req := newRequest(subj, remote)
+	req.Header.Set("Method", "PUT")
+	req.Header.Set(haveSHA256Header, hash)
+	resp, err := sendRequest(nc, req)
+	if err != nil {
+		return false, err
+	}
+	if resp.StatusCode == 404 {
+		resp.Body.Close()
+		return false, nil
+	}
+	if err := checkStatus(resp); err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return true, nil
+}
+
+// putFile uploads local to remote, with its hash if known so the server
+// can check it.
+func putFile(nc *nats.Conn, subj, local, remote, hash string) error {
+	fd, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	fi, err := fd.Stat()
+	if err != nil {
+		return err
+	}
+	req := newRequest(subj, remote)
+	req.Header.Set("Method", "PUT")
+	if hash != "" {
+		req.Header.Set(contentSHA256Header, hash)
+	}
+	contentType := mime.TypeByExtension(filepath.Ext(local))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	bsub, err := attachBody(nc, req, fd, fi.Size(), contentType)
+	if err != nil {
+		return err
+	}
+	if bsub != nil {
+		defer bsub.Unsubscribe()
+	}
+	resp, err := sendRequest(nc, req)
+	if err != nil {
+		return err
+	}
+	if err := checkStatus(resp); err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// putResumable uploads local to remote in parts. The session ID is kept in
+// a file next to local until the upload completes, so running again picks
+// up from the parts the server already has.
+func putResumable(nc *nats.Conn, subj, local, remote string, partSize int64) error {
+	fd, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	fi, err := fd.Stat()
+	if err != nil {
+		return err
+	}
+	state := local + ".nats-fs-upload"
+
+	// Resume a session for the same destination if the server still has it.
+	var s *uploadSession
+	if b, err := os.ReadFile(state); err == nil {
+		id, dest, _ := strings.Cut(strings.TrimSpace(string(b)), " ")
+		if dest == subj+remote {
+			req := newRequest(subj, remote)
+			req.Header.Set(uploadIDHeader, id)
+			if s, err = uploadSessionOf(nc, req); err != nil {
+				log.Printf("Starting over, upload %s can not be resumed: %v", id, err)
+				s = nil
+			}
+		}
+	}
+	if s == nil {
+		req := newRequest(subj, remote)
+		req.Header.Set("Method", "POST")
+		req.Header.Set(uploadHeader, "start")
+		req.Header.Set(uploadLengthHeader, strconv.FormatInt(fi.Size(), 10))
+		if s, err = uploadSessionOf(nc, req); err != nil {
+			return err
+		}
+		if err := os.WriteFile(state, []byte(s.ID+" "+subj+remote+"\n"), 0600); err != nil {
+			return err
+		}
+	}
+
+	for off := s.received(); off < fi.Size(); off += partSize {
+		n := partSize
+		if off+n > fi.Size() {
+			n = fi.Size() - off
+		}
+		for attempt := 0; ; attempt++ {
+			err := putPart(nc, subj, remote, s.ID, io.NewSectionReader(fd, off, n), off, n)
+			if err == nil {
+				break
+			}
+			if !retryWait(attempt, fmt.Sprintf("part at %d", off), err) {
+				return fmt.Errorf("%w, run again to resume", err)
+			}
+		}
+	}
+
+	req := newRequest(subj, remote)
+	req.Header.Set("Method", "POST")
+	req.Header.Set(uploadIDHeader, s.ID)
+	req.Header.Set(uploadHeader, "complete")
+	if _, _, err := uploadRequest(nc, req); err != nil {
+		return err
+	}
+	return os.Remove(state)
+}
+
+// putPart sends n bytes from r as the part at off.
+func putPart(nc *nats.Conn, subj, remote, id string, r io.Reader, off, n int64) error {
+	req := newRequest(subj, remote)
+	req.Header.Set("Method", "PUT")
+	req.Header.Set(uploadIDHeader, id)
+	req.Header.Set(uploadOffsetHeader, strconv.FormatInt(off, 10))
+	bsub, err := attachBody(nc, req, r, n, "application/octet-stream")
+	if err != nil {
+		return err
+	}
+	if bsub != nil {
+		defer bsub.Unsubscribe()
+	}
+	_, _, err = uploadRequest(nc, req)
+	return err
+}
+
+// uploadSessionOf sends a request answered with an upload session.
+func uploadSessionOf(nc *nats.Conn, req *nats.Msg) (*uploadSession, error) {
+	_, body, err := uploadRequest(nc, req)
+	if err != nil {
+		return nil, err
+	}
+	var s uploadSession
+	if err := json.Unmarshal(body, &s); err != nil || s.ID == "" {
+		return nil, errors.New("bad upload session in response")
+	}
+	return &s, nil
+}
+
+// uploadRequest sends req and returns the response and its body.
+func uploadRequest(nc *nats.Conn, req *nats.Msg) (*client.Response, []byte, error) {
+	resp, err := sendRequest(nc, req)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := checkStatus(resp); err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	var buf bytes.Buffer
+	if _, err := readBody(resp, &buf); err != nil {
+		return nil, nil, err
+	}
+	return resp, buf.Bytes(), nil
+}
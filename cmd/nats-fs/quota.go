@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Writes are limited three ways: -max-upload caps the size of a single
+// file, -write-allow rules say where writes may go and -quota caps the
+// bytes stored by each tenant. Usage is kept in a KV bucket so every
+// replica serving the tree counts against the same number. A tenant's
+// usage starts out as the size of its tree the first time it is needed.
+
+var (
+	errTooLarge      = errors.New("upload too large")
+	errQuotaExceeded = errors.New("quota exceeded")
+)
+
+// Times we retry a usage update that lost a race with another replica.
+const maxQuotaRetries = 10
+
+// quotas counts the bytes stored per tenant. Nil counts nothing.
+type quotas struct {
+	kv    nats.KeyValue
+	limit int64
+}
+
+// newQuotas uses bucket for usage, creating it if needed.
+func newQuotas(nc *nats.Conn, bucket string, limit int64) (*quotas, error) {
+	js, err := nc.JetStream()
+	if err != nil {
+		return nil, err
+	}
+	kv, err := js.KeyValue(bucket)
+	if errors.Is(err, nats.ErrBucketNotFound) {
+		kv, err = js.CreateKeyValue(&nats.KeyValueConfig{Bucket: bucket, Description: "nats-fs bytes stored per tenant"})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &quotas{kv: kv, limit: limit}, nil
+}
+
+// quotaKey is the KV key for tenant, empty when not serving tenants.
+func quotaKey(tenant string) string {
+	if tenant == "" {
+		return "root"
+	}
+	return "tenant." + escapeKey(tenant)
+}
+
+// escapeKey escapes the bytes of s not allowed in a KV key token as =XX.
+func escapeKey(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' {
+			sb.WriteByte(c)
+		} else {
+			fmt.Fprintf(&sb, "=%02X", c)
+		}
+	}
+	return sb.String()
+}
+
+// usage returns the bytes stored under key and the revision they were
+// read at. The first time, it is the size of dir.
+func (q *quotas) usage(key, dir string) (int64, uint64, error) {
+	e, err := q.kv.Get(key)
+	if errors.Is(err, nats.ErrKeyNotFound) {
+		size, err := treeSize(dir)
+		if err != nil {
+			return 0, 0, err
+		}
+		rev, err := q.kv.Create(key, []byte(strconv.FormatInt(size, 10)))
+		if errors.Is(err, nats.ErrKeyExists) {
+			// Another replica got there first.
+			return q.usage(key, dir)
+		}
+		return size, rev, err
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	used, err := strconv.ParseInt(string(e.Value()), 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("bad usage %q for %s", e.Value(), key)
+	}
+	return used, e.Revision(), nil
+}
+
+// fits reports errQuotaExceeded if n more bytes would not fit, without
+// counting them.
+func (q *quotas) fits(tenant, dir string, n int64) error {
+	if q == nil || n <= 0 {
+		return nil
+	}
+	used, _, err := q.usage(quotaKey(tenant), dir)
+	if err != nil {
+		return err
+	}
+	if used+n > q.limit {
+		return errQuotaExceeded
+	}
+	return nil
+}
+
+// add counts n more bytes, negative when freeing space. Growth past the
+// limit is refused with errQuotaExceeded.
+func (q *quotas) add(tenant, dir string, n int64) error {
+	if q == nil || n == 0 {
+		return nil
+	}
+	key := quotaKey(tenant)
+	var err error
+	for i := 0; i < maxQuotaRetries; i++ {
+		var used int64
+		var rev uint64
+		if used, rev, err = q.usage(key, dir); err != nil {
+			return err
+		}
+		if n > 0 && used+n > q.limit {
+			return errQuotaExceeded
+		}
+		if used += n; used < 0 {
+			used = 0
+		}
+		if _, err = q.kv.Update(key, []byte(strconv.FormatInt(used, 10)), rev); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// treeSize returns the bytes in the regular files at or under p, 0 if
+// there is nothing there.
+func treeSize(p string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(p, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// writeAllowed reports whether writes may go to upath. A rule matches the
+// path or one of its parents, so /incoming allows everything under it.
+func (wc *writeConfig) writeAllowed(upath string) bool {
+	if len(wc.allow) == 0 {
+		return true
+	}
+	p := path.Clean("/" + upath)
+	for {
+		for _, rule := range wc.allow {
+			if ok, _ := path.Match(rule, p); ok {
+				return true
+			}
+		}
+		if p == "/" {
+			return false
+		}
+		p = path.Dir(p)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/derekcollison/nats-fs/natsfstest"
+)
+
+func TestQuotas(t *testing.T) {
+	srv := &natsfstest.Server{NATS: natsfstest.RunJetStream(t)}
+	nc := srv.Connect(t)
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "existing"), make([]byte, 40), 0644)
+
+	q, err := newQuotas(nc, "quota-test", 100)
+	if err != nil {
+		t.Fatalf("newQuotas: %v", err)
+	}
+	// Usage starts as the size of the tree.
+	if err := q.fits("", root, 60); err != nil {
+		t.Fatalf("60 more bytes do not fit: %v", err)
+	}
+	if err := q.fits("", root, 61); !errors.Is(err, errQuotaExceeded) {
+		t.Fatalf("61 more bytes = %v, want errQuotaExceeded", err)
+	}
+	if err := q.add("", root, 50); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if err := q.add("", root, 11); !errors.Is(err, errQuotaExceeded) {
+		t.Fatalf("add past the limit = %v, want errQuotaExceeded", err)
+	}
+	if err := q.add("", root, -90); err != nil {
+		t.Fatalf("freeing: %v", err)
+	}
+	// Tenants are counted apart, their trees being empty.
+	if err := q.add("a.b", root+"/none", 100); err != nil {
+		t.Fatalf("tenant add: %v", err)
+	}
+	if err := q.fits("", root, 100); err != nil {
+		t.Fatalf("tenant usage counted against the root: %v", err)
+	}
+}
+
+func TestQuotaKey(t *testing.T) {
+	if got := quotaKey(""); got != "root" {
+		t.Errorf("quotaKey(\"\") = %q, want root", got)
+	}
+	if got := quotaKey("acme.corp/x"); got != "tenant.acme=2Ecorp=2Fx" {
+		t.Errorf("quotaKey = %q, want dots and slashes escaped", got)
+	}
+}
+
+func TestWriteAllowed(t *testing.T) {
+	wc := &writeConfig{allow: []string{"/incoming", "/users/*/inbox"}}
+	for upath, want := range map[string]bool{
+		"/incoming":               true,
+		"/incoming/a/b.txt":       true,
+		"/users/bob/inbox/x":      true,
+		"/users/bob/private":      false,
+		"/elsewhere":              false,
+		"/incoming/../etc/passwd": false,
+	} {
+		if got := wc.writeAllowed(upath); got != want {
+			t.Errorf("writeAllowed(%q) = %v, want %v", upath, got, want)
+		}
+	}
+}
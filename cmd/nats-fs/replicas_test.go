@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/derekcollison/nats-fs/natsfstest"
+	"github.com/derekcollison/nats-fs/natshttp"
+)
+
+// replica serves name from its own copy, with the ETag serve sends,
+// counting the requests it answers.
+func replica(t *testing.T, name string, data []byte, mod time.Time, served *atomic.Int32) http.Handler {
+	file := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(file, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(file, mod, mod); err != nil {
+		t.Fatal(err)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served.Add(1)
+		fd, err := os.Open(file)
+		if err != nil {
+			http.Error(w, "404 page not found", http.StatusNotFound)
+			return
+		}
+		defer fd.Close()
+		fi, _ := fd.Stat()
+		w.Header().Set("ETag", fileETag(fi))
+		http.ServeContent(w, r, name, fi.ModTime(), fd)
+	})
+}
+
+func TestReplicasServeRanges(t *testing.T) {
+	data := make([]byte, 16*minRangeSize)
+	rand.New(rand.NewSource(1)).Read(data)
+	mod := time.Now().Add(-time.Hour)
+	var a, b atomic.Int32
+	opts := &natshttp.Options{Queue: "replicas"}
+	srv := natsfstest.NewServer(t, replica(t, "big.bin", data, mod, &a), opts)
+	if _, err := natshttp.Handle(srv.Connect(t), srv.Subject, replica(t, "big.bin", data, mod, &b), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(t.TempDir(), "big.bin")
+	if err := getParallel(srv.Connect(t), srv.Subject, "/big.bin", 16, out, newProgress(false)); err != nil {
+		t.Fatalf("getParallel: %v", err)
+	}
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("ranges from the replicas do not add up to the file")
+	}
+	if a.Load() == 0 || b.Load() == 0 {
+		t.Fatalf("replicas served %d and %d requests, want both to serve some", a.Load(), b.Load())
+	}
+}
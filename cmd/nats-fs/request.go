@@ -0,0 +1,138 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"unicode"
+
+	"github.com/derekcollison/nats-fs/client"
+	"github.com/derekcollison/nats-fs/ratelimit"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+)
+
+// Request settings, set from flags.
+var (
+	// Limits the rate we ack received data, nil is unlimited.
+	ackLimit *ratelimit.Bucket
+	// Our ephemeral key pair with -e2e, responses are encrypted to it.
+	e2eKey *client.E2EKey
+	// Key writes are signed with, nil to send them unsigned.
+	writeSigner nkeys.KeyPair
+)
+
+// Clients by connection, so requests on one share their settings and
+// retransmit counts.
+var clients sync.Map
+
+// clientFor returns the client making requests on nc.
+func clientFor(nc *nats.Conn) *client.Client {
+	if c, ok := clients.Load(nc); ok {
+		return c.(*client.Client)
+	}
+	c := &client.Client{
+		Conn:             nc,
+		Header:           requestHeaders(),
+		FirstByteTimeout: firstByteTimeout,
+		IdleTimeout:      idleTimeout,
+		AckLimit:         ackLimit,
+		Retry:            retryRequest,
+		E2E:              e2eKey,
+	}
+	v, _ := clients.LoadOrStore(nc, c)
+	return v.(*client.Client)
+}
+
+// requestHeaders returns the -H headers, continuing a trace started by
+// whatever is running us.
+func requestHeaders() http.Header {
+	h := make(http.Header, len(extraHeaders)+2)
+	if tp := os.Getenv("TRACEPARENT"); tp != "" {
+		h.Set("traceparent", tp)
+		if ts := os.Getenv("TRACESTATE"); ts != "" {
+			h.Set("tracestate", ts)
+		}
+	}
+	for k, v := range extraHeaders {
+		h[k] = v
+	}
+	return h
+}
+
+// newRequest creates a request for the path on subject.
+func newRequest(subj, upath string) *nats.Msg {
+	c := client.Client{Header: requestHeaders(), E2E: e2eKey}
+	return c.NewRequest(subj, upath)
+}
+
+// sendRequest sends the request and waits for the header message,
+// following any redirects. No responders, timeouts and 5xx responses are
+// retried with backoff, unless the body is being streamed, which is sent
+// once the server accepts the request. Writes are signed with -seed.
+func sendRequest(nc *nats.Conn, req *nats.Msg) (*client.Response, error) {
+	if writeSigner != nil && isWriteMethod(req.Header.Get("Method")) {
+		if err := signWrite(writeSigner, req); err != nil {
+			return nil, err
+		}
+	}
+	ctx := runCtx
+	if bs, ok := bodyStreams.Load(req.Header.Get(bodyInboxHeader)); ok {
+		ctx = client.WithContinue(ctx, bs.(*bodyStream).accept)
+	}
+	inflight.sending.Add(1)
+	defer inflight.sending.Add(-1)
+	return clientFor(nc).Do(ctx, req)
+}
+
+// checkStatus returns nil for 2xx and 304 responses. Otherwise the error
+// body is read and returned in a client.StatusError.
+func checkStatus(resp *client.Response) error {
+	return client.CheckStatus(resp)
+}
+
+// readBody copies the body of resp to w, acking each chunk for flow
+// control. A nil writer writes the body to stdout. An interrupt cancels
+// the rest of the transfer.
+func readBody(resp *client.Response, w io.Writer) (int, error) {
+	trackCancel(resp)
+	defer untrackCancel(resp)
+	if w == nil {
+		w = &stdoutWriter{}
+	}
+	n, err := io.Copy(w, resp.Body)
+	return int(n), err
+}
+
+// stdoutWriter writes to stdout, warning once if binary data is about to
+// mess up a terminal.
+type stdoutWriter struct {
+	checked bool
+}
+
+func (sw *stdoutWriter) Write(data []byte) (int, error) {
+	if !sw.checked {
+		// Binary data can mess up a terminal, not a pipe.
+		if isTerminal(os.Stdout) && !isPrintable(data) {
+			log.Printf("Warning, data received is binary, consider using -output FILE")
+		}
+		sw.checked = true
+	}
+	return os.Stdout.Write(data)
+}
+
+func isPrintable(data []byte) bool {
+	const snippetSize = 32
+	s := string(data)
+	if len(s) > snippetSize {
+		s = s[:snippetSize]
+	}
+	for _, r := range string(s) {
+		if !unicode.IsPrint(r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/textproto"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/derekcollison/nats-fs/client"
+	"github.com/nats-io/nats.go"
+)
+
+// Retries for transient failures, from -retry, and how many were made
+// other than by the clients, which count their own.
+var (
+	retries    int
+	retryCount atomic.Int64
+)
+
+// Backoff between retries doubles from the base up to the max, with jitter.
+const (
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 30 * time.Second
+)
+
+// backoff returns a random delay up to the exponential backoff for attempt.
+func backoff(attempt int) time.Duration {
+	d := retryBaseDelay << uint(attempt)
+	if d > retryMaxDelay || d <= 0 {
+		d = retryMaxDelay
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)))
+}
+
+// retransmits returns how many requests were retried and chunks fetched
+// again so far.
+func retransmits() int64 {
+	n := retryCount.Load()
+	clients.Range(func(_, c any) bool {
+		n += c.(*client.Client).Retransmits()
+		return true
+	})
+	return n
+}
+
+// retryRequest is the clients' Retry, they count the retries.
+func retryRequest(attempt int, req *nats.Msg, err error) bool {
+	return backoffWait(attempt, req.Header.Get("URL"), err)
+}
+
+// retryWait sleeps before another attempt, false if out of attempts or
+// the sleep would go past the deadline.
+func retryWait(attempt int, what string, err error) bool {
+	if !backoffWait(attempt, what, err) {
+		return false
+	}
+	retryCount.Add(1)
+	return true
+}
+
+// backoffWait is retryWait without counting the retry.
+func backoffWait(attempt int, what string, err error) bool {
+	if attempt >= retries {
+		return false
+	}
+	delay := backoff(attempt)
+	if !deadline.IsZero() && time.Now().Add(delay).After(deadline) {
+		return false
+	}
+	log.Printf("Retrying %s in %v: %v", what, delay.Round(time.Millisecond), err)
+	time.Sleep(delay)
+	return true
+}
+
+// resume continues a download into fd that failed with received bytes in
+// fd, the body of the first response written from offset. With a validator
+// from the first response it asks for the rest with a Range, up to the end
+// of the range first was, otherwise or if the server ignores it the
+// download starts over. A range can not start over, fd holds only part of
+// the file.
+func resume(nc *nats.Conn, first *client.Response, fd *os.File, offset, received int64, err error, p *progress) error {
+	req := first.Request
+	validator := validatorOf(first.Header)
+	base, end, cerr := resumeBounds(first, offset)
+	if cerr != nil {
+		return err
+	}
+	for attempt := 0; errors.Is(err, client.ErrIncomplete) && retryWait(attempt, req.Header.Get("URL"), err); attempt++ {
+		next := nats.NewMsg(req.Subject)
+		for k, v := range req.Header {
+			next.Header[k] = v
+		}
+		if validator != "" && (received > 0 || base > 0) {
+			next.Header.Set("Range", resumeRange(base+received, end))
+			next.Header.Set("If-Range", validator)
+		}
+
+		resp, serr := sendRequest(nc, next)
+		if serr != nil {
+			err = fmt.Errorf("%w: %v", client.ErrIncomplete, serr)
+			continue
+		}
+		if err = checkStatus(resp); err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusPartialContent {
+			if base > 0 {
+				resp.Body.Close()
+				return fmt.Errorf("%w: %s", errChanged, req.Header.Get("URL"))
+			}
+			// Starting over.
+			if err := fd.Truncate(0); err != nil {
+				resp.Body.Close()
+				return err
+			}
+			if _, err := fd.Seek(0, io.SeekStart); err != nil {
+				resp.Body.Close()
+				return err
+			}
+			received = 0
+		}
+		var n int
+		n, err = readBody(resp, p.Writer(fd))
+		received += int64(n)
+		resp.Body.Close()
+	}
+	return err
+}
+
+// resumeBounds returns the byte of the file that byte 0 of the output is,
+// the body of first being written from offset, and the last byte wanted,
+// -1 for the end of the file.
+func resumeBounds(first *client.Response, offset int64) (int64, int64, error) {
+	if first.StatusCode != http.StatusPartialContent {
+		return 0, -1, nil
+	}
+	start, end, err := parseContentRange(textproto.MIMEHeader(first.Header))
+	if err != nil {
+		return 0, 0, err
+	}
+	return start - offset, end, nil
+}
+
+// resumeRange returns the Range asking for bytes start to end, -1 for the
+// end of the file.
+func resumeRange(start, end int64) string {
+	r := fmt.Sprintf("bytes=%d-", start)
+	if end >= 0 {
+		r += strconv.FormatInt(end, 10)
+	}
+	return r
+}
+
+// validatorOf returns the ETag of a response, or else its Last-Modified,
+// for If-Range.
+func validatorOf(h http.Header) string {
+	if v := h.Get("ETag"); v != "" {
+		return v
+	}
+	return h.Get("Last-Modified")
+}
+
+// resumeFile is where -continue keeps the validator of a download until
+// it completes, so a later run can resume it with If-Range.
+func resumeFile(output string) string {
+	return output + ".nats-fs-resume"
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/derekcollison/nats-fs/client"
+)
+
+func TestResumeRange(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		status   int
+		cr       string
+		offset   int64
+		received int64
+		want     string
+	}{
+		// A whole file cut short.
+		{"whole", 200, "", 0, 100, "bytes=100-"},
+		// -range 1000-1999 written from 0, cut after 100 bytes.
+		{"range", 206, "bytes 1000-1999/5000", 0, 100, "bytes=1100-1999"},
+		// -continue from 300, the first 300 bytes already in the output.
+		{"continue", 206, "bytes 300-4999/5000", 300, 400, "bytes=400-4999"},
+	} {
+		first := &client.Response{Stat: client.Stat{StatusCode: tc.status, Header: http.Header{}}}
+		if tc.cr != "" {
+			first.Header.Set("Content-Range", tc.cr)
+		}
+		base, end, err := resumeBounds(first, tc.offset)
+		if err != nil {
+			t.Fatalf("%s: resumeBounds: %v", tc.name, err)
+		}
+		if got := resumeRange(base+tc.received, end); got != tc.want {
+			t.Errorf("%s: resuming with %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	natsfs "github.com/derekcollison/nats-fs"
+)
+
+// With -backend s3 objects are streamed from an S3 compatible bucket, such
+// as MinIO, as they arrive, served like any other natsfs.Backend. Buckets are addressed path style. Requests are
+// signed with AWS Signature V4 using AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN, or sent unsigned without
+// them for public buckets.
+
+// SHA-256 of an empty payload, all our requests have one.
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// s3Backend is a read-only natsfs.Backend of the objects in bucket under
+// prefix.
+type s3Backend struct {
+	endpoint *url.URL
+	bucket   string
+	prefix   string
+	region   string
+	// Credentials, empty to send requests unsigned.
+	accessKey string
+	secretKey string
+	token     string
+	client    *http.Client
+}
+
+func newS3Backend(endpoint, bucket, region, prefix string) (*s3Backend, error) {
+	if bucket == "" {
+		return nil, fmt.Errorf("-bucket is required with -backend s3")
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("bad S3 endpoint %q", endpoint)
+	}
+	// Objects are passed through as stored, not decompressed on the way.
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DisableCompression = true
+	return &s3Backend{
+		endpoint:  u,
+		bucket:    bucket,
+		prefix:    strings.Trim(prefix, "/"),
+		region:    region,
+		accessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		token:     os.Getenv("AWS_SESSION_TOKEN"),
+		client:    &http.Client{Transport: t},
+	}, nil
+}
+
+// key returns the object key for a request path.
+func (s *s3Backend) key(upath string) string {
+	return strings.TrimPrefix(path.Join(s.prefix, path.Clean("/"+upath)), "/")
+}
+
+// Stat returns the info of an object, or of a directory if objects share
+// its name as a prefix. Stat of the root checks the bucket can be reached.
+func (s *s3Backend) Stat(ctx context.Context, name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		res, err := s.do(ctx, http.MethodHead, "", nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("bucket %q: %s", s.bucket, res.Status)
+		}
+		return s3Info{name: ".", dir: true}, nil
+	}
+	res, err := s.do(ctx, http.MethodHead, s.key(name), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+		return objectInfo(path.Base(name), res.Header), nil
+	case http.StatusNotFound:
+	case http.StatusForbidden:
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrPermission}
+	default:
+		return nil, fmt.Errorf("stat %q: %s", name, res.Status)
+	}
+	var lr listBucketResult
+	q := url.Values{"list-type": {"2"}, "prefix": {s.key(name) + "/"}, "max-keys": {"1"}}
+	if err := s.getXML(ctx, q, &lr); err != nil {
+		return nil, err
+	}
+	if len(lr.Contents) == 0 && len(lr.CommonPrefixes) == 0 {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return s3Info{name: path.Base(name), dir: true}, nil
+}
+
+// Open returns an object read with ranged GETs from wherever it was last
+// sought to, so ranges are served without fetching the whole object.
+func (s *s3Backend) Open(ctx context.Context, name string) (io.ReadCloser, fs.FileInfo, error) {
+	fi, err := s.Stat(ctx, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if fi.IsDir() {
+		return nil, nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	info := fi.(s3Info)
+	return &s3Object{s: s, ctx: ctx, key: s.key(name), size: info.size, etag: info.etag}, fi, nil
+}
+
+// List returns the objects in dir, and its subdirectories being the common
+// prefixes of their keys.
+func (s *s3Backend) List(ctx context.Context, dir string) ([]fs.FileInfo, error) {
+	if !fs.ValidPath(dir) {
+		return nil, &fs.PathError{Op: "readdir", Path: dir, Err: fs.ErrInvalid}
+	}
+	prefix := s.key(dir)
+	if prefix != "" {
+		prefix += "/"
+	}
+	fis := []fs.FileInfo{}
+	q := url.Values{"list-type": {"2"}, "prefix": {prefix}, "delimiter": {"/"}}
+	for {
+		var lr listBucketResult
+		if err := s.getXML(ctx, q, &lr); err != nil {
+			return nil, err
+		}
+		for _, cp := range lr.CommonPrefixes {
+			fis = append(fis, s3Info{name: path.Base(strings.TrimSuffix(cp.Prefix, "/")), dir: true})
+		}
+		for _, c := range lr.Contents {
+			// Placeholders some tools create for empty directories.
+			if strings.HasSuffix(c.Key, "/") {
+				continue
+			}
+			fis = append(fis, s3Info{name: path.Base(c.Key), size: c.Size, mtime: c.LastModified})
+		}
+		if !lr.IsTruncated || lr.NextContinuationToken == "" {
+			break
+		}
+		q.Set("continuation-token", lr.NextContinuationToken)
+	}
+	if len(fis) == 0 && dir != "." {
+		return nil, &fs.PathError{Op: "readdir", Path: dir, Err: fs.ErrNotExist}
+	}
+	sort.Slice(fis, func(i, j int) bool { return fis[i].Name() < fis[j].Name() })
+	return fis, nil
+}
+
+func (s *s3Backend) Put(ctx context.Context, name string, r io.Reader) error {
+	return natsfs.ErrReadOnly
+}
+
+func (s *s3Backend) Delete(ctx context.Context, name string) error {
+	return natsfs.ErrReadOnly
+}
+
+// s3Object reads an object from off, opening a ranged GET on the first
+// read after each seek. Reads fail if the object changes underneath.
+type s3Object struct {
+	s    *s3Backend
+	ctx  context.Context
+	key  string
+	size int64
+	etag string
+	off  int64
+	body io.ReadCloser
+}
+
+func (o *s3Object) Read(p []byte) (int, error) {
+	if o.body == nil {
+		if o.off >= o.size {
+			return 0, io.EOF
+		}
+		hdr := http.Header{"Range": {fmt.Sprintf("bytes=%d-", o.off)}}
+		if o.etag != "" {
+			hdr.Set("If-Match", o.etag)
+		}
+		res, err := o.s.do(o.ctx, http.MethodGet, o.key, nil, hdr)
+		if err != nil {
+			return 0, err
+		}
+		if res.StatusCode != http.StatusPartialContent && (res.StatusCode != http.StatusOK || o.off != 0) {
+			res.Body.Close()
+			return 0, fmt.Errorf("reading %q: %s", o.key, res.Status)
+		}
+		o.body = res.Body
+	}
+	n, err := o.body.Read(p)
+	o.off += int64(n)
+	return n, err
+}
+
+func (o *s3Object) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += o.off
+	case io.SeekEnd:
+		offset += o.size
+	}
+	if offset < 0 {
+		return 0, errors.New("s3: negative position")
+	}
+	if offset != o.off && o.body != nil {
+		o.body.Close()
+		o.body = nil
+	}
+	o.off = offset
+	return offset, nil
+}
+
+func (o *s3Object) Close() error {
+	if o.body != nil {
+		return o.body.Close()
+	}
+	return nil
+}
+
+// s3Info describes an object or a common prefix.
+type s3Info struct {
+	name  string
+	size  int64
+	mtime time.Time
+	etag  string
+	dir   bool
+}
+
+// objectInfo returns the info in the response headers of an object.
+func objectInfo(name string, h http.Header) s3Info {
+	size, _ := strconv.ParseInt(h.Get("Content-Length"), 10, 64)
+	mtime, _ := http.ParseTime(h.Get("Last-Modified"))
+	return s3Info{name: name, size: size, mtime: mtime, etag: h.Get("ETag")}
+}
+
+func (fi s3Info) Name() string       { return fi.name }
+func (fi s3Info) Size() int64        { return fi.size }
+func (fi s3Info) ModTime() time.Time { return fi.mtime }
+func (fi s3Info) IsDir() bool        { return fi.dir }
+func (fi s3Info) Sys() interface{}   { return nil }
+
+func (fi s3Info) Mode() fs.FileMode {
+	if fi.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+// listBucketResult is the part of a ListObjectsV2 response we use.
+type listBucketResult struct {
+	Contents []struct {
+		Key          string
+		Size         int64
+		LastModified time.Time
+	}
+	CommonPrefixes []struct {
+		Prefix string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// getXML sends a bucket request with query q and decodes the response.
+func (s *s3Backend) getXML(ctx context.Context, q url.Values, v interface{}) error {
+	res, err := s.do(ctx, http.MethodGet, "", q, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s", res.Status)
+	}
+	return xml.NewDecoder(res.Body).Decode(v)
+}
+
+// do sends a signed request for key, or the bucket if key is empty.
+func (s *s3Backend) do(ctx context.Context, method, key string, q url.Values, hdr http.Header) (*http.Response, error) {
+	u := *s.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawPath = awsEscape(u.Path, false)
+	u.RawQuery = canonicalQuery(q)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range hdr {
+		req.Header[k] = v
+	}
+	s.sign(req, time.Now().UTC())
+	return s.client.Do(req)
+}
+
+// sign adds an AWS Signature V4 to req, if we have credentials.
+func (s *s3Backend) sign(req *http.Request, now time.Time) {
+	if s.accessKey == "" {
+		return
+	}
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptySHA256)
+	if s.token != "" {
+		req.Header.Set("X-Amz-Security-Token", s.token)
+	}
+
+	signed := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		signed[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(signed))
+	for k := range signed {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var headers strings.Builder
+	for _, k := range names {
+		headers.WriteString(k + ":" + signed[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		headers.String(),
+		signedHeaders,
+		emptySHA256,
+	}, "\n")
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	sum := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	sig := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.accessKey, scope, signedHeaders, sig))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// canonicalQuery encodes q sorted, the way AWS signs it.
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		for _, v := range q[k] {
+			parts = append(parts, awsEscape(k, true)+"="+awsEscape(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// awsEscape percent-encodes all but the unreserved characters, and
+// slashes unless encodeSlash is set.
+func awsEscape(s string, encodeSlash bool) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			sb.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			sb.WriteByte(c)
+		default:
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// securityHeaders are added to responses for browsers loading assets,
+// whether they arrive over NATS or the HTTP listener.
+type securityHeaders struct {
+	nosniff bool
+	csp     string
+	cache   cacheRules
+}
+
+// Nil adds nothing.
+func newSecurityHeaders(nosniff bool, csp string, cache cacheRules) *securityHeaders {
+	if !nosniff && csp == "" && len(cache) == 0 {
+		return nil
+	}
+	return &securityHeaders{nosniff: nosniff, csp: csp, cache: cache}
+}
+
+// wrap sets X-Content-Type-Options and Content-Security-Policy on every
+// response, and Cache-Control on those that succeed unless the handler
+// set its own.
+func (sh *securityHeaders) wrap(next http.Handler) http.Handler {
+	if sh == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		if sh.nosniff {
+			h.Set("X-Content-Type-Options", "nosniff")
+		}
+		if sh.csp != "" {
+			h.Set("Content-Security-Policy", sh.csp)
+		}
+		cc := sh.cache.match(r.URL.Path)
+		if cc == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(&cacheControlWriter{ResponseWriter: w, value: cc}, r)
+	})
+}
+
+type cacheRule struct {
+	pattern string
+	value   string
+}
+
+// cacheRules are Cache-Control values by path pattern. It is a flag.Value
+// taking repeated PATTERN=VALUE arguments. Like -exclude, patterns without
+// a slash match the file name, others the whole path.
+type cacheRules []cacheRule
+
+func (cr *cacheRules) String() string {
+	return ""
+}
+
+func (cr *cacheRules) Set(v string) error {
+	pattern, value, ok := strings.Cut(v, "=")
+	if !ok || pattern == "" || strings.TrimSpace(value) == "" {
+		return fmt.Errorf("expected PATTERN=VALUE, got %q", v)
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("bad pattern %q: %v", pattern, err)
+	}
+	*cr = append(*cr, cacheRule{pattern: pattern, value: strings.TrimSpace(value)})
+	return nil
+}
+
+// match returns the value of the first rule matching upath, empty if none.
+func (cr cacheRules) match(upath string) string {
+	rel := strings.Trim(path.Clean("/"+upath), "/")
+	for _, rule := range cr {
+		var ok bool
+		if strings.Contains(rule.pattern, "/") {
+			ok, _ = path.Match(strings.Trim(rule.pattern, "/"), rel)
+		} else {
+			ok, _ = path.Match(rule.pattern, path.Base("/"+rel))
+		}
+		if ok {
+			return rule.value
+		}
+	}
+	return ""
+}
+
+// cacheControlWriter sets Cache-Control when a successful status is
+// written, so errors are not cached for as long as the files.
+type cacheControlWriter struct {
+	http.ResponseWriter
+	value       string
+	wroteHeader bool
+}
+
+func (w *cacheControlWriter) WriteHeader(code int) {
+	if code < 200 {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if h := w.Header(); code < 400 && h.Get("Cache-Control") == "" {
+			h.Set("Cache-Control", w.value)
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *cacheControlWriter) Write(data []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// ReadFrom keeps the underlying writer's fast path.
+func (w *cacheControlWriter) ReadFrom(r io.Reader) (int64, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(struct{ io.Writer }{w.ResponseWriter}, r)
+}
+
+func (w *cacheControlWriter) Flush() {
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *cacheControlWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
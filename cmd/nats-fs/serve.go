@@ -0,0 +1,526 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"expvar"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	natsfs "github.com/derekcollison/nats-fs"
+	"github.com/derekcollison/nats-fs/natshttp"
+	"github.com/derekcollison/nats-fs/ratelimit"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// runServe serves a file or directory over NATS and HTTP.
+func runServe(args []string) {
+	fs := newFlagSet("serve", "<file|directory>")
+	conn := addConnFlags(fs)
+	var subject = fs.String("subject", "foo", "Subject to serve requests on, health checks are on SUBJECT.healthz and HTTP "+healthPath)
+	var tenants = fs.String("tenants", "", "Serve ROOT/<tenant> on PREFIX.<tenant> and PREFIX.<tenant>.> for this prefix, HTTP requests have no tenant")
+	var queue = fs.String("queue", "nats-fs", "Queue group shared by server replicas, which share out requests down to the ranges of one file")
+	var rate = fs.String("max-rate", "", "Max total send rate, e.g. 50MB/s")
+	var transferRate = fs.String("max-rate-per-transfer", "", "Max send rate for a single transfer, e.g. 10MB/s")
+	var requesterRate = fs.Float64("requester-rate", 0, "Max requests per second from a single requester")
+	var requesterTransfers = fs.Int("requester-max-transfers", 0, "Max concurrent transfers for a single requester")
+	var maxHandlers = fs.Int("max-handlers", 0, "Max concurrent NATS request handlers, 0 is unlimited")
+	var maxQueued = fs.Int("max-queued", 64, "Max NATS requests waiting for a handler")
+	var overflow = fs.String("overflow", "reject", "When handlers and queue are full, \"reject\" with 503 or \"block\"")
+	var chunk = fs.String("chunk-size", "0", "Size of response chunks, 0 for the default")
+	var window = fs.String("window", "0", "Bytes sent before waiting for acks, 0 for the default")
+	var readAhead = fs.Int("read-ahead", 0, "Chunks read from disk ahead of those being sent, 0 for the default, -1 for none")
+	var readAheadMem = fs.String("read-ahead-memory", "64MB", "Most memory chunks read ahead may take, transfers over it go without")
+	var cacheSize = fs.String("cache-size", "0", "Memory used to cache small files, e.g. 64MB, 0 disables")
+	var cacheMaxObject = fs.String("cache-max-object", "1MB", "Largest file that will be cached")
+	var precompressed = fs.Bool("precompressed", false, "Serve file.br, file.zst or file.gz in place of file when accepted")
+	var autoindex = fs.Bool("autoindex", true, "List directories without an index.html, otherwise 403")
+	var hideDotfiles = fs.Bool("hide-dotfiles", false, "Never serve or list files or directories starting with a dot")
+	var excludes stringList
+	fs.Var(&excludes, "exclude", "Glob pattern of paths to never serve or list, e.g. *.key (repeatable)")
+	var corsOrigins stringList
+	fs.Var(&corsOrigins, "cors-origin", "Allowed CORS origin for the HTTP listener, * for any (repeatable)")
+	var corsMethods = fs.String("cors-methods", "GET, HEAD, OPTIONS", "Allowed CORS methods")
+	var corsHeaders = fs.String("cors-headers", "", "Allowed CORS request headers, default is to allow those requested")
+	var corsExpose = fs.String("cors-expose", "Content-Length, Content-Range, ETag, Last-Modified", "CORS response headers exposed to browsers")
+	var corsMaxAge = fs.Int("cors-max-age", 600, "Seconds browsers may cache a CORS preflight response")
+	var nosniff = fs.Bool("nosniff", false, "Send X-Content-Type-Options: nosniff so browsers never guess content types")
+	var csp = fs.String("csp", "", "Content-Security-Policy sent with every response, e.g. \"default-src 'self'\"")
+	var cacheControl cacheRules
+	fs.Var(&cacheControl, "cache-control", "Cache-Control for successful responses as PATTERN=VALUE, first match wins, e.g. \"*.html=no-cache\" (repeatable)")
+	var tracing = fs.Bool("trace", false, "Export OpenTelemetry traces via OTLP, see OTEL_EXPORTER_OTLP_ENDPOINT")
+	var control = fs.String("control", defaultControlPrefix, "Prefix of the admin subjects, empty disables")
+	var adminKey = fs.String("admin-key", "", "Public nkey admin commands must be signed with, see nats-fs admin -seed")
+	var adminUnsigned = fs.Bool("admin-unsigned", false, "Answer unsigned admin commands, only when NATS permissions restrict who may publish on the control subjects")
+	var level = fs.String("log-level", logInfo, "Log level, \"info\" or \"debug\" to log every request")
+	var statsSubject = fs.String("stats-events", "nats-fs.events.stats", "Subject per path stats snapshots are published on")
+	var statsInterval = fs.Duration("stats-interval", 0, "How often to publish per path stats snapshots, 0 disables")
+	var signSeed = fs.String("sign-seed", "", "Nkey seed file to sign responses and change events with, and end to end encryption keys, clients verify with -verify-key or -e2e-key and mirrors with -mirror-key")
+	var readOnly = fs.Bool("read-only", true, "Refuse methods that change files, set to false to allow PUT, uploads, DELETE, MKCOL, MOVE and COPY")
+	var uploadDir = fs.String("upload-dir", filepath.Join(os.TempDir(), "nats-fs-uploads"), "Where resumable upload sessions are kept, outside the served tree")
+	var uploadTTL = fs.Duration("upload-ttl", 24*time.Hour, "How long an upload session may go without a request before it is removed, 0 keeps them")
+	var maxUpload = fs.String("max-upload", "0", "Largest file that may be written, e.g. 1GB, 0 for no limit")
+	var writeKeys stringList
+	fs.Var(&writeKeys, "write-key", "Public nkey writes may be signed with, by requesters running with -seed (repeatable)")
+	var writeCallout = fs.String("write-callout", "", "Subject asked to approve each write, replying with nothing to allow it or the reason it is refused")
+	var writeAllow stringList
+	fs.Var(&writeAllow, "write-allow", "Glob pattern of paths writes may go to, matching the path or a parent, e.g. /incoming (repeatable, default anywhere)")
+	var quota = fs.String("quota", "0", "Bytes each tenant, or the whole tree, may store, e.g. 10GB, 0 for no limit")
+	var quotaBucket = fs.String("quota-bucket", "nats-fs-quota", "JetStream KV bucket usage is kept in, shared by replicas")
+	var casDir = fs.String("cas", "", "Store written files once per content in this directory, on the same filesystem as the tree, and link paths to them")
+	var historyBucket = fs.String("history", "", "JetStream object store to keep revisions of written files in, fetched with ?rev=N or X-Version")
+	var historyKeep = fs.Int("history-keep", 10, "Revisions kept of each file, 0 keeps all")
+	var mirrorSubject = fs.String("mirror", "", "Keep the directory in step with the instance serving this subject, syncing at start then following its change feed")
+	var mirrorEvents = fs.String("mirror-events", "nats-fs.events.changes", "Change feed subject of the instance we mirror")
+	var mirrorDelta = fs.Bool("mirror-delta", true, "Fetch changed files as deltas against our copy when mirroring")
+	var mirrorKey = fs.String("mirror-key", "", "Public nkey the instance we mirror signs its change events with, its -sign-seed, required with -mirror")
+	var source = fs.String("backend", "file", "Where files are served from, \"file\", \"s3\" to stream objects from -bucket or \"objects\" for the JetStream object store -bucket")
+	var s3Bucket = fs.String("bucket", "", "S3 bucket to serve, the argument being an optional key prefix, or object store with -backend objects")
+	var s3Endpoint = fs.String("endpoint", "https://s3.amazonaws.com", "S3 compatible endpoint, e.g. http://localhost:9000 for MinIO")
+	var s3Region = fs.String("region", "us-east-1", "S3 region requests are signed for")
+	var zipFile = fs.String("zip", "", "Serve the files in this zip archive, without unpacking them, in place of a file or directory")
+	var pidFile = fs.String("pid-file", "", "Write our process ID to this file while serving")
+	var changeEvents = fs.String("change-events", "nats-fs.events.changes", "Subject change events are published on, empty disables")
+	var pages = errorPages{}
+	fs.Var(pages, "error-page", "Custom error body as CODE=FILE, FILE.tmpl is a Go template (repeatable)")
+
+	fs.Parse(args)
+
+	var alt *storeHandler
+	var objects *storeHandler
+	var err error
+	switch {
+	case *zipFile != "":
+		if *source != "file" || fs.NArg() != 0 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		zr, err := zip.OpenReader(*zipFile)
+		if err != nil {
+			log.Fatalf("Error opening %q: %v", *zipFile, err)
+		}
+		defer zr.Close()
+		alt = newStoreHandler(natsfs.FSBackend(&zr.Reader), *autoindex)
+	case *source == "file":
+		if fs.NArg() != 1 {
+			fs.Usage()
+			os.Exit(1)
+		}
+	case *source == "objects":
+		if fs.NArg() != 0 || *s3Bucket == "" {
+			fs.Usage()
+			os.Exit(1)
+		}
+		// Opened once connected.
+		objects = &storeHandler{}
+		alt = objects
+	case *source == "s3":
+		if fs.NArg() > 1 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		s3, err := newS3Backend(*s3Endpoint, *s3Bucket, *s3Region, fs.Arg(0))
+		if err != nil {
+			log.Fatal(err)
+		}
+		alt = newStoreHandler(s3, *autoindex)
+	default:
+		log.Fatalf("Unknown backend %q", *source)
+	}
+	if alt != nil && (*tenants != "" || *mirrorSubject != "" || !*readOnly) {
+		log.Fatalf("Serving from a zip, S3 or an object store is read-only and of a single tree")
+	}
+
+	root := fs.Arg(0)
+	isDir := false
+	if alt == nil {
+		fi, err := os.Stat(root)
+		if os.IsNotExist(err) {
+			log.Fatalf("File %q does not exist", root)
+		} else if err != nil {
+			log.Fatal(err)
+		}
+		isDir = fi.IsDir()
+	}
+	if *tenants != "" && !isDir {
+		log.Fatalf("Serving tenants requires a directory")
+	}
+	if *mirrorSubject != "" && !isDir {
+		log.Fatalf("Mirroring requires a directory")
+	}
+	if *mirrorSubject != "" && *mirrorKey == "" {
+		log.Fatalf("Mirroring requires -mirror-key to authenticate the change feed")
+	}
+
+	nopts := &natshttp.Options{
+		Queue:                 *queue,
+		RequesterRate:         *requesterRate,
+		RequesterMaxTransfers: *requesterTransfers,
+		MaxHandlers:           *maxHandlers,
+		MaxQueued:             *maxQueued,
+	}
+	if nopts.MaxRate, err = ratelimit.ParseRate(*rate); err != nil {
+		log.Fatal(err)
+	}
+	if nopts.MaxRatePerTransfer, err = ratelimit.ParseRate(*transferRate); err != nil {
+		log.Fatal(err)
+	}
+	switch *overflow {
+	case "reject":
+	case "block":
+		nopts.Block = true
+	default:
+		log.Fatalf("Unknown overflow behavior %q", *overflow)
+	}
+
+	if nopts.ChunkSize, err = parseSizeInt(*chunk); err != nil {
+		log.Fatal(err)
+	}
+	if nopts.WindowSize, err = parseSizeInt(*window); err != nil {
+		log.Fatal(err)
+	}
+	nopts.ReadAhead = *readAhead
+	if nopts.MaxReadAheadMemory, err = parseSize(*readAheadMem); err != nil {
+		log.Fatal(err)
+	}
+	nopts.HandlerStats = &natshttp.HandlerStats{}
+	nopts.ReadAheadStats = &natshttp.ReadAheadStats{}
+	publishStats(nopts.HandlerStats, nopts.ReadAheadStats)
+
+	if err := setLogLevel(*level); err != nil {
+		log.Fatal(err)
+	}
+
+	cacheTotal, err := parseSize(*cacheSize)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cacheObject, err := parseSize(*cacheMaxObject)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cache := newFileCache(cacheTotal, cacheObject)
+
+	if hidden, err = newHideRules(*hideDotfiles, excludes); err != nil {
+		log.Fatal(err)
+	}
+
+	var tp *sdktrace.TracerProvider
+	if *tracing {
+		if tp, err = setupTracing(context.Background()); err != nil {
+			log.Fatalf("Error setting up tracing: %v", err)
+		}
+	}
+
+	var sign natshttp.Middleware
+	var signer nkeys.KeyPair
+	if *signSeed != "" {
+		if signer, err = loadSigner(*signSeed); err != nil {
+			log.Fatalf("Error loading signing key: %v", err)
+		}
+		pub, _ := signer.PublicKey()
+		log.Printf("Signing responses and change events with %s", pub)
+		sign = signResponses(signer)
+		nopts.E2ESigner = signer
+	}
+
+	// Connect to NATS
+	nc := conn.connect("NATS HTTP File Server")
+	defer nc.Close()
+
+	if objects != nil {
+		obs, err := openObjectStore(nc, *s3Bucket)
+		if err != nil {
+			log.Fatalf("Error opening object store %q: %v", *s3Bucket, err)
+		}
+		*objects = *newStoreHandler(natsfs.ObjectStoreBackend(obs), *autoindex)
+	}
+
+	if *mirrorSubject != "" {
+		if err := startMirror(nc, *mirrorSubject, *mirrorEvents, *mirrorKey, root, *mirrorDelta); err != nil {
+			log.Fatalf("NATS Error subscribing to %q, %v", *mirrorEvents, err)
+		}
+	}
+
+	writes := &writeConfig{readOnly: *readOnly, nc: nc, events: *changeEvents, subject: *subject, signer: signer, uploads: &uploads{dir: *uploadDir, ttl: *uploadTTL}, tenants: *tenants}
+	if writes.maxUpload, err = parseSize(*maxUpload); err != nil {
+		log.Fatal(err)
+	}
+	writes.auth = &writeAuth{nc: nc, callout: *writeCallout}
+	for _, k := range writeKeys {
+		kp, err := nkeys.FromPublicKey(k)
+		if err != nil {
+			log.Fatalf("Bad write key %q: %v", k, err)
+		}
+		writes.auth.keys = append(writes.auth.keys, kp)
+	}
+	if !*readOnly && len(writeKeys) == 0 && *writeCallout == "" {
+		log.Printf("Writes are only taken over NATS, from whoever may publish on %q, set -write-key or -write-callout to authorize them", *subject)
+	}
+	if !*readOnly && *uploadTTL > 0 {
+		// Sessions may have expired while we were not running.
+		writes.uploads.sweep(time.Now())
+		go writes.uploads.sweepEvery(min(*uploadTTL/4, time.Hour))
+	}
+	for _, p := range writeAllow {
+		p = path.Clean("/" + p)
+		if _, err := path.Match(p, ""); err != nil {
+			log.Fatalf("Bad write-allow pattern %q: %v", p, err)
+		}
+		writes.allow = append(writes.allow, p)
+	}
+	if *casDir != "" {
+		if writes.cas, err = openCAS(*casDir); err != nil {
+			log.Fatalf("Error opening content store %q: %v", *casDir, err)
+		}
+	}
+	if *historyBucket != "" {
+		if writes.history, err = openHistory(nc, *historyBucket, *historyKeep, root); err != nil {
+			log.Fatalf("Error opening history in %q: %v", *historyBucket, err)
+		}
+	}
+	if limit, err := parseSize(*quota); err != nil {
+		log.Fatal(err)
+	} else if limit > 0 {
+		if writes.quotas, err = newQuotas(nc, *quotaBucket, limit); err != nil {
+			log.Fatalf("Error setting up quotas in %q: %v", *quotaBucket, err)
+		}
+	}
+
+	// Largest chunk ReadFrom will send.
+	maxChunk := natshttp.MaxChunk(nc)
+	if nopts.ChunkSize > 0 && nopts.ChunkSize < maxChunk {
+		maxChunk = nopts.ChunkSize
+	}
+	// Only directories are written to.
+	caps := newCapabilities(maxChunk, alt == nil, *readOnly || !isDir, *precompressed, sign != nil, writes.cas != nil)
+
+	fh := pages.wrap(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			caps.serveOptions(w, r)
+			return
+		}
+		if hidden.hide(r.URL.Path) {
+			http.Error(w, "404 page not found", http.StatusNotFound)
+			return
+		}
+		if alt != nil {
+			alt.ServeHTTP(w, r)
+			return
+		}
+		file := root
+		if *tenants != "" {
+			tenant, ok := tenantOf(r, *tenants)
+			if !ok {
+				http.Error(w, "404 page not found", http.StatusNotFound)
+				return
+			}
+			file = filepath.Join(root, tenant)
+		}
+		dir := file
+		var store *storeHandler
+		if isDir {
+			store = newStoreHandler(natsfs.DirBackend(dir), *autoindex)
+			file = resolvePath(dir, r.URL.Path)
+		} else {
+			// A single file is served whatever the path.
+			store = newStoreHandler(natsfs.DirBackend(filepath.Dir(root)), false)
+			r = r.Clone(r.Context())
+			r.URL.Path = "/" + filepath.Base(root)
+		}
+		if isWriteMethod(r.Method) {
+			if !isDir {
+				w.Header().Set("Allow", "GET, HEAD")
+				http.Error(w, "405 method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			writes.serve(w, r, dir, file)
+			return
+		}
+		if isFollowRequest(r) {
+			serveFollow(w, r, file)
+			return
+		}
+		if isStatRequest(r) {
+			serveStat(w, r, file, writes.history)
+			return
+		}
+		if rev, err := requestedRev(r); err != nil {
+			http.Error(w, "400 bad request, "+err.Error(), http.StatusBadRequest)
+			return
+		} else if rev > 0 {
+			writes.history.serveVersion(w, r, file, rev)
+			return
+		}
+		if isListRequest(r) {
+			store.ServeHTTP(w, r)
+			return
+		}
+		if isArchiveRequest(r) {
+			serveArchive(w, r, file)
+			return
+		}
+		if isDeltaRequest(r) {
+			serveDelta(w, r, file)
+			return
+		}
+		if isDir {
+			var ok bool
+			if r, ok = store.resolveIndex(w, r); !ok {
+				return
+			}
+			file = resolvePath(dir, r.URL.Path)
+		}
+		if fi, err := os.Stat(file); err == nil && fi.Mode().IsRegular() {
+			w.Header().Set("ETag", fileETag(fi))
+		}
+		if *precompressed && servePrecompressed(w, r, file) {
+			return
+		}
+		if cache.serveCached(w, r, file) {
+			return
+		}
+		store.ServeHTTP(w, r)
+	})
+
+	stats := newPathStats()
+	if *statsInterval > 0 {
+		go stats.publishEvents(nc, *statsSubject, *statsInterval)
+	}
+
+	// Same middleware whichever way requests arrive.
+	mw := []natshttp.Middleware{natshttp.RequestID, logRequests, stats.count}
+	if sign != nil {
+		// Just inside Recover so its 500s are signed too.
+		mw = append(mw, sign)
+	}
+	if sh := newSecurityHeaders(*nosniff, *csp, cacheControl); sh != nil {
+		mw = append(mw, sh.wrap)
+	}
+	h := natshttp.Chain(fh, append(mw, natshttp.Recover)...)
+
+	// Handle via NATS.
+	var servers natsServers
+	servers.serve(nc, *subject, h, nopts)
+	if *tenants != "" {
+		serveTenants(&servers, nc, *tenants, h, nopts)
+	}
+
+	// Health checks, every replica answers.
+	healthy := func() error { return checkRoot(root) }
+	if alt != nil {
+		healthy = alt.check
+	}
+	health := healthHandler(nc, healthy, nopts.HandlerStats)
+	servers.serve(nc, *subject+"."+healthName, health, nil)
+
+	// Admin commands, every replica answers. They are only taken from
+	// whoever holds the admin key, or on trust with -admin-unsigned.
+	switch {
+	case *control == "":
+	case *adminKey != "":
+		key, err := nkeys.FromPublicKey(*adminKey)
+		if err != nil {
+			log.Fatalf("Bad admin key %q: %v", *adminKey, err)
+		}
+		if _, err := handleControl(nc, *control, key, stats, fs); err != nil {
+			log.Fatalf("NATS Error subscribing to %q, %v", *control+".*", err)
+		}
+	case *adminUnsigned:
+		if _, err := handleControl(nc, *control, nil, stats, fs); err != nil {
+			log.Fatalf("NATS Error subscribing to %q, %v", *control+".*", err)
+		}
+	default:
+		log.Printf("Admin commands disabled, set -admin-key or -admin-unsigned")
+	}
+
+	// Handle via HTTP
+	cors := newCorsConfig(corsOrigins, *corsMethods, *corsHeaders, *corsExpose, *corsMaxAge)
+	http.Handle("/", cors.wrap(h))
+	http.Handle(healthPath, health)
+
+	ln, err := net.Listen("tcp", ":8080")
+	if err != nil {
+		log.Fatal(err)
+	}
+	hs := &http.Server{}
+	go func() {
+		if err := hs.Serve(ln); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+	log.Printf("Listening on HTTP localhost:8080")
+
+	d, err := startDaemon(*pidFile, healthy)
+	if err != nil {
+		log.Fatalf("Error writing PID file: %v", err)
+	}
+	waitForStop()
+	d.stop()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	// Let requests in progress finish.
+	servers.shutdown(ctx)
+	hs.Shutdown(ctx)
+	if err := nc.Drain(); err != nil {
+		log.Printf("Error draining NATS connection: %v", err)
+	}
+	for !nc.IsClosed() && ctx.Err() == nil {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if tp != nil {
+		// Export the spans still batched.
+		if err := tp.Shutdown(ctx); err != nil {
+			log.Printf("Error shutting down tracing: %v", err)
+		}
+	}
+}
+
+// natsServers serve the NATS subjects, shut down together.
+type natsServers []*natshttp.Server
+
+// serve serves h on subj until shutdown.
+func (ss *natsServers) serve(nc *nats.Conn, subj string, h http.Handler, opts *natshttp.Options) {
+	s := &natshttp.Server{Conn: nc, Subject: subj, Handler: h, Options: opts}
+	*ss = append(*ss, s)
+	go func() {
+		if err := s.ListenAndServe(); err != natshttp.ErrServerClosed {
+			log.Fatalf("NATS Error subscribing to %q, %v", subj, err)
+		}
+	}()
+}
+
+// publishStats publishes handler and read-ahead stats with expvar on the
+// HTTP listener. Ready counts chunks already read when they were wanted,
+// waits those that had to be waited on.
+func publishStats(hs *natshttp.HandlerStats, st *natshttp.ReadAheadStats) {
+	expvar.Publish("handlers_in_flight", expvar.Func(func() interface{} { return hs.InFlight.Load() }))
+	expvar.Publish("handlers_queued", expvar.Func(func() interface{} { return hs.Queued.Load() }))
+	expvar.Publish("handlers_rejected", expvar.Func(func() interface{} { return hs.Rejected.Load() }))
+	expvar.Publish("read_ahead_bytes", expvar.Func(func() interface{} { return st.Bytes.Load() }))
+	expvar.Publish("read_ahead_ready", expvar.Func(func() interface{} { return st.Ready.Load() }))
+	expvar.Publish("read_ahead_waits", expvar.Func(func() interface{} { return st.Waits.Load() }))
+	expvar.Publish("read_ahead_skipped", expvar.Func(func() interface{} { return st.Skipped.Load() }))
+}
+
+// shutdown stops taking requests and waits for those in progress, until
+// ctx is done.
+func (ss natsServers) shutdown(ctx context.Context) {
+	for _, s := range ss {
+		if err := s.Shutdown(ctx); err != nil {
+			log.Printf("Error shutting down %q: %v", s.Subject, err)
+		}
+	}
+}
+
+// resolvePath maps a request path to a file under root.
+func resolvePath(root, upath string) string {
+	return filepath.Join(root, filepath.FromSlash(path.Clean("/"+upath)))
+}
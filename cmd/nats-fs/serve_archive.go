@@ -0,0 +1,113 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/derekcollison/nats-fs/natshttp"
+)
+
+// Request header (or format query parameter) asking for an archive.
+const archiveHeader = "Archive"
+const archiveTarGz = "tar.gz"
+
+// Size of the chunks we hand to the response writer.
+const archiveChunkSize = 32 * 1024
+
+func isArchiveRequest(r *http.Request) bool {
+	return r.Header.Get(archiveHeader) == archiveTarGz || r.URL.Query().Get("format") == archiveTarGz
+}
+
+// serveArchive streams a tar.gz of the directory (or single file) at target.
+func serveArchive(w http.ResponseWriter, r *http.Request, target string) {
+	upath := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	fi, err := os.Stat(target)
+	if err != nil {
+		http.Error(w, "404 page not found", http.StatusNotFound)
+		return
+	}
+	base := target
+	if !fi.IsDir() {
+		base = filepath.Dir(target)
+	}
+
+	// Length is not known up front, the end of the stream is signaled
+	// with an empty message.
+	w.Header().Set(archiveHeader, archiveTarGz)
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filepath.Base(target)+`.tar.gz"`)
+	w.WriteHeader(http.StatusOK)
+
+	bw := bufio.NewWriterSize(w, archiveChunkSize)
+	gw := gzip.NewWriter(bw)
+	tw := tar.NewWriter(gw)
+
+	err = filepath.WalkDir(target, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(base, p)
+		if err != nil || name == "." {
+			return err
+		}
+		if rel, _ := filepath.Rel(target, p); hidden.hide(path.Join(upath, filepath.ToSlash(rel))) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		// Only regular files and directories.
+		if !info.Mode().IsRegular() && !info.IsDir() {
+			natshttp.Logf(r, "Skipping %q in archive, not a regular file", p)
+			return nil
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(name)
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		fd, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer fd.Close()
+		_, err = io.Copy(tw, fd)
+		return err
+	})
+	if err != nil {
+		natshttp.Logf(r, "Error creating archive for %q: %v", target, err)
+		return
+	}
+	if err := tw.Close(); err != nil {
+		natshttp.Logf(r, "Error creating archive for %q: %v", target, err)
+		return
+	}
+	if err := gw.Close(); err != nil {
+		natshttp.Logf(r, "Error creating archive for %q: %v", target, err)
+		return
+	}
+	if err := bw.Flush(); err != nil {
+		natshttp.Logf(r, "Error creating archive for %q: %v", target, err)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/derekcollison/nats-fs/delta"
+	"github.com/derekcollison/nats-fs/natshttp"
+)
+
+// Request header asking for a delta against the signature in the body.
+const deltaHeader = "Delta"
+const deltaRsync = "rsync"
+
+func isDeltaRequest(r *http.Request) bool {
+	return r.Header.Get(deltaHeader) == deltaRsync
+}
+
+// serveDelta reads the requester's signature from the body and streams
+// back the delta stream needed to turn their copy into ours.
+func serveDelta(w http.ResponseWriter, r *http.Request, file string) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var sig delta.Signature
+	if err := sig.UnmarshalBinary(body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fd, err := os.Open(file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	defer fd.Close()
+
+	// Length is not known up front, the end of the stream is signaled
+	// with an empty message.
+	w.Header().Set(deltaHeader, deltaRsync)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(http.StatusOK)
+
+	if err := delta.Diff(&sig, fd, w); err != nil {
+		natshttp.Logf(r, "Error sending delta for %q: %v", file, err)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/derekcollison/nats-fs/natshttp"
+)
+
+// capabilities are what we support, advertised in response to OPTIONS so
+// clients can feature-detect.
+type capabilities struct {
+	Version      int      `json:"version"`
+	Methods      []string `json:"methods"`
+	MaxChunkSize int      `json:"max_chunk_size"`
+	Ranges       []string `json:"ranges"`
+	Encodings    []string `json:"encodings,omitempty"`
+	Features     []string `json:"features"`
+}
+
+// newCapabilities describes this server. maxChunk is the largest chunk a
+// response may be sent in, files whether we serve a file or directory
+// rather than a zip, S3 or an object store, which only support reads and
+// listings.
+func newCapabilities(maxChunk int, files, readOnly, precompressed, signed, cas bool) *capabilities {
+	c := &capabilities{
+		Version:      natshttp.ProtocolVersion,
+		Methods:      []string{"GET", "HEAD", "OPTIONS"},
+		MaxChunkSize: maxChunk,
+		Ranges:       []string{"bytes"},
+		Features:     []string{"list", "glob", "trailers", "body-inbox"},
+	}
+	if files {
+		c.Methods = append(c.Methods, "STAT", "PROPFIND")
+		c.Features = append(c.Features, "archive", "delta", "stat")
+	}
+	if !readOnly {
+		c.Methods = append(c.Methods, "PUT", "POST", "DELETE", "MKCOL", "MOVE", "COPY")
+		c.Features = append(c.Features, "resumable-upload")
+		if cas {
+			c.Features = append(c.Features, "cas")
+		}
+	}
+	if precompressed && files {
+		for _, sc := range sidecars {
+			c.Encodings = append(c.Encodings, sc.encoding)
+		}
+	}
+	if signed {
+		// Our end to end keys can only be trusted signed.
+		c.Features = append(c.Features, "signed", "e2e")
+	}
+	return c
+}
+
+// serveOptions responds with our capabilities, as headers and as JSON.
+func (c *capabilities) serveOptions(w http.ResponseWriter, r *http.Request) {
+	body, err := json.Marshal(c)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h := w.Header()
+	h.Set("Allow", strings.Join(c.Methods, ", "))
+	h.Set("Accept-Ranges", strings.Join(c.Ranges, ", "))
+	h.Set("Content-Type", "application/json")
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
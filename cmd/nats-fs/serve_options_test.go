@@ -0,0 +1,32 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCapabilitiesPerBackend(t *testing.T) {
+	files := newCapabilities(1024, true, false, true, false, false)
+	for _, f := range []string{"list", "archive", "delta", "stat", "resumable-upload"} {
+		if !slices.Contains(files.Features, f) {
+			t.Errorf("serving files, %q not advertised", f)
+		}
+	}
+	if !slices.Contains(files.Methods, "PUT") || len(files.Encodings) == 0 {
+		t.Errorf("serving writable files with -precompressed, advertised %v %v", files.Methods, files.Encodings)
+	}
+
+	// A zip, S3 or object store is read-only and only listed.
+	alt := newCapabilities(1024, false, true, true, false, false)
+	for _, f := range []string{"archive", "delta", "stat", "resumable-upload"} {
+		if slices.Contains(alt.Features, f) {
+			t.Errorf("serving a store, %q advertised", f)
+		}
+	}
+	if !slices.Contains(alt.Features, "list") {
+		t.Error("serving a store, list not advertised")
+	}
+	if slices.Contains(alt.Methods, "STAT") || slices.Contains(alt.Methods, "PUT") || len(alt.Encodings) > 0 {
+		t.Errorf("serving a store, advertised %v %v", alt.Methods, alt.Encodings)
+	}
+}
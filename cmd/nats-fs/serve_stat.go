@@ -0,0 +1,240 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/derekcollison/nats-fs/natshttp"
+)
+
+// Metadata is requested with Method STAT, or PROPFIND, and a Depth header
+// of 0 for just the path, 1 to add the entries of a directory or infinity
+// for everything under it, up to maxStatDepth levels and maxStatEntries
+// entries. The response is JSON, not WebDAV XML.
+const depthHeader = "Depth"
+
+// Files are only hashed when asked for with Want-Digest: sha-256, as
+// reading every file is far more work than listing them.
+const (
+	wantDigestHeader = "Want-Digest"
+	digestSHA256     = "sha-256"
+)
+
+// Most a STAT may describe before it is refused.
+const (
+	maxStatDepth   = 32
+	maxStatEntries = 10000
+)
+
+// Most hashes remembered between requests.
+const maxCachedHashes = 16384
+
+// errStatTooLarge is returned walking a tree too big for one STAT.
+var errStatTooLarge = errors.New("too many entries")
+
+// statEntry is a listing entry with what is needed to plan a sync.
+type statEntry struct {
+	natshttp.ListEntry
+	SHA256      string `json:"sha256,omitempty"`
+	ETag        string `json:"etag,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	// Revisions kept with -history, for the requested path only.
+	Versions []version `json:"versions,omitempty"`
+}
+
+func isStatRequest(r *http.Request) bool {
+	return r.Method == "STAT" || r.Method == "PROPFIND"
+}
+
+// wantsSHA256 reports whether a STAT asked for file hashes.
+func wantsSHA256(r *http.Request) bool {
+	for _, v := range strings.Split(r.Header.Get(wantDigestHeader), ",") {
+		if alg, _, _ := strings.Cut(strings.TrimSpace(v), ";"); strings.EqualFold(alg, digestSHA256) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseDepth returns the depth asked for, -1 for infinity.
+func parseDepth(v string) (int, bool) {
+	switch strings.ToLower(v) {
+	case "", "0":
+		return 0, true
+	case "1":
+		return 1, true
+	case "infinity":
+		return -1, true
+	}
+	return 0, false
+}
+
+// hashCache remembers file hashes by path, size and modification time, so
+// repeated STATs of an unchanged tree do not read it all again.
+type hashCache struct {
+	mu sync.Mutex
+	m  map[string]cachedHash
+}
+
+type cachedHash struct {
+	size int64
+	mod  time.Time
+	sum  string
+}
+
+var statHashes hashCache
+
+func (hc *hashCache) get(p string, fi fs.FileInfo) string {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	if c, ok := hc.m[p]; ok && c.size == fi.Size() && c.mod.Equal(fi.ModTime()) {
+		return c.sum
+	}
+	return ""
+}
+
+func (hc *hashCache) put(p string, fi fs.FileInfo, sum string) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	if hc.m == nil || len(hc.m) >= maxCachedHashes {
+		hc.m = make(map[string]cachedHash)
+	}
+	hc.m[p] = cachedHash{size: fi.Size(), mod: fi.ModTime(), sum: sum}
+}
+
+// newStatEntry describes the file at p, with its SHA-256 if hash is set.
+// The ETag is the one GET sends, so it can be used with If-None-Match and
+// If-Range.
+func newStatEntry(name, p string, fi fs.FileInfo, hash bool) (statEntry, error) {
+	se := statEntry{ListEntry: natshttp.NewListEntry(name, fi)}
+	if !fi.Mode().IsRegular() {
+		return se, nil
+	}
+	se.ETag = fileETag(fi)
+	se.ContentType = mime.TypeByExtension(filepath.Ext(p))
+	if hash {
+		se.SHA256 = statHashes.get(p, fi)
+	}
+	if se.ContentType != "" && (!hash || se.SHA256 != "") {
+		return se, nil
+	}
+	fd, err := os.Open(p)
+	if err != nil {
+		return se, err
+	}
+	defer fd.Close()
+	var sniff [512]byte
+	n, _ := io.ReadFull(fd, sniff[:])
+	if se.ContentType == "" {
+		se.ContentType = http.DetectContentType(sniff[:n])
+	}
+	if hash && se.SHA256 == "" {
+		h := sha256.New()
+		h.Write(sniff[:n])
+		if _, err := io.Copy(h, fd); err != nil {
+			return se, err
+		}
+		se.SHA256 = hex.EncodeToString(h.Sum(nil))
+		statHashes.put(p, fi, se.SHA256)
+	}
+	return se, nil
+}
+
+// serveStat responds with JSON metadata for target, and its entries down
+// to the requested depth.
+func serveStat(w http.ResponseWriter, r *http.Request, target string, hist *history) {
+	depth, ok := parseDepth(r.Header.Get(depthHeader))
+	if !ok {
+		http.Error(w, "400 bad request, Depth is 0, 1 or infinity", http.StatusBadRequest)
+		return
+	}
+	upath := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	fi, err := os.Stat(target)
+	if err != nil {
+		http.Error(w, "404 page not found", http.StatusNotFound)
+		return
+	}
+	name := upath
+	if name == "" {
+		name = fi.Name()
+	}
+	hash := wantsSHA256(r)
+	self, err := newStatEntry(name, target, fi, hash)
+	if err != nil {
+		natshttp.Logf(r, "Error describing %q: %v", target, err)
+		http.Error(w, "500 internal server error", http.StatusInternalServerError)
+		return
+	}
+	if self.Versions, err = hist.versions(target); err != nil {
+		natshttp.Logf(r, "Error listing history of %q: %v", target, err)
+	}
+	entries := []statEntry{self}
+
+	if fi.IsDir() && depth != 0 {
+		err = filepath.WalkDir(target, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(target, p)
+			if err != nil || rel == "." {
+				return err
+			}
+			rel = filepath.ToSlash(rel)
+			if len(entries) >= maxStatEntries || strings.Count(rel, "/") >= maxStatDepth {
+				return errStatTooLarge
+			}
+			if hidden.hide(path.Join(upath, rel)) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			se, err := newStatEntry(path.Join(upath, rel), p, info, hash)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, se)
+			if d.IsDir() && depth == 1 {
+				return filepath.SkipDir
+			}
+			return nil
+		})
+		if errors.Is(err, errStatTooLarge) {
+			http.Error(w, "403 forbidden, too many entries, ask with a smaller Depth", http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			natshttp.Logf(r, "Error walking %q: %v", target, err)
+			http.Error(w, "500 internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	body, err := json.Marshal(entries)
+	if err != nil {
+		natshttp.Logf(r, "Error encoding metadata of %q: %v", target, err)
+		http.Error(w, "500 internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
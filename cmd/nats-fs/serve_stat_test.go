@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// statTree sends a STAT for upath under root, returning the code and entries.
+func statTree(t *testing.T, root, upath string, hdr map[string]string) (int, []statEntry) {
+	t.Helper()
+	r := natsRequest(t, "STAT", upath, hdr, "")
+	rec := httptest.NewRecorder()
+	serveStat(rec, r, resolvePath(root, r.URL.Path), nil)
+	var entries []statEntry
+	if rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), &entries); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rec.Code, entries
+}
+
+func TestServeStat(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "a.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	code, entries := statTree(t, root, "/a.txt", nil)
+	if code != http.StatusOK || len(entries) != 1 {
+		t.Fatalf("STAT = %d %v", code, entries)
+	}
+	if entries[0].SHA256 != "" {
+		t.Fatal("file hashed without Want-Digest")
+	}
+	_, entries = statTree(t, root, "/a.txt", map[string]string{wantDigestHeader: "sha-256"})
+	const sum = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	if entries[0].SHA256 != sum {
+		t.Fatalf("SHA256 = %q, want %q", entries[0].SHA256, sum)
+	}
+	if entries[0].ContentType != "text/plain; charset=utf-8" {
+		t.Fatalf("ContentType = %q", entries[0].ContentType)
+	}
+}
+
+func TestServeStatTooLarge(t *testing.T) {
+	root := t.TempDir()
+	deep := filepath.Join(root, strings.Repeat("d/", maxStatDepth+1))
+	if err := os.MkdirAll(deep, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := statTree(t, root, "/", map[string]string{depthHeader: "infinity"}); code != http.StatusForbidden {
+		t.Fatalf("STAT of a tree deeper than %d = %d, want 403", maxStatDepth, code)
+	}
+	if code, entries := statTree(t, root, "/", map[string]string{depthHeader: "1"}); code != http.StatusOK || len(entries) != 2 {
+		t.Fatalf("STAT with Depth 1 = %d %v", code, entries)
+	}
+}
+
+func TestServeStatErrorsAreGeneric(t *testing.T) {
+	root := t.TempDir()
+	file := filepath.Join(root, "secret.txt")
+	if err := os.WriteFile(file, []byte("x"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if os.Getuid() == 0 {
+		t.Skip("root can read any file")
+	}
+	r := natsRequest(t, "STAT", "/secret.txt", map[string]string{wantDigestHeader: "sha-256"}, "")
+	rec := httptest.NewRecorder()
+	serveStat(rec, r, file, nil)
+	if rec.Code != http.StatusInternalServerError || strings.Contains(rec.Body.String(), root) {
+		t.Fatalf("STAT of an unreadable file = %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestServeStatETagMatchesGet(t *testing.T) {
+	root := t.TempDir()
+	file := filepath.Join(root, "a.txt")
+	if err := os.WriteFile(file, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, hdr := range []map[string]string{nil, {wantDigestHeader: "sha-256"}} {
+		_, entries := statTree(t, root, "/a.txt", hdr)
+		if entries[0].ETag != fileETag(fi) {
+			t.Fatalf("STAT ETag = %q, GET sends %q", entries[0].ETag, fileETag(fi))
+		}
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+
+package main
+
+import (
+	"log"
+	"os"
+)
+
+// runService is for Windows, elsewhere serve runs under the system's
+// supervisor, such as systemd with Type=notify.
+func runService(args []string) {
+	log.Printf("Services are only for Windows, run \"nats-fs serve\" under systemd with Type=notify and -pid-file instead")
+	os.Exit(1)
+}
@@ -0,0 +1,116 @@
+//go:build windows
+
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// runService manages a Windows service running serve:
+//
+//	install [serve options] <dir>   register it to start at boot
+//	uninstall                       remove it
+//	start, stop                     start or stop it
+//	run [serve options] <dir>       what the service manager runs
+func runService(args []string) {
+	fs := newFlagSet("service", "install|uninstall|start|stop [serve options] [file|directory]")
+	name := fs.String("name", "nats-fs", "Service name")
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	op, serveArgs := fs.Arg(0), fs.Args()[1:]
+	if op == "run" {
+		if err := svc.Run(*name, &service{args: serveArgs}); err != nil {
+			log.Fatalf("Error running service %q: %v", *name, err)
+		}
+		return
+	}
+
+	m, err := mgr.Connect()
+	if err != nil {
+		log.Fatalf("Error connecting to the service manager: %v", err)
+	}
+	defer m.Disconnect()
+	switch op {
+	case "install":
+		exe, err := os.Executable()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if exe, err = filepath.Abs(exe); err != nil {
+			log.Fatal(err)
+		}
+		s, err := m.CreateService(*name, exe, mgr.Config{
+			DisplayName: "NATS file server",
+			Description: "Serves files over NATS",
+			StartType:   mgr.StartAutomatic,
+		}, append([]string{"service", "-name", *name, "run"}, serveArgs...)...)
+		if err != nil {
+			log.Fatalf("Error installing service %q: %v", *name, err)
+		}
+		s.Close()
+		log.Printf("Installed service %q", *name)
+		return
+	}
+	s, err := m.OpenService(*name)
+	if err != nil {
+		log.Fatalf("Error opening service %q: %v", *name, err)
+	}
+	defer s.Close()
+	switch op {
+	case "uninstall":
+		err = s.Delete()
+	case "start":
+		err = s.Start()
+	case "stop":
+		_, err = s.Control(svc.Stop)
+	default:
+		fs.Usage()
+		os.Exit(1)
+	}
+	if err != nil {
+		log.Fatalf("Error with %s of service %q: %v", op, *name, err)
+	}
+}
+
+// service runs serve until the service manager stops it.
+type service struct {
+	args []string
+}
+
+func (s *service) Execute(_ []string, reqs <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	done := make(chan struct{})
+	go func() {
+		runServe(s.args)
+		close(done)
+	}()
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case <-done:
+			return false, 0
+		case req := <-reqs:
+			switch req.Cmd {
+			case svc.Interrogate:
+				status <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending, WaitHint: 15000}
+				close(serviceStop)
+				select {
+				case <-done:
+				case <-time.After(15 * time.Second):
+				}
+				return false, 0
+			}
+		}
+	}
+}
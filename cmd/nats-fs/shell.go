@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/derekcollison/nats-fs/natshttp"
+	"github.com/nats-io/nats.go"
+	"golang.org/x/term"
+)
+
+var shellCommands = []string{"cd", "exit", "get", "help", "ls", "put", "pwd", "stat"}
+
+const shellHelp = `Commands:
+  ls [path]             list a directory
+  cd [path]             change directory, / if no path
+  pwd                   show the current directory
+  get remote [local]    download a file
+  put local [remote]    upload a file with PUT
+  stat path             show a file's size, type and modification time
+  exit                  leave the shell
+`
+
+// session is an interactive shell against a subject.
+type session struct {
+	nc   *nats.Conn
+	subj string
+	cwd  string
+	// Listings for completion, by directory.
+	dirs map[string][]natshttp.ListEntry
+}
+
+// runShell runs an FTP like session against a subject. On a terminal paths
+// and commands complete with tab, otherwise commands are read a line at a time.
+func runShell(args []string) {
+	fs := newFlagSet("shell", "<subject>")
+	conn := addConnFlags(fs)
+	rf := addRequestFlags(fs)
+	args = requestArgs(fs, rf, args, 1, 1)
+
+	nc := conn.connect("NATS HTTP Style Requestor")
+	defer nc.Close()
+	if err := shell(nc, args[0]); err != nil {
+		fatal(err)
+	}
+}
+
+func shell(nc *nats.Conn, subj string) error {
+	sh := &session{nc: nc, subj: subj, cwd: "/", dirs: make(map[string][]natshttp.ListEntry)}
+
+	var out io.Writer = os.Stdout
+	var readLine func() (string, error)
+	var setPrompt func(string)
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		state, err := term.MakeRaw(fd)
+		if err != nil {
+			return err
+		}
+		defer term.Restore(fd, state)
+		t := term.NewTerminal(struct {
+			io.Reader
+			io.Writer
+		}{os.Stdin, os.Stdout}, sh.prompt())
+		t.AutoCompleteCallback = sh.complete
+		out, readLine, setPrompt = t, t.ReadLine, t.SetPrompt
+	} else {
+		scanner := bufio.NewScanner(os.Stdin)
+		readLine = func() (string, error) {
+			if !scanner.Scan() {
+				if err := scanner.Err(); err != nil {
+					return "", err
+				}
+				return "", io.EOF
+			}
+			return scanner.Text(), nil
+		}
+		setPrompt = func(string) {}
+	}
+
+	for {
+		line, err := readLine()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			continue
+		}
+		if args[0] == "exit" || args[0] == "quit" {
+			return nil
+		}
+		if err := sh.run(out, args); err != nil {
+			fmt.Fprintf(out, "Error: %v\n", err)
+		}
+		setPrompt(sh.prompt())
+	}
+}
+
+func (sh *session) prompt() string {
+	return sh.subj + ":" + sh.cwd + "> "
+}
+
+// resolve maps p to a remote path relative to the current directory.
+func (sh *session) resolve(p string) string {
+	if strings.HasPrefix(p, "/") {
+		return path.Clean(p)
+	}
+	return path.Join(sh.cwd, p)
+}
+
+func (sh *session) run(out io.Writer, args []string) error {
+	arg := func(i int) string {
+		if i < len(args) {
+			return args[i]
+		}
+		return ""
+	}
+	switch args[0] {
+	case "help", "?":
+		fmt.Fprint(out, shellHelp)
+	case "pwd":
+		fmt.Fprintln(out, sh.cwd)
+	case "ls":
+		entries, err := sh.list(sh.resolve(arg(1)))
+		if err != nil {
+			return err
+		}
+		printEntries(out, entries, true, false)
+	case "cd":
+		dir := sh.resolve(arg(1))
+		if arg(1) == "" {
+			dir = "/"
+		}
+		entries, err := sh.list(dir)
+		if err != nil {
+			return err
+		}
+		if len(entries) == 1 && !entries[0].IsDir && "/"+entries[0].Name == dir {
+			return fmt.Errorf("%s: not a directory", dir)
+		}
+		sh.cwd = dir
+	case "stat":
+		if arg(1) == "" {
+			return fmt.Errorf("usage: stat path")
+		}
+		st, err := stat(sh.nc, sh.subj, sh.resolve(arg(1)))
+		if err != nil {
+			return err
+		}
+		printStat(out, st)
+	case "get":
+		if arg(1) == "" {
+			return fmt.Errorf("usage: get remote [local]")
+		}
+		remote := sh.resolve(arg(1))
+		local := arg(2)
+		if local == "" {
+			local = path.Base(remote)
+		}
+		n, err := sh.get(remote, local)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%s -> %s (%s)\n", remote, local, formatBytes(int64(n)))
+	case "put":
+		if arg(1) == "" {
+			return fmt.Errorf("usage: put local [remote]")
+		}
+		local := arg(1)
+		remote := arg(2)
+		if remote == "" {
+			remote = filepath.Base(local)
+		}
+		remote = sh.resolve(remote)
+		if err := putFile(sh.nc, sh.subj, local, remote, ""); err != nil {
+			return err
+		}
+		delete(sh.dirs, path.Dir(remote))
+		fmt.Fprintf(out, "%s -> %s\n", local, remote)
+	default:
+		return fmt.Errorf("unknown command %q, try help", args[0])
+	}
+	return nil
+}
+
+// list lists dir and remembers it for completion.
+func (sh *session) list(dir string) ([]natshttp.ListEntry, error) {
+	entries, err := listDir(sh.nc, sh.subj, dir, false, "")
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	sh.dirs[dir] = entries
+	return entries, nil
+}
+
+func (sh *session) get(remote, local string) (int, error) {
+	resp, err := sendRequest(sh.nc, newRequest(sh.subj, remote))
+	if err != nil {
+		return 0, err
+	}
+	if err := checkStatus(resp); err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	fd, err := os.Create(local)
+	if err != nil {
+		return 0, err
+	}
+	n, err := readBody(resp, fd)
+	if cerr := fd.Close(); err == nil {
+		err = cerr
+	}
+	return n, err
+}
+
+// complete is the terminal's tab completion, commands for the first word
+// and remote paths after that.
+func (sh *session) complete(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' {
+		return "", 0, false
+	}
+	head, rest := line[:pos], line[pos:]
+	start := strings.LastIndexByte(head, ' ') + 1
+	word := head[start:]
+
+	var candidates []string
+	if start == 0 {
+		for _, c := range shellCommands {
+			if strings.HasPrefix(c, word) {
+				candidates = append(candidates, c+" ")
+			}
+		}
+	} else {
+		dirPart, prefix := "", word
+		if i := strings.LastIndexByte(word, '/'); i >= 0 {
+			dirPart, prefix = word[:i+1], word[i+1:]
+		}
+		dir := sh.resolve(dirPart)
+		entries, ok := sh.dirs[dir]
+		if !ok {
+			var err error
+			if entries, err = sh.list(dir); err != nil {
+				return "", 0, false
+			}
+		}
+		for _, e := range entries {
+			name := path.Base(e.Name)
+			if !strings.HasPrefix(name, prefix) {
+				continue
+			}
+			if e.IsDir {
+				candidates = append(candidates, dirPart+name+"/")
+			} else {
+				candidates = append(candidates, dirPart+name+" ")
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return "", 0, false
+	}
+	completion := candidates[0]
+	for _, c := range candidates[1:] {
+		completion = commonPrefix(completion, c)
+	}
+	if len(completion) <= len(word) {
+		return "", 0, false
+	}
+	newHead := head[:start] + completion
+	return newHead + rest, len(newHead), true
+}
+
+func commonPrefix(a, b string) string {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return a[:i]
+}
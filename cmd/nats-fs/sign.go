@@ -0,0 +1,214 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/derekcollison/nats-fs/client"
+	"github.com/derekcollison/nats-fs/natshttp"
+	"github.com/nats-io/nkeys"
+)
+
+// Signed responses carry trailers describing the response, signed with the
+// server's nkey, so a client holding the public key can tell a response
+// came from us and was not altered on the way.
+const (
+	signedMetadataHeader = "Signed-Metadata"
+	signatureHeader      = "Signature"
+	signerHeader         = "Signer"
+	// Sent by the requester, signed back so a response can not be replayed
+	// to another request.
+	signNonceHeader = "Sign-Nonce"
+)
+
+// How far the signed time may be from ours, for clock skew and transfers.
+const maxSignatureAge = 5 * time.Minute
+
+// loadSigner reads an nkey seed from file.
+func loadSigner(file string) (nkeys.KeyPair, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return nkeys.ParseDecoratedNKey(data)
+}
+
+// signResponses signs each response's status, path, length and SHA-256
+// of the body, the time and the requester's nonce, sent as trailers once
+// the body is done.
+func signResponses(kp nkeys.KeyPair) natshttp.Middleware {
+	pub, _ := kp.PublicKey()
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sw := &signWriter{ResponseWriter: w, sum: sha256.New()}
+			next.ServeHTTP(sw, r)
+			if w.Header().Get(natshttp.DuplexInboxHeader) != "" {
+				// Taken over for a duplex session, there is no body to sign.
+				return
+			}
+			if sw.status == 0 {
+				sw.status = http.StatusOK
+			}
+			vals := url.Values{
+				"status":  {strconv.Itoa(sw.status)},
+				"path":    {path.Clean("/" + r.URL.Path)},
+				"length":  {strconv.FormatInt(sw.n, 10)},
+				"sha-256": {base64.StdEncoding.EncodeToString(sw.sum.Sum(nil))},
+				"time":    {time.Now().UTC().Format(time.RFC3339)},
+			}
+			if nonce := r.Header.Get(signNonceHeader); nonce != "" {
+				vals.Set("nonce", nonce)
+			}
+			md := vals.Encode()
+			sig, err := kp.Sign([]byte(md))
+			if err != nil {
+				return
+			}
+			h := w.Header()
+			h.Set(http.TrailerPrefix+signedMetadataHeader, md)
+			h.Set(http.TrailerPrefix+signatureHeader, base64.RawURLEncoding.EncodeToString(sig))
+			h.Set(http.TrailerPrefix+signerHeader, pub)
+		})
+	}
+}
+
+// signWriter hashes the body of a response.
+type signWriter struct {
+	http.ResponseWriter
+	status int
+	n      int64
+	sum    hash.Hash
+}
+
+func (w *signWriter) WriteHeader(code int) {
+	if code < 200 {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *signWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(data)
+	w.sum.Write(data[:n])
+	w.n += int64(n)
+	return n, err
+}
+
+// ReadFrom keeps the underlying writer's fast path.
+func (w *signWriter) ReadFrom(r io.Reader) (int64, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	var n int64
+	var err error
+	tr := io.TeeReader(r, w.sum)
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(tr)
+	} else {
+		n, err = io.Copy(w.ResponseWriter, tr)
+	}
+	w.n += n
+	return n, err
+}
+
+func (w *signWriter) Flush() {
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *signWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Returned when a signed response does not check out.
+var errBadSignature = errors.New("response signature verification failed")
+
+// verifier checks signed responses against the server's public key.
+type verifier struct {
+	kp  nkeys.KeyPair
+	sum hash.Hash
+	n   int64
+	// Sent with the request, the response must be signed with it.
+	nonce string
+}
+
+func newVerifier(pub string) (*verifier, error) {
+	kp, err := nkeys.FromPublicKey(pub)
+	if err != nil {
+		return nil, fmt.Errorf("bad public key %q: %v", pub, err)
+	}
+	var b [16]byte
+	rand.Read(b[:])
+	return &verifier{kp: kp, sum: sha256.New(), nonce: hex.EncodeToString(b[:])}, nil
+}
+
+// Write hashes the body as it is received.
+func (v *verifier) Write(p []byte) (int, error) {
+	v.sum.Write(p)
+	v.n += int64(len(p))
+	return len(p), nil
+}
+
+// verify checks the signed trailers of the end message against the status
+// of the response, the requested path, the body received and our nonce,
+// and that they were signed recently.
+func (v *verifier) verify(resp *client.Response) error {
+	trailer, err := resp.ReadTrailer()
+	if err != nil {
+		return fmt.Errorf("%w: %v", errBadSignature, err)
+	}
+	upath := resp.Request.Header.Get("URL")
+	md := trailer.Get(signedMetadataHeader)
+	sig, err := base64.RawURLEncoding.DecodeString(trailer.Get(signatureHeader))
+	if md == "" || err != nil {
+		return fmt.Errorf("%w: response is not signed", errBadSignature)
+	}
+	if err := v.kp.Verify([]byte(md), sig); err != nil {
+		return fmt.Errorf("%w: %v", errBadSignature, err)
+	}
+	vals, err := url.ParseQuery(md)
+	if err != nil {
+		return fmt.Errorf("%w: bad metadata: %v", errBadSignature, err)
+	}
+	if u, err := url.Parse(upath); err == nil {
+		upath = u.Path
+	}
+	switch {
+	case vals.Get("status") != strconv.Itoa(resp.StatusCode):
+		return fmt.Errorf("%w: signed status %s, received %d", errBadSignature, vals.Get("status"), resp.StatusCode)
+	case vals.Get("path") != path.Clean("/"+upath):
+		return fmt.Errorf("%w: signed path %q, requested %q", errBadSignature, vals.Get("path"), upath)
+	case vals.Get("length") != strconv.FormatInt(v.n, 10):
+		return fmt.Errorf("%w: signed length %s, received %d", errBadSignature, vals.Get("length"), v.n)
+	case vals.Get("sha-256") != base64.StdEncoding.EncodeToString(v.sum.Sum(nil)):
+		return fmt.Errorf("%w: body does not match the signed checksum", errBadSignature)
+	case vals.Get("nonce") != v.nonce:
+		return fmt.Errorf("%w: signed for another request", errBadSignature)
+	}
+	signed, err := time.Parse(time.RFC3339, vals.Get("time"))
+	if err != nil {
+		return fmt.Errorf("%w: bad signed time %q", errBadSignature, vals.Get("time"))
+	}
+	if age := time.Since(signed); age > maxSignatureAge || age < -maxSignatureAge {
+		return fmt.Errorf("%w: signed at %v, not within %v", errBadSignature, signed, maxSignatureAge)
+	}
+	return nil
+}
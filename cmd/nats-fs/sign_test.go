@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/derekcollison/nats-fs/client"
+	"github.com/derekcollison/nats-fs/natsfstest"
+	"github.com/nats-io/nkeys"
+)
+
+func TestSignedResponse(t *testing.T) {
+	kp, err := nkeys.CreateServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, _ := kp.PublicKey()
+	h := signResponses(kp)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "signed body")
+	}))
+	srv := natsfstest.NewServer(t, h)
+	c := client.New(srv.Connect(t))
+
+	// get fetches /file, sending the nonce of sent and verifying with v.
+	get := func(sent, v *verifier) error {
+		req := c.NewRequest(srv.Subject, "/file")
+		req.Header.Set(signNonceHeader, sent.nonce)
+		resp, err := c.Do(context.Background(), req)
+		if err != nil {
+			t.Fatalf("Do: %v", err)
+		}
+		defer resp.Body.Close()
+		if _, err := io.Copy(v, resp.Body); err != nil {
+			t.Fatalf("reading body: %v", err)
+		}
+		return v.verify(resp)
+	}
+
+	v, err := newVerifier(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := get(v, v); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+
+	// A response signed for another request does not verify.
+	other, _ := newVerifier(pub)
+	v, _ = newVerifier(pub)
+	if err := get(other, v); !errors.Is(err, errBadSignature) {
+		t.Fatalf("verify of a replayed response = %v, want errBadSignature", err)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/derekcollison/nats-fs/client"
+	"github.com/nats-io/nats.go"
+)
+
+// runStat shows the size, type and modification time of a file.
+func runStat(args []string) {
+	fs := newFlagSet("stat", "<subject> <path>")
+	conn := addConnFlags(fs)
+	rf := addRequestFlags(fs)
+	depth := fs.String("depth", "", "Ask for metadata, of the entries of a directory too with 1 or infinity")
+	withSHA256 := fs.Bool("sha256", false, "Ask for the SHA-256 of each file with the metadata")
+	jsonOut := fs.Bool("json", false, "Write the metadata as JSON")
+	args = requestArgs(fs, rf, args, 2, 2)
+
+	nc := conn.connect("NATS HTTP Style Requestor")
+	defer nc.Close()
+	if *depth != "" || *jsonOut || *withSHA256 {
+		entries, data, err := statMeta(nc, args[0], args[1], *depth, *withSHA256)
+		if err != nil {
+			fatal(err)
+		}
+		if *jsonOut {
+			fmt.Printf("%s\n", data)
+			return
+		}
+		for _, e := range entries {
+			printStatEntry(os.Stdout, e)
+		}
+		return
+	}
+	st, err := stat(nc, args[0], args[1])
+	if err != nil {
+		fatal(err)
+	}
+	printStat(os.Stdout, st)
+}
+
+// printStat prints the headers of a HEAD response describing the file.
+func printStat(w io.Writer, st *client.Stat) {
+	for _, h := range []string{"Content-Length", "Content-Type", "Last-Modified", "ETag"} {
+		if v := st.Header.Get(h); v != "" {
+			fmt.Fprintf(w, "%-15s %s\n", h+":", v)
+		}
+	}
+}
+
+// statMeta requests the metadata of upath, and of its entries down to
+// depth, with file hashes if withSHA256 is set, returning the raw JSON too.
+func statMeta(nc *nats.Conn, subj, upath, depth string, withSHA256 bool) ([]statEntry, []byte, error) {
+	req := newRequest(subj, upath)
+	req.Header.Set("Method", "STAT")
+	req.Header.Set("Accept", "application/json")
+	if depth != "" {
+		req.Header.Set(depthHeader, depth)
+	}
+	if withSHA256 {
+		req.Header.Set(wantDigestHeader, digestSHA256)
+	}
+	resp, err := sendRequest(nc, req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w for stat of %q", err, upath)
+	}
+	if err := checkStatus(resp); err != nil {
+		return nil, nil, fmt.Errorf("stat of %q: %w", upath, err)
+	}
+	defer resp.Body.Close()
+	var buf bytes.Buffer
+	if _, err := readBody(resp, &buf); err != nil {
+		return nil, nil, err
+	}
+	var entries []statEntry
+	if err := json.Unmarshal(buf.Bytes(), &entries); err != nil {
+		return nil, nil, fmt.Errorf("bad metadata for %q: %v", upath, err)
+	}
+	return entries, buf.Bytes(), nil
+}
+
+// printStatEntry prints one entry of a STAT response.
+func printStatEntry(w io.Writer, e statEntry) {
+	name := e.Name
+	if e.IsDir {
+		name += "/"
+	}
+	fmt.Fprintf(w, "%s %10s %s %-64s %s\n", e.Mode, formatBytes(e.Size), e.ModTime.Local().Format("Jan _2 15:04"), e.SHA256, name)
+	for _, v := range e.Versions {
+		fmt.Fprintf(w, "  rev %-4d %10s %s %s\n", v.Rev, formatBytes(v.Size), v.Time.Local().Format("Jan _2 15:04"), v.Digest)
+	}
+}
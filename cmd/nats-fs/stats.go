@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Most paths we keep counters for, the rest are counted together.
+const maxStatsPaths = 10000
+const otherPaths = "(other)"
+
+// pathStat holds the counters for one path.
+type pathStat struct {
+	Requests int64     `json:"requests"`
+	Errors   int64     `json:"errors"`
+	Bytes    int64     `json:"bytes"`
+	Last     time.Time `json:"last"`
+}
+
+// pathStats counts requests and bytes served per path.
+type pathStats struct {
+	sync.Mutex
+	m map[string]*pathStat
+}
+
+func newPathStats() *pathStats {
+	ps := &pathStats{m: make(map[string]*pathStat)}
+	expvar.Publish("path_stats", expvar.Func(func() interface{} { return ps.snapshot("") }))
+	return ps
+}
+
+func (ps *pathStats) record(upath string, status int, n int64) {
+	ps.Lock()
+	defer ps.Unlock()
+	st := ps.m[upath]
+	if st == nil {
+		if len(ps.m) >= maxStatsPaths {
+			upath = otherPaths
+			st = ps.m[upath]
+		}
+		if st == nil {
+			st = &pathStat{}
+			ps.m[upath] = st
+		}
+	}
+	st.Requests++
+	if status >= 400 {
+		st.Errors++
+	}
+	st.Bytes += n
+	st.Last = time.Now().UTC()
+}
+
+// snapshot copies the counters for paths under prefix.
+func (ps *pathStats) snapshot(prefix string) map[string]pathStat {
+	ps.Lock()
+	defer ps.Unlock()
+	snap := make(map[string]pathStat)
+	for p, st := range ps.m {
+		if strings.HasPrefix(p, prefix) {
+			snap[p] = *st
+		}
+	}
+	return snap
+}
+
+// count is middleware recording each response.
+func (ps *pathStats) count(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statsWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		ps.record(path.Clean("/"+r.URL.Path), sw.status, sw.n)
+	})
+}
+
+// publishEvents publishes a snapshot to subject every interval until nc
+// is closed.
+func (ps *pathStats) publishEvents(nc *nats.Conn, subject string, interval time.Duration) {
+	name := serverName()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for range t.C {
+		if nc.IsClosed() {
+			return
+		}
+		data, err := json.Marshal(struct {
+			Server string              `json:"server"`
+			Time   time.Time           `json:"time"`
+			Stats  map[string]pathStat `json:"stats"`
+		}{name, time.Now().UTC(), ps.snapshot("")})
+		if err != nil {
+			continue
+		}
+		if err := nc.Publish(subject, data); err != nil {
+			log.Printf("Error publishing stats: %v", err)
+		}
+	}
+}
+
+// statsWriter records the status and body bytes of a response.
+type statsWriter struct {
+	http.ResponseWriter
+	status int
+	n      int64
+}
+
+func (w *statsWriter) WriteHeader(code int) {
+	if code < 200 {
+		// Interim, as when a handler sends 100 Continue. The response is
+		// still to come, here and in the other wrappers.
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statsWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(data)
+	w.n += int64(n)
+	return n, err
+}
+
+// ReadFrom keeps the underlying writer's fast path.
+func (w *statsWriter) ReadFrom(r io.Reader) (int64, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	var n int64
+	var err error
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(r)
+	} else {
+		n, err = io.Copy(struct{ io.Writer }{w.ResponseWriter}, r)
+	}
+	w.n += n
+	return n, err
+}
+
+func (w *statsWriter) Flush() {
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *statsWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
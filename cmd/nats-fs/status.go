@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+
+	"github.com/derekcollison/nats-fs/client"
+	"github.com/derekcollison/nats-fs/natshttp"
+	"github.com/nats-io/nats.go"
+)
+
+// Process exit codes, so scripts can branch on the result.
+const (
+	exitOK          = 0
+	exitError       = 1   // Usage and local errors.
+	exitTransport   = 2   // No responders, timeouts, incomplete and corrupt transfers.
+	exitRedirect    = 3   // Too many or bad redirects.
+	exitClientError = 4   // 4xx responses.
+	exitServerError = 5   // 5xx responses.
+	exitInterrupted = 130 // Interrupted or terminated, as shells report Ctrl-C.
+)
+
+// exitCode maps an error to our process exit code.
+func exitCode(err error) int {
+	var se *client.StatusError
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &se):
+		switch {
+		case se.StatusCode >= 300 && se.StatusCode < 400:
+			return exitRedirect
+		case se.StatusCode >= 400 && se.StatusCode < 500:
+			return exitClientError
+		case se.StatusCode >= 500:
+			return exitServerError
+		}
+	case errors.Is(err, nats.ErrTimeout), errors.Is(err, nats.ErrNoResponders), errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, client.ErrIncomplete), errors.Is(err, natshttp.ErrCorruptChunk):
+		return exitTransport
+	}
+	return exitError
+}
+
+// fatal logs err and exits with the matching exit code.
+func fatal(err error) {
+	log.Print(err)
+	os.Exit(exitCode(err))
+}
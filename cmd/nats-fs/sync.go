@@ -0,0 +1,136 @@
+package main
+
+import (
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/derekcollison/nats-fs/delta"
+	"github.com/derekcollison/nats-fs/natshttp"
+	"github.com/nats-io/nats.go"
+)
+
+// runSync makes a local directory match a remote one. Only files whose
+// size or modification time differ are fetched, as deltas against the
+// local copy where there is one.
+func runSync(args []string) {
+	flags := newFlagSet("sync", "<subject> <remote dir> <local dir>")
+	conn := addConnFlags(flags)
+	rf := addRequestFlags(flags)
+	workers := flags.Int("P", 4, "Number of files to sync at once")
+	remove := flags.Bool("delete", false, "Remove local files that are not on the remote")
+	dryRun := flags.Bool("n", false, "Only show what would change")
+	quiet := flags.Bool("q", false, "Do not show progress or the transfer summary")
+	args = requestArgs(flags, rf, args, 3, 3)
+	subj, remote, dir := args[0], strings.Trim(args[1], "/"), args[2]
+
+	nc := conn.connect("NATS HTTP Style Requestor")
+	defer nc.Close()
+
+	entries, err := listDir(nc, subj, "/"+remote, true, "")
+	if err != nil {
+		fatal(err)
+	}
+
+	// Local names for the remote files, and the ones that need fetching.
+	names := make(map[string]string)
+	var stale []natshttp.ListEntry
+	for _, e := range entries {
+		if e.IsDir {
+			continue
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(e.Name, remote), "/")
+		name, err := localPath(dir, rel)
+		if err != nil {
+			fatal(err)
+		}
+		names[e.Name] = name
+		if fi, err := os.Stat(name); err == nil && fi.Size() == e.Size && fi.ModTime().Truncate(time.Second).Equal(e.ModTime.Truncate(time.Second)) {
+			continue
+		}
+		stale = append(stale, e)
+		if *dryRun {
+			log.Printf("Would fetch %s", name)
+		}
+	}
+
+	if *remove {
+		keep := make(map[string]bool, len(names))
+		for _, name := range names {
+			keep[name] = true
+		}
+		filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || keep[p] {
+				return nil
+			}
+			if *dryRun {
+				log.Printf("Would remove %s", p)
+			} else if err := os.Remove(p); err != nil {
+				log.Printf("Error: %v", err)
+			} else if !*quiet {
+				log.Printf("Removed %s", p)
+			}
+			return nil
+		})
+	}
+
+	if *dryRun || len(stale) == 0 {
+		return
+	}
+	p := newProgress(!*quiet)
+	err = runWorkers(stale, *workers, p, func(e natshttp.ListEntry) (int, error) {
+		return syncFile(nc, subj, e, names[e.Name], p)
+	})
+	if err != nil {
+		os.Exit(exitCode(err))
+	}
+}
+
+// syncFile fetches a listed file into name, as a delta if it exists.
+func syncFile(nc *nats.Conn, subj string, e natshttp.ListEntry, name string, p *progress) (int, error) {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return 0, err
+	}
+	req := newRequest(subj, e.Name)
+	var sig *delta.Signature
+	basis, err := os.Open(name)
+	if err == nil {
+		defer basis.Close()
+		if sig, err = deltaSignature(basis, nc.MaxPayload()); err != nil {
+			return 0, err
+		}
+		req.Header.Set("Delta", "rsync")
+		req.Data, _ = sig.MarshalBinary()
+	}
+
+	resp, err := sendRequest(nc, req)
+	if err != nil {
+		return 0, err
+	}
+	if err := checkStatus(resp); err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var out io.WriteCloser
+	if sig != nil && resp.Header.Get("Delta") == "rsync" {
+		out, err = newDeltaWriter(basis, sig.BlockSize, name)
+	} else {
+		out, err = os.Create(name)
+	}
+	if err != nil {
+		return 0, err
+	}
+	n, err := readBody(resp, p.Writer(out))
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return n, err
+	}
+	return n, os.Chtimes(name, e.ModTime, e.ModTime)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/derekcollison/nats-fs/natshttp"
+	"github.com/nats-io/nats.go"
+)
+
+// Tenant trees are served on PREFIX.<tenant> and PREFIX.<tenant>.>, the
+// tenant token selecting ROOT/<tenant>. With an account exporting PREFIX.>
+// and each importing account mapped onto its own tenant token, accounts
+// only ever see their own tree.
+
+// tenantSubjects are the subjects serving all tenants under prefix.
+func tenantSubjects(prefix string) []string {
+	return []string{prefix + ".*", prefix + ".*.>"}
+}
+
+// tenantOf returns the tenant token following prefix in the subject the
+// request arrived on. Requests not arriving over NATS have no tenant.
+func tenantOf(r *http.Request, prefix string) (string, bool) {
+	subj := natshttp.Subject(r)
+	if !strings.HasPrefix(subj, prefix+".") {
+		return "", false
+	}
+	tenant := subj[len(prefix)+1:]
+	if i := strings.IndexByte(tenant, '.'); i >= 0 {
+		tenant = tenant[:i]
+	}
+	// Tokens can hold anything but dots and spaces, keep to a single
+	// directory name.
+	if tenant == "" || strings.ContainsAny(tenant, `/\`) {
+		return "", false
+	}
+	return tenant, true
+}
+
+// serveTenants serves h on the tenant subjects under prefix.
+func serveTenants(servers *natsServers, nc *nats.Conn, prefix string, h http.Handler, opts *natshttp.Options) {
+	for _, subj := range tenantSubjects(prefix) {
+		servers.serve(nc, subj, h, opts)
+	}
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// Client timeouts, set from flags.
+var (
+	// How long to wait for the header message after a request.
+	firstByteTimeout = 10 * time.Second
+	// How long to wait for each chunk of the body.
+	idleTimeout = 30 * time.Second
+	// When the whole run has to be done by, zero for no deadline.
+	deadline time.Time
+)
+
+// Context requests run in, done at the deadline or on an interrupt.
+var runCtx, cancelRun = context.WithCancel(context.Background())
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/derekcollison/nats-fs/natshttp"
+	"github.com/nats-io/nats.go"
+)
+
+// runTrace follows the transfers on a subject, or those captured earlier,
+// printing the header message, chunks, gaps in their sequence, acks and
+// how much is in flight as they go, to debug flow control stalls.
+//
+// Responses and acks go to inboxes, so we watch -inbox as well as the
+// subject and follow the replies of the requests we see. Transfers under
+// way when we start are not followed. Gaps may be ours if we fall behind.
+func runTrace(args []string) {
+	flags := newFlagSet("trace", "<subject>")
+	conn := addConnFlags(flags)
+	inbox := flags.String("inbox", "_INBOX.>", "Subjects responses and acks go to, the requesters' inbox prefix")
+	capture := flags.String("capture", "", "Also write what is seen to this file, to trace again with -replay")
+	replay := flags.String("replay", "", "Trace the transfers captured in this file instead of live ones")
+	chunks := flags.Bool("chunks", true, "Print each chunk and ack, otherwise only headers and a summary of each transfer")
+	flags.Parse(args)
+
+	t := newTransferTracer(os.Stdout, *chunks)
+	if *replay != "" {
+		if flags.NArg() != 0 {
+			flags.Usage()
+			os.Exit(1)
+		}
+		if err := replayTrace(*replay, t); err != nil {
+			log.Fatalf("Error replaying %q: %v", *replay, err)
+		}
+		t.finish()
+		return
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(1)
+	}
+	subject := flags.Arg(0)
+
+	var enc *json.Encoder
+	if *capture != "" {
+		f, err := os.Create(*capture)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		bw := bufio.NewWriter(f)
+		defer bw.Flush()
+		enc = json.NewEncoder(bw)
+	}
+
+	nc := conn.connect("NATS HTTP File Server Trace")
+	defer nc.Close()
+
+	// One channel keeps requests, responses and acks in the order we got them.
+	msgs := make(chan *nats.Msg, 8192)
+	requests, err := nc.ChanSubscribe(subject, msgs)
+	if err != nil {
+		log.Fatalf("NATS Error subscribing to %q: %v", subject, err)
+	}
+	if _, err := nc.ChanSubscribe(*inbox, msgs); err != nil {
+		log.Fatalf("NATS Error subscribing to %q: %v", *inbox, err)
+	}
+	if err := nc.Flush(); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Tracing transfers on %s", subject)
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	for {
+		var rec traceRecord
+		select {
+		case m := <-msgs:
+			rec = newTraceRecord(m, m.Sub == requests)
+		case <-sigs:
+			t.finish()
+			return
+		}
+		if enc != nil {
+			if err := enc.Encode(rec); err != nil {
+				log.Fatalf("Error capturing to %q: %v", *capture, err)
+			}
+		}
+		t.observe(rec)
+	}
+}
+
+// traceRecord is a message as captured, without its data.
+type traceRecord struct {
+	Time    time.Time   `json:"time"`
+	Request bool        `json:"request,omitempty"`
+	Subject string      `json:"subject"`
+	Reply   string      `json:"reply,omitempty"`
+	Header  nats.Header `json:"header,omitempty"`
+	Size    int         `json:"size"`
+}
+
+func newTraceRecord(m *nats.Msg, request bool) traceRecord {
+	return traceRecord{Time: time.Now(), Request: request, Subject: m.Subject, Reply: m.Reply, Header: m.Header, Size: len(m.Data)}
+}
+
+// msg returns the record as a message, for the natshttp helpers.
+func (rec traceRecord) msg() *nats.Msg {
+	return &nats.Msg{Subject: rec.Subject, Reply: rec.Reply, Header: rec.Header}
+}
+
+// replayTrace feeds the records captured in file to t.
+func replayTrace(file string, t *transferTracer) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for {
+		var rec traceRecord
+		if err := dec.Decode(&rec); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		t.observe(rec)
+	}
+}
+
+// transferTracer follows transfers from the messages seen.
+type transferTracer struct {
+	out    io.Writer
+	chunks bool
+	// By the reply subject of the request, and by the ack subject prefix of
+	// the response's chunks. Late acks are still followed for a while after
+	// the end.
+	replies map[string]*tracedTransfer
+	acks    map[string]*tracedTransfer
+}
+
+func newTransferTracer(out io.Writer, chunks bool) *transferTracer {
+	return &transferTracer{out: out, chunks: chunks, replies: make(map[string]*tracedTransfer), acks: make(map[string]*tracedTransfer)}
+}
+
+// tracedTransfer is what we know of one transfer.
+type tracedTransfer struct {
+	id      string
+	start   time.Time
+	last    time.Time
+	ended   time.Time
+	request string
+	status  string
+	ackSubj string
+
+	firstByte  time.Duration
+	chunks     int
+	bytes      int64
+	seq        int64
+	gaps       int
+	acked      int64
+	window     int
+	maxFlight  int64
+	heartbeats int
+
+	// Chunks not yet acked, oldest first, for ack round trips.
+	unacked []sentChunk
+	rtts    int
+	rttSum  time.Duration
+	rttMax  time.Duration
+}
+
+type sentChunk struct {
+	seq int64
+	at  time.Time
+}
+
+func (tr *tracedTransfer) inFlight() int64 {
+	return tr.bytes - tr.acked
+}
+
+func (t *transferTracer) observe(rec traceRecord) {
+	if rec.Request {
+		t.observeRequest(rec)
+		return
+	}
+	if tr := t.replies[rec.Subject]; tr != nil {
+		t.observeResponse(tr, rec)
+		return
+	}
+	if i := strings.LastIndexByte(rec.Subject, '.'); i > 0 {
+		if tr := t.acks[rec.Subject[:i]]; tr != nil {
+			t.observeAck(tr, rec, rec.Subject[i+1:])
+		}
+	}
+}
+
+func (t *transferTracer) observeRequest(rec traceRecord) {
+	if rec.Reply == "" {
+		return
+	}
+	h := rec.Header
+	tr := &tracedTransfer{id: traceID(rec.Reply), start: rec.Time, last: rec.Time}
+	tr.request = strings.TrimSpace(h.Get("Method") + " " + h.Get("URL"))
+	if tr.request == "" {
+		tr.request = rec.Subject
+	}
+	version := h.Get(natshttp.VersionHeader)
+	if version == "" {
+		version = "1"
+	}
+	t.replies[rec.Reply] = tr
+	t.printf(tr, rec.Time, "request %s on %s, version %s, %s body", tr.request, rec.Subject, version, formatBytes(int64(rec.Size)))
+}
+
+func (t *transferTracer) observeResponse(tr *tracedTransfer, rec traceRecord) {
+	m := rec.msg()
+	tr.last = rec.Time
+	switch {
+	case natshttp.IsHeartbeat(m):
+		tr.heartbeats++
+		t.printf(tr, rec.Time, "heartbeat, %s in flight", formatBytes(tr.inFlight()))
+	case natshttp.IsContinue(m):
+		t.printf(tr, rec.Time, "continue")
+	case m.Header.Get("Status") != "":
+		tr.status = natshttp.StatusLine(m)
+		tr.firstByte = rec.Time.Sub(tr.start)
+		info := []string{tr.status}
+		if v := m.Header.Get(natshttp.VersionHeader); v != "" {
+			info = append(info, "version "+v)
+		}
+		if v := m.Header.Get("Content-Length"); v != "" {
+			if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+				v = formatBytes(n)
+			}
+			info = append(info, "length "+v)
+		}
+		t.printf(tr, rec.Time, "header %s, first byte after %s", strings.Join(info, ", "), roundDuration(tr.firstByte))
+	case rec.Reply != "":
+		t.observeChunk(tr, rec)
+	default:
+		t.end(tr, rec.Time, "end")
+	}
+}
+
+func (t *transferTracer) observeChunk(tr *tracedTransfer, rec traceRecord) {
+	if tr.ackSubj == "" {
+		if i := strings.LastIndexByte(rec.Reply, '.'); i > 0 {
+			tr.ackSubj = rec.Reply[:i]
+			t.acks[tr.ackSubj] = tr
+		}
+	}
+	tr.chunks++
+	tr.bytes += int64(rec.Size)
+	seq := int64(tr.chunks)
+	gap := ""
+	if v := rec.Header.Get(natshttp.SeqHeader); v != "" {
+		seq, _ = strconv.ParseInt(v, 10, 64)
+		if seq != tr.seq+1 {
+			tr.gaps++
+			gap = fmt.Sprintf(", gap after %d", tr.seq)
+		}
+	}
+	tr.seq = seq
+	tr.unacked = append(tr.unacked, sentChunk{seq: seq, at: rec.Time})
+	if f := tr.inFlight(); f > tr.maxFlight {
+		tr.maxFlight = f
+	}
+	if t.chunks {
+		t.printf(tr, rec.Time, "chunk %d, %s, %s in flight%s", seq, formatBytes(int64(rec.Size)), formatBytes(tr.inFlight()), gap)
+	}
+}
+
+func (t *transferTracer) observeAck(tr *tracedTransfer, rec traceRecord, token string) {
+	if token == natshttp.CancelToken {
+		if tr.ended.IsZero() {
+			t.end(tr, rec.Time, "canceled by the requester")
+		}
+		return
+	}
+	// Version 2 acks are cumulative with headers, version 1 acks name the
+	// size of a chunk.
+	var acked sentChunk
+	if v := rec.Header.Get(natshttp.AckedHeader); v != "" {
+		n, _ := strconv.ParseInt(v, 10, 64)
+		seq, _ := strconv.ParseInt(rec.Header.Get(natshttp.SeqHeader), 10, 64)
+		if n > tr.acked {
+			tr.acked = n
+		}
+		if w, err := strconv.Atoi(rec.Header.Get(natshttp.WindowHeader)); err == nil {
+			tr.window = w
+		}
+		for len(tr.unacked) > 0 && tr.unacked[0].seq <= seq {
+			acked, tr.unacked = tr.unacked[0], tr.unacked[1:]
+		}
+	} else if len(tr.unacked) > 0 {
+		n, _ := strconv.ParseInt(token, 10, 64)
+		tr.acked += n
+		acked, tr.unacked = tr.unacked[0], tr.unacked[1:]
+	}
+	rtt := ""
+	if !acked.at.IsZero() {
+		d := rec.Time.Sub(acked.at)
+		tr.rtts++
+		tr.rttSum += d
+		if d > tr.rttMax {
+			tr.rttMax = d
+		}
+		rtt = fmt.Sprintf(", chunk %d after %s", acked.seq, roundDuration(d))
+	}
+	if t.chunks {
+		window := ""
+		if tr.window > 0 {
+			window = ", window " + formatBytes(int64(tr.window))
+		}
+		t.printf(tr, rec.Time, "ack %s%s, %s in flight%s", formatBytes(tr.acked), rtt, formatBytes(tr.inFlight()), window)
+	}
+}
+
+// end prints a summary of the transfer and stops following it.
+func (t *transferTracer) end(tr *tracedTransfer, at time.Time, how string) {
+	elapsed := at.Sub(tr.start)
+	rate := ""
+	if secs := elapsed.Seconds(); secs > 0 {
+		rate = fmt.Sprintf(" (%s/s)", formatBytes(int64(float64(tr.bytes)/secs)))
+	}
+	t.printf(tr, at, "%s: %s %s, %s in %d chunks in %s%s, %d gaps, %d heartbeats, max %s in flight",
+		how, tr.request, tr.status, formatBytes(tr.bytes), tr.chunks, roundDuration(elapsed), rate, tr.gaps, tr.heartbeats, formatBytes(tr.maxFlight))
+	if tr.rtts > 0 {
+		t.printf(tr, at, "acks: %d, round trip avg %s, max %s", tr.rtts, roundDuration(tr.rttSum/time.Duration(tr.rtts)), roundDuration(tr.rttMax))
+	}
+	for reply, r := range t.replies {
+		if r == tr {
+			delete(t.replies, reply)
+		}
+	}
+	tr.ended = at
+	for subj, r := range t.acks {
+		if !r.ended.IsZero() && at.Sub(r.ended) > lateAcks {
+			delete(t.acks, subj)
+		}
+	}
+}
+
+// How long acks are followed after the end of a transfer.
+const lateAcks = time.Minute
+
+// finish ends the transfers still under way, oldest first.
+func (t *transferTracer) finish() {
+	var open []*tracedTransfer
+	for _, tr := range t.replies {
+		open = append(open, tr)
+	}
+	sort.Slice(open, func(i, j int) bool { return open[i].start.Before(open[j].start) })
+	for _, tr := range open {
+		t.end(tr, tr.last, "incomplete")
+	}
+}
+
+func (t *transferTracer) printf(tr *tracedTransfer, at time.Time, format string, args ...interface{}) {
+	fmt.Fprintf(t.out, "%s [%s] +%-9s %s\n", at.Format("15:04:05.000"), tr.id, roundDuration(at.Sub(tr.start)), fmt.Sprintf(format, args...))
+}
+
+// traceID is a short name for a transfer, the end of its reply inbox.
+func traceID(reply string) string {
+	if len(reply) > 6 {
+		return reply[len(reply)-6:]
+	}
+	return reply
+}
+
+// roundDuration rounds d for display.
+func roundDuration(d time.Duration) time.Duration {
+	if d > time.Second {
+		return d.Round(time.Millisecond)
+	}
+	return d.Round(10 * time.Microsecond)
+}
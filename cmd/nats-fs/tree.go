@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/derekcollison/nats-fs/natshttp"
+	"github.com/nats-io/nats.go"
+)
+
+func hasGlob(p string) bool {
+	return strings.ContainsAny(p, "*?[")
+}
+
+// splitGlob splits p into the directory before any glob and the pattern.
+func splitGlob(p string) (string, string) {
+	if !hasGlob(p) {
+		return p, ""
+	}
+	parts := strings.Split(strings.Trim(p, "/"), "/")
+	for i, part := range parts {
+		if hasGlob(part) {
+			return strings.Join(parts[:i], "/"), strings.Join(parts[i:], "/")
+		}
+	}
+	return p, ""
+}
+
+// listDir requests a listing of upath, recursive or just one level.
+// Glob is matched by the server against paths relative to upath.
+func listDir(nc *nats.Conn, subj, upath string, recursive bool, glob string) ([]natshttp.ListEntry, error) {
+	entries, err := clientFor(nc).List(runCtx, subj, upath, recursive, glob)
+	if err != nil {
+		return nil, fmt.Errorf("listing %q: %w", upath, err)
+	}
+	return entries, nil
+}
+
+// listTree returns the files under upath, or matching a glob in upath.
+func listTree(nc *nats.Conn, subj, upath string, recursive bool) ([]natshttp.ListEntry, error) {
+	base, glob := splitGlob(upath)
+	entries, err := listDir(nc, subj, base, recursive && glob == "", glob)
+	if err != nil {
+		return nil, err
+	}
+	var files []natshttp.ListEntry
+	for _, e := range entries {
+		if !e.IsDir {
+			files = append(files, e)
+			continue
+		}
+		// Directories matching a glob are only followed when recursive.
+		if !recursive || glob == "" {
+			continue
+		}
+		sub, err := listDir(nc, subj, e.Name, true, "")
+		if err != nil {
+			return nil, err
+		}
+		for _, se := range sub {
+			if !se.IsDir {
+				files = append(files, se)
+			}
+		}
+	}
+	return files, nil
+}
+
+// getFiles downloads files into dir mirroring the remote paths, running up
+// to workers transfers at once. Each is reported as asked by -json and -w.
+func getFiles(nc *nats.Conn, subj string, files []natshttp.ListEntry, dir string, workers int, p *progress) error {
+	return runWorkers(files, workers, p, func(e natshttp.ListEntry) (int, error) {
+		res := newResult(subj, e.Name)
+		n, err := getFile(nc, subj, e, dir, p, res)
+		res.Bytes = int64(n)
+		res.report(err)
+		return n, err
+	})
+}
+
+// runWorkers calls fetch for each file, running up to workers at once and
+// logging progress. It returns the first error.
+func runWorkers(files []natshttp.ListEntry, workers int, p *progress, fetch func(natshttp.ListEntry) (int, error)) error {
+	if workers < 1 {
+		workers = 1
+	}
+	var (
+		mu       sync.Mutex
+		done     int
+		firstErr error
+	)
+	// Sizes are only known for listed files.
+	var total int64
+	for _, e := range files {
+		if e.ModTime.IsZero() {
+			total = -1
+			break
+		}
+		total += e.Size
+	}
+	p.SetTotal(total)
+	work := make(chan natshttp.ListEntry)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for e := range work {
+				n, err := fetch(e)
+				mu.Lock()
+				if err != nil {
+					p.Logf("Error: %v", err)
+					if firstErr == nil {
+						firstErr = err
+					}
+				} else {
+					done++
+					if p.enabled {
+						p.Logf("[%d/%d] %s (%s)", done, len(files), e.Name, formatBytes(int64(n)))
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, e := range files {
+		work <- e
+	}
+	close(work)
+	wg.Wait()
+
+	p.Done()
+	if p.enabled {
+		log.Printf("Fetched %d of %d files", done, len(files))
+	}
+	return firstErr
+}
+
+// getFile downloads a single listed file into dir, recording the response
+// in res.
+func getFile(nc *nats.Conn, subj string, e natshttp.ListEntry, dir string, p *progress, res *result) (int, error) {
+	name, err := localPath(dir, e.Name)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return 0, err
+	}
+
+	resp, err := sendRequest(nc, newRequest(subj, e.Name))
+	if err != nil {
+		return 0, fmt.Errorf("%w for request %q", err, e.Name)
+	}
+	res.gotHeader(resp)
+	if err := checkStatus(resp); err != nil {
+		return 0, fmt.Errorf("%s: %w", e.Name, err)
+	}
+	defer resp.Body.Close()
+	fd, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return 0, err
+	}
+	trackPartial(name)
+	n, err := readBody(resp, p.Writer(fd))
+	if cerr := fd.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return n, err
+	}
+	untrackPartial(name)
+	if !e.ModTime.IsZero() {
+		os.Chtimes(name, e.ModTime, e.ModTime)
+	}
+	return n, nil
+}
+
+// localPath maps a remote path into dir, refusing anything that escapes it.
+func localPath(dir, name string) (string, error) {
+	p := filepath.Join(dir, filepath.FromSlash(path.Clean("/"+name)))
+	if rel, err := filepath.Rel(dir, p); err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("illegal path %q", name)
+	}
+	return p, nil
+}
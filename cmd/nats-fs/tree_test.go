@@ -0,0 +1,25 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalPath(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.txt", "/sub/b.txt", "..foo", "sub/..bar/c", "../x", "/../../etc/passwd"} {
+		p, err := localPath(dir, name)
+		if err != nil {
+			t.Errorf("localPath(%q): %v", name, err)
+			continue
+		}
+		if rel, _ := filepath.Rel(dir, p); rel == ".." || filepath.IsAbs(rel) || len(rel) > 2 && rel[:3] == ".."+string(filepath.Separator) {
+			t.Errorf("localPath(%q) = %q, outside %q", name, p, dir)
+		}
+	}
+	for _, name := range []string{"", "/", "."} {
+		if p, err := localPath(dir, name); err == nil {
+			t.Errorf("localPath(%q) = %q, want an error", name, p)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Request header naming the inbox the server pulls a streamed body from.
+const bodyInboxHeader = "Body-Inbox"
+
+// requestBody opens the body given with -d or -data-binary. Like curl, -d
+// strips newlines from files and both take @file, with @- for stdin. The
+// size is -1 if unknown.
+func requestBody(data, dataBinary string) (io.ReadCloser, int64, string, error) {
+	switch {
+	case dataBinary != "":
+		r, size, err := openData(dataBinary)
+		return r, size, "application/octet-stream", err
+	case data != "":
+		r, _, err := openData(data)
+		if err != nil {
+			return nil, 0, "", err
+		}
+		b, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return nil, 0, "", err
+		}
+		b = bytes.ReplaceAll(bytes.ReplaceAll(b, []byte("\r"), nil), []byte("\n"), nil)
+		return io.NopCloser(bytes.NewReader(b)), int64(len(b)), "application/x-www-form-urlencoded", nil
+	}
+	return nil, 0, "", nil
+}
+
+func openData(v string) (io.ReadCloser, int64, error) {
+	name, ok := strings.CutPrefix(v, "@")
+	if !ok {
+		return io.NopCloser(strings.NewReader(v)), int64(len(v)), nil
+	}
+	if name == "-" {
+		return os.Stdin, -1, nil
+	}
+	fd, err := os.Open(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	fi, err := fd.Stat()
+	if err != nil {
+		fd.Close()
+		return nil, 0, err
+	}
+	return fd, fi.Size(), nil
+}
+
+// bodyStreams are the streamed bodies of requests in progress, by inbox,
+// for sendRequest to tell them when the server accepts.
+var bodyStreams sync.Map
+
+// bodyStream serves the server's pulls of a streamed request body, none
+// of which are answered before it accepts the request with 100 Continue.
+type bodyStream struct {
+	sub      *nats.Subscription
+	once     sync.Once
+	accepted chan struct{}
+	done     chan struct{}
+}
+
+// accept lets the body be sent.
+func (bs *bodyStream) accept() {
+	bs.once.Do(func() { close(bs.accepted) })
+}
+
+// Unsubscribe stops serving the body, once the response has arrived.
+func (bs *bodyStream) Unsubscribe() error {
+	bodyStreams.Delete(bs.sub.Subject)
+	close(bs.done)
+	return bs.sub.Unsubscribe()
+}
+
+// attachBody sends the body in the request if it fits, otherwise the
+// server pulls it a chunk at a time as it reads it, once it has accepted
+// the request and sent 100 Continue. The returned stream serves those
+// pulls and should be unsubscribed once the response arrives.
+func attachBody(nc *nats.Conn, req *nats.Msg, r io.Reader, size int64, contentType string) (*bodyStream, error) {
+	if req.Header.Get("Method") == "GET" {
+		req.Header.Set("Method", "POST")
+	}
+	if req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if size >= 0 {
+		req.Header.Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+
+	chunk := int(nc.MaxPayload())
+	if size >= 0 && size <= int64(chunk/2) {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		req.Data = data
+		return nil, nil
+	}
+
+	inbox := nats.NewInbox()
+	req.Header.Set(bodyInboxHeader, inbox)
+	req.Header.Set("Expect", "100-continue")
+	buf := make([]byte, chunk)
+	done := false
+	bs := &bodyStream{accepted: make(chan struct{}), done: make(chan struct{})}
+	sub, err := nc.Subscribe(inbox, func(m *nats.Msg) {
+		select {
+		case <-bs.accepted:
+		case <-bs.done:
+			return
+		}
+		if done {
+			m.Respond(nil)
+			return
+		}
+		n, err := io.ReadFull(r, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			fatal(fmt.Errorf("error reading request body: %w", err))
+		}
+		if n == 0 {
+			done = true
+		}
+		m.Respond(buf[:n])
+	})
+	if err != nil {
+		return nil, err
+	}
+	bs.sub = sub
+	bodyStreams.Store(inbox, bs)
+	return bs, nil
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/derekcollison/nats-fs/natshttp"
+)
+
+// Resumable uploads send a file in parts to an upload session, so a
+// dropped connection only costs the part in flight:
+//
+//	POST   Upload: start [Upload-Length: N]  start a session, 201 with Upload-Id
+//	PUT    Upload-Id, Upload-Offset          write the body at the offset
+//	GET    Upload-Id                         JSON of the parts received
+//	POST   Upload-Id, Upload: complete       move the file into place
+//	DELETE Upload-Id                         abort
+//
+// Sessions live in the upload directory, outside the served tree, and
+// survive restarts. Those nothing is sent to for longer than the TTL are
+// removed.
+const (
+	uploadHeader       = "Upload"
+	uploadIDHeader     = "Upload-Id"
+	uploadOffsetHeader = "Upload-Offset"
+	uploadLengthHeader = "Upload-Length"
+)
+
+// Part of an upload, as received.
+type uploadPart struct {
+	Offset int64 `json:"offset"`
+	Size   int64 `json:"size"`
+}
+
+// uploadSession is the manifest of an upload, kept next to its data.
+type uploadSession struct {
+	ID      string       `json:"id"`
+	Path    string       `json:"path"`
+	Length  int64        `json:"length"`
+	Parts   []uploadPart `json:"parts"`
+	Started time.Time    `json:"started"`
+	// Root of the tree it is for, sessions can not be used from another.
+	Root string `json:"root"`
+}
+
+// uploads keeps the sessions, one manifest and data file per session. The
+// lock guards the manifests, parts are written without it.
+type uploads struct {
+	sync.Mutex
+	dir string
+	// How long a session may go without a request, 0 keeps them.
+	ttl time.Duration
+}
+
+func isUploadRequest(r *http.Request) bool {
+	return r.Header.Get(uploadHeader) != "" || r.Header.Get(uploadIDHeader) != ""
+}
+
+func (u *uploads) manifest(id string) string { return filepath.Join(u.dir, id+".json") }
+func (u *uploads) data(id string) string     { return filepath.Join(u.dir, id+".data") }
+
+// load returns the session, checking it belongs to root. Lock should be held.
+func (u *uploads) load(id, root string) (*uploadSession, error) {
+	if _, err := hex.DecodeString(id); err != nil || len(id) != 32 {
+		return nil, os.ErrNotExist
+	}
+	b, err := os.ReadFile(u.manifest(id))
+	if err != nil {
+		return nil, err
+	}
+	var s uploadSession
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, err
+	}
+	if s.Root != root {
+		return nil, os.ErrNotExist
+	}
+	return &s, nil
+}
+
+// save writes the manifest. Lock should be held.
+func (u *uploads) save(s *uploadSession) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmp := u.manifest(s.ID) + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, u.manifest(s.ID))
+}
+
+func (u *uploads) remove(id string) {
+	os.Remove(u.manifest(id))
+	os.Remove(u.data(id))
+}
+
+// sweepEvery removes expired sessions every interval, for good.
+func (u *uploads) sweepEvery(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for now := range t.C {
+		u.sweep(now)
+	}
+}
+
+// sweep removes the files of sessions none of which changed within the
+// TTL before now, including those left by sessions that ended part way.
+// It returns how many sessions it removed.
+func (u *uploads) sweep(now time.Time) int {
+	if u.ttl <= 0 {
+		return 0
+	}
+	u.Lock()
+	defer u.Unlock()
+	entries, err := os.ReadDir(u.dir)
+	if err != nil {
+		return 0
+	}
+	// Files of a session start with its ID, last is when any changed.
+	last := make(map[string]time.Time)
+	for _, e := range entries {
+		id, _, _ := strings.Cut(e.Name(), ".")
+		if _, err := hex.DecodeString(id); err != nil || len(id) != 32 || e.IsDir() {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue
+		}
+		if t := fi.ModTime(); t.After(last[id]) {
+			last[id] = t
+		}
+	}
+	var removed int
+	for id, t := range last {
+		if now.Sub(t) <= u.ttl {
+			continue
+		}
+		for _, e := range entries {
+			if strings.HasPrefix(e.Name(), id+".") {
+				os.Remove(filepath.Join(u.dir, e.Name()))
+			}
+		}
+		log.Printf("Removed upload %s, nothing sent since %s", id, t.UTC().Format(time.RFC3339))
+		removed++
+	}
+	return removed
+}
+
+// serve handles a request on an upload session, file being the resolved
+// path under root.
+func (u *uploads) serve(wc *writeConfig, w http.ResponseWriter, r *http.Request, root, file string) {
+	id := r.Header.Get(uploadIDHeader)
+	if id == "" {
+		if r.Method != http.MethodPost || r.Header.Get(uploadHeader) != "start" {
+			http.Error(w, "400 bad request, start an upload with POST and Upload: start", http.StatusBadRequest)
+			return
+		}
+		u.start(wc, w, r, root, file)
+		return
+	}
+
+	u.Lock()
+	s, err := u.load(id, root)
+	u.Unlock()
+	if err != nil {
+		http.Error(w, "404 upload not found", http.StatusNotFound)
+		return
+	}
+	switch {
+	case r.Method == http.MethodPut:
+		u.writePart(wc, w, r, s)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		writeJSON(w, s)
+	case r.Method == http.MethodPost && r.Header.Get(uploadHeader) == "complete":
+		u.Lock()
+		defer u.Unlock()
+		if s, err = u.load(id, root); err != nil {
+			http.Error(w, "404 upload not found", http.StatusNotFound)
+			return
+		}
+		u.complete(wc, w, r, s, root)
+	case r.Method == http.MethodDelete:
+		u.Lock()
+		u.remove(s.ID)
+		u.Unlock()
+		natshttp.Logf(r, "Aborted upload %s of %q", s.ID, s.Path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "400 bad request", http.StatusBadRequest)
+	}
+}
+
+func (u *uploads) start(wc *writeConfig, w http.ResponseWriter, r *http.Request, root, file string) {
+	upath := path.Clean("/" + r.URL.Path)
+	if fi, err := os.Stat(file); err == nil && fi.IsDir() {
+		http.Error(w, "409 conflict, is a directory", http.StatusConflict)
+		return
+	}
+	if _, err := os.Stat(filepath.Dir(file)); err != nil {
+		http.Error(w, "409 conflict, parent does not exist", http.StatusConflict)
+		return
+	}
+	length := int64(-1)
+	if v := r.Header.Get(uploadLengthHeader); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			http.Error(w, "400 bad request, bad "+uploadLengthHeader, http.StatusBadRequest)
+			return
+		}
+		length = n
+		if wc.maxUpload > 0 && n > wc.maxUpload {
+			writeError(w, r, file, errTooLarge)
+			return
+		}
+		old, _ := treeSize(file)
+		if err := wc.quotas.fits(wc.tenant(r), root, n-old); err != nil {
+			writeError(w, r, file, err)
+			return
+		}
+	}
+	var b [16]byte
+	rand.Read(b[:])
+	s := &uploadSession{ID: hex.EncodeToString(b[:]), Path: upath, Length: length, Parts: []uploadPart{}, Started: time.Now().UTC(), Root: root}
+
+	u.Lock()
+	defer u.Unlock()
+	if err := os.MkdirAll(u.dir, 0700); err != nil {
+		writeError(w, r, u.dir, err)
+		return
+	}
+	fd, err := os.OpenFile(u.data(s.ID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		writeError(w, r, u.data(s.ID), err)
+		return
+	}
+	fd.Close()
+	if err := u.save(s); err != nil {
+		u.remove(s.ID)
+		writeError(w, r, u.manifest(s.ID), err)
+		return
+	}
+	natshttp.Logf(r, "Started upload %s of %q", s.ID, upath)
+	w.Header().Set(uploadIDHeader, s.ID)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(s)
+}
+
+// writePart writes the body at its offset, then records the part.
+func (u *uploads) writePart(wc *writeConfig, w http.ResponseWriter, r *http.Request, s *uploadSession) {
+	off, err := strconv.ParseInt(r.Header.Get(uploadOffsetHeader), 10, 64)
+	if err != nil || off < 0 || (s.Length >= 0 && off > s.Length) {
+		http.Error(w, "400 bad request, bad "+uploadOffsetHeader, http.StatusBadRequest)
+		return
+	}
+	fd, err := os.OpenFile(u.data(s.ID), os.O_WRONLY, 0600)
+	if err != nil {
+		writeError(w, r, u.data(s.ID), err)
+		return
+	}
+	limit := s.Length
+	if wc.maxUpload > 0 && (limit < 0 || limit > wc.maxUpload) {
+		limit = wc.maxUpload
+	}
+	sendContinue(w, r)
+	var body io.Reader = r.Body
+	if limit >= 0 {
+		body = io.LimitReader(r.Body, limit-off+1)
+	}
+	n, err := io.Copy(io.NewOffsetWriter(fd, off), body)
+	if cerr := fd.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		// Nothing is recorded, the part is sent again.
+		natshttp.Logf(r, "Error writing part of upload %s at %d: %v", s.ID, off, err)
+		http.Error(w, "500 internal server error", http.StatusInternalServerError)
+		return
+	}
+	if s.Length >= 0 && off+n > s.Length {
+		http.Error(w, "413 part goes past "+uploadLengthHeader, http.StatusRequestEntityTooLarge)
+		return
+	}
+	if limit >= 0 && off+n > limit {
+		writeError(w, r, u.data(s.ID), errTooLarge)
+		return
+	}
+	u.Lock()
+	defer u.Unlock()
+	// Other parts may have been recorded meanwhile.
+	if s, err = u.load(s.ID, s.Root); err != nil {
+		http.Error(w, "404 upload not found", http.StatusNotFound)
+		return
+	}
+	s.Parts = append(s.Parts, uploadPart{Offset: off, Size: n})
+	if err := u.save(s); err != nil {
+		writeError(w, r, u.manifest(s.ID), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// received returns how many bytes from the start have arrived without a gap.
+func (s *uploadSession) received() int64 {
+	parts := append([]uploadPart(nil), s.Parts...)
+	sort.Slice(parts, func(i, j int) bool { return parts[i].Offset < parts[j].Offset })
+	var end int64
+	for _, p := range parts {
+		if p.Offset > end {
+			break
+		}
+		if p.Offset+p.Size > end {
+			end = p.Offset + p.Size
+		}
+	}
+	return end
+}
+
+// complete checks every byte arrived and moves the file into place.
+// Lock should be held.
+func (u *uploads) complete(wc *writeConfig, w http.ResponseWriter, r *http.Request, s *uploadSession, root string) {
+	target := resolvePath(root, s.Path)
+	got := s.received()
+	if s.Length >= 0 && got != s.Length {
+		http.Error(w, fmt.Sprintf("409 conflict, have %d of %d bytes", got, s.Length), http.StatusConflict)
+		return
+	}
+	if err := os.Truncate(u.data(s.ID), got); err != nil {
+		writeError(w, r, u.data(s.ID), err)
+		return
+	}
+	_, err := os.Stat(target)
+	exists := err == nil
+	old, _ := treeSize(target)
+	if err := wc.quotas.add(wc.tenant(r), root, got-old); err != nil {
+		writeError(w, r, target, err)
+		return
+	}
+	if wc.cas != nil {
+		var hash string
+		if hash, err = hashFile(u.data(s.ID)); err == nil {
+			err = wc.cas.store(u.data(s.ID), hash, target)
+		}
+	} else {
+		err = moveFile(u.data(s.ID), target)
+	}
+	if err != nil {
+		wc.quotas.add(wc.tenant(r), root, old-got)
+		writeError(w, r, target, err)
+		return
+	}
+	os.Remove(u.manifest(s.ID))
+	natshttp.Logf(r, "Completed upload %s of %q, %d bytes", s.ID, s.Path, got)
+	wc.history.record(r, target)
+	wc.publish(r, "put", s.Path)
+	if exists {
+		w.WriteHeader(http.StatusNoContent)
+	} else {
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+// moveFile renames src to dst, copying if they are on different devices.
+func moveFile(src, dst string) error {
+	err := os.Rename(src, dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	tmp := dst + ".nats-fs-tmp"
+	if err := copyTree(src, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(src)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/derekcollison/nats-fs/natshttp"
+	"github.com/nats-io/nats.go"
+)
+
+// natsRequest returns a request for upath as it arrives over NATS.
+func natsRequest(t *testing.T, method, upath string, hdr map[string]string, body string) *http.Request {
+	t.Helper()
+	m := nats.NewMsg("files")
+	m.Header.Set("Method", method)
+	m.Header.Set("URL", upath)
+	for k, v := range hdr {
+		m.Header.Set(k, v)
+	}
+	m.Data = []byte(body)
+	r, err := natshttp.NewRequest(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestResumableUpload(t *testing.T) {
+	root := t.TempDir()
+	wc := &writeConfig{uploads: &uploads{dir: t.TempDir()}}
+	serve := func(r *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		wc.serve(rec, r, root, resolvePath(root, r.URL.Path))
+		return rec
+	}
+
+	rec := serve(natsRequest(t, "POST", "/up.txt", map[string]string{uploadHeader: "start", uploadLengthHeader: "11"}, ""))
+	id := rec.Header().Get(uploadIDHeader)
+	if rec.Code != http.StatusCreated || id == "" {
+		t.Fatalf("start = %d %q", rec.Code, rec.Body.String())
+	}
+	part := func(off int, data string) int {
+		return serve(natsRequest(t, "PUT", "/up.txt", map[string]string{uploadIDHeader: id, uploadOffsetHeader: strconv.Itoa(off)}, data)).Code
+	}
+	complete := func() int {
+		return serve(natsRequest(t, "POST", "/up.txt", map[string]string{uploadIDHeader: id, uploadHeader: "complete"}, "")).Code
+	}
+
+	// Parts arrive out of order, completing early is refused.
+	if code := part(6, "world"); code != http.StatusNoContent {
+		t.Fatalf("part at 6 = %d", code)
+	}
+	if code := complete(); code != http.StatusConflict {
+		t.Fatalf("complete with a gap = %d, want 409", code)
+	}
+	rec = serve(natsRequest(t, "GET", "/up.txt", map[string]string{uploadIDHeader: id}, ""))
+	var s uploadSession
+	if err := json.Unmarshal(rec.Body.Bytes(), &s); err != nil || s.received() != 0 {
+		t.Fatalf("session %q, %v, want nothing received from the start", rec.Body.String(), err)
+	}
+	if code := part(0, "hello "); code != http.StatusNoContent {
+		t.Fatalf("part at 0 = %d", code)
+	}
+	if code := part(6, "world and more"); code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("part past the length = %d, want 413", code)
+	}
+	if code := complete(); code != http.StatusCreated {
+		t.Fatalf("complete = %d, want 201", code)
+	}
+	if data, err := os.ReadFile(filepath.Join(root, "up.txt")); err != nil || string(data) != "hello world" {
+		t.Fatalf("uploaded %q, %v, want hello world", data, err)
+	}
+	if code := part(0, "again"); code != http.StatusNotFound {
+		t.Fatalf("part after completing = %d, want 404", code)
+	}
+}
+
+func TestUploadOtherRoot(t *testing.T) {
+	wc := &writeConfig{uploads: &uploads{dir: t.TempDir()}}
+	root, other := t.TempDir(), t.TempDir()
+	rec := httptest.NewRecorder()
+	wc.serve(rec, natsRequest(t, "POST", "/a", map[string]string{uploadHeader: "start"}, ""), root, filepath.Join(root, "a"))
+	id := rec.Header().Get(uploadIDHeader)
+
+	// Sessions can not be used from another tenant's tree.
+	rec = httptest.NewRecorder()
+	wc.serve(rec, natsRequest(t, "PUT", "/a", map[string]string{uploadIDHeader: id, uploadOffsetHeader: "0"}, "x"), other, filepath.Join(other, "a"))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("part from another root = %d, want 404", rec.Code)
+	}
+}
+
+func TestUploadSweep(t *testing.T) {
+	u := &uploads{dir: t.TempDir(), ttl: time.Hour}
+	idle, active := strings.Repeat("a", 32), strings.Repeat("b", 32)
+	for _, name := range []string{idle + ".json", idle + ".data", active + ".json", active + ".data", strings.Repeat("c", 32) + ".data", "unrelated"} {
+		if err := os.WriteFile(filepath.Join(u.dir, name), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now()
+	old := now.Add(-2 * time.Hour)
+	for _, name := range []string{idle + ".json", idle + ".data", strings.Repeat("c", 32) + ".data", "unrelated"} {
+		os.Chtimes(filepath.Join(u.dir, name), old, old)
+	}
+	// Parts still arriving keep a session whose manifest is old.
+	os.Chtimes(filepath.Join(u.dir, active+".json"), old, old)
+
+	if n := u.sweep(now); n != 2 {
+		t.Fatalf("swept %d sessions, want the idle one and the orphaned data", n)
+	}
+	left, _ := os.ReadDir(u.dir)
+	var names []string
+	for _, e := range left {
+		names = append(names, e.Name())
+	}
+	if want := []string{active + ".data", active + ".json", "unrelated"}; strings.Join(names, " ") != strings.Join(want, " ") {
+		t.Fatalf("left %v, want %v", names, want)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/derekcollison/nats-fs/natshttp"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+)
+
+// Writes are authorized in one of three ways, checked in this order:
+//
+//   - signed with a key given to serve with -write-key, by requesters
+//     running with -seed, over the method, request URI, destination,
+//     content hashes and the time in writeTimeHeader, within
+//     maxSignatureAge.
+//   - approved by whatever answers on the -write-callout subject, sent a
+//     writeCheck and replying with an empty body to allow the write or
+//     the reason it is refused.
+//   - with neither, over NATS only, trusting NATS permissions to decide
+//     who may publish on the served subjects. The HTTP listener has no
+//     such protection and refuses every write.
+const writeTimeHeader = "Write-Time"
+
+// How long the callout has to answer before the write is refused.
+const writeCalloutTimeout = 2 * time.Second
+
+// writeAuth decides whether requests may change the served tree.
+type writeAuth struct {
+	// Public keys writes may be signed with.
+	keys []nkeys.KeyPair
+	// Where each write is sent for approval, the subject empty for nowhere.
+	nc      *nats.Conn
+	callout string
+}
+
+// writeCheck is sent to the callout for each write.
+type writeCheck struct {
+	Method      string      `json:"method"`
+	Path        string      `json:"path"`
+	Destination string      `json:"destination,omitempty"`
+	Subject     string      `json:"subject,omitempty"`
+	Header      http.Header `json:"header"`
+}
+
+// writeSigned returns what a write is signed over.
+func writeSigned(method, uri string, h http.Header, at string) []byte {
+	return []byte(strings.Join([]string{
+		method, uri, h.Get("Destination"), h.Get(contentSHA256Header), h.Get(haveSHA256Header), at,
+	}, "\n"))
+}
+
+// signWrite signs a write request with kp, the method and URL being those
+// in its headers.
+func signWrite(kp nkeys.KeyPair, req *nats.Msg) error {
+	at := time.Now().UTC().Format(time.RFC3339Nano)
+	h := natshttp.HTTPHeader(req.Header)
+	// Parsed as the server will, so the URI is the one it checks.
+	u, err := url.Parse(h.Get("URL"))
+	if err != nil {
+		return err
+	}
+	sig, err := kp.Sign(writeSigned(h.Get("Method"), u.RequestURI(), h, at))
+	if err != nil {
+		return err
+	}
+	req.Header.Set(writeTimeHeader, at)
+	req.Header.Set(signatureHeader, base64.RawURLEncoding.EncodeToString(sig))
+	return nil
+}
+
+// authorize returns an error unless r may write. Nil authorizes writes
+// over NATS only.
+func (wa *writeAuth) authorize(r *http.Request) error {
+	if wa == nil {
+		wa = &writeAuth{}
+	}
+	if len(wa.keys) > 0 {
+		if err := wa.checkSignature(r); err == nil || wa.callout == "" {
+			return err
+		}
+	}
+	if wa.callout != "" {
+		return wa.ask(r)
+	}
+	if natshttp.Subject(r) == "" {
+		return errors.New("writes over HTTP need -write-key or -write-callout")
+	}
+	return nil
+}
+
+// checkSignature returns an error unless r is signed by one of our keys.
+func (wa *writeAuth) checkSignature(r *http.Request) error {
+	at := r.Header.Get(writeTimeHeader)
+	sig, err := base64.RawURLEncoding.DecodeString(r.Header.Get(signatureHeader))
+	if err != nil || at == "" {
+		return errors.New("write not signed")
+	}
+	t, err := time.Parse(time.RFC3339Nano, at)
+	if err != nil {
+		return errors.New("bad write time")
+	}
+	if age := time.Since(t); age > maxSignatureAge || age < -maxSignatureAge {
+		return errors.New("write signed too long ago")
+	}
+	data := writeSigned(r.Method, r.URL.RequestURI(), r.Header, at)
+	for _, kp := range wa.keys {
+		if kp.Verify(data, sig) == nil {
+			return nil
+		}
+	}
+	return errors.New("write not signed by a write key")
+}
+
+// ask sends the write to the callout, returning its reason for refusing.
+func (wa *writeAuth) ask(r *http.Request) error {
+	data, err := json.Marshal(writeCheck{
+		Method:      r.Method,
+		Path:        r.URL.Path,
+		Destination: r.Header.Get("Destination"),
+		Subject:     natshttp.Subject(r),
+		Header:      r.Header,
+	})
+	if err != nil {
+		return err
+	}
+	m, err := wa.nc.Request(wa.callout, data, writeCalloutTimeout)
+	if err != nil {
+		natshttp.Logf(r, "Error asking %q about a write: %v", wa.callout, err)
+		return errors.New("write could not be authorized")
+	}
+	if reason := strings.TrimSpace(string(m.Data)); reason != "" {
+		return errors.New(reason)
+	}
+	return nil
+}
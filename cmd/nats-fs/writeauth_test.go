@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/derekcollison/nats-fs/natshttp"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+)
+
+// natsWrite returns a PUT of upath as it arrives over NATS, signed by kp
+// unless it is nil.
+func natsWrite(t *testing.T, kp nkeys.KeyPair, upath string) *http.Request {
+	t.Helper()
+	m := nats.NewMsg("files")
+	m.Header.Set("Method", http.MethodPut)
+	m.Header.Set("URL", upath)
+	if kp != nil {
+		if err := signWrite(kp, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := natshttp.NewRequest(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestWriteAuthWithoutKeys(t *testing.T) {
+	var wa *writeAuth
+	if err := wa.authorize(natsWrite(t, nil, "/a.txt")); err != nil {
+		t.Fatalf("write over NATS refused: %v", err)
+	}
+	if err := wa.authorize(httptest.NewRequest(http.MethodPut, "/a.txt", nil)); err == nil {
+		t.Fatal("unauthenticated write over HTTP accepted")
+	}
+}
+
+func TestWriteAuthSigned(t *testing.T) {
+	kp, err := nkeys.CreateServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, _ := kp.PublicKey()
+	key, _ := nkeys.FromPublicKey(pub)
+	wa := &writeAuth{keys: []nkeys.KeyPair{key}}
+
+	if err := wa.authorize(natsWrite(t, kp, "/dir/a b.txt?x=1")); err != nil {
+		t.Fatalf("signed write refused: %v", err)
+	}
+	if err := wa.authorize(natsWrite(t, nil, "/a.txt")); err == nil {
+		t.Fatal("unsigned write accepted")
+	}
+	// The signature covers the path.
+	r := natsWrite(t, kp, "/a.txt")
+	r.URL.Path = "/b.txt"
+	if err := wa.authorize(r); err == nil {
+		t.Fatal("write moved to another path accepted")
+	}
+	other, _ := nkeys.CreateServer()
+	if err := wa.authorize(natsWrite(t, other, "/a.txt")); err == nil {
+		t.Fatal("write signed by another key accepted")
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/derekcollison/nats-fs/client"
+)
+
+// result describes a transfer for -json and -w, written to stdout once it
+// is over, after any body. Each transfer has its own.
+type result struct {
+	Subject   string      `json:"subject"`
+	URL       string      `json:"url"`
+	Status    int         `json:"status"`
+	Headers   http.Header `json:"headers,omitempty"`
+	Bytes     int64       `json:"bytes"`
+	FirstByte float64     `json:"time_first_byte"`
+	Total     float64     `json:"time_total"`
+	ExitCode  int         `json:"exit_code"`
+	Error     string      `json:"error,omitempty"`
+
+	start time.Time
+}
+
+// Set from -json and -w.
+var (
+	jsonOutput bool
+	writeOut   string
+)
+
+// Results of concurrent transfers are written one at a time.
+var reportMu sync.Mutex
+
+// newResult starts the result of a transfer of url on subject.
+func newResult(subject, url string) *result {
+	return &result{Subject: subject, URL: url, start: time.Now()}
+}
+
+// gotHeader records the header message of the response, and its path if
+// redirected.
+func (r *result) gotHeader(resp *client.Response) {
+	r.FirstByte = time.Since(r.start).Seconds()
+	r.URL = resp.Request.Header.Get("URL")
+	r.Status = resp.StatusCode
+	r.Headers = resp.Header
+}
+
+// report writes the result as asked by -json and -w.
+func (r *result) report(err error) {
+	if !jsonOutput && writeOut == "" {
+		return
+	}
+	r.Total = time.Since(r.start).Seconds()
+	r.ExitCode = exitCode(err)
+	if err != nil {
+		r.Error = err.Error()
+	}
+	reportMu.Lock()
+	defer reportMu.Unlock()
+	if jsonOutput {
+		data, _ := json.Marshal(r)
+		fmt.Fprintf(os.Stdout, "%s\n", data)
+	}
+	if writeOut != "" {
+		fmt.Fprint(os.Stdout, expandWriteOut(writeOut, r))
+	}
+}
+
+// fatal reports the failed transfer, then exits as fatal does.
+func (r *result) fatal(err error) {
+	r.report(err)
+	fatal(err)
+}
+
+// expandWriteOut expands curl style %{variable} references and \n, \t
+// escapes in a -w template. Headers are %{header.Name}.
+func expandWriteOut(tmpl string, r *result) string {
+	var b strings.Builder
+	for len(tmpl) > 0 {
+		switch {
+		case strings.HasPrefix(tmpl, `\n`):
+			b.WriteByte('\n')
+			tmpl = tmpl[2:]
+		case strings.HasPrefix(tmpl, `\t`):
+			b.WriteByte('\t')
+			tmpl = tmpl[2:]
+		case strings.HasPrefix(tmpl, "%{"):
+			end := strings.IndexByte(tmpl, '}')
+			if end < 0 {
+				b.WriteString(tmpl)
+				return b.String()
+			}
+			b.WriteString(writeOutVar(tmpl[2:end], r))
+			tmpl = tmpl[end+1:]
+		default:
+			b.WriteByte(tmpl[0])
+			tmpl = tmpl[1:]
+		}
+	}
+	return b.String()
+}
+
+func writeOutVar(name string, r *result) string {
+	if h, ok := strings.CutPrefix(name, "header."); ok {
+		return r.Headers.Get(h)
+	}
+	switch name {
+	case "status", "http_code", "response_code":
+		return strconv.Itoa(r.Status)
+	case "size", "size_download":
+		return strconv.FormatInt(r.Bytes, 10)
+	case "time_total":
+		return strconv.FormatFloat(r.Total, 'f', 6, 64)
+	case "time_first_byte", "time_starttransfer":
+		return strconv.FormatFloat(r.FirstByte, 'f', 6, 64)
+	case "speed", "speed_download":
+		if r.Total == 0 {
+			return "0"
+		}
+		return strconv.FormatInt(int64(float64(r.Bytes)/r.Total), 10)
+	case "content_type":
+		return r.Headers.Get("Content-Type")
+	case "request_id":
+		return r.Headers.Get("X-Request-Id")
+	case "subject":
+		return r.Subject
+	case "url":
+		return r.URL
+	case "exitcode":
+		return strconv.Itoa(r.ExitCode)
+	case "errormsg":
+		return r.Error
+	}
+	return "%{" + name + "}"
+}
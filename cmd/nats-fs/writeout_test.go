@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestExpandWriteOut(t *testing.T) {
+	a, b := newResult("files", "/a"), newResult("files", "/b")
+	a.Status, a.Bytes = 200, 10
+	b.Status, b.Headers = 404, http.Header{"Content-Type": {"text/plain"}}
+
+	for _, tc := range []struct {
+		r    *result
+		tmpl string
+		want string
+	}{
+		{a, `%{url} %{status} %{size}\n`, "/a 200 10\n"},
+		{b, `%{url}\t%{http_code} %{header.Content-Type}`, "/b\t404 text/plain"},
+		{a, `%{unknown} %{url`, "%{unknown} %{url"},
+	} {
+		if got := expandWriteOut(tc.tmpl, tc.r); got != tc.want {
+			t.Errorf("expandWriteOut(%q) = %q, want %q", tc.tmpl, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,491 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/derekcollison/nats-fs/natshttp"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+)
+
+// writeConfig handles the methods that change the served tree.
+type writeConfig struct {
+	readOnly bool
+	// Decides who may write once -read-only is off.
+	auth *writeAuth
+	// Where change events are published, empty for nowhere, for writes
+	// to subject. Signed with signer if not nil.
+	nc      *nats.Conn
+	events  string
+	subject string
+	signer  nkeys.KeyPair
+	// Resumable upload sessions.
+	uploads *uploads
+	// Largest file that may be written, 0 for no limit.
+	maxUpload int64
+	// Patterns of paths writes may go to, empty for anywhere.
+	allow []string
+	// Bytes stored per tenant, tenants being the prefix served with -tenants.
+	quotas  *quotas
+	tenants string
+	// Content-addressable store files are kept in, nil to write them as is.
+	cas *casStore
+	// Revisions of written files, nil to keep none.
+	history *history
+}
+
+// changeEvent is published to the change feed for every change we make.
+type changeEvent struct {
+	Server    string    `json:"server"`
+	Time      time.Time `json:"time"`
+	Op        string    `json:"op"`
+	Path      string    `json:"path"`
+	Subject   string    `json:"subject,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+// isWriteMethod reports whether the method changes the served tree.
+func isWriteMethod(method string) bool {
+	switch method {
+	case http.MethodPut, http.MethodPost, http.MethodDelete, "MKCOL", "MOVE", "COPY":
+		return true
+	}
+	return false
+}
+
+// serve checks the request may write and dispatches it, file being the
+// resolved path under root.
+func (wc *writeConfig) serve(w http.ResponseWriter, r *http.Request, root, file string) {
+	if wc.readOnly {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "405 method not allowed, serving read-only", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := wc.auth.authorize(r); err != nil {
+		http.Error(w, "403 forbidden: "+err.Error(), http.StatusForbidden)
+		return
+	}
+	if !wc.writeAllowed(r.URL.Path) {
+		http.Error(w, "403 forbidden, writes are not allowed here", http.StatusForbidden)
+		return
+	}
+	if isUploadRequest(r) {
+		wc.uploads.serve(wc, w, r, root, file)
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		wc.servePut(w, r, root, file)
+	case http.MethodPost:
+		http.Error(w, "400 bad request, POST starts or completes an upload", http.StatusBadRequest)
+	case http.MethodDelete:
+		wc.serveDelete(w, r, root, file)
+	case "MKCOL":
+		wc.serveMkcol(w, r, file)
+	case "MOVE", "COPY":
+		wc.serveMoveCopy(w, r, root, file)
+	}
+}
+
+// tenant returns the tenant a request writes for, empty when not serving
+// tenants.
+func (wc *writeConfig) tenant(r *http.Request) string {
+	if wc.tenants == "" {
+		return ""
+	}
+	tenant, _ := tenantOf(r, wc.tenants)
+	return tenant
+}
+
+// serveDelete removes a file or an empty directory.
+func (wc *writeConfig) serveDelete(w http.ResponseWriter, r *http.Request, root, file string) {
+	upath := path.Clean("/" + r.URL.Path)
+	if upath == "/" {
+		http.Error(w, "403 forbidden, will not delete the root", http.StatusForbidden)
+		return
+	}
+	size, _ := treeSize(file)
+	if err := os.Remove(file); err != nil {
+		switch {
+		case os.IsNotExist(err):
+			http.NotFound(w, r)
+		case errors.Is(err, syscall.ENOTEMPTY), errors.Is(err, syscall.EEXIST):
+			http.Error(w, "409 conflict, directory is not empty", http.StatusConflict)
+		default:
+			writeError(w, r, file, err)
+		}
+		return
+	}
+	natshttp.Logf(r, "Deleted %q", upath)
+	wc.cas.removed(file)
+	if err := wc.quotas.add(wc.tenant(r), root, -size); err != nil {
+		natshttp.Logf(r, "Error updating usage: %v", err)
+	}
+	wc.publish(r, "delete", upath)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// publish sends a change event to the change feed, signed so mirrors can
+// tell it came from us.
+func (wc *writeConfig) publish(r *http.Request, op, upath string) {
+	if wc.nc == nil || wc.events == "" {
+		return
+	}
+	// Writes over HTTP arrive without a subject, they change the same tree.
+	subj := natshttp.Subject(r)
+	if subj == "" {
+		subj = wc.subject
+	}
+	data, err := json.Marshal(changeEvent{
+		Server:    serverName(),
+		Time:      time.Now().UTC(),
+		Op:        op,
+		Path:      upath,
+		Subject:   subj,
+		RequestID: natshttp.RequestIDOf(r),
+	})
+	if err != nil {
+		return
+	}
+	msg := nats.NewMsg(wc.events)
+	msg.Data = data
+	if wc.signer != nil {
+		sig, err := wc.signer.Sign(data)
+		if err != nil {
+			log.Printf("Error signing change event: %v", err)
+			return
+		}
+		msg.Header.Set(signatureHeader, base64.RawURLEncoding.EncodeToString(sig))
+	}
+	if err := wc.nc.PublishMsg(msg); err != nil {
+		log.Printf("Error publishing change event: %v", err)
+	}
+}
+
+// servePut writes the body to a file, replacing it whole once the body has
+// arrived. Large files are better sent with a resumable upload.
+func (wc *writeConfig) servePut(w http.ResponseWriter, r *http.Request, root, file string) {
+	upath := path.Clean("/" + r.URL.Path)
+	if fi, err := os.Stat(file); err == nil && fi.IsDir() {
+		http.Error(w, "409 conflict, is a directory", http.StatusConflict)
+		return
+	}
+	if have := r.Header.Get(haveSHA256Header); have != "" {
+		wc.serveHave(w, r, root, file, have)
+		return
+	}
+	if wc.maxUpload > 0 && r.ContentLength > wc.maxUpload {
+		writeError(w, r, file, errTooLarge)
+		return
+	}
+	old, _ := treeSize(file)
+	if r.ContentLength >= 0 {
+		if err := wc.quotas.fits(wc.tenant(r), root, r.ContentLength-old); err != nil {
+			writeError(w, r, file, err)
+			return
+		}
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), "."+filepath.Base(file)+".*")
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.Error(w, "409 conflict, parent does not exist", http.StatusConflict)
+		} else {
+			writeError(w, r, file, err)
+		}
+		return
+	}
+	defer os.Remove(tmp.Name())
+	sendContinue(w, r)
+	var body io.Reader = r.Body
+	if wc.maxUpload > 0 {
+		body = io.LimitReader(r.Body, wc.maxUpload+1)
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), body)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if wc.maxUpload > 0 && n > wc.maxUpload {
+		writeError(w, r, file, errTooLarge)
+		return
+	}
+	if err == nil && r.ContentLength >= 0 && n != r.ContentLength {
+		err = fmt.Errorf("%w, received %d of %d bytes", io.ErrUnexpectedEOF, n, r.ContentLength)
+	}
+	if err != nil {
+		natshttp.Logf(r, "Error receiving %q: %v", upath, err)
+		http.Error(w, "400 bad request, body incomplete", http.StatusBadRequest)
+		return
+	}
+	hash := hex.EncodeToString(h.Sum(nil))
+	if want := r.Header.Get(contentSHA256Header); want != "" && !strings.EqualFold(want, hash) {
+		natshttp.Logf(r, "Body of %q does not match %s", upath, contentSHA256Header)
+		http.Error(w, "400 bad request, body does not match "+contentSHA256Header, http.StatusBadRequest)
+		return
+	}
+	_, err = os.Stat(file)
+	exists := err == nil
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		writeError(w, r, file, err)
+		return
+	}
+	if err := wc.quotas.add(wc.tenant(r), root, n-old); err != nil {
+		writeError(w, r, file, err)
+		return
+	}
+	if wc.cas != nil {
+		err = wc.cas.store(tmp.Name(), hash, file)
+	} else {
+		err = os.Rename(tmp.Name(), file)
+	}
+	if err != nil {
+		wc.quotas.add(wc.tenant(r), root, old-n)
+		writeError(w, r, file, err)
+		return
+	}
+	natshttp.Logf(r, "Wrote %q, %d bytes", upath, n)
+	wc.history.record(r, file)
+	wc.publish(r, "put", upath)
+	if exists {
+		w.WriteHeader(http.StatusNoContent)
+	} else {
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+// serveHave links file to the blob for hash if we have it, sparing the
+// client the upload, otherwise responds 404 so it sends the body.
+func (wc *writeConfig) serveHave(w http.ResponseWriter, r *http.Request, root, file, hash string) {
+	upath := path.Clean("/" + r.URL.Path)
+	hash = strings.ToLower(hash)
+	size, err := wc.cas.size(hash, root)
+	if err != nil {
+		http.Error(w, "404 not found, send the body", http.StatusNotFound)
+		return
+	}
+	_, err = os.Stat(file)
+	exists := err == nil
+	old, _ := treeSize(file)
+	if err := wc.quotas.add(wc.tenant(r), root, size-old); err != nil {
+		writeError(w, r, file, err)
+		return
+	}
+	if err := wc.cas.link(hash, root, file); err != nil {
+		wc.quotas.add(wc.tenant(r), root, old-size)
+		writeError(w, r, file, err)
+		return
+	}
+	natshttp.Logf(r, "Linked %q to %s, %d bytes", upath, hash, size)
+	wc.history.record(r, file)
+	wc.publish(r, "put", upath)
+	if exists {
+		w.WriteHeader(http.StatusNoContent)
+	} else {
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+// serveMkcol creates a directory, its parent must exist.
+func (wc *writeConfig) serveMkcol(w http.ResponseWriter, r *http.Request, file string) {
+	if r.ContentLength > 0 {
+		http.Error(w, "415 unsupported media type, MKCOL takes no body", http.StatusUnsupportedMediaType)
+		return
+	}
+	upath := path.Clean("/" + r.URL.Path)
+	if err := os.Mkdir(file, 0755); err != nil {
+		switch {
+		case os.IsExist(err):
+			w.Header().Set("Allow", "GET, HEAD, DELETE, MOVE, COPY")
+			http.Error(w, "405 method not allowed, already exists", http.StatusMethodNotAllowed)
+		case os.IsNotExist(err):
+			http.Error(w, "409 conflict, parent does not exist", http.StatusConflict)
+		default:
+			writeError(w, r, file, err)
+		}
+		return
+	}
+	natshttp.Logf(r, "Created directory %q", upath)
+	wc.publish(r, "mkcol", upath)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// serveMoveCopy renames or copies a file or directory to the path in the
+// Destination header, replacing anything there unless Overwrite is F.
+// Responds 201 if the destination is new, 204 if it was replaced.
+func (wc *writeConfig) serveMoveCopy(w http.ResponseWriter, r *http.Request, root, file string) {
+	upath := path.Clean("/" + r.URL.Path)
+	dest, err := destination(r)
+	if err != nil {
+		http.Error(w, "400 bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if upath == "/" || dest == "/" || dest == upath || strings.HasPrefix(dest, upath+"/") {
+		http.Error(w, "403 forbidden, bad destination", http.StatusForbidden)
+		return
+	}
+	if hidden.hide(dest) || !wc.writeAllowed(dest) {
+		http.Error(w, "403 forbidden, bad destination", http.StatusForbidden)
+		return
+	}
+	if _, err := os.Lstat(file); err != nil {
+		if os.IsNotExist(err) {
+			http.NotFound(w, r)
+		} else {
+			writeError(w, r, file, err)
+		}
+		return
+	}
+	target := resolvePath(root, dest)
+	if _, err := os.Stat(filepath.Dir(target)); err != nil {
+		http.Error(w, "409 conflict, destination parent does not exist", http.StatusConflict)
+		return
+	}
+	_, err = os.Lstat(target)
+	exists := err == nil
+	if exists && strings.EqualFold(r.Header.Get("Overwrite"), "F") {
+		http.Error(w, "412 precondition failed, destination exists", http.StatusPreconditionFailed)
+		return
+	}
+	// Bytes gained, a copy adds the source and both drop what they replace.
+	replaced, _ := treeSize(target)
+	delta := -replaced
+	if r.Method == "COPY" {
+		size, _ := treeSize(file)
+		delta += size
+	}
+	if err := wc.quotas.add(wc.tenant(r), root, delta); err != nil {
+		writeError(w, r, target, err)
+		return
+	}
+	if exists {
+		if err := os.RemoveAll(target); err != nil {
+			wc.quotas.add(wc.tenant(r), root, -delta)
+			writeError(w, r, target, err)
+			return
+		}
+		wc.cas.removed(target)
+	}
+	op := strings.ToLower(r.Method)
+	if r.Method == "MOVE" {
+		err = os.Rename(file, target)
+	} else {
+		err = copyTree(file, target)
+	}
+	if err != nil {
+		// What it replaced is gone either way.
+		wc.quotas.add(wc.tenant(r), root, -delta-replaced)
+		writeError(w, r, file, err)
+		return
+	}
+	if r.Method == "MOVE" {
+		wc.cas.moved(file, target)
+	} else {
+		wc.cas.copied(file, target)
+	}
+	natshttp.Logf(r, "%s %q to %q", r.Method, upath, dest)
+	wc.history.record(r, target)
+	wc.publish(r, op, dest)
+	if r.Method == "MOVE" {
+		wc.publish(r, "delete", upath)
+	}
+	if exists {
+		w.WriteHeader(http.StatusNoContent)
+	} else {
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+// destination returns the clean path of the Destination header, which may
+// be a full URL or just a path.
+func destination(r *http.Request) (string, error) {
+	d := r.Header.Get("Destination")
+	if d == "" {
+		return "", errors.New("missing Destination header")
+	}
+	u, err := url.Parse(d)
+	if err != nil || u.Path == "" {
+		return "", fmt.Errorf("bad Destination %q", d)
+	}
+	return path.Clean("/" + u.Path), nil
+}
+
+// copyTree copies a file, or a directory and everything under it.
+func copyTree(src, dst string) error {
+	fi, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	switch {
+	case fi.IsDir():
+		if err := os.Mkdir(dst, fi.Mode().Perm()); err != nil {
+			return err
+		}
+		entries, err := os.ReadDir(src)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if err := copyTree(filepath.Join(src, e.Name()), filepath.Join(dst, e.Name())); err != nil {
+				return err
+			}
+		}
+		return nil
+	case fi.Mode().IsRegular():
+		in, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_EXCL, fi.Mode().Perm())
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, in); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	}
+	// Links and devices are not copied, they could point out of the root.
+	return nil
+}
+
+// sendContinue tells a requester that sent Expect: 100-continue the write
+// was accepted, so it sends the body. Others are not sent the 100.
+func sendContinue(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.Header.Get("Expect"), "100-continue") {
+		w.WriteHeader(http.StatusContinue)
+	}
+}
+
+// writeError responds to a failed write, logging anything unexpected.
+func writeError(w http.ResponseWriter, r *http.Request, file string, err error) {
+	switch {
+	case os.IsPermission(err):
+		http.Error(w, "403 forbidden", http.StatusForbidden)
+		return
+	case errors.Is(err, errTooLarge):
+		http.Error(w, "413 request entity too large", http.StatusRequestEntityTooLarge)
+		return
+	case errors.Is(err, errQuotaExceeded):
+		http.Error(w, "507 insufficient storage, quota exceeded", http.StatusInsufficientStorage)
+		return
+	}
+	natshttp.Logf(r, "Error writing %q: %v", file, err)
+	http.Error(w, "500 internal server error", http.StatusInternalServerError)
+}
@@ -0,0 +1,377 @@
+// Package delta implements an rsync style delta transfer.
+//
+// The receiver sends a Signature of the copy it already has, the sender
+// replies with a stream of operations that either copy a block from the
+// receiver's copy or carry literal data, and the receiver applies them.
+package delta
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	MinBlockSize = 2 * 1024
+	MaxBlockSize = 128 * 1024
+
+	strongSize = md5.Size
+	entrySize  = 4 + strongSize
+
+	// Max literal data we hold before emitting it.
+	maxLiteral = 64 * 1024
+)
+
+// Operation codes in the delta stream. The stream ends with opEnd, carrying
+// the length and MD5 of the sender's copy.
+const (
+	opCopy    = 'C'
+	opLiteral = 'L'
+	opEnd     = 'E'
+)
+
+var (
+	ErrBadSignature = errors.New("delta: bad signature")
+	// The stream ended before its end operation.
+	ErrTruncated = errors.New("delta: truncated stream")
+	// The reconstructed copy does not match the sender's.
+	ErrMismatch = errors.New("delta: reconstruction does not match")
+)
+
+// Block is the checksum pair for one block of the receiver's copy.
+type Block struct {
+	Weak   uint32
+	Strong [strongSize]byte
+}
+
+// Signature describes the receiver's copy. Only full blocks are included.
+type Signature struct {
+	BlockSize int
+	Blocks    []Block
+}
+
+// BlockSizeFor picks a block size for a file of the given size.
+// Roughly the square root of the size, like rsync.
+func BlockSizeFor(size int64) int {
+	bs := int(math.Sqrt(float64(size)))
+	bs = (bs + 7) &^ 7
+	if bs < MinBlockSize {
+		bs = MinBlockSize
+	}
+	if bs > MaxBlockSize {
+		bs = MaxBlockSize
+	}
+	return bs
+}
+
+// EncodedSize is the size of the encoded signature.
+func (s *Signature) EncodedSize() int {
+	return 4 + len(s.Blocks)*entrySize
+}
+
+// NewSignature computes the signature of r using the given block size.
+func NewSignature(r io.Reader, blockSize int) (*Signature, error) {
+	if blockSize <= 0 {
+		return nil, ErrBadSignature
+	}
+	sig := &Signature{BlockSize: blockSize}
+	buf := make([]byte, blockSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n == blockSize {
+			sig.Blocks = append(sig.Blocks, Block{Weak: weakSum(buf), Strong: md5.Sum(buf)})
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return sig, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// MarshalBinary encodes the signature.
+func (s *Signature) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 4, s.EncodedSize())
+	binary.BigEndian.PutUint32(b, uint32(s.BlockSize))
+	for _, blk := range s.Blocks {
+		b = binary.BigEndian.AppendUint32(b, blk.Weak)
+		b = append(b, blk.Strong[:]...)
+	}
+	return b, nil
+}
+
+// UnmarshalBinary decodes a signature.
+func (s *Signature) UnmarshalBinary(b []byte) error {
+	if len(b) < 4 || (len(b)-4)%entrySize != 0 {
+		return ErrBadSignature
+	}
+	bs := int(binary.BigEndian.Uint32(b))
+	if bs < MinBlockSize || bs > MaxBlockSize {
+		return ErrBadSignature
+	}
+	s.BlockSize = bs
+	s.Blocks = make([]Block, 0, (len(b)-4)/entrySize)
+	for b = b[4:]; len(b) > 0; b = b[entrySize:] {
+		var blk Block
+		blk.Weak = binary.BigEndian.Uint32(b)
+		copy(blk.Strong[:], b[4:entrySize])
+		s.Blocks = append(s.Blocks, blk)
+	}
+	return nil
+}
+
+// Diff reads the sender's copy from r and writes the delta stream to w.
+func Diff(sig *Signature, r io.Reader, w io.Writer) error {
+	bs := sig.BlockSize
+	lookup := make(map[uint32][]int, len(sig.Blocks))
+	for i, blk := range sig.Blocks {
+		lookup[blk.Weak] = append(lookup[blk.Weak], i)
+	}
+
+	bw := bufio.NewWriterSize(w, maxLiteral+16)
+	sum := md5.New()
+	cr := &countingReader{r: io.TeeReader(r, sum)}
+	br := bufio.NewReader(cr)
+
+	// buf holds unsent data, buf[:pos] is literal, buf[pos:] is the window.
+	buf := make([]byte, 0, maxLiteral+bs)
+	pos := 0
+	var a, b uint32
+
+	// Pending run of copied blocks.
+	runStart, runLen := -1, 0
+	flushRun := func() error {
+		if runLen == 0 {
+			return nil
+		}
+		var op [9]byte
+		op[0] = opCopy
+		binary.BigEndian.PutUint32(op[1:], uint32(runStart))
+		binary.BigEndian.PutUint32(op[5:], uint32(runLen))
+		runStart, runLen = -1, 0
+		_, err := bw.Write(op[:])
+		return err
+	}
+	flushLiteral := func() error {
+		if pos == 0 {
+			return nil
+		}
+		if err := flushRun(); err != nil {
+			return err
+		}
+		var op [5]byte
+		op[0] = opLiteral
+		binary.BigEndian.PutUint32(op[1:], uint32(pos))
+		if _, err := bw.Write(op[:]); err != nil {
+			return err
+		}
+		if _, err := bw.Write(buf[:pos]); err != nil {
+			return err
+		}
+		buf = append(buf[:0], buf[pos:]...)
+		pos = 0
+		return nil
+	}
+	finish := func() error {
+		pos = len(buf)
+		if err := flushLiteral(); err != nil {
+			return err
+		}
+		if err := flushRun(); err != nil {
+			return err
+		}
+		var op [9]byte
+		op[0] = opEnd
+		binary.BigEndian.PutUint64(op[1:], uint64(cr.n))
+		if _, err := bw.Write(op[:]); err != nil {
+			return err
+		}
+		if _, err := bw.Write(sum.Sum(nil)); err != nil {
+			return err
+		}
+		return bw.Flush()
+	}
+
+	for {
+		// Fill the window.
+		for len(buf)-pos < bs {
+			c, err := br.ReadByte()
+			if err == io.EOF {
+				return finish()
+			}
+			if err != nil {
+				return err
+			}
+			buf = append(buf, c)
+			if len(buf)-pos == bs {
+				a, b = rollInit(buf[pos:])
+			}
+		}
+
+		window := buf[pos:]
+		if idx, ok := match(sig, lookup, a|b<<16, window); ok {
+			if err := flushLiteral(); err != nil {
+				return err
+			}
+			if runLen > 0 && runStart+runLen == idx {
+				runLen++
+			} else {
+				if err := flushRun(); err != nil {
+					return err
+				}
+				runStart, runLen = idx, 1
+			}
+			buf = buf[:0]
+			continue
+		}
+
+		// No match, slide the window by one byte.
+		c, err := br.ReadByte()
+		if err == io.EOF {
+			return finish()
+		}
+		if err != nil {
+			return err
+		}
+		out := buf[pos]
+		buf = append(buf, c)
+		pos++
+		a, b = roll(a, b, out, c, bs)
+		if pos >= maxLiteral {
+			if err := flushLiteral(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func match(sig *Signature, lookup map[uint32][]int, weak uint32, window []byte) (int, bool) {
+	idxs, ok := lookup[weak]
+	if !ok {
+		return 0, false
+	}
+	strong := md5.Sum(window)
+	for _, i := range idxs {
+		if sig.Blocks[i].Strong == strong {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// Apply reads the delta stream from r and writes the reconstructed copy to w.
+// The basis is the receiver's copy the signature was computed from. A stream
+// cut short fails with ErrTruncated, a copy differing in length or MD5 from
+// the sender's with ErrMismatch.
+func Apply(basis io.ReaderAt, blockSize int, r io.Reader, w io.Writer) error {
+	br := bufio.NewReader(r)
+	block := make([]byte, blockSize)
+	sum := md5.New()
+	cw := &countingWriter{w: io.MultiWriter(w, sum)}
+	var hdr [8 + strongSize]byte
+	for {
+		op, err := br.ReadByte()
+		if err == io.EOF {
+			return ErrTruncated
+		}
+		if err != nil {
+			return err
+		}
+		switch op {
+		case opCopy:
+			if _, err := io.ReadFull(br, hdr[:8]); err != nil {
+				return truncated(err)
+			}
+			start := int64(binary.BigEndian.Uint32(hdr[:4]))
+			count := int64(binary.BigEndian.Uint32(hdr[4:]))
+			for i := start; i < start+count; i++ {
+				if _, err := basis.ReadAt(block, i*int64(blockSize)); err != nil {
+					return err
+				}
+				if _, err := cw.Write(block); err != nil {
+					return err
+				}
+			}
+		case opLiteral:
+			if _, err := io.ReadFull(br, hdr[:4]); err != nil {
+				return truncated(err)
+			}
+			n := int64(binary.BigEndian.Uint32(hdr[:4]))
+			if _, err := io.CopyN(cw, br, n); err != nil {
+				return truncated(err)
+			}
+		case opEnd:
+			if _, err := io.ReadFull(br, hdr[:]); err != nil {
+				return truncated(err)
+			}
+			if n := binary.BigEndian.Uint64(hdr[:8]); n != uint64(cw.n) {
+				return fmt.Errorf("%w: %d bytes, sent %d", ErrMismatch, cw.n, n)
+			}
+			if string(hdr[8:]) != string(sum.Sum(nil)) {
+				return fmt.Errorf("%w: checksum differs", ErrMismatch)
+			}
+			if _, err := br.ReadByte(); err != io.EOF {
+				return errors.New("delta: data after the end of the stream")
+			}
+			return nil
+		default:
+			return fmt.Errorf("delta: unknown operation %q", op)
+		}
+	}
+}
+
+// truncated maps a stream ending within an operation to ErrTruncated.
+func truncated(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrTruncated
+	}
+	return err
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Weak rolling checksum, same as rsync's.
+func weakSum(data []byte) uint32 {
+	a, b := rollInit(data)
+	return a | b<<16
+}
+
+func rollInit(data []byte) (a, b uint32) {
+	l := uint32(len(data))
+	for i, c := range data {
+		a += uint32(c)
+		b += (l - uint32(i)) * uint32(c)
+	}
+	return a & 0xffff, b & 0xffff
+}
+
+func roll(a, b uint32, out, in byte, blockSize int) (uint32, uint32) {
+	a = (a - uint32(out) + uint32(in)) & 0xffff
+	b = (b - uint32(blockSize)*uint32(out) + a) & 0xffff
+	return a, b
+}
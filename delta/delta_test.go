@@ -0,0 +1,82 @@
+package delta
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+// diff returns the delta stream turning basis into data.
+func diff(t *testing.T, basis, data []byte) []byte {
+	t.Helper()
+	sig, err := NewSignature(bytes.NewReader(basis), MinBlockSize)
+	if err != nil {
+		t.Fatalf("NewSignature: %v", err)
+	}
+	var stream bytes.Buffer
+	if err := Diff(sig, bytes.NewReader(data), &stream); err != nil {
+		t.Fatalf("Diff: %v", err)
+	}
+	return stream.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	basis := make([]byte, 20*MinBlockSize+100)
+	rng.Read(basis)
+	// Changed in the middle, cut at the start and grown at the end.
+	data := append([]byte(nil), basis[MinBlockSize/2:]...)
+	copy(data[5*MinBlockSize:], "changed")
+	data = append(data, "appended"...)
+
+	for _, tc := range []struct {
+		name        string
+		basis, data []byte
+	}{
+		{"changed", basis, data},
+		{"same", basis, basis},
+		{"empty basis", nil, data},
+		{"empty data", basis, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			stream := diff(t, tc.basis, tc.data)
+			var out bytes.Buffer
+			if err := Apply(bytes.NewReader(tc.basis), MinBlockSize, bytes.NewReader(stream), &out); err != nil {
+				t.Fatalf("Apply: %v", err)
+			}
+			if !bytes.Equal(out.Bytes(), tc.data) {
+				t.Fatalf("reconstructed %d bytes differing from the %d sent", out.Len(), len(tc.data))
+			}
+		})
+	}
+}
+
+func TestApplyTruncated(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	basis := make([]byte, 8*MinBlockSize)
+	rng.Read(basis)
+	data := append(append([]byte("prefix"), basis...), "suffix"...)
+	stream := diff(t, basis, data)
+
+	// Every cut, including at op boundaries, must fail.
+	for n := 0; n < len(stream); n++ {
+		err := Apply(bytes.NewReader(basis), MinBlockSize, bytes.NewReader(stream[:n]), &bytes.Buffer{})
+		if !errors.Is(err, ErrTruncated) {
+			t.Fatalf("Apply of the first %d of %d bytes = %v, want ErrTruncated", n, len(stream), err)
+		}
+	}
+}
+
+func TestApplyMismatch(t *testing.T) {
+	basis := bytes.Repeat([]byte("basis block "), MinBlockSize)
+	data := append([]byte("new "), basis...)
+	stream := diff(t, basis, data)
+
+	// A different basis reconstructs something else.
+	other := bytes.Repeat([]byte("other block "), MinBlockSize)
+	err := Apply(bytes.NewReader(other), MinBlockSize, bytes.NewReader(stream), &bytes.Buffer{})
+	if !errors.Is(err, ErrMismatch) {
+		t.Fatalf("Apply with another basis = %v, want ErrMismatch", err)
+	}
+}
@@ -0,0 +1,138 @@
+package natsfs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+
+	"github.com/derekcollison/nats-fs/natshttp"
+	"github.com/nats-io/nats.go"
+)
+
+// FileServer returns a handler serving the files in fsys, such as assets
+// embedded with go:embed or a *zip.Reader, so single artifact deployments
+// need not unpack to disk. Files that can not seek, like compressed zip
+// entries, are read into memory when first read so ranges still work, or
+// into a temporary file if large. Larger than 1GB they are not served.
+func FileServer(fsys fs.FS) http.Handler {
+	return http.FileServer(http.FS(seekableFS{fsys}))
+}
+
+// HandleFS serves the files in fsys on subject.
+func HandleFS(nc *nats.Conn, subject string, fsys fs.FS, opts *natshttp.Options) (*nats.Subscription, error) {
+	return natshttp.Handle(nc, subject, FileServer(fsys), opts)
+}
+
+// seekableFS makes the files of an fs.FS seekable, as http.FS needs.
+type seekableFS struct {
+	fs.FS
+}
+
+func (sfs seekableFS) Open(name string) (fs.File, error) {
+	f, err := sfs.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := f.(io.Seeker); ok {
+		return f, nil
+	}
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() {
+		return f, err
+	}
+	return &spillFile{f: f, fi: fi}, nil
+}
+
+// Files that can not seek are held in memory up to maxMemFile, in a
+// temporary file up to maxSpillFile.
+const (
+	maxMemFile   = 1024 * 1024
+	maxSpillFile = 1024 * 1024 * 1024
+)
+
+// errTooLarge is returned reading files larger than maxSpillFile.
+var errTooLarge = errors.New("natsfs: file too large to serve without seeking")
+
+// spillFile makes a file seekable by copying it into memory or a temporary
+// file, when first read or seeked rather than opened so a HEAD or Stat
+// reads nothing. The copy is limited so a zip bomb can not exhaust memory
+// or disk.
+type spillFile struct {
+	f   fs.File
+	fi  fs.FileInfo
+	rs  io.ReadSeeker
+	tmp *os.File
+	err error
+}
+
+func (sf *spillFile) load() error {
+	if sf.rs != nil || sf.err != nil {
+		return sf.err
+	}
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, sf.f, maxMemFile+1)
+	if err == io.EOF {
+		sf.rs = bytes.NewReader(buf.Bytes())
+		return nil
+	}
+	if err != nil {
+		sf.err = err
+		return err
+	}
+	if sf.tmp, err = os.CreateTemp("", "natsfs-*"); err != nil {
+		sf.err = err
+		return err
+	}
+	os.Remove(sf.tmp.Name())
+	if _, err = sf.tmp.Write(buf.Bytes()); err == nil {
+		var m int64
+		m, err = io.Copy(sf.tmp, io.LimitReader(sf.f, maxSpillFile-n+1))
+		if err == nil && n+m > maxSpillFile {
+			err = errTooLarge
+		}
+	}
+	if err == nil {
+		_, err = sf.tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		sf.err = err
+		return err
+	}
+	sf.rs = sf.tmp
+	return nil
+}
+
+func (sf *spillFile) Read(p []byte) (int, error) {
+	if err := sf.load(); err != nil {
+		return 0, err
+	}
+	return sf.rs.Read(p)
+}
+
+func (sf *spillFile) Seek(offset int64, whence int) (int64, error) {
+	if err := sf.load(); err != nil {
+		return 0, err
+	}
+	return sf.rs.Seek(offset, whence)
+}
+
+func (sf *spillFile) Stat() (fs.FileInfo, error) { return sf.fi, nil }
+
+func (sf *spillFile) Close() error {
+	if sf.tmp != nil {
+		sf.tmp.Close()
+	}
+	return sf.f.Close()
+}
+
+// memFile is a file held in memory.
+type memFile struct {
+	*bytes.Reader
+	fi fs.FileInfo
+}
+
+func (mf *memFile) Stat() (fs.FileInfo, error) { return mf.fi, nil }
+func (mf *memFile) Close() error               { return nil }
@@ -0,0 +1,72 @@
+package natsfs
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// zipFS returns a zip of files, compressed so they can not seek.
+func zipFS(t *testing.T, files map[string][]byte) *zip.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, data := range files {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return zr
+}
+
+func TestFileServerRanges(t *testing.T) {
+	small := []byte("a small compressed file")
+	large := bytes.Repeat([]byte("0123456789"), maxMemFile/5)
+	h := FileServer(zipFS(t, map[string][]byte{"small.txt": small, "large.txt": large}))
+
+	for _, tc := range []struct {
+		name string
+		data []byte
+	}{
+		{"/small.txt", small},
+		{"/large.txt", large},
+	} {
+		req := httptest.NewRequest(http.MethodGet, tc.name, nil)
+		req.Header.Set("Range", "bytes=2-11")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusPartialContent || !bytes.Equal(rec.Body.Bytes(), tc.data[2:12]) {
+			t.Errorf("%s: range got %d %q, want 206 %q", tc.name, rec.Code, rec.Body.Bytes(), tc.data[2:12])
+		}
+	}
+}
+
+func TestFSBackendStreams(t *testing.T) {
+	data := bytes.Repeat([]byte("streamed "), 1000)
+	b := FSBackend(zipFS(t, map[string][]byte{"f.txt": data}))
+	rc, fi, err := b.Open(context.Background(), "f.txt")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer rc.Close()
+	if _, ok := rc.(io.Seeker); ok {
+		t.Fatalf("compressed entry opened seekable, read into memory")
+	}
+	got, err := io.ReadAll(rc)
+	if err != nil || !bytes.Equal(got, data) || fi.Size() != int64(len(data)) {
+		t.Fatalf("read %d bytes, %v, want the %d stored", len(got), err, len(data))
+	}
+}
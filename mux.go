@@ -0,0 +1,198 @@
+// Package natsfs provides building blocks for serving files and other
+// HTTP style handlers over NATS, on top of the natshttp bridge.
+package natsfs
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/derekcollison/nats-fs/natshttp"
+	"github.com/nats-io/nats.go"
+)
+
+// Mux routes requests by NATS subject, method and path pattern.
+//
+// Patterns are slash separated paths where a segment of the form {name}
+// matches any single segment and a final {name...} matches the rest of the
+// path. Values are available with Param. When several routes match, the one
+// with the most literal segments wins, and routes restricted to a subject
+// win over those for any subject.
+type Mux struct {
+	mu     sync.RWMutex
+	routes []*route
+}
+
+type route struct {
+	subject  string
+	method   string
+	segments []string
+	handler  http.Handler
+	score    int
+}
+
+// NewMux returns an empty Mux.
+func NewMux() *Mux {
+	return &Mux{}
+}
+
+// Handle registers handler for method and pattern on any subject.
+// An empty method matches any method.
+func (mux *Mux) Handle(method, pattern string, handler http.Handler) {
+	mux.HandleSubject("", method, pattern, handler)
+}
+
+// HandleFunc registers a handler function for method and pattern on any subject.
+func (mux *Mux) HandleFunc(method, pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	mux.HandleSubject("", method, pattern, http.HandlerFunc(handler))
+}
+
+// HandleSubject registers handler for requests arriving on subject, which may
+// contain the usual NATS wildcards. Requests that did not arrive over NATS
+// never match routes with a subject.
+func (mux *Mux) HandleSubject(subject, method, pattern string, handler http.Handler) {
+	rt := &route{
+		subject:  subject,
+		method:   strings.ToUpper(method),
+		segments: splitPath(pattern),
+		handler:  handler,
+	}
+	for i, seg := range rt.segments {
+		if !isParam(seg) {
+			rt.score += 2
+		} else if !strings.HasSuffix(seg, "...}") || i < len(rt.segments)-1 {
+			rt.score++
+		}
+	}
+	if subject != "" {
+		rt.score += 1000
+	}
+	mux.mu.Lock()
+	mux.routes = append(mux.routes, rt)
+	sort.SliceStable(mux.routes, func(i, j int) bool { return mux.routes[i].score > mux.routes[j].score })
+	mux.mu.Unlock()
+}
+
+// ServeHTTP dispatches to the best matching route, replying 405 if only the
+// method did not match and 404 if nothing did.
+func (mux *Mux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	subject := natshttp.Subject(r)
+	segments := splitPath(r.URL.Path)
+
+	mux.mu.RLock()
+	var allowed []string
+	for _, rt := range mux.routes {
+		if rt.subject != "" && !subjectMatches(rt.subject, subject) {
+			continue
+		}
+		params, ok := rt.match(segments)
+		if !ok {
+			continue
+		}
+		if rt.method != "" && rt.method != r.Method {
+			allowed = append(allowed, rt.method)
+			continue
+		}
+		mux.mu.RUnlock()
+		if len(params) > 0 {
+			r = r.WithContext(context.WithValue(r.Context(), paramsKey{}, params))
+		}
+		rt.handler.ServeHTTP(w, r)
+		return
+	}
+	mux.mu.RUnlock()
+
+	if len(allowed) > 0 {
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		http.Error(w, "405 method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	http.NotFound(w, r)
+}
+
+// Serve subscribes to each subject and routes requests arriving on them
+// through the mux.
+func (mux *Mux) Serve(nc *nats.Conn, opts *natshttp.Options, subjects ...string) ([]*nats.Subscription, error) {
+	var subs []*nats.Subscription
+	for _, subj := range subjects {
+		sub, err := natshttp.Handle(nc, subj, mux, opts)
+		if err != nil {
+			for _, s := range subs {
+				s.Unsubscribe()
+			}
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+	return subs, nil
+}
+
+func (rt *route) match(segments []string) (map[string]string, bool) {
+	var params map[string]string
+	for i, seg := range rt.segments {
+		if isParam(seg) {
+			name := seg[1 : len(seg)-1]
+			if rest := strings.TrimSuffix(name, "..."); rest != name && i == len(rt.segments)-1 {
+				if params == nil {
+					params = make(map[string]string)
+				}
+				if i < len(segments) {
+					params[rest] = strings.Join(segments[i:], "/")
+				}
+				return params, true
+			}
+			if i >= len(segments) {
+				return nil, false
+			}
+			if params == nil {
+				params = make(map[string]string)
+			}
+			params[name] = segments[i]
+			continue
+		}
+		if i >= len(segments) || segments[i] != seg {
+			return nil, false
+		}
+	}
+	return params, len(segments) == len(rt.segments)
+}
+
+type paramsKey struct{}
+
+// Param returns the value of a path parameter matched by a Mux route.
+func Param(r *http.Request, name string) string {
+	params, _ := r.Context().Value(paramsKey{}).(map[string]string)
+	return params[name]
+}
+
+func isParam(seg string) bool {
+	return len(seg) > 2 && seg[0] == '{' && seg[len(seg)-1] == '}'
+}
+
+func splitPath(p string) []string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
+
+// subjectMatches reports whether subject matches pattern with NATS wildcards.
+func subjectMatches(pattern, subject string) bool {
+	if subject == "" {
+		return false
+	}
+	pt := strings.Split(pattern, ".")
+	st := strings.Split(subject, ".")
+	for i, tok := range pt {
+		if tok == ">" {
+			return len(st) > i
+		}
+		if i >= len(st) || (tok != "*" && tok != st[i]) {
+			return false
+		}
+	}
+	return len(pt) == len(st)
+}
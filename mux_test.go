@@ -0,0 +1,103 @@
+package natsfs
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/derekcollison/nats-fs/natshttp"
+	"github.com/nats-io/nats.go"
+)
+
+// echo responds with its name and the named path parameters.
+func echo(name string, params ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, name)
+		for _, p := range params {
+			io.WriteString(w, " "+p+"="+Param(r, p))
+		}
+	})
+}
+
+func TestMuxRoutes(t *testing.T) {
+	mux := NewMux()
+	mux.Handle("GET", "/files/{path...}", echo("files", "path"))
+	mux.Handle("GET", "/files/readme", echo("readme"))
+	mux.Handle("GET", "/users/{id}", echo("user", "id"))
+	mux.Handle("PUT", "/users/{id}", echo("put", "id"))
+
+	for _, tc := range []struct {
+		method, target string
+		code           int
+		body           string
+	}{
+		{"GET", "/files/readme", 200, "readme"},
+		{"GET", "/files/a/b.txt", 200, "files path=a/b.txt"},
+		{"GET", "/files/", 200, "files path="},
+		{"GET", "/users/42", 200, "user id=42"},
+		{"PUT", "/users/42", 200, "put id=42"},
+		{"DELETE", "/users/42", 405, ""},
+		{"GET", "/users/42/more", 404, ""},
+		{"GET", "/nowhere", 404, ""},
+	} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(tc.method, tc.target, nil))
+		if rec.Code != tc.code || tc.body != "" && rec.Body.String() != tc.body {
+			t.Errorf("%s %s = %d %q, want %d %q", tc.method, tc.target, rec.Code, rec.Body.String(), tc.code, tc.body)
+		}
+		if tc.code == 405 && rec.Header().Get("Allow") == "" {
+			t.Errorf("%s %s: 405 without Allow", tc.method, tc.target)
+		}
+	}
+}
+
+func TestMuxSubjects(t *testing.T) {
+	mux := NewMux()
+	mux.Handle("", "/{name}", echo("any"))
+	mux.HandleSubject("tenant.*", "", "/{name}", echo("tenant"))
+
+	serve := func(subject string) string {
+		var r *http.Request
+		if subject == "" {
+			r = httptest.NewRequest("GET", "/x", nil)
+		} else {
+			m := nats.NewMsg(subject)
+			m.Header.Set("URL", "/x")
+			var err error
+			if r, err = natshttp.NewRequest(m); err != nil {
+				t.Fatal(err)
+			}
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, r)
+		return rec.Body.String()
+	}
+	if got := serve("tenant.a"); got != "tenant" {
+		t.Errorf("tenant.a routed to %q, want the subject route", got)
+	}
+	if got := serve("other"); got != "any" {
+		t.Errorf("other routed to %q, want any", got)
+	}
+	if got := serve(""); got != "any" {
+		t.Errorf("HTTP routed to %q, want any", got)
+	}
+}
+
+func TestSubjectMatches(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, subject string
+		want             bool
+	}{
+		{"a.b", "a.b", true},
+		{"a.*", "a.b", true},
+		{"a.*", "a.b.c", false},
+		{"a.>", "a.b.c", true},
+		{"a.>", "a", false},
+		{"a.b", "", false},
+	} {
+		if got := subjectMatches(tc.pattern, tc.subject); got != tc.want {
+			t.Errorf("subjectMatches(%q, %q) = %v, want %v", tc.pattern, tc.subject, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// Package natsfstest runs an in-process NATS server serving a directory or
+// handler, for integration tests of handlers and the chunk protocol
+// without any external infrastructure.
+//
+//	srv := natsfstest.NewServer(t, nil)
+//	srv.WriteFile(t, "hello.txt", []byte("hello"))
+//	nc := srv.Connect(t)
+//	// Request /hello.txt on srv.Subject.
+package natsfstest
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/derekcollison/nats-fs/natshttp"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+// Subject requests are served on.
+const Subject = "natsfstest"
+
+// Server is an in-process NATS server with a handler serving requests.
+type Server struct {
+	// NATS is the embedded server, also listening on a random port.
+	NATS *server.Server
+	// Subject the handler serves.
+	Subject string
+	// Dir is the directory served when no handler was given.
+	Dir string
+	// Conn is the connection the handler is served on.
+	Conn *nats.Conn
+}
+
+// RunNATS starts an in-process NATS server, shut down when the test ends.
+func RunNATS(t testing.TB) *server.Server {
+	t.Helper()
+	return run(t, &server.Options{Host: "127.0.0.1", Port: server.RANDOM_PORT, NoLog: true, NoSigs: true})
+}
+
+// RunJetStream starts an in-process NATS server with JetStream, storing
+// streams in a temporary directory, for KV and object store tests.
+func RunJetStream(t testing.TB) *server.Server {
+	t.Helper()
+	return run(t, &server.Options{Host: "127.0.0.1", Port: server.RANDOM_PORT, NoLog: true, NoSigs: true, JetStream: true, StoreDir: t.TempDir()})
+}
+
+func run(t testing.TB, opts *server.Options) *server.Server {
+	t.Helper()
+	ns, err := server.NewServer(opts)
+	if err != nil {
+		t.Fatalf("natsfstest: creating NATS server: %v", err)
+	}
+	ns.Start()
+	if !ns.ReadyForConnections(10 * time.Second) {
+		ns.Shutdown()
+		t.Fatalf("natsfstest: NATS server did not start")
+	}
+	t.Cleanup(func() {
+		ns.Shutdown()
+		ns.WaitForShutdown()
+	})
+	return ns
+}
+
+// NewServer starts a NATS server and serves handler on Subject with opts,
+// which may be nil. A nil handler serves files from a temporary directory.
+func NewServer(t testing.TB, handler http.Handler, opts ...*natshttp.Options) *Server {
+	t.Helper()
+	s := &Server{NATS: RunNATS(t), Subject: Subject}
+	if handler == nil {
+		s.Dir = t.TempDir()
+		handler = http.FileServer(http.Dir(s.Dir))
+	}
+	s.Conn = s.Connect(t)
+	var o *natshttp.Options
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+	if _, err := natshttp.Handle(s.Conn, s.Subject, handler, o); err != nil {
+		t.Fatalf("natsfstest: serving %q: %v", s.Subject, err)
+	}
+	if err := s.Conn.Flush(); err != nil {
+		t.Fatalf("natsfstest: %v", err)
+	}
+	return s
+}
+
+// Connect returns a new connection to the server, closed when the test ends.
+func (s *Server) Connect(t testing.TB) *nats.Conn {
+	t.Helper()
+	nc, err := nats.Connect("", nats.InProcessServer(s.NATS), nats.Name("natsfstest"))
+	if err != nil {
+		t.Fatalf("natsfstest: connecting: %v", err)
+	}
+	t.Cleanup(nc.Close)
+	return nc
+}
+
+// URL is the server's client URL, for connecting from other processes.
+func (s *Server) URL() string {
+	return s.NATS.ClientURL()
+}
+
+// WriteFile writes a file under the served directory, creating any parent
+// directories.
+func (s *Server) WriteFile(t testing.TB, name string, data []byte) {
+	t.Helper()
+	if s.Dir == "" {
+		t.Fatalf("natsfstest: WriteFile with a custom handler")
+	}
+	p := filepath.Join(s.Dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		t.Fatalf("natsfstest: %v", err)
+	}
+	if err := os.WriteFile(p, data, 0644); err != nil {
+		t.Fatalf("natsfstest: %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+package natshttp
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Flow control. Each chunk of a response has a reply subject the requester
+// acks on. From protocol version 2 chunks carry their sequence number in
+// SeqHeader, reply to a single ack subject, and acks carry headers: the
+// total body bytes received so far, as sent including any encryption
+// overhead, the highest sequence received and optionally the most the
+// requester wants unacked. Acks being cumulative a lost one does no harm.
+// In version 1 the chunk size is the last token of the reply subject and
+// acks are empty.
+const (
+	SeqHeader    = "Nats-Fs-Seq"
+	AckedHeader  = "Nats-Fs-Acked"
+	WindowHeader = "Nats-Fs-Window"
+)
+
+// A requester giving up on a response publishes to the ack subject of a
+// chunk it received with the last token replaced by CancelToken, so the
+// handler stops rather than sending the rest into the void. Before any
+// chunk arrived it publishes to RequestCancelSubject of its reply subject.
+const CancelToken = "cancel"
+
+// RequestCancelSubject returns the subject that cancels the response to a
+// request with the reply subject, from version 2.
+func RequestCancelSubject(reply string) string {
+	return reply + "." + CancelToken
+}
+
+// CancelSubject returns the subject that cancels the response chunk is
+// part of, empty if it has no ack subject.
+func CancelSubject(chunk *nats.Msg) string {
+	i := strings.LastIndexByte(chunk.Reply, '.')
+	if i < 0 {
+		return ""
+	}
+	return chunk.Reply[:i+1] + CancelToken
+}
+
+// Acker acks the chunks of one response as they are received.
+type Acker struct {
+	// Window, if set, asks the server to keep no more than this many bytes
+	// unacked.
+	Window int
+
+	received int64
+}
+
+// Ack acknowledges a chunk, in whichever way the server expects.
+func (a *Acker) Ack(msg *nats.Msg) error {
+	a.received += int64(len(msg.Data))
+	seq := msg.Header.Get(SeqHeader)
+	if seq == "" {
+		return msg.Respond(nil)
+	}
+	ack := nats.NewMsg(msg.Reply)
+	ack.Header.Set(AckedHeader, strconv.FormatInt(a.received, 10))
+	ack.Header.Set(SeqHeader, seq)
+	if a.Window > 0 {
+		ack.Header.Set(WindowHeader, strconv.Itoa(a.Window))
+	}
+	return msg.RespondMsg(ack)
+}
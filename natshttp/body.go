@@ -0,0 +1,84 @@
+package natshttp
+
+import (
+	"context"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// BodyInboxHeader names the inbox a streamed request body is pulled from.
+// Bodies that fit in the request message are sent as its payload, larger
+// ones are requested a chunk at a time from this inbox as the handler
+// reads them, the requester responding with the next chunk and an empty
+// message at the end. Reading is the flow control.
+//
+// A requester that sends Expect: 100-continue with a streamed body waits
+// for an interim 100 Continue before sending any of it. Handlers send it
+// with WriteHeader(http.StatusContinue) once they have checked the
+// request, or it is sent when they first read the body, as with net/http.
+// Handlers that reject a request without either never pull any of it.
+const BodyInboxHeader = "Body-Inbox"
+
+// How long we wait for the requester to send the next chunk.
+const bodyChunkTimeout = 10 * time.Second
+
+// bodyReader pulls a streamed request body from the requester.
+type bodyReader struct {
+	nc    *nats.Conn
+	inbox string
+	ctx   context.Context
+	buf   []byte
+	err   error
+	// Called before the first chunk is pulled, nil for nothing.
+	cont func()
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	for len(b.buf) == 0 {
+		if b.err != nil {
+			return 0, b.err
+		}
+		if b.cont != nil {
+			b.cont()
+			b.cont = nil
+		}
+		ctx, cancel := context.WithTimeout(b.ctx, bodyChunkTimeout)
+		msg, err := b.nc.RequestWithContext(ctx, b.inbox, nil)
+		cancel()
+		switch {
+		case err != nil:
+			b.err = err
+		case len(msg.Data) == 0:
+			b.err = io.EOF
+		default:
+			b.buf = msg.Data
+		}
+	}
+	n := copy(p, b.buf)
+	b.buf = b.buf[n:]
+	return n, nil
+}
+
+func (b *bodyReader) Close() error {
+	if b.err == nil {
+		b.err = io.ErrClosedPipe
+	}
+	return nil
+}
+
+// streamBody replaces the request body with one pulled from the requester
+// if it is streaming it. cont is called before the first chunk is pulled.
+func streamBody(ctx context.Context, nc *nats.Conn, m *nats.Msg, cont func()) (io.ReadCloser, int64) {
+	inbox := m.Header.Get(BodyInboxHeader)
+	if inbox == "" {
+		return nil, 0
+	}
+	length := int64(-1)
+	if cl, err := strconv.ParseInt(m.Header.Get("Content-Length"), 10, 64); err == nil {
+		length = cl
+	}
+	return &bodyReader{nc: nc, inbox: inbox, ctx: ctx, cont: cont}, length
+}
@@ -0,0 +1,41 @@
+package natshttp_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/derekcollison/nats-fs/client"
+	"github.com/derekcollison/nats-fs/natsfstest"
+)
+
+func TestCancelBeforeFirstChunk(t *testing.T) {
+	started, canceled := make(chan struct{}), make(chan struct{})
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		select {
+		case <-r.Context().Done():
+			close(canceled)
+		case <-time.After(5 * time.Second):
+		}
+	})
+	srv := natsfstest.NewServer(t, h)
+	c := client.New(srv.Connect(t))
+
+	// Nothing is sent until the handler returns, so the requester gives up
+	// without ever learning the ack subject.
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	if _, err := c.Do(ctx, c.NewRequest(srv.Subject, "/")); err == nil {
+		t.Fatal("Do succeeded after being canceled")
+	}
+	select {
+	case <-canceled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler not canceled")
+	}
+}
@@ -0,0 +1,91 @@
+package natshttp_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/derekcollison/nats-fs/client"
+	"github.com/derekcollison/nats-fs/natsfstest"
+	"github.com/derekcollison/nats-fs/natshttp"
+	"github.com/nats-io/nats.go"
+)
+
+func TestContinueAfterAccept(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/refused" {
+			http.Error(w, "403 forbidden", http.StatusForbidden)
+			return
+		}
+		if r.URL.Path != "/implicit" {
+			w.WriteHeader(http.StatusContinue)
+		}
+		io.Copy(w, r.Body)
+	})
+	srv := natsfstest.NewServer(t, h)
+	nc := srv.Connect(t)
+	c := client.New(nc)
+
+	// send streams "body", waiting as requesters do for the 100 Continue
+	// before answering pulls. It returns how many pulls came and whether
+	// any went unanswered for want of it.
+	send := func(upath string) (resp *client.Response, pulls int32, stuck bool) {
+		accepted := make(chan struct{})
+		var once sync.Once
+		var n atomic.Int32
+		var timedOut, sent atomic.Bool
+		inbox := nats.NewInbox()
+		sub, err := nc.Subscribe(inbox, func(m *nats.Msg) {
+			n.Add(1)
+			select {
+			case <-accepted:
+			case <-time.After(5 * time.Second):
+				timedOut.Store(true)
+				return
+			}
+			if sent.Swap(true) {
+				m.Respond(nil)
+				return
+			}
+			m.Respond([]byte("body"))
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sub.Unsubscribe()
+		req := c.NewRequest(srv.Subject, upath)
+		req.Header.Set("Method", "PUT")
+		req.Header.Set(natshttp.BodyInboxHeader, inbox)
+		req.Header.Set("Expect", "100-continue")
+		ctx := client.WithContinue(context.Background(), func() { once.Do(func() { close(accepted) }) })
+		resp, err = c.Do(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, n.Load(), timedOut.Load()
+	}
+
+	resp, pulls, _ := send("/refused")
+	if resp.StatusCode != http.StatusForbidden || pulls != 0 {
+		t.Fatalf("refused request = %d with %d pulls, want 403 and none", resp.StatusCode, pulls)
+	}
+	resp.Body.Close()
+
+	// Accepted explicitly, and by the first read of the body, which
+	// ReadFrom does holding the writer lock.
+	for _, upath := range []string{"/accepted", "/implicit"} {
+		resp, _, stuck := send(upath)
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil || string(body) != "body" {
+			t.Fatalf("%s echoed %q %v", upath, body, err)
+		}
+		if stuck {
+			t.Fatalf("%s body pulled without a 100 Continue", upath)
+		}
+	}
+}
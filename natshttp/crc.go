@@ -0,0 +1,34 @@
+package natshttp
+
+import (
+	"errors"
+	"hash/crc32"
+	"strconv"
+
+	"github.com/nats-io/nats.go"
+)
+
+// CRCHeader carries the CRC32C of a chunk's payload as sent, in hex, from
+// protocol version 2. Requesters check it on receipt to catch corruption
+// a chunk at a time, rather than after the whole body has been written.
+const CRCHeader = "Nats-Fs-Crc32c"
+
+// ErrCorruptChunk is returned for a chunk that does not match its CRC.
+var ErrCorruptChunk = errors.New("natshttp: chunk does not match its checksum")
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// chunkCRC returns the CRCHeader value for payload.
+func chunkCRC(payload []byte) string {
+	return strconv.FormatUint(uint64(crc32.Checksum(payload, castagnoli)), 16)
+}
+
+// CheckChunk returns ErrCorruptChunk if chunk does not match its CRC. One
+// without a CRC passes.
+func CheckChunk(chunk *nats.Msg) error {
+	want := chunk.Header.Get(CRCHeader)
+	if want == "" || want == chunkCRC(chunk.Data) {
+		return nil
+	}
+	return ErrCorruptChunk
+}
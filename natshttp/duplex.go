@@ -0,0 +1,180 @@
+package natshttp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Duplex sessions. A requester that sends Upgrade: nats-duplex lets the
+// handler take over the response with http.Hijacker, as with net/http. The
+// header message is then a 101 carrying DuplexInboxHeader, an inbox the
+// requester publishes its side of the session to. Those messages carry
+// SeqHeader and a reply subject acked on like response chunks, so each
+// direction has its own flow control. The handler's writes go out as
+// response chunks as usual. An empty message from the requester ends its
+// side, the session ends when the handler closes the conn or returns.
+const (
+	DuplexProtocol    = "nats-duplex"
+	DuplexInboxHeader = "Duplex-Inbox"
+)
+
+var (
+	errNoDuplex      = errors.New("natshttp: requester did not ask for a duplex session")
+	errHeaderWritten = errors.New("natshttp: hijack after the response started")
+)
+
+// Hijack implements http.Hijacker for requesters that asked for a duplex
+// session.
+func (w *nrw) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.Lock()
+	defer w.Unlock()
+	if !w.duplex {
+		return nil, nil, errNoDuplex
+	}
+	if w.wroteHeader {
+		return nil, nil, errHeaderWritten
+	}
+	inbox := nats.NewInbox()
+	sub, err := w.nc.SubscribeSync(inbox)
+	if err != nil {
+		return nil, nil, err
+	}
+	h := w.Header()
+	h.Set("Connection", "Upgrade")
+	h.Set("Upgrade", DuplexProtocol)
+	h.Set(DuplexInboxHeader, inbox)
+	w.writeHeader(http.StatusSwitchingProtocols)
+	c := &duplexConn{w: w, sub: sub, remote: duplexAddr(w.reply)}
+	return c, bufio.NewReadWriter(bufio.NewReader(c), bufio.NewWriter(c)), nil
+}
+
+var _ http.Hijacker = (*nrw)(nil)
+
+// duplexAddr is the subject at either end of a session.
+type duplexAddr string
+
+func (a duplexAddr) Network() string { return "nats" }
+func (a duplexAddr) String() string  { return string(a) }
+
+// duplexConn is the handler's end of a duplex session.
+type duplexConn struct {
+	w      *nrw
+	sub    *nats.Subscription
+	remote duplexAddr
+
+	// Held by reads.
+	rmu   sync.Mutex
+	acker Acker
+	buf   []byte
+	err   error
+
+	mu       sync.Mutex
+	deadline time.Time
+	closed   bool
+}
+
+func (c *duplexConn) Read(p []byte) (int, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	for len(c.buf) == 0 {
+		if c.err != nil {
+			return 0, c.err
+		}
+		ctx := c.w.ctx
+		var cancel context.CancelFunc
+		c.mu.Lock()
+		deadline := c.deadline
+		c.mu.Unlock()
+		if !deadline.IsZero() {
+			ctx, cancel = context.WithDeadline(ctx, deadline)
+		}
+		msg, err := c.sub.NextMsgWithContext(ctx)
+		if cancel != nil {
+			cancel()
+		}
+		switch {
+		case errors.Is(err, context.DeadlineExceeded):
+			return 0, timeoutError{}
+		case err != nil:
+			c.err = err
+		case len(msg.Data) == 0:
+			c.err = io.EOF
+		default:
+			c.buf = msg.Data
+			c.acker.Ack(msg)
+		}
+	}
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+// Write sends p right away, subject to flow control.
+func (c *duplexConn) Write(p []byte) (int, error) {
+	c.w.Lock()
+	defer c.w.Unlock()
+	if c.w.ended {
+		return 0, net.ErrClosed
+	}
+	written := 0
+	for written < len(p) {
+		n := len(p) - written
+		if size := c.w.chunkSize(); n > size {
+			n = size
+		}
+		if err := c.w.publish(p[written : written+n]); err != nil {
+			return written, err
+		}
+		written += n
+	}
+	return written, nil
+}
+
+// Close ends the session.
+func (c *duplexConn) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return net.ErrClosed
+	}
+	c.closed = true
+	c.mu.Unlock()
+	c.sub.Unsubscribe()
+	c.w.done()
+	return nil
+}
+
+func (c *duplexConn) LocalAddr() net.Addr  { return duplexAddr(c.sub.Subject) }
+func (c *duplexConn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *duplexConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+// SetReadDeadline applies to reads started after it is set.
+func (c *duplexConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadline = t
+	c.mu.Unlock()
+	return nil
+}
+
+// SetWriteDeadline is not supported, writes are bounded by flow control.
+func (c *duplexConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// timeoutError is returned by reads past the deadline.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "natshttp: i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
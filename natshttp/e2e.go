@@ -0,0 +1,86 @@
+package natshttp
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+
+	"github.com/nats-io/nkeys"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// E2EKeyHeader carries an X25519 public key, base64 encoded. A requester
+// wanting the response body kept from anyone who can see the NATS traffic,
+// operators included, sends an ephemeral key in it. The response then
+// carries our own ephemeral key, signed in E2EKeySignatureHeader with
+// Options.E2ESigner so the requester knows it was not swapped on the way.
+// Each chunk is a NaCl box with the chunk's sequence number in the
+// response as its nonce, see E2ENonce, so chunks reordered, replayed or
+// dropped do not open. The status and headers are not encrypted.
+const (
+	E2EKeyHeader          = "E2E-Key"
+	E2EKeySignatureHeader = "E2E-Key-Signature"
+)
+
+// E2EOverhead is how much larger an encrypted chunk is than its data.
+const E2EOverhead = box.Overhead
+
+var errBadE2EKey = errors.New("natshttp: bad " + E2EKeyHeader + " header")
+
+// E2ESigned returns what E2EKeySignatureHeader signs, the requester's key
+// and ours, so our key can not be replayed to another requester.
+func E2ESigned(requester, server string) []byte {
+	return []byte(requester + "\n" + server)
+}
+
+// E2ENonce returns the nonce of chunk seq of an encrypted response,
+// counting from 1. Keys are never used for more than one response, so
+// nonces are never reused.
+func E2ENonce(seq uint64) *[24]byte {
+	var nonce [24]byte
+	binary.BigEndian.PutUint64(nonce[16:], seq)
+	return &nonce
+}
+
+// sealer encrypts the chunks of a response to the requester's key.
+type sealer struct {
+	shared [32]byte
+	// Our public key and its signature, sent back in the header message.
+	public    string
+	signature string
+	// Chunks sealed so far.
+	seq uint64
+}
+
+// newSealer returns a sealer for the requester's key, nil if none was
+// sent. Our key is signed with signer if not nil.
+func newSealer(key string, signer nkeys.KeyPair) (*sealer, error) {
+	if key == "" {
+		return nil, nil
+	}
+	peer, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(peer) != 32 {
+		return nil, errBadE2EKey
+	}
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	s := &sealer{public: base64.StdEncoding.EncodeToString(pub[:])}
+	if signer != nil {
+		sig, err := signer.Sign(E2ESigned(key, s.public))
+		if err != nil {
+			return nil, err
+		}
+		s.signature = base64.RawURLEncoding.EncodeToString(sig)
+	}
+	box.Precompute(&s.shared, (*[32]byte)(peer), priv)
+	return s, nil
+}
+
+// seal returns the next chunk encrypted.
+func (s *sealer) seal(data []byte) ([]byte, error) {
+	s.seq++
+	return box.SealAfterPrecomputation(nil, data, E2ENonce(s.seq), &s.shared), nil
+}
@@ -0,0 +1,31 @@
+package natshttp
+
+import (
+	"net/http"
+	"net/textproto"
+
+	"github.com/nats-io/nats.go"
+)
+
+// HTTPHeader converts NATS message headers, whose keys are case sensitive,
+// to an http.Header with canonical keys.
+func HTTPHeader(h nats.Header) http.Header {
+	if h == nil {
+		return http.Header{}
+	}
+	hh := make(http.Header, len(h))
+	for k, v := range h {
+		ck := textproto.CanonicalMIMEHeaderKey(k)
+		hh[ck] = append(hh[ck], v...)
+	}
+	return hh
+}
+
+// NATSHeader converts an http.Header to NATS message headers.
+func NATSHeader(h http.Header) nats.Header {
+	nh := make(nats.Header, len(h))
+	for k, v := range h {
+		nh[k] = append([]string(nil), v...)
+	}
+	return nh
+}
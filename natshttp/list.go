@@ -0,0 +1,38 @@
+package natshttp
+
+import (
+	"io/fs"
+	"time"
+)
+
+// A request with ListHeader asks for a JSON array of ListEntry rather than
+// the file, ListDir listing one level of a directory and ListRecursive the
+// whole tree below it. GlobHeader optionally filters entries by their path
+// relative to the request. Names are full request paths, so requesters can
+// fetch and mirror them directly.
+const (
+	ListHeader    = "List"
+	ListDir       = "dir"
+	ListRecursive = "recursive"
+	GlobHeader    = "Glob"
+)
+
+// ListEntry is an entry of a directory listing.
+type ListEntry struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	Mode    string    `json:"mode"`
+	ModTime time.Time `json:"mtime"`
+	IsDir   bool      `json:"dir,omitempty"`
+}
+
+// NewListEntry returns the entry for fi, named name.
+func NewListEntry(name string, fi fs.FileInfo) ListEntry {
+	return ListEntry{
+		Name:    name,
+		Size:    fi.Size(),
+		Mode:    fi.Mode().String(),
+		ModTime: fi.ModTime().UTC(),
+		IsDir:   fi.IsDir(),
+	}
+}
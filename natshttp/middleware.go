@@ -0,0 +1,36 @@
+package natshttp
+
+import (
+	"net/http"
+	"runtime/debug"
+)
+
+// Middleware wraps a handler, the same as for net/http.
+type Middleware func(http.Handler) http.Handler
+
+// Chain wraps h with the middleware, the first being the outermost.
+// Mount the result with both Handle and net/http so requests get the same
+// treatment whichever way they arrive.
+func Chain(h http.Handler, mw ...Middleware) http.Handler {
+	for i := len(mw) - 1; i >= 0; i-- {
+		h = mw[i](h)
+	}
+	return h
+}
+
+// Recover turns a panic in the handler into a 500 response instead of
+// taking down the process.
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if err := recover(); err != nil {
+				if err == http.ErrAbortHandler {
+					return
+				}
+				Logf(r, "Panic serving %s %s: %v\n%s", r.Method, r.URL.Path, err, debug.Stack())
+				http.Error(w, "500 internal server error", http.StatusInternalServerError)
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,292 @@
+// Package natshttp serves HTTP style requests over NATS.
+//
+// A request is a NATS message whose headers carry the HTTP headers plus
+// Method and URL, with the body as the payload. The response is a header
+// message carrying Status and the response headers, followed by the body in
+// chunks. Each chunk has a reply subject the requester acks on for flow
+// control, see Acker, and an empty message marks the end of the response. Its
+// headers carry any trailers.
+package natshttp
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/derekcollison/nats-fs/ratelimit"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+)
+
+// ProtocolVersion is the version of the wire protocol described above.
+const ProtocolVersion = 2
+
+// Options control how requests arriving over NATS are handled.
+type Options struct {
+	// Queue group shared with other replicas, empty for none.
+	Queue string
+
+	// Bandwidth limits in bytes per second, 0 is unlimited.
+	MaxRate            float64
+	MaxRatePerTransfer float64
+
+	// Per requester limits, 0 is unlimited. Requests over the limits
+	// are rejected with a 429.
+	RequesterRate         float64
+	RequesterMaxTransfers int
+
+	// Max concurrent handlers, 0 is unbounded. When all are busy requests
+	// wait in a queue of up to MaxQueued, or hold up the subscription if
+	// Block is set. Otherwise they are rejected with a 503.
+	MaxHandlers int
+	MaxQueued   int
+	Block       bool
+	// Counts requests being handled, queued and rejected when set.
+	HandlerStats *HandlerStats
+
+	// Size of the body chunks and how many bytes may be unacked before we
+	// wait, 0 for the defaults of 64KB (up to the max payload when
+	// copying from a reader) and 32MB.
+	ChunkSize  int
+	WindowSize int
+	// How long a transfer waits for acks with the window full before
+	// giving up on the requester, 0 for the default of 30s.
+	AckTimeout time.Duration
+
+	// Chunks read ahead of those being published when copying from a
+	// reader, so disk and network latency overlap, 0 for the default of
+	// 4 and negative for none. MaxReadAheadMemory bounds the buffers
+	// read into across the transfers on a subscription, 0 for 64MB,
+	// transfers over it go without reading ahead.
+	ReadAhead          int
+	MaxReadAheadMemory int64
+	// Counts chunks read ahead when set.
+	ReadAheadStats *ReadAheadStats
+
+	// Signs our keys for end to end encrypted responses, see E2EKeyHeader.
+	// Without it requesters can not tell our key from one swapped in.
+	E2ESigner nkeys.KeyPair
+}
+
+// natsHandler dispatches requests from a subscription to an http.Handler.
+type natsHandler struct {
+	nc             *nats.Conn
+	handler        http.Handler
+	globalLimit    *ratelimit.Bucket
+	transferRate   float64
+	requesters     *requesterLimits
+	pool           *handlerPool
+	stats          *HandlerStats
+	chunkSize      int
+	windowSize     int
+	ackTimeout     time.Duration
+	readAhead      int
+	readAheadMem   *readAheadBudget
+	readAheadStats *ReadAheadStats
+	queue          string
+	e2eSigner      nkeys.KeyPair
+	// Called with errors sending responses, nil logs them.
+	onError func(error)
+
+	// Responses in progress, so a Server can wait for or cancel them.
+	mu     sync.Mutex
+	active map[*nrw]context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// Handle serves requests arriving on subject with handler.
+func Handle(nc *nats.Conn, subject string, handler http.Handler, opts *Options) (*nats.Subscription, error) {
+	nh := newNatsHandler(nc, handler, opts)
+	return nc.QueueSubscribe(subject, nh.queue, nh.serveMsg)
+}
+
+func newNatsHandler(nc *nats.Conn, handler http.Handler, opts *Options) *natsHandler {
+	if opts == nil {
+		opts = &Options{}
+	}
+	readAhead := opts.ReadAhead
+	if readAhead == 0 {
+		readAhead = defaultReadAhead
+	} else if readAhead < 0 {
+		readAhead = 0
+	}
+	readAheadMem := opts.MaxReadAheadMemory
+	if readAheadMem <= 0 {
+		readAheadMem = defaultReadAheadMemory
+	}
+	stats := opts.HandlerStats
+	if stats == nil {
+		stats = &HandlerStats{}
+	}
+	readAheadStats := opts.ReadAheadStats
+	if readAheadStats == nil {
+		readAheadStats = &ReadAheadStats{}
+	}
+	return &natsHandler{
+		nc:             nc,
+		handler:        handler,
+		globalLimit:    ratelimit.New(opts.MaxRate),
+		transferRate:   opts.MaxRatePerTransfer,
+		requesters:     newRequesterLimits(opts.RequesterRate, opts.RequesterMaxTransfers),
+		pool:           newHandlerPool(opts.MaxHandlers, opts.MaxQueued, opts.Block, stats),
+		stats:          stats,
+		chunkSize:      opts.ChunkSize,
+		windowSize:     opts.WindowSize,
+		ackTimeout:     opts.AckTimeout,
+		readAhead:      readAhead,
+		readAheadMem:   &readAheadBudget{left: readAheadMem},
+		readAheadStats: readAheadStats,
+		queue:          opts.Queue,
+		e2eSigner:      opts.E2ESigner,
+		active:         make(map[*nrw]context.CancelFunc),
+	}
+}
+
+// HandleFunc serves requests arriving on subject with the handler function.
+func HandleFunc(nc *nats.Conn, subject string, handler func(http.ResponseWriter, *http.Request), opts *Options) (*nats.Subscription, error) {
+	return Handle(nc, subject, http.HandlerFunc(handler), opts)
+}
+
+// NewRequest creates an http.Request from a request message.
+func NewRequest(m *nats.Msg) (*http.Request, error) {
+	// Determine if HTTP request format. For now assume its not and construct one.
+	method := "GET"
+	if hm := m.Header.Get("Method"); hm != "" {
+		method = hm
+	}
+	path := m.Header.Get("URL")
+	if path == "" {
+		path = "/"
+	}
+	req, err := http.NewRequest(method, path, bytes.NewBuffer(m.Data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = HTTPHeader(m.Header)
+	return req.WithContext(context.WithValue(req.Context(), subjectKey{}, m.Subject)), nil
+}
+
+type subjectKey struct{}
+
+// Subject returns the NATS subject a request arrived on, empty if it did
+// not arrive over NATS.
+func Subject(r *http.Request) string {
+	subj, _ := r.Context().Value(subjectKey{}).(string)
+	return subj
+}
+
+func (nh *natsHandler) serveMsg(m *nats.Msg) {
+	w := &nrw{nc: nh.nc, reply: m.Reply, ctx: context.Background(), global: nh.globalLimit, limit: ratelimit.New(nh.transferRate), chunk: nh.chunkSize, window: nh.windowSize, ackTimeout: nh.ackTimeout, readAhead: nh.readAhead, readAheadMem: nh.readAheadMem, readAheadStats: nh.readAheadStats, onError: nh.onError}
+	nh.wg.Add(1)
+
+	req, err := NewRequest(m)
+	if err == nil {
+		w.sealer, err = newSealer(m.Header.Get(E2EKeyHeader), nh.e2eSigner)
+	}
+	if err != nil {
+		w.Header().Set(RequestIDHeader, newRequestID())
+		http.Error(w, "400 bad request", http.StatusBadRequest)
+		w.done()
+		nh.wg.Done()
+		return
+	}
+	req = withRequestID(w, req)
+	w.id = RequestIDOf(req)
+	version := negotiateVersion(req.Header)
+	if version == 0 {
+		w.Header().Set(VersionHeader, versionRange())
+		http.Error(w, "505 protocol version not supported, we speak "+versionRange(), http.StatusHTTPVersionNotSupported)
+		w.done()
+		nh.wg.Done()
+		return
+	}
+	w.Header().Set(VersionHeader, strconv.Itoa(version))
+	req = withVersion(req, version)
+	w.version = version
+	req, span := startSpan(m, req)
+	ctx, cancel := context.WithCancel(req.Context())
+	req = req.WithContext(ctx)
+	w.ctx, w.abort = ctx, cancel
+	w.duplex = version >= 2 && strings.EqualFold(m.Header.Get("Upgrade"), DuplexProtocol)
+	w.expectContinue = version >= 2 && strings.EqualFold(m.Header.Get("Expect"), "100-continue")
+	if body, length := streamBody(ctx, nh.nc, m, w.writeContinue); body != nil {
+		req.Body, req.ContentLength = body, length
+	}
+	nh.mu.Lock()
+	nh.active[w] = cancel
+	nh.mu.Unlock()
+	// Requesters may give up before the first chunk tells them where to.
+	// Flushed so the server has the subscription before the handler runs.
+	var csub *nats.Subscription
+	if version >= 2 && m.Reply != "" {
+		if csub, err = nh.nc.Subscribe(RequestCancelSubject(m.Reply), func(*nats.Msg) { w.requesterCanceled() }); err == nil {
+			err = nh.nc.Flush()
+		}
+		if err != nil {
+			Logf(req, "Error subscribing for cancels: %v", err)
+		}
+	}
+	hbDone := make(chan struct{})
+	if version >= 2 {
+		w.last = time.Now()
+		go w.heartbeats(hbDone)
+	}
+	finish := func() {
+		if csub != nil {
+			csub.Unsubscribe()
+		}
+		close(hbDone)
+		w.done()
+		endSpan(span, w.status)
+		cancel()
+		nh.mu.Lock()
+		delete(nh.active, w)
+		nh.mu.Unlock()
+		nh.wg.Done()
+	}
+
+	id := requesterOf(m)
+	if !nh.requesters.acquire(id) {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "429 too many requests", http.StatusTooManyRequests)
+		finish()
+		return
+	}
+
+	// Call into our handler.
+	pool := nh.pool
+	serve := func() {
+		nh.stats.InFlight.Add(1)
+		t := track(w, req, id, cancel)
+		nh.handler.ServeHTTP(w, req)
+		nh.stats.InFlight.Add(-1)
+		pool.release()
+		nh.requesters.release(id)
+		finish()
+		t.untrack()
+	}
+
+	switch {
+	case pool.tryAcquire():
+		go serve()
+	case pool.block:
+		// Hold up the subscription until a handler finishes.
+		pool.acquire()
+		go serve()
+	case pool.enqueue():
+		go func() {
+			pool.acquire()
+			pool.dequeue()
+			serve()
+		}()
+	default:
+		nh.requesters.release(id)
+		nh.stats.Rejected.Add(1)
+		http.Error(w, "503 service unavailable", http.StatusServiceUnavailable)
+		finish()
+	}
+}
@@ -0,0 +1,84 @@
+package natshttp_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"testing"
+
+	"github.com/derekcollison/nats-fs/client"
+	"github.com/derekcollison/nats-fs/natsfstest"
+	"github.com/derekcollison/nats-fs/natshttp"
+)
+
+// randomData returns n reproducible random bytes, which do not compress.
+func randomData(n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(int64(n))).Read(data)
+	return data
+}
+
+func TestServeFileLargerThanMaxPayload(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts *natshttp.Options
+	}{
+		{"read ahead", nil},
+		{"no read ahead", &natshttp.Options{ReadAhead: -1}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := natsfstest.NewServer(t, nil, tc.opts)
+			nc := srv.Connect(t)
+			data := randomData(3*int(nc.MaxPayload()) + 123)
+			srv.WriteFile(t, "big.bin", data)
+
+			var buf bytes.Buffer
+			st, err := client.New(nc).Get(context.Background(), srv.Subject, "/big.bin", &buf)
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			if st.StatusCode != http.StatusOK {
+				t.Fatalf("status %d, want 200", st.StatusCode)
+			}
+			if !bytes.Equal(buf.Bytes(), data) {
+				t.Fatalf("received %d bytes, want the %d served", buf.Len(), len(data))
+			}
+		})
+	}
+}
+
+func TestLargeWritesWithChunkSize(t *testing.T) {
+	var data []byte
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// One write of the whole body, chunked by the writer.
+		w.Write(data)
+	})
+	// Asking for chunks as large as ReadFrom allows must still leave
+	// room for the chunk headers.
+	srv := natsfstest.NewServer(t, h, &natshttp.Options{ChunkSize: 1024 * 1024})
+	nc := srv.Connect(t)
+	data = randomData(2*int(nc.MaxPayload()) + 1)
+
+	rc, _, err := client.New(nc).Open(context.Background(), srv.Subject, "/")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer rc.Close()
+	got, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("reading body: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("received %d bytes, want the %d written", len(got), len(data))
+	}
+}
+
+func TestMaxChunk(t *testing.T) {
+	srv := natsfstest.NewServer(t, nil)
+	nc := srv.Connect(t)
+	if got, want := natshttp.MaxChunk(nc), int(nc.MaxPayload())-natshttp.ChunkHeaderSize; got != want {
+		t.Fatalf("MaxChunk = %d, want %d", got, want)
+	}
+}
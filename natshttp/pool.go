@@ -0,0 +1,73 @@
+package natshttp
+
+import "sync/atomic"
+
+// HandlerStats counts requests arriving over NATS, see Options.HandlerStats.
+type HandlerStats struct {
+	// Requests being handled and waiting for a handler.
+	InFlight atomic.Int64
+	Queued   atomic.Int64
+	// Requests rejected as all handlers were busy and the queue full.
+	Rejected atomic.Int64
+}
+
+// handlerPool bounds the number of NATS request handlers running at once.
+// When full, requests either wait in a bounded queue or block the
+// subscription, and are rejected once the queue is full.
+// A nil pool is unbounded.
+type handlerPool struct {
+	slots    chan struct{}
+	maxQueue int64
+	queued   int64
+	block    bool
+	stats    *HandlerStats
+}
+
+func newHandlerPool(maxHandlers, maxQueue int, block bool, stats *HandlerStats) *handlerPool {
+	if maxHandlers <= 0 {
+		return nil
+	}
+	return &handlerPool{slots: make(chan struct{}, maxHandlers), maxQueue: int64(maxQueue), block: block, stats: stats}
+}
+
+// tryAcquire takes a slot if one is free.
+func (p *handlerPool) tryAcquire() bool {
+	if p == nil {
+		return true
+	}
+	select {
+	case p.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// acquire waits for a slot.
+func (p *handlerPool) acquire() {
+	if p != nil {
+		p.slots <- struct{}{}
+	}
+}
+
+func (p *handlerPool) release() {
+	if p != nil {
+		<-p.slots
+	}
+}
+
+// enqueue reserves a place in the queue, dequeue must be called once a
+// slot has been acquired.
+func (p *handlerPool) enqueue() bool {
+	if atomic.AddInt64(&p.queued, 1) > p.maxQueue {
+		atomic.AddInt64(&p.queued, -1)
+		return false
+	}
+	p.stats.Queued.Add(1)
+	return true
+}
+
+func (p *handlerPool) dequeue() {
+	atomic.AddInt64(&p.queued, -1)
+	p.stats.Queued.Add(-1)
+}
@@ -0,0 +1,47 @@
+package natshttp_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/derekcollison/nats-fs/client"
+	"github.com/derekcollison/nats-fs/natsfstest"
+	"github.com/derekcollison/nats-fs/natshttp"
+)
+
+func TestHandlerStats(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	// Never leave the handler blocked, even when failing.
+	defer close(release)
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	})
+	stats := &natshttp.HandlerStats{}
+	srv := natsfstest.NewServer(t, h, &natshttp.Options{MaxHandlers: 1, HandlerStats: stats})
+	c := client.New(srv.Connect(t))
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.Get(context.Background(), srv.Subject, "/", io.Discard)
+		done <- err
+	}()
+	<-started
+	if n := stats.InFlight.Load(); n != 1 {
+		t.Fatalf("%d handlers in flight, want 1", n)
+	}
+	// Rejected with the only handler busy and no queue.
+	st, err := c.Get(context.Background(), srv.Subject, "/", io.Discard)
+	if st == nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if st.StatusCode != http.StatusServiceUnavailable || stats.Rejected.Load() != 1 {
+		t.Fatalf("second request = %d with %d rejected, want 503 and 1", st.StatusCode, stats.Rejected.Load())
+	}
+	release <- struct{}{}
+	if err := <-done; err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+}
@@ -0,0 +1,154 @@
+package natshttp
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+const defaultReadAhead = 4
+
+// Default bound on the memory read-ahead buffers hold, shared by all
+// transfers on a subscription.
+const defaultReadAheadMemory = 64 * 1024 * 1024
+
+// ReadAheadStats counts chunks read ahead, see Options.ReadAheadStats.
+type ReadAheadStats struct {
+	// Bytes read and not yet published.
+	Bytes atomic.Int64
+	// Chunks already read when the publisher wanted them, and those it
+	// had to wait on the reader for.
+	Ready atomic.Int64
+	Waits atomic.Int64
+	// Transfers sent without reading ahead as the memory for it was in use.
+	Skipped atomic.Int64
+}
+
+// readAheadBudget bounds the buffers held by prefetchers.
+type readAheadBudget struct {
+	mu   sync.Mutex
+	left int64
+}
+
+// take reserves up to n buffers without waiting, returning how many it got.
+func (b *readAheadBudget) take(n int) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if avail := int(b.left / maxChunkSize); avail < n {
+		n = avail
+	}
+	b.left -= int64(n) * maxChunkSize
+	return n
+}
+
+func (b *readAheadBudget) give(n int) {
+	b.mu.Lock()
+	b.left += int64(n) * maxChunkSize
+	b.mu.Unlock()
+}
+
+type readChunk struct {
+	buf *[]byte
+	n   int
+	err error
+}
+
+// prefetcher reads chunks ahead of the publisher, up to depth of them, so
+// disk reads overlap with waiting on acks instead of following them.
+type prefetcher struct {
+	chunks  chan readChunk
+	free    chan *[]byte
+	done    chan struct{}
+	exited  chan struct{}
+	budget  *readAheadBudget
+	buffers int
+	stats   *ReadAheadStats
+}
+
+// newPrefetcher returns a prefetcher reading ahead as far as budget
+// allows, or nil if it does not allow for reading ahead at all.
+func newPrefetcher(r io.Reader, size, depth int, budget *readAheadBudget, stats *ReadAheadStats) *prefetcher {
+	n := budget.take(depth + 1)
+	if n < 2 {
+		budget.give(n)
+		stats.Skipped.Add(1)
+		return nil
+	}
+	p := &prefetcher{
+		chunks:  make(chan readChunk, n),
+		free:    make(chan *[]byte, n),
+		done:    make(chan struct{}),
+		exited:  make(chan struct{}),
+		budget:  budget,
+		buffers: n,
+		stats:   stats,
+	}
+	for i := 0; i < n; i++ {
+		p.free <- readPool.Get().(*[]byte)
+	}
+	go p.run(r, size)
+	return p
+}
+
+func (p *prefetcher) run(r io.Reader, size int) {
+	defer close(p.exited)
+	for {
+		var bp *[]byte
+		select {
+		case bp = <-p.free:
+		case <-p.done:
+			return
+		}
+		select {
+		case <-p.done:
+			readPool.Put(bp)
+			return
+		default:
+		}
+		n, err := io.ReadFull(r, (*bp)[:size])
+		p.stats.Bytes.Add(int64(n))
+		p.chunks <- readChunk{buf: bp, n: n, err: err}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// next returns the next chunk, which must be released once published.
+// l is unlocked while waiting on the reader.
+func (p *prefetcher) next(l sync.Locker) readChunk {
+	var c readChunk
+	select {
+	case c = <-p.chunks:
+		p.stats.Ready.Add(1)
+	default:
+		p.stats.Waits.Add(1)
+		l.Unlock()
+		c = <-p.chunks
+		l.Lock()
+	}
+	p.stats.Bytes.Add(-int64(c.n))
+	return c
+}
+
+func (p *prefetcher) release(c readChunk) {
+	p.free <- c.buf
+}
+
+// stop waits for the reader to finish and returns the buffers to the pool.
+func (p *prefetcher) stop() {
+	close(p.done)
+	<-p.exited
+	for {
+		select {
+		case c := <-p.chunks:
+			p.stats.Bytes.Add(-int64(c.n))
+			readPool.Put(c.buf)
+		case bp := <-p.free:
+			readPool.Put(bp)
+		default:
+			p.budget.give(p.buffers)
+			return
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package natshttp_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/derekcollison/nats-fs/client"
+	"github.com/derekcollison/nats-fs/natsfstest"
+	"github.com/derekcollison/nats-fs/natshttp"
+)
+
+func TestReadAheadMemory(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		memory  int64
+		skipped int64
+	}{
+		{"within memory", 0, 0},
+		{"over memory", 1024 * 1024, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			stats := &natshttp.ReadAheadStats{}
+			srv := natsfstest.NewServer(t, nil, &natshttp.Options{MaxReadAheadMemory: tc.memory, ReadAheadStats: stats})
+			nc := srv.Connect(t)
+			data := randomData(3*int(nc.MaxPayload()) + 123)
+			srv.WriteFile(t, "big.bin", data)
+
+			var buf bytes.Buffer
+			if _, err := client.New(nc).Get(context.Background(), srv.Subject, "/big.bin", &buf); err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			if !bytes.Equal(buf.Bytes(), data) {
+				t.Fatalf("received %d bytes, want the %d served", buf.Len(), len(data))
+			}
+			if n := stats.Skipped.Load(); n != tc.skipped {
+				t.Fatalf("%d transfers went without reading ahead, want %d", n, tc.skipped)
+			}
+			if n := stats.Ready.Load() + stats.Waits.Load(); (n == 0) != (tc.skipped > 0) {
+				t.Fatalf("%d chunks read ahead", n)
+			}
+			if n := stats.Bytes.Load(); n != 0 {
+				t.Fatalf("%d bytes read ahead left after the transfer", n)
+			}
+		})
+	}
+}
+
+// stuckReader returns what is sent on more, blocking until there is some,
+// and ends when it is closed.
+type stuckReader struct {
+	buf  []byte
+	more chan []byte
+}
+
+func (r *stuckReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		b, ok := <-r.more
+		if !ok {
+			return 0, io.EOF
+		}
+		r.buf = b
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func TestReadAheadWaitsUnlocked(t *testing.T) {
+	const chunk = 1024
+	more := make(chan []byte)
+	defer close(more)
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, &stuckReader{more: more})
+	})
+	srv := natsfstest.NewServer(t, h, &natshttp.Options{ChunkSize: chunk})
+	c := client.New(srv.Connect(t))
+	path := "/" + t.Name()
+	go c.Get(context.Background(), srv.Subject, path, io.Discard)
+
+	// Listing and canceling the transfer take the writer lock, which must
+	// not be held waiting on a stuck reader, for the next chunk or to stop.
+	within := func(what string, f func()) {
+		t.Helper()
+		done := make(chan struct{})
+		go func() {
+			f()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s held up by a stuck reader", what)
+		}
+	}
+	more <- randomData(chunk)
+	var list []natshttp.Transfer
+	within("Transfers", func() { list = transfersOf(path) })
+	if len(list) != 1 {
+		t.Fatalf("%d transfers listed, want 1", len(list))
+	}
+	within("Cancel", func() {
+		if !natshttp.Cancel(list[0].ID) {
+			t.Errorf("transfer %s not found", list[0].ID)
+		}
+	})
+	// The next chunk finds the transfer canceled, and the reader is
+	// stuck on the one after as it stops.
+	more <- randomData(chunk)
+	time.Sleep(50 * time.Millisecond)
+	within("Transfers", func() { transfersOf(path) })
+}
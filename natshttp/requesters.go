@@ -0,0 +1,128 @@
+package natshttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/derekcollison/nats-fs/ratelimit"
+	"github.com/nats-io/nats.go"
+)
+
+// Header the NATS server adds to requests reaching us through a service
+// import, saying who sent them.
+const requestInfoHeader = "Nats-Request-Info"
+
+// requestInfo is what we use of requestInfoHeader.
+type requestInfo struct {
+	Account string `json:"acc"`
+	User    string `json:"user"`
+	Server  string `json:"server"`
+	ID      uint64 `json:"id"`
+}
+
+// How long we remember an idle requester.
+const requesterIdle = time.Minute
+
+// Length of a nuid, the first part is fixed per process.
+const (
+	nuidLen       = 22
+	nuidPrefixLen = 12
+)
+
+// requesterLimits enforces per requester request rates and concurrent transfers.
+type requesterLimits struct {
+	sync.Mutex
+	rate      float64
+	maxActive int
+	reqs      map[string]*requester
+	pruned    time.Time
+}
+
+type requester struct {
+	limit  *ratelimit.Bucket
+	active int
+	last   time.Time
+}
+
+func newRequesterLimits(rate float64, maxActive int) *requesterLimits {
+	if rate <= 0 && maxActive <= 0 {
+		return nil
+	}
+	return &requesterLimits{rate: rate, maxActive: maxActive, reqs: make(map[string]*requester), pruned: time.Now()}
+}
+
+// requesterOf identifies who sent m. Requests through a service import
+// are told apart by the user, or the connection, the NATS server says sent
+// them, others by the prefix of their reply inbox, which is stable per
+// client process. A requester can change its inbox at will, so limits only
+// hold against untrusted requesters given their own accounts.
+func requesterOf(m *nats.Msg) string {
+	if v := m.Header.Get(requestInfoHeader); v != "" {
+		var ri requestInfo
+		if json.Unmarshal([]byte(v), &ri) == nil && ri.Account != "" {
+			if ri.User != "" {
+				return ri.Account + "/" + ri.User
+			}
+			return fmt.Sprintf("%s/%s/%d", ri.Account, ri.Server, ri.ID)
+		}
+	}
+	// Inboxes are _INBOX.<nuid>, and the nuid prefix is stable per client.
+	tokens := strings.Split(m.Reply, ".")
+	if len(tokens) < 2 {
+		return m.Reply
+	}
+	if len(tokens[1]) == nuidLen {
+		tokens[1] = tokens[1][:nuidPrefixLen]
+	}
+	return tokens[0] + "." + tokens[1]
+}
+
+// acquire reports whether the requester may start another transfer.
+// If so release must be called when it is done.
+func (rl *requesterLimits) acquire(id string) bool {
+	if rl == nil {
+		return true
+	}
+	rl.Lock()
+	defer rl.Unlock()
+
+	now := time.Now()
+	if now.Sub(rl.pruned) > requesterIdle {
+		for k, r := range rl.reqs {
+			if r.active == 0 && now.Sub(r.last) > requesterIdle {
+				delete(rl.reqs, k)
+			}
+		}
+		rl.pruned = now
+	}
+
+	r := rl.reqs[id]
+	if r == nil {
+		r = &requester{limit: ratelimit.New(rl.rate)}
+		rl.reqs[id] = r
+	}
+	r.last = now
+	if rl.maxActive > 0 && r.active >= rl.maxActive {
+		return false
+	}
+	if !r.limit.Allow(1) {
+		return false
+	}
+	r.active++
+	return true
+}
+
+func (rl *requesterLimits) release(id string) {
+	if rl == nil {
+		return
+	}
+	rl.Lock()
+	if r := rl.reqs[id]; r != nil {
+		r.active--
+		r.last = time.Now()
+	}
+	rl.Unlock()
+}
@@ -0,0 +1,36 @@
+package natshttp_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/derekcollison/nats-fs/client"
+	"github.com/derekcollison/nats-fs/natsfstest"
+	"github.com/derekcollison/nats-fs/natshttp"
+)
+
+func TestRequesterLimitIgnoresHeaders(t *testing.T) {
+	started, release := make(chan struct{}, 2), make(chan struct{})
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	})
+	srv := natsfstest.NewServer(t, h, &natshttp.Options{RequesterMaxTransfers: 1})
+	nc := srv.Connect(t)
+	defer close(release)
+
+	go client.New(nc).Get(context.Background(), srv.Subject, "/", io.Discard)
+	<-started
+	// Claiming to be someone else does not get around the limit.
+	c := client.New(nc)
+	c.Header = http.Header{"Requester-Id": {"someone-else"}}
+	st, err := c.Get(context.Background(), srv.Subject, "/", io.Discard)
+	if st == nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if st.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("second transfer = %d, want 429", st.StatusCode)
+	}
+}
@@ -0,0 +1,63 @@
+package natshttp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// Header carrying the request ID. One set by the requester is honored,
+// otherwise we generate one, and it is always echoed in the response.
+const RequestIDHeader = "X-Request-Id"
+
+// Longest requester supplied ID we will honor.
+const maxRequestIDLen = 128
+
+type requestIDKey struct{}
+
+// RequestID assigns each request an ID, available with RequestIDOf and
+// prefixed to log lines from Logf. Requests arriving over NATS already have
+// one, this is for those arriving over net/http.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, withRequestID(w, r))
+	})
+}
+
+// RequestIDOf returns the ID of a request, empty if it has none.
+func RequestIDOf(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// Logf logs with the request ID prefixed.
+func Logf(r *http.Request, format string, args ...interface{}) {
+	if id := RequestIDOf(r); id != "" {
+		format = "[" + id + "] " + format
+	}
+	log.Printf(format, args...)
+}
+
+func withRequestID(w http.ResponseWriter, r *http.Request) *http.Request {
+	if RequestIDOf(r) != "" {
+		return r
+	}
+	id := r.Header.Get(RequestIDHeader)
+	if id == "" || len(id) > maxRequestIDLen {
+		id = newRequestID()
+		r.Header.Set(RequestIDHeader, id)
+	}
+	w.Header().Set(RequestIDHeader, id)
+	return r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+}
+
+func newRequestID() string {
+	var b [12]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(fmt.Sprintf("natshttp: reading random request ID: %v", err))
+	}
+	return hex.EncodeToString(b[:])
+}
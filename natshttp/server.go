@@ -0,0 +1,170 @@
+package natshttp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// ErrServerClosed is returned by ListenAndServe after Shutdown or Close.
+var ErrServerClosed = errors.New("natshttp: Server closed")
+
+// How often Shutdown checks whether the subscription has drained.
+const shutdownPollInterval = 10 * time.Millisecond
+
+// Server serves requests arriving on a subject, with lifecycle control
+// along the lines of http.Server.
+type Server struct {
+	Conn    *nats.Conn
+	Subject string
+	Handler http.Handler
+	Options *Options
+
+	// ErrorHandler is called with errors publishing responses and
+	// unsubscribing, nil logs them.
+	ErrorHandler func(error)
+
+	mu          sync.Mutex
+	nh          *natsHandler
+	sub         *nats.Subscription
+	done        chan struct{}
+	closed      bool
+	noKeepAlive atomic.Bool
+}
+
+// ListenAndServeNATS serves requests arriving on subject with handler. It
+// blocks, and only returns an error if the subscription fails.
+func ListenAndServeNATS(nc *nats.Conn, subject string, handler http.Handler) error {
+	s := &Server{Conn: nc, Subject: subject, Handler: handler}
+	return s.ListenAndServe()
+}
+
+// ListenAndServe subscribes to the subject and serves requests until
+// Shutdown or Close, then returns ErrServerClosed.
+func (s *Server) ListenAndServe() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	if s.sub != nil {
+		s.mu.Unlock()
+		return errors.New("natshttp: Server already serving")
+	}
+	handler := s.Handler
+	if handler == nil {
+		handler = http.DefaultServeMux
+	}
+	s.nh = newNatsHandler(s.Conn, handler, s.Options)
+	s.nh.onError = s.ErrorHandler
+	sub, err := s.Conn.QueueSubscribe(s.Subject, s.nh.queue, s.nh.serveMsg)
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	s.sub = sub
+	done := s.doneChan()
+	s.mu.Unlock()
+
+	<-done
+	return ErrServerClosed
+}
+
+// Shutdown stops taking new requests and waits for those in progress to
+// finish, or for ctx to be done, in which case its error is returned and
+// the remaining transfers carry on.
+func (s *Server) Shutdown(ctx context.Context) error {
+	nh, sub, err := s.stop()
+	if nh != nil {
+		// Requests already delivered are still handed to serveMsg until
+		// the drain completes, so only then are all transfers counted.
+		t := time.NewTicker(shutdownPollInterval)
+		defer t.Stop()
+		for sub.IsValid() {
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		finished := make(chan struct{})
+		go func() {
+			nh.wg.Wait()
+			close(finished)
+		}()
+		select {
+		case <-finished:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if s.noKeepAlive.Load() {
+		if derr := s.Conn.Drain(); err == nil {
+			err = derr
+		}
+	}
+	return err
+}
+
+// Close stops taking new requests and cancels those in progress, their
+// requesters see the responses end early.
+func (s *Server) Close() error {
+	nh, _, err := s.stop()
+	if nh != nil {
+		nh.mu.Lock()
+		for w, cancel := range nh.active {
+			w.Lock()
+			w.canceled = true
+			w.Unlock()
+			cancel()
+		}
+		nh.mu.Unlock()
+	}
+	return err
+}
+
+// SetKeepAlivesEnabled controls whether the connection is kept open after
+// Shutdown, the default. Over NATS requesters do not hold connections of
+// their own, so disabling keep-alives only means the server's connection
+// is drained and closed once Shutdown has waited for the transfers.
+func (s *Server) SetKeepAlivesEnabled(v bool) {
+	s.noKeepAlive.Store(!v)
+}
+
+// stop drains the subscription and wakes ListenAndServe, returning the
+// handler and subscription if we were serving.
+func (s *Server) stop() (*natsHandler, *nats.Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sub == nil {
+		if !s.closed {
+			s.closed = true
+			close(s.doneChan())
+		}
+		return nil, nil, nil
+	}
+	if s.closed {
+		return s.nh, s.sub, nil
+	}
+	s.closed = true
+	close(s.doneChan())
+	// Drain lets requests already delivered be handled.
+	err := s.sub.Drain()
+	if err != nil && s.ErrorHandler != nil {
+		s.ErrorHandler(err)
+	}
+	return s.nh, s.sub, err
+}
+
+// Lock should be held.
+func (s *Server) doneChan() chan struct{} {
+	if s.done == nil {
+		s.done = make(chan struct{})
+	}
+	return s.done
+}
@@ -0,0 +1,94 @@
+package natshttp_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/derekcollison/nats-fs/client"
+	"github.com/derekcollison/nats-fs/natsfstest"
+	"github.com/derekcollison/nats-fs/natshttp"
+	"github.com/nats-io/nats.go"
+)
+
+// retryingClient returns a client on srv retrying until the Server under
+// test has subscribed.
+func retryingClient(t *testing.T, srv *natsfstest.Server) *client.Client {
+	c := client.New(srv.Connect(t))
+	c.Retry = func(attempt int, req *nats.Msg, err error) bool {
+		time.Sleep(10 * time.Millisecond)
+		return attempt < 100
+	}
+	return c
+}
+
+func TestShutdownWaitsForRequests(t *testing.T) {
+	srv := natsfstest.NewServer(t, http.NotFoundHandler())
+	started, release := make(chan struct{}), make(chan struct{})
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		io.WriteString(w, "finished")
+	})
+	s := &natshttp.Server{Conn: srv.Connect(t), Subject: "shutdown", Handler: h}
+	served := make(chan error, 1)
+	go func() { served <- s.ListenAndServe() }()
+
+	c := retryingClient(t, srv)
+	got := make(chan error, 1)
+	go func() {
+		var body []byte
+		resp, err := c.Do(context.Background(), c.NewRequest("shutdown", "/"))
+		if err == nil {
+			body, err = io.ReadAll(resp.Body)
+			resp.Body.Close()
+		}
+		if err == nil && string(body) != "finished" {
+			err = io.ErrUnexpectedEOF
+		}
+		got <- err
+	}()
+	<-started
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- s.Shutdown(context.Background()) }()
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Shutdown returned %v with a request in progress", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	if err := <-shutdown; err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if err := <-got; err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	if err := <-served; err != natshttp.ErrServerClosed {
+		t.Fatalf("ListenAndServe = %v, want ErrServerClosed", err)
+	}
+}
+
+func TestShutdownContextDone(t *testing.T) {
+	srv := natsfstest.NewServer(t, http.NotFoundHandler())
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+	s := &natshttp.Server{Conn: srv.Connect(t), Subject: "blocked", Handler: h}
+	go s.ListenAndServe()
+
+	c := retryingClient(t, srv)
+	go c.Do(context.Background(), c.NewRequest("blocked", "/"))
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Shutdown = %v, want DeadlineExceeded", err)
+	}
+}
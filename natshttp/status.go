@@ -0,0 +1,122 @@
+package natshttp
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// From protocol version 2 the header message follows the NATS status
+// convention used by JetStream and the nats CLI, a bare code in Status and
+// the text in Description, and idle heartbeats keep requesters waiting on
+// a slow handler or a stalled transfer from timing out. A 503 keeps its
+// text in Status, nats.go reporting a bare one as no responders. Version 1
+// has the code and text together in Status and no heartbeats.
+const (
+	DescriptionHeader = "Description"
+	// Interim messages, neither carrying any part of the response.
+	interimStatus = "100"
+	heartbeatDesc = "Idle Heartbeat"
+	continueDesc  = "Continue"
+)
+
+// HeartbeatInterval is how long a response can go quiet before we send an
+// idle heartbeat.
+var HeartbeatInterval = 5 * time.Second
+
+// IsHeartbeat reports whether msg is an idle heartbeat, which carries no
+// part of the response and should be skipped.
+func IsHeartbeat(msg *nats.Msg) bool {
+	return msg.Header.Get("Status") == interimStatus && msg.Header.Get(DescriptionHeader) == heartbeatDesc
+}
+
+// IsContinue reports whether msg is the interim 100 Continue sent to a
+// requester that asked for it with Expect, once the handler accepted the
+// request. It carries no part of the response.
+func IsContinue(msg *nats.Msg) bool {
+	return msg.Header.Get("Status") == interimStatus && msg.Header.Get(DescriptionHeader) == continueDesc
+}
+
+// StatusLine returns the status of a header message as "404 Not Found",
+// whichever convention it follows.
+func StatusLine(msg *nats.Msg) string {
+	status := msg.Header.Get("Status")
+	if desc := msg.Header.Get(DescriptionHeader); desc != "" && !strings.Contains(status, " ") {
+		return status + " " + desc
+	}
+	return status
+}
+
+// heartbeats sends an idle heartbeat whenever the response has been quiet
+// for the interval, until done is closed.
+func (w *nrw) heartbeats(done <-chan struct{}) {
+	t := time.NewTicker(HeartbeatInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+		}
+		w.Lock()
+		select {
+		case <-done:
+			// Never after the end of the response.
+			w.Unlock()
+			return
+		default:
+		}
+		if !w.ended && time.Since(w.last) >= HeartbeatInterval {
+			hb := nats.NewMsg(w.reply)
+			hb.Header.Set("Status", interimStatus)
+			hb.Header.Set(DescriptionHeader, heartbeatDesc)
+			w.nc.PublishMsg(hb)
+			w.last = time.Now()
+		}
+		w.Unlock()
+	}
+}
+
+// writeContinue tells a requester that sent Expect: 100-continue the
+// handler accepted the request, once, unless the response has already
+// started. It takes only contMu, as the body reader calls it before the
+// first pull while ReadFrom may hold the writer lock.
+func (w *nrw) writeContinue() {
+	w.contMu.Lock()
+	defer w.contMu.Unlock()
+	if !w.expectContinue || w.continued {
+		return
+	}
+	w.continued = true
+	msg := nats.NewMsg(w.reply)
+	msg.Header.Set("Status", interimStatus)
+	msg.Header.Set(DescriptionHeader, continueDesc)
+	w.nc.PublishMsg(msg)
+}
+
+// noContinue stops any 100 Continue going out after the final status.
+func (w *nrw) noContinue() {
+	w.contMu.Lock()
+	w.continued = true
+	w.contMu.Unlock()
+}
+
+// setStatus sets the status headers for the protocol version.
+// Lock should be held.
+func (w *nrw) setStatus(code int) {
+	h := w.Header()
+	if w.version < 2 {
+		h.Set("Status", strconv.Itoa(code)+" "+http.StatusText(code))
+		return
+	}
+	status := strconv.Itoa(code)
+	if code == http.StatusServiceUnavailable {
+		// nats.go takes a header message with a bare 503 for no responders.
+		status += " " + http.StatusText(code)
+	}
+	h.Set("Status", status)
+	h.Set(DescriptionHeader, http.StatusText(code))
+}
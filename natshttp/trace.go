@@ -0,0 +1,40 @@
+package natshttp
+
+import (
+	"net/http"
+
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Spans go to the global tracer provider, a no-op unless the program
+// sets one up. It is looked up per span, as a provider set again after
+// the first would not reach a tracer kept from before.
+const tracerName = "github.com/derekcollison/nats-fs/natshttp"
+
+// startSpan continues any trace carried in the request headers, e.g. a W3C
+// traceparent, and starts the span covering the whole response.
+func startSpan(m *nats.Msg, r *http.Request) (*http.Request, trace.Span) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := otel.Tracer(tracerName).Start(ctx, r.Method, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+		attribute.String("http.request.method", r.Method),
+		attribute.String("url.path", r.URL.Path),
+		attribute.String("messaging.system", "nats"),
+		attribute.String("messaging.destination.name", m.Subject),
+		attribute.String("http.request.id", RequestIDOf(r)),
+	))
+	return r.WithContext(ctx), span
+}
+
+// endSpan records the response status and ends the span.
+func endSpan(span trace.Span, status int) {
+	span.SetAttributes(attribute.Int("http.response.status_code", status))
+	if status >= 500 {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+	span.End()
+}
@@ -0,0 +1,49 @@
+package natshttp_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/derekcollison/nats-fs/client"
+	"github.com/derekcollison/nats-fs/natsfstest"
+	"github.com/derekcollison/nats-fs/natshttp"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestOneSpanPerTransfer(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+	otel.SetTracerProvider(tp)
+	defer tp.Shutdown(context.Background())
+
+	const chunk = 1024
+	srv := natsfstest.NewServer(t, nil, &natshttp.Options{ChunkSize: chunk})
+	data := randomData(4 * chunk)
+	srv.WriteFile(t, "a.bin", data)
+	var buf bytes.Buffer
+	if _, err := client.New(srv.Connect(t)).Get(context.Background(), srv.Subject, "/a.bin", &buf); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	// The span ends just after the end of the response is sent.
+	spans := sr.Ended()
+	for deadline := time.Now().Add(time.Second); len(spans) == 0 && time.Now().Before(deadline); spans = sr.Ended() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(spans) != 1 {
+		t.Fatalf("%d spans, want one for the transfer", len(spans))
+	}
+	var chunks int
+	for _, ev := range spans[0].Events() {
+		if ev.Name == "chunk" {
+			chunks++
+		}
+	}
+	if chunks < 4 {
+		t.Fatalf("%d chunk events, want one per chunk", chunks)
+	}
+}
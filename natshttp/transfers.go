@@ -0,0 +1,104 @@
+package natshttp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Transfer describes a response being sent to a NATS requester. The ID is
+// ours, the one Cancel takes, while the request ID may be chosen by the
+// requester and so need not be unique.
+type Transfer struct {
+	ID        string    `json:"id"`
+	RequestID string    `json:"request_id"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Subject   string    `json:"subject"`
+	Requester string    `json:"requester"`
+	Start     time.Time `json:"start"`
+	Bytes     int64     `json:"bytes"`
+}
+
+// errCanceled is returned from writes once a transfer has been canceled.
+var errCanceled = errors.New("natshttp: transfer canceled")
+
+type transfer struct {
+	info   Transfer
+	w      *nrw
+	cancel context.CancelFunc
+}
+
+// Active transfers keyed by their ID.
+var transfers = struct {
+	sync.Mutex
+	m map[string]*transfer
+}{m: make(map[string]*transfer)}
+
+func track(w *nrw, r *http.Request, requester string, cancel context.CancelFunc) *transfer {
+	t := &transfer{
+		info: Transfer{
+			ID:        newRequestID(),
+			RequestID: RequestIDOf(r),
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			Subject:   Subject(r),
+			Requester: requester,
+			Start:     time.Now(),
+		},
+		w:      w,
+		cancel: cancel,
+	}
+	transfers.Lock()
+	transfers.m[t.info.ID] = t
+	transfers.Unlock()
+	return t
+}
+
+func (t *transfer) untrack() {
+	transfers.Lock()
+	delete(transfers.m, t.info.ID)
+	transfers.Unlock()
+	t.cancel()
+}
+
+// Transfers returns the transfers in progress, oldest first.
+func Transfers() []Transfer {
+	transfers.Lock()
+	list := make([]*transfer, 0, len(transfers.m))
+	for _, t := range transfers.m {
+		list = append(list, t)
+	}
+	transfers.Unlock()
+
+	infos := make([]Transfer, 0, len(list))
+	for _, t := range list {
+		info := t.info
+		t.w.Lock()
+		info.Bytes = t.w.sent
+		t.w.Unlock()
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Start.Before(infos[j].Start) })
+	return infos
+}
+
+// Cancel stops the transfer with the given ID, as listed by Transfers. The handler's
+// context is canceled and its writes fail, the requester sees the response
+// end early. It reports whether the transfer was found.
+func Cancel(id string) bool {
+	transfers.Lock()
+	t := transfers.m[id]
+	transfers.Unlock()
+	if t == nil {
+		return false
+	}
+	t.w.Lock()
+	t.w.canceled = true
+	t.w.Unlock()
+	t.cancel()
+	return true
+}
@@ -0,0 +1,74 @@
+package natshttp_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/derekcollison/nats-fs/client"
+	"github.com/derekcollison/nats-fs/natsfstest"
+	"github.com/derekcollison/nats-fs/natshttp"
+)
+
+func TestTransfersKeyedByOurID(t *testing.T) {
+	started := make(chan struct{}, 2)
+	canceled := make(chan struct{}, 2)
+	release := make(chan struct{})
+	defer close(release)
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		select {
+		case <-r.Context().Done():
+			canceled <- struct{}{}
+		case <-release:
+		}
+	})
+	srv := natsfstest.NewServer(t, h)
+	c := client.New(srv.Connect(t))
+	// Transfers are tracked across the package, other tests' may linger
+	// and ours must not outlive the test.
+	path := "/" + t.Name()
+	t.Cleanup(func() {
+		deadline := time.Now().Add(5 * time.Second)
+		for len(transfersOf(path)) > 0 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+	// Both requesters pick the same request ID.
+	c.Header = http.Header{natshttp.RequestIDHeader: {"same"}}
+	for i := 0; i < 2; i++ {
+		go c.Get(context.Background(), srv.Subject, path, io.Discard)
+	}
+	<-started
+	<-started
+
+	list := transfersOf(path)
+	if len(list) != 2 || list[0].ID == list[1].ID {
+		t.Fatalf("transfers %+v, want two with their own IDs", list)
+	}
+	if natshttp.Cancel("same") {
+		t.Fatal("canceled by the requester's ID")
+	}
+	if !natshttp.Cancel(list[0].ID) {
+		t.Fatalf("transfer %s not found", list[0].ID)
+	}
+	<-canceled
+	select {
+	case <-canceled:
+		t.Fatal("both transfers canceled")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// transfersOf returns the transfers in progress for path.
+func transfersOf(path string) []natshttp.Transfer {
+	var list []natshttp.Transfer
+	for _, tr := range natshttp.Transfers() {
+		if tr.Path == path {
+			list = append(list, tr)
+		}
+	}
+	return list
+}
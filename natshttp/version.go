@@ -0,0 +1,72 @@
+package natshttp
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// VersionHeader carries the protocol version. Requesters send the range of
+// versions they speak, as "MIN-MAX" or just "MAX" for 1 to MAX, and we
+// answer with the highest we both speak in every response. Requests
+// without it are taken to be version 1. If there is no version in common
+// the request is rejected with a 505 carrying the range we speak.
+const VersionHeader = "Nats-Fs-Version"
+
+// MinProtocolVersion is the oldest protocol version we still speak.
+const MinProtocolVersion = 1
+
+// versionRange returns the range of versions we speak, as sent on a 505.
+func versionRange() string {
+	if MinProtocolVersion == ProtocolVersion {
+		return strconv.Itoa(ProtocolVersion)
+	}
+	return strconv.Itoa(MinProtocolVersion) + "-" + strconv.Itoa(ProtocolVersion)
+}
+
+// ParseVersions parses a version header value into the range it covers.
+func ParseVersions(v string) (min, max int, ok bool) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 1, 1, true
+	}
+	lo, hi, isRange := strings.Cut(v, "-")
+	max, err := strconv.Atoi(strings.TrimSpace(hi))
+	if !isRange {
+		max, err = strconv.Atoi(strings.TrimSpace(lo))
+		lo = "1"
+	}
+	if err != nil {
+		return 0, 0, false
+	}
+	if min, err = strconv.Atoi(strings.TrimSpace(lo)); err != nil || min < 1 || max < min {
+		return 0, 0, false
+	}
+	return min, max, true
+}
+
+// negotiateVersion returns the highest version both sides speak, 0 if none.
+func negotiateVersion(h http.Header) int {
+	min, max, ok := ParseVersions(h.Get(VersionHeader))
+	if !ok || max < MinProtocolVersion || min > ProtocolVersion {
+		return 0
+	}
+	if max > ProtocolVersion {
+		max = ProtocolVersion
+	}
+	return max
+}
+
+type versionKey struct{}
+
+// Version returns the protocol version negotiated for a request, 0 if it
+// did not arrive over NATS.
+func Version(r *http.Request) int {
+	v, _ := r.Context().Value(versionKey{}).(int)
+	return v
+}
+
+func withVersion(r *http.Request, v int) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), versionKey{}, v))
+}
@@ -0,0 +1,646 @@
+package natshttp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/derekcollison/nats-fs/ratelimit"
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Our own response writer.
+type nrw struct {
+	sync.Mutex
+	reply   string
+	nc      *nats.Conn
+	hdr     *nats.Msg
+	inbox   string
+	asub    *nats.Subscription
+	acks    chan struct{}
+	index   int
+	pending int
+	limit   *ratelimit.Bucket
+	global  *ratelimit.Bucket
+	chunk   int
+	window  int
+	buf     *[]byte
+	ackLen  int
+	ackSubj string
+	// How long to wait for acks with the window full, 0 for the default.
+	ackTimeout time.Duration
+	// Request ID for log lines and context for spans.
+	id  string
+	ctx context.Context
+	// Exactly one header message is published per response.
+	wroteHeader bool
+	status      int
+	// Bytes of body published, and whether the transfer was canceled.
+	sent     int64
+	canceled bool
+	// Called with errors publishing the response, nil logs them.
+	onError func(error)
+	// Encrypts chunks end to end, nil if the requester did not ask.
+	sealer *sealer
+	// Negotiated protocol version, from 2 acks carry headers.
+	version int
+	// Chunks and bytes published, bytes acked, and the requester's window.
+	seq       int64
+	published int64
+	acked     int64
+	recvWin   int
+	// When we last published anything, for heartbeats.
+	last time.Time
+	// Chunks ReadFrom reads ahead of publishing, 0 for none, memory
+	// shared with other transfers to read them into, and their stats.
+	readAhead      int
+	readAheadMem   *readAheadBudget
+	readAheadStats *ReadAheadStats
+	// Whether the requester asked for a duplex session, and whether the
+	// end of the response has been sent.
+	duplex bool
+	ended  bool
+	// Whether the requester sent Expect: 100-continue, and whether it has
+	// been sent the 100 or the response started. contMu guards continued
+	// so the 100 can go out without the writer lock.
+	expectContinue bool
+	contMu         sync.Mutex
+	continued      bool
+	// Cancels the handler's context.
+	abort context.CancelFunc
+}
+
+func (w *nrw) logf(format string, args ...interface{}) {
+	if w.id != "" {
+		format = "[" + w.id + "] " + format
+	}
+	log.Printf(format, args...)
+}
+
+func (w *nrw) error(err error) {
+	if w.onError != nil {
+		w.onError(err)
+		return
+	}
+	w.logf("Error publishing response: %v", err)
+}
+
+func (w *nrw) Header() http.Header {
+	if w.hdr == nil {
+		w.hdr = nats.NewMsg(w.reply)
+	}
+	return http.Header(w.hdr.Header)
+}
+
+const defaultWindowSize = 32 * 1024 * 1024
+
+// How long we wait for acks with the window full before giving up on the
+// requester.
+const defaultAckTimeout = 30 * time.Second
+
+// errAckTimeout is returned from writes when the requester stops acking.
+var errAckTimeout = errors.New("natshttp: timed out waiting for acks")
+
+// Writes are coalesced into chunks of this size.
+const chunkSize = 64 * 1024
+
+// Pool of chunk buffers shared by all responses.
+var chunkPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, chunkSize)
+		return &b
+	},
+}
+
+// ReadFrom reads directly into chunks of up to this size, bounded by the max payload.
+const maxChunkSize = 1024 * 1024
+
+var readPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, maxChunkSize)
+		return &b
+	},
+}
+
+// ChunkHeaderSize is the most the headers of a version 2 chunk, its
+// sequence number and CRC, add to the payload. NATS counts them against
+// the max payload.
+const ChunkHeaderSize = len("NATS/1.0\r\n" + SeqHeader + ": 9223372036854775807\r\n" + CRCHeader + ": ffffffff\r\n\r\n")
+
+// MaxChunk returns the largest payload a version 2 chunk can carry on nc,
+// up to the 1MB ReadFrom reads at a time.
+func MaxChunk(nc *nats.Conn) int {
+	size := int(nc.MaxPayload()) - ChunkHeaderSize
+	if size <= 0 || size > maxChunkSize {
+		size = maxChunkSize
+	}
+	return size
+}
+
+// payloadSize is the most data a chunk can carry, leaving room for the
+// chunk headers and any end to end encryption.
+func (w *nrw) payloadSize() int {
+	size := int(w.nc.MaxPayload())
+	if w.version >= 2 {
+		size -= ChunkHeaderSize
+	}
+	if w.sealer != nil {
+		// Leave room for the box overhead.
+		size -= E2EOverhead
+	}
+	if size <= 0 || size > maxChunkSize {
+		size = maxChunkSize
+	}
+	return size
+}
+
+// chunkSize is the size writes are coalesced into.
+func (w *nrw) chunkSize() int {
+	size := chunkSize
+	if w.chunk > 0 && w.chunk <= maxChunkSize {
+		size = w.chunk
+	}
+	if max := w.payloadSize(); size > max {
+		size = max
+	}
+	return size
+}
+
+// requesterCanceled stops the response, the requester having given up on it.
+func (w *nrw) requesterCanceled() {
+	w.Lock()
+	if w.canceled {
+		w.Unlock()
+		return
+	}
+	w.canceled = true
+	abort := w.abort
+	w.Unlock()
+	w.signalAck()
+	if abort != nil {
+		abort()
+	}
+	w.logf("Transfer canceled by the requester")
+}
+
+// windowSize is how much we send before waiting for acks.
+func (w *nrw) windowSize() int {
+	size := defaultWindowSize
+	if w.window > 0 {
+		size = w.window
+	}
+	if w.recvWin > 0 && w.recvWin < size {
+		size = w.recvWin
+	}
+	return size
+}
+
+func (w *nrw) processFlowAck(m *nats.Msg) {
+	if strings.HasSuffix(m.Subject, "."+CancelToken) {
+		w.requesterCanceled()
+		return
+	}
+	if v := m.Header.Get(AckedHeader); v != "" {
+		acked, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			log.Printf("Bad ack %s %q", AckedHeader, v)
+			return
+		}
+		win, _ := strconv.Atoi(m.Header.Get(WindowHeader))
+		w.Lock()
+		// Acks are cumulative, late ones are stale.
+		if acked > w.acked {
+			w.acked = acked
+			w.pending = int(w.published - acked)
+		}
+		if win > 0 {
+			w.recvWin = win
+		}
+		w.Unlock()
+		w.signalAck()
+		return
+	}
+	// Version 1, last token of the subject is chunk size.
+	i := strings.LastIndexByte(m.Subject, '.')
+	if i < 0 {
+		log.Printf("Bad ack subject %q", m.Subject)
+		return
+	}
+	acked, err := strconv.Atoi(m.Subject[i+1:])
+	if err != nil {
+		log.Printf("Bad ack subject %q", m.Subject)
+		return
+	}
+	w.Lock()
+	w.pending -= acked
+	w.Unlock()
+	w.signalAck()
+}
+
+// signalAck wakes a publish waiting for acks.
+func (w *nrw) signalAck() {
+	select {
+	case w.acks <- struct{}{}:
+	default:
+	}
+}
+
+func (w *nrw) Write(data []byte) (int, error) {
+	w.Lock()
+	defer w.Unlock()
+
+	w.implicitHeader(data)
+
+	written := 0
+	for len(data) > 0 {
+		// Large writes with nothing buffered go straight out.
+		size := w.chunkSize()
+		if w.buf == nil && len(data) >= size {
+			if err := w.publish(data[:size]); err != nil {
+				return written, err
+			}
+			data, written = data[size:], written+size
+			continue
+		}
+		if w.buf == nil {
+			if size == chunkSize {
+				w.buf = chunkPool.Get().(*[]byte)
+			} else {
+				b := make([]byte, 0, size)
+				w.buf = &b
+			}
+		}
+		b := *w.buf
+		n := copy(b[len(b):cap(b)], data)
+		*w.buf = b[:len(b)+n]
+		data, written = data[n:], written+n
+		if len(*w.buf) == cap(*w.buf) {
+			if err := w.flushBuffer(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// ReadFrom implements io.ReaderFrom, which io.Copy and so http.ServeFile
+// will use. Data is read straight into chunks as large as the max payload
+// allows and published without the intermediate copy into our write buffer.
+func (w *nrw) ReadFrom(r io.Reader) (int64, error) {
+	w.Lock()
+	defer w.Unlock()
+
+	// Anything already written goes first.
+	if err := w.flushBuffer(); err != nil {
+		return 0, err
+	}
+
+	// Without a header written we need the first chunk to sniff the content type.
+	sniff := !w.wroteHeader
+
+	size := w.payloadSize()
+	if w.chunk > 0 && w.chunk < size {
+		size = w.chunk
+	}
+	if w.readAhead > 0 {
+		return w.readAheadFrom(r, size, sniff)
+	}
+	return w.readFrom(r, size, sniff)
+}
+
+// readFrom is ReadFrom reading one chunk at a time.
+// Lock should be held.
+func (w *nrw) readFrom(r io.Reader, size int, sniff bool) (int64, error) {
+	bp := readPool.Get().(*[]byte)
+	defer readPool.Put(bp)
+	buf := (*bp)[:size]
+
+	var total int64
+	for {
+		n, err := io.ReadFull(r, buf)
+		if sniff {
+			w.implicitHeader(buf[:n])
+			sniff = false
+		}
+		if n > 0 {
+			if perr := w.publish(buf[:n]); perr != nil {
+				return total, perr
+			}
+			total += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+var _ io.ReaderFrom = (*nrw)(nil)
+
+// readAheadFrom is ReadFrom with the next chunks read while the current
+// one is published.
+// Lock should be held.
+func (w *nrw) readAheadFrom(r io.Reader, size int, sniff bool) (int64, error) {
+	p := newPrefetcher(r, size, w.readAhead, w.readAheadMem, w.readAheadStats)
+	if p == nil {
+		return w.readFrom(r, size, sniff)
+	}
+	// Waiting on the reader, which may be mid read, must not hold up
+	// cancels, heartbeats and Transfers.
+	defer func() {
+		w.Unlock()
+		p.stop()
+		w.Lock()
+	}()
+
+	var total int64
+	for {
+		c := p.next(w)
+		buf := (*c.buf)[:c.n]
+		if sniff {
+			w.implicitHeader(buf)
+			sniff = false
+		}
+		if c.n > 0 {
+			if perr := w.publish(buf); perr != nil {
+				p.release(c)
+				return total, perr
+			}
+			total += int64(c.n)
+		}
+		p.release(c)
+		if c.err == io.EOF || c.err == io.ErrUnexpectedEOF {
+			return total, nil
+		}
+		if c.err != nil {
+			return total, c.err
+		}
+	}
+}
+
+// flushBuffer publishes anything buffered and returns the buffer to the pool.
+// Lock should be held.
+func (w *nrw) flushBuffer() error {
+	if w.buf == nil {
+		return nil
+	}
+	var err error
+	if len(*w.buf) > 0 {
+		err = w.publish(*w.buf)
+	}
+	*w.buf = (*w.buf)[:0]
+	if cap(*w.buf) == chunkSize {
+		chunkPool.Put(w.buf)
+	}
+	w.buf = nil
+	return err
+}
+
+// publish sends a single chunk, subject to bandwidth limits and flow control.
+// Lock should be held.
+func (w *nrw) publish(data []byte) error {
+	if w.canceled {
+		return errCanceled
+	}
+	if w.acks == nil {
+		w.inbox = nats.NewInbox()
+		w.asub, _ = w.nc.Subscribe(w.inbox+".*", w.processFlowAck)
+		w.acks = make(chan struct{}, 1)
+	}
+
+	// Chunks are events on the span of the whole transfer.
+	span := trace.SpanFromContext(w.ctx)
+
+	// Bandwidth limits, unlock if we are held up.
+	w.Unlock()
+	w.global.Wait(len(data))
+	w.limit.Wait(len(data))
+	w.Lock()
+
+	if err := w.waitForWindow(len(data), span); err != nil {
+		return err
+	}
+	payload := data
+	if w.sealer != nil {
+		var err error
+		if payload, err = w.sealer.seal(data); err != nil {
+			return err
+		}
+	}
+	w.last = time.Now()
+	if err := w.publishChunk(payload); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	span.AddEvent("chunk", trace.WithAttributes(attribute.Int("messaging.message.body.size", len(data))))
+	w.pending += len(payload)
+	w.published += int64(len(payload))
+	w.sent += int64(len(data))
+	return nil
+}
+
+// waitForWindow waits until n more bytes fit in the window, or nothing is
+// unacked. It gives up when the transfer is canceled or no acks arrive
+// for the ack timeout.
+// Lock should be held.
+func (w *nrw) waitForWindow(n int, span trace.Span) error {
+	if w.canceled {
+		return errCanceled
+	}
+	if w.pending == 0 || w.pending+n <= w.windowSize() {
+		return nil
+	}
+	span.AddEvent("flow control stall", trace.WithAttributes(attribute.Int("pending", w.pending)))
+	timer := time.NewTimer(w.ackWait())
+	defer timer.Stop()
+	for w.pending > 0 && w.pending+n > w.windowSize() {
+		// Unlock while we are held up.
+		acks := w.acks
+		w.Unlock()
+		select {
+		case <-acks:
+		case <-w.ctx.Done():
+			w.Lock()
+			return errCanceled
+		case <-timer.C:
+			w.Lock()
+			return errAckTimeout
+		}
+		w.Lock()
+		if w.canceled {
+			return errCanceled
+		}
+		timer.Reset(w.ackWait())
+	}
+	return nil
+}
+
+// ackWait is how long we wait for an ack with the window full.
+func (w *nrw) ackWait() time.Duration {
+	if w.ackTimeout > 0 {
+		return w.ackTimeout
+	}
+	return defaultAckTimeout
+}
+
+// publishChunk publishes a chunk with the ack subject and headers of the
+// protocol version.
+// Lock should be held.
+func (w *nrw) publishChunk(payload []byte) error {
+	if w.version < 2 {
+		return w.nc.PublishRequest(w.reply, w.ackSubject(len(payload)), payload)
+	}
+	w.seq++
+	msg := nats.NewMsg(w.reply)
+	msg.Reply = w.inbox + ".ack"
+	msg.Header.Set(SeqHeader, strconv.FormatInt(w.seq, 10))
+	msg.Header.Set(CRCHeader, chunkCRC(payload))
+	msg.Data = payload
+	return w.nc.PublishMsg(msg)
+}
+
+// ackSubject returns the ack subject for a chunk, chunks are mostly the
+// same size so we keep the last one.
+// Lock should be held.
+func (w *nrw) ackSubject(n int) string {
+	if n != w.ackLen || w.ackSubj == "" {
+		w.ackLen = n
+		w.ackSubj = w.inbox + "." + strconv.Itoa(n)
+	}
+	return w.ackSubj
+}
+
+func (w *nrw) WriteHeader(statusCode int) {
+	w.Lock()
+	defer w.Unlock()
+	if statusCode == http.StatusContinue {
+		// Interim, the handler accepts the request and wants the body.
+		w.writeContinue()
+		return
+	}
+	w.writeHeader(statusCode)
+}
+
+// writeHeader publishes the header message. Like net/http, any call after the
+// header has been written, explicitly or by a Write, is ignored.
+// Lock should be held.
+func (w *nrw) writeHeader(statusCode int) {
+	if w.wroteHeader {
+		w.logf("Superfluous WriteHeader call with status %d", statusCode)
+		return
+	}
+	w.wroteHeader = true
+	w.noContinue()
+	w.status = statusCode
+	w.setStatus(statusCode)
+	if w.sealer != nil {
+		w.Header().Set(E2EKeyHeader, w.sealer.public)
+		if w.sealer.signature != "" {
+			w.Header().Set(E2EKeySignatureHeader, w.sealer.signature)
+		}
+	}
+	hdr := w.hdr
+	for k := range w.hdr.Header {
+		if strings.HasPrefix(k, http.TrailerPrefix) {
+			// Trailers go on the end message, not this one.
+			hdr = nats.NewMsg(w.reply)
+			for k, v := range w.hdr.Header {
+				if !strings.HasPrefix(k, http.TrailerPrefix) {
+					hdr.Header[k] = v
+				}
+			}
+			break
+		}
+	}
+	w.nc.PublishMsg(hdr)
+	w.last = time.Now()
+}
+
+// trailer returns the end message, carrying any trailers the handler set.
+// Like net/http these are headers declared in a Trailer header or set with
+// http.TrailerPrefix.
+// Lock should be held.
+func (w *nrw) trailer() *nats.Msg {
+	end := nats.NewMsg(w.reply)
+	h := w.Header()
+	for _, v := range h["Trailer"] {
+		for _, k := range strings.Split(v, ",") {
+			k = http.CanonicalHeaderKey(strings.TrimSpace(k))
+			if vv, ok := h[k]; ok && k != "" {
+				end.Header[k] = vv
+			}
+		}
+	}
+	for k, vv := range h {
+		if strings.HasPrefix(k, http.TrailerPrefix) {
+			end.Header[http.CanonicalHeaderKey(k[len(http.TrailerPrefix):])] = vv
+		}
+	}
+	return end
+}
+
+// implicitHeader writes a 200 header if the handler has not written one,
+// sniffing the content type from data if it was not set.
+// Lock should be held.
+func (w *nrw) implicitHeader(data []byte) {
+	if w.wroteHeader {
+		return
+	}
+	h := w.Header()
+	if _, ok := h["Content-Type"]; !ok && h.Get("Content-Encoding") == "" && len(data) > 0 {
+		h.Set("Content-Type", http.DetectContentType(data))
+	}
+	w.writeHeader(http.StatusOK)
+}
+
+// Flush implements http.Flusher. Writes are coalesced into chunks and
+// only published once a chunk is full or the handler returns, Flush
+// publishes whatever is buffered right away as a shorter chunk.
+// Streaming handlers should call it after each event they want delivered.
+func (w *nrw) Flush() {
+	w.Lock()
+	defer w.Unlock()
+	w.implicitHeader(nil)
+	if err := w.flushBuffer(); err != nil {
+		w.error(err)
+	}
+}
+
+var _ http.Flusher = (*nrw)(nil)
+
+// done flushes anything buffered, cleans up and marks the end of the
+// response, once.
+func (w *nrw) done() {
+	w.Lock()
+	if w.ended {
+		w.Unlock()
+		return
+	}
+	w.ended = true
+	w.implicitHeader(nil)
+	if err := w.flushBuffer(); err != nil {
+		w.error(err)
+	}
+	if w.asub != nil {
+		w.asub.Unsubscribe()
+	}
+	// Empty message marks the end of the response, with any trailers.
+	if end := w.trailer(); len(end.Header) > 0 {
+		w.nc.PublishMsg(end)
+	} else {
+		w.nc.Publish(w.reply, nil)
+	}
+	w.Unlock()
+}
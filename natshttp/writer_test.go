@@ -0,0 +1,119 @@
+package natshttp_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/derekcollison/nats-fs/client"
+	"github.com/derekcollison/nats-fs/natsfstest"
+	"github.com/derekcollison/nats-fs/natshttp"
+	"github.com/nats-io/nats.go"
+)
+
+func TestManySmallWrites(t *testing.T) {
+	data := randomData(100 * 1024)
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for b := data; len(b) > 0; b = b[min(len(b), 100):] {
+			w.Write(b[:min(len(b), 100)])
+		}
+	})
+	srv := natsfstest.NewServer(t, h, &natshttp.Options{ChunkSize: 1024, WindowSize: 4096})
+
+	var buf bytes.Buffer
+	if _, err := client.New(srv.Connect(t)).Get(context.Background(), srv.Subject, "/", &buf); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Fatalf("received %d bytes, want the %d written in order", buf.Len(), len(data))
+	}
+}
+
+func TestWindowWaitsForAcks(t *testing.T) {
+	const chunk, window = 1024, 4096
+	data := randomData(64 * 1024)
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	})
+	srv := natsfstest.NewServer(t, h, &natshttp.Options{ChunkSize: chunk, WindowSize: window})
+	nc := srv.Connect(t)
+
+	// Request by hand, holding back acks.
+	msgs := make(chan *nats.Msg, 128)
+	inbox := nats.NewInbox()
+	if _, err := nc.ChanSubscribe(inbox, msgs); err != nil {
+		t.Fatal(err)
+	}
+	req := client.New(nc).NewRequest(srv.Subject, "/")
+	req.Reply = inbox
+	if err := nc.PublishMsg(req); err != nil {
+		t.Fatal(err)
+	}
+	// Don't leave the handler waiting on acks if we fail.
+	defer nc.Publish(natshttp.RequestCancelSubject(inbox), nil)
+	var unacked []*nats.Msg
+	var sent int
+	timeout := time.After(200 * time.Millisecond)
+wait:
+	for {
+		select {
+		case m := <-msgs:
+			if len(m.Data) > 0 {
+				unacked = append(unacked, m)
+				sent += len(m.Data)
+			}
+		case <-timeout:
+			break wait
+		}
+	}
+	if sent == 0 || sent > window {
+		t.Fatalf("%d bytes sent without acks, want some but at most the %d byte window", sent, window)
+	}
+
+	// Acking lets the rest through.
+	var a natshttp.Acker
+	for _, m := range unacked {
+		a.Ack(m)
+	}
+	received := sent
+	for received < len(data) {
+		select {
+		case m := <-msgs:
+			received += len(m.Data)
+			a.Ack(m)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("stalled at %d of %d bytes after acking", received, len(data))
+		}
+	}
+}
+
+func TestAckTimeout(t *testing.T) {
+	errs := make(chan error, 1)
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := w.Write(randomData(64 * 1024))
+		errs <- err
+	})
+	srv := natsfstest.NewServer(t, h, &natshttp.Options{ChunkSize: 1024, WindowSize: 4096, AckTimeout: 100 * time.Millisecond})
+	nc := srv.Connect(t)
+
+	// A requester that never acks.
+	inbox := nats.NewInbox()
+	if _, err := nc.Subscribe(inbox, func(*nats.Msg) {}); err != nil {
+		t.Fatal(err)
+	}
+	req := client.New(nc).NewRequest(srv.Subject, "/")
+	req.Reply = inbox
+	if err := nc.PublishMsg(req); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Fatal("write completed without acks")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("write still waiting for acks after the ack timeout")
+	}
+}
@@ -0,0 +1,101 @@
+// Package ratelimit provides a simple token bucket for limiting bandwidth.
+package ratelimit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Bucket limits throughput to a rate in bytes per second.
+// A nil Bucket is unlimited.
+type Bucket struct {
+	sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// New returns a bucket for rate bytes per second, nil if rate is not positive.
+func New(rate float64) *Bucket {
+	if rate <= 0 {
+		return nil
+	}
+	return &Bucket{rate: rate, burst: rate, tokens: rate, last: time.Now()}
+}
+
+// Wait blocks until n bytes may be sent. Tokens are taken up front so
+// concurrent callers queue behind each other.
+func (tb *Bucket) Wait(n int) {
+	if tb == nil {
+		return
+	}
+	tb.Lock()
+	tb.refill(time.Now())
+	tb.tokens -= float64(n)
+	var d time.Duration
+	if tb.tokens < 0 {
+		d = time.Duration(-tb.tokens / tb.rate * float64(time.Second))
+	}
+	tb.Unlock()
+	if d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// Allow takes n tokens if they are available without waiting.
+func (tb *Bucket) Allow(n int) bool {
+	if tb == nil {
+		return true
+	}
+	tb.Lock()
+	defer tb.Unlock()
+	tb.refill(time.Now())
+	if tb.tokens < float64(n) {
+		return false
+	}
+	tb.tokens -= float64(n)
+	return true
+}
+
+// Lock held.
+func (tb *Bucket) refill(now time.Time) {
+	tb.tokens += now.Sub(tb.last).Seconds() * tb.rate
+	if tb.tokens > tb.burst {
+		tb.tokens = tb.burst
+	}
+	tb.last = now
+}
+
+// ParseRate parses rates like "50MB/s", "10M" or "1048576" into bytes per second.
+func ParseRate(s string) (float64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	v := strings.ToUpper(strings.TrimSpace(s))
+	v = strings.TrimSuffix(v, "/S")
+	v = strings.TrimSuffix(v, "B")
+	v = strings.TrimSuffix(v, "I")
+	mult := 1.0
+	if n := len(v); n > 0 {
+		switch v[n-1] {
+		case 'K':
+			mult = 1024
+		case 'M':
+			mult = 1024 * 1024
+		case 'G':
+			mult = 1024 * 1024 * 1024
+		}
+		if mult > 1 {
+			v = v[:n-1]
+		}
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+	return f * mult, nil
+}
@@ -0,0 +1,67 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want float64
+	}{
+		{"", 0},
+		{"1048576", 1 << 20},
+		{"10K", 10 << 10},
+		{"50MB/s", 50 << 20},
+		{"2GiB/s", 2 << 30},
+		{"1.5m", 1.5 * (1 << 20)},
+	} {
+		got, err := ParseRate(tc.in)
+		if err != nil || got != tc.want {
+			t.Errorf("ParseRate(%q) = %v, %v, want %v", tc.in, got, err, tc.want)
+		}
+	}
+	for _, in := range []string{"fast", "-1MB/s", "MB"} {
+		if _, err := ParseRate(in); err == nil {
+			t.Errorf("ParseRate(%q) succeeded", in)
+		}
+	}
+}
+
+func TestAllow(t *testing.T) {
+	tb := New(1000)
+	if !tb.Allow(1000) {
+		t.Fatal("burst not allowed")
+	}
+	if tb.Allow(1) {
+		t.Fatal("allowed past the burst")
+	}
+	// Refilled at the rate, capped at the burst.
+	tb.last = tb.last.Add(-10 * time.Second)
+	if !tb.Allow(1000) || tb.Allow(1) {
+		t.Fatal("refill not capped at the burst")
+	}
+}
+
+func TestWait(t *testing.T) {
+	tb := New(1 << 20)
+	tb.Wait(1 << 20)
+	start := time.Now()
+	// Half the rate again takes about half a second.
+	tb.Wait(1 << 19)
+	if d := time.Since(start); d < 400*time.Millisecond {
+		t.Fatalf("waited %v, want about 500ms", d)
+	}
+}
+
+func TestNilBucket(t *testing.T) {
+	var tb *Bucket
+	if New(0) != nil {
+		t.Fatal("New(0) is not unlimited")
+	}
+	tb.Wait(1 << 30)
+	if !tb.Allow(1 << 30) {
+		t.Fatal("nil bucket limited")
+	}
+}